@@ -3,36 +3,98 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Port             string
-	APIKey           string
-	AssemblyAIAPIKey string
-	WhisperServerURL string
-	WhisperModelPath string
-	WorkDir          string
-	MaxVideoLength   int
-	FreeJobLimit     int
+	Port                string
+	APIKey              string
+	AdminAPIKey         string
+	APIKeysFile         string
+	DatabaseURL         string
+	AuditLogFile        string
+	UsageFile           string
+	PublicBaseURL       string
+	ArtifactSigningKeys string
+	ArtifactURLTTL      time.Duration
+	WebhookSecrets      []string
+	WebhookOutboxFile   string
+	AssemblyAIAPIKey    string
+	WhisperServerURL    string
+	WhisperModelPath    string
+	WorkDir             string
+	MaxVideoLength      int
+	SyncMaxDuration     int
+	MaxUploadBytes      int64
+	MaxRequestBytes     int64
+	FreeJobLimit        int
+	QuotaMediaMinutes   int
+	QuotaConcurrentJobs int
+	RateLimitSubmit     int
+	RateLimitPoll       int
+	RateLimitDownload   int
+	WorkerCount         int
+	IdempotencyWindow   time.Duration
+	SwaggerUI           bool
+	LegacyDeprecatedAt  time.Time
+	LegacySunset        time.Time
 }
 
 func Load() *Config {
 	godotenv.Load()
 
 	maxLength, _ := strconv.Atoi(getEnv("MAX_VIDEO_LENGTH", "1800"))
+	syncMaxDuration, _ := strconv.Atoi(getEnv("SYNC_MAX_DURATION", "120"))
+	maxUploadMB, _ := strconv.ParseInt(getEnv("MAX_UPLOAD_MB", "500"), 10, 64)
+	maxRequestKB, _ := strconv.ParseInt(getEnv("MAX_REQUEST_BODY_KB", "1024"), 10, 64)
 	freeLimit, _ := strconv.Atoi(getEnv("FREE_JOB_LIMIT", "5"))
+	quotaMinutes, _ := strconv.Atoi(getEnv("QUOTA_MEDIA_MINUTES", "0"))
+	quotaConcurrent, _ := strconv.Atoi(getEnv("QUOTA_CONCURRENT_JOBS", "0"))
+	rateSubmit, _ := strconv.Atoi(getEnv("RATE_LIMIT_SUBMIT", "10"))
+	ratePoll, _ := strconv.Atoi(getEnv("RATE_LIMIT_POLL", "120"))
+	rateDownload, _ := strconv.Atoi(getEnv("RATE_LIMIT_DOWNLOAD", "60"))
+	workerCount, _ := strconv.Atoi(getEnv("WORKER_COUNT", "4"))
+	artifactURLTTL, _ := time.ParseDuration(getEnv("ARTIFACT_URL_TTL", "24h"))
+	idempotencyWindow, _ := time.ParseDuration(getEnv("IDEMPOTENCY_WINDOW", "24h"))
+	// An unset LEGACY_DEPRECATED_AT is lib.DefaultLegacyDeprecatedAt.
+	legacyDeprecatedAt, _ := time.Parse(time.DateOnly, getEnv("LEGACY_DEPRECATED_AT", ""))
+	legacySunset, _ := time.Parse(time.DateOnly, getEnv("LEGACY_SUNSET", "2027-04-30"))
 
 	return &Config{
-		Port:             getEnv("PORT", "3000"),
-		APIKey:           getEnv("API_KEY", "your-api-key-here"),
-		AssemblyAIAPIKey: getEnv("ASSEMBLYAI_API_KEY", ""),
-		WhisperServerURL: getEnv("WHISPER_SERVER_URL", ""),
-		WhisperModelPath: getEnv("WHISPER_MODEL_PATH", ""),
-		WorkDir:          getEnv("WORK_DIR", "/tmp/omnitranscripts"),
-		MaxVideoLength:   maxLength,
-		FreeJobLimit:     freeLimit,
+		Port:                getEnv("PORT", "3000"),
+		APIKey:              getEnv("API_KEY", "your-api-key-here"),
+		AdminAPIKey:         getEnv("ADMIN_API_KEY", ""),
+		APIKeysFile:         getEnv("API_KEYS_FILE", ""),
+		DatabaseURL:         getEnv("DATABASE_URL", ""),
+		AuditLogFile:        getEnv("AUDIT_LOG_FILE", ""),
+		UsageFile:           getEnv("USAGE_FILE", ""),
+		PublicBaseURL:       getEnv("PUBLIC_BASE_URL", ""),
+		ArtifactSigningKeys: getEnv("ARTIFACT_SIGNING_KEYS", ""),
+		ArtifactURLTTL:      artifactURLTTL,
+		WebhookSecrets:      splitList(getEnv("WEBHOOK_SECRETS", "")),
+		WebhookOutboxFile:   getEnv("WEBHOOK_OUTBOX_FILE", ""),
+		AssemblyAIAPIKey:    getEnv("ASSEMBLYAI_API_KEY", ""),
+		WhisperServerURL:    getEnv("WHISPER_SERVER_URL", ""),
+		WhisperModelPath:    getEnv("WHISPER_MODEL_PATH", ""),
+		WorkDir:             getEnv("WORK_DIR", "/tmp/omnitranscripts"),
+		MaxVideoLength:      maxLength,
+		SyncMaxDuration:     syncMaxDuration,
+		MaxUploadBytes:      maxUploadMB << 20,
+		MaxRequestBytes:     maxRequestKB << 10,
+		FreeJobLimit:        freeLimit,
+		QuotaMediaMinutes:   quotaMinutes,
+		QuotaConcurrentJobs: quotaConcurrent,
+		RateLimitSubmit:     rateSubmit,
+		RateLimitPoll:       ratePoll,
+		RateLimitDownload:   rateDownload,
+		WorkerCount:         workerCount,
+		IdempotencyWindow:   idempotencyWindow,
+		SwaggerUI:           getEnv("SWAGGER_UI", "false") == "true",
+		LegacyDeprecatedAt:  legacyDeprecatedAt,
+		LegacySunset:        legacySunset,
 	}
 }
 
@@ -42,3 +104,14 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -2,9 +2,13 @@ package engine
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/lrstanley/go-ytdlp"
 	ffmpeg_go "github.com/u2takey/ffmpeg-go"
@@ -57,21 +61,38 @@ func Transcribe(url string, jobID string, opts Options) (*Result, error) {
 	}, nil
 }
 
-// GetMediaDuration returns the duration of media at the given URL in seconds.
-// Uses yt-dlp to extract metadata without downloading the full media.
+// GetMediaDuration returns the duration of media at the given URL in whole
+// seconds, rounded up, or 0 when yt-dlp does not report one. It reads
+// yt-dlp's metadata without downloading the media.
 func GetMediaDuration(url string) (int, error) {
 	dl := ytdlp.New()
 
-	result, err := dl.Run(context.Background(), url, "--get-duration", "--no-warnings")
+	result, err := dl.Run(context.Background(), url,
+		"--dump-json", "--skip-download", "--no-playlist", "--no-warnings")
 	if err != nil {
 		return 0, NewError(StageDownload, "failed to get media info", err)
 	}
 
 	if result.ExitCode != 0 {
-		return 0, NewError(StageDownload, fmt.Sprintf("yt-dlp failed with code %d", result.ExitCode), nil)
+		return 0, NewError(StageDownload, "failed to get media info",
+			fmt.Errorf("yt-dlp failed with code %d: %s", result.ExitCode, result.Stderr))
 	}
 
-	return parseDuration(result.Stdout), nil
+	return parseDuration(result.Stdout)
+}
+
+// CheckLength returns a download.too_long error when media lasting seconds
+// is longer than max seconds. A max of zero allows any length.
+func CheckLength(seconds, max int) error {
+	if max <= 0 || seconds <= max {
+		return nil
+	}
+	return &TranscriptionError{
+		Stage: StageDownload,
+		Code:  CodeDownloadTooLong,
+		Message: fmt.Sprintf("media is %s long, over the limit of %s",
+			time.Duration(seconds)*time.Second, time.Duration(max)*time.Second),
+	}
 }
 
 func downloadAudio(url, outputPath string) error {
@@ -186,7 +207,27 @@ To enable actual transcription, configure WHISPER_MODEL_PATH, ASSEMBLYAI_API_KEY
 	return transcript, segments, nil
 }
 
-func parseDuration(duration string) int {
-	// TODO: Implement actual duration parsing
-	return 120
+// parseDuration reads the duration from yt-dlp's --dump-json output. Only
+// the first JSON object is read, in case the URL named several entries.
+// Live streams are rejected, as they never end; other media without a
+// duration returns 0, and its length is checked once it has run.
+func parseDuration(output string) (int, error) {
+	var info struct {
+		Duration *float64 `json:"duration"`
+		IsLive   bool     `json:"is_live"`
+	}
+	if err := json.NewDecoder(strings.NewReader(output)).Decode(&info); err != nil {
+		return 0, NewError(StageDownload, "failed to read media info", err)
+	}
+	if info.IsLive {
+		return 0, &TranscriptionError{
+			Stage:   StageDownload,
+			Code:    CodeDownloadUnavailable,
+			Message: "media is a live stream, which has no fixed duration",
+		}
+	}
+	if info.Duration == nil {
+		return 0, nil
+	}
+	return int(math.Ceil(*info.Duration)), nil
 }
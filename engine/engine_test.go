@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   int
+		code   string
+	}{
+		{"whole seconds", `{"id": "abc", "duration": 754}`, 754, ""},
+		{"fractions round up", `{"duration": 61.2}`, 62, ""},
+		{"first of several entries", "{\"duration\": 30}\n{\"duration\": 4000}\n", 30, ""},
+		{"live stream", `{"duration": null, "is_live": true}`, 0, CodeDownloadUnavailable},
+		{"live stream with a duration so far", `{"duration": 3600, "is_live": true}`, 0, CodeDownloadUnavailable},
+		{"unknown duration", `{"id": "abc", "duration": null}`, 0, ""},
+		{"no duration field", `{"id": "abc"}`, 0, ""},
+		{"not json", "ERROR: something", 0, CodeDownloadFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDuration(tt.output)
+			if tt.code == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("parseDuration() = %d, %v; want %d", got, err, tt.want)
+				}
+				return
+			}
+			var tErr *TranscriptionError
+			if !errors.As(err, &tErr) || tErr.Code != tt.code {
+				t.Errorf("parseDuration() error = %v, want code %s", err, tt.code)
+			}
+		})
+	}
+}
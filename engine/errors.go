@@ -1,6 +1,11 @@
 package engine
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // Stage represents a stage in the transcription pipeline.
 type Stage string
@@ -14,11 +19,35 @@ const (
 	StageTranscribe Stage = "transcribe"
 )
 
+// Stable error codes, of the form "<stage>.<reason>". Clients may match on
+// them; new codes can be added but existing ones keep their meaning.
+const (
+	CodeDownloadUnavailable    = "download.unavailable"
+	CodeDownloadUnsupportedURL = "download.unsupported_url"
+	CodeDownloadRateLimited    = "download.rate_limited"
+	CodeDownloadTimeout        = "download.timeout"
+	CodeDownloadFailed         = "download.failed"
+	CodeDownloadTooLong        = "download.too_long"
+
+	CodeNormalizeUnsupportedCodec = "normalize.unsupported_codec"
+	CodeNormalizeNoAudio          = "normalize.no_audio"
+	CodeNormalizeFailed           = "normalize.failed"
+
+	CodeTranscribeNotConfigured      = "transcribe.backend_not_configured"
+	CodeTranscribeBackendTimeout     = "transcribe.backend_timeout"
+	CodeTranscribeBackendUnavailable = "transcribe.backend_unavailable"
+	CodeTranscribeFailed             = "transcribe.failed"
+)
+
 // TranscriptionError represents a stage-specific error in the transcription pipeline.
 // It wraps the underlying error and provides context about which stage failed.
 type TranscriptionError struct {
 	// Stage indicates which pipeline stage encountered the error.
 	Stage Stage
+	// Code is a stable, machine-readable code such as "download.unavailable".
+	Code string
+	// Retryable reports whether running the job again may succeed.
+	Retryable bool
 	// Message provides a human-readable description of the error.
 	Message string
 	// Err is the underlying error, if any.
@@ -38,19 +67,89 @@ func (e *TranscriptionError) Unwrap() error {
 	return e.Err
 }
 
-// NewError creates a new TranscriptionError for the given stage.
+// NewError creates a new TranscriptionError for the given stage, deriving
+// its code and whether it is retryable from the underlying error.
 func NewError(stage Stage, message string, err error) *TranscriptionError {
+	code, retryable := classify(stage, err)
 	return &TranscriptionError{
-		Stage:   stage,
-		Message: message,
-		Err:     err,
+		Stage:     stage,
+		Code:      code,
+		Retryable: retryable,
+		Message:   message,
+		Err:       err,
 	}
 }
 
+// failure maps text found in a tool's error output to a code.
+type failure struct {
+	code      string
+	retryable bool
+	markers   []string
+}
+
+// failures lists, per stage, the failures recognised from yt-dlp, ffmpeg
+// and backend error messages, most specific first.
+var failures = map[Stage][]failure{
+	StageDownload: {
+		{CodeDownloadUnsupportedURL, false, []string{"unsupported url"}},
+		{CodeDownloadRateLimited, true, []string{"http error 429", "too many requests"}},
+		{CodeDownloadUnavailable, false, []string{
+			"video unavailable", "private video", "has been removed", "does not exist",
+			"http error 404", "http error 410", "not available in your country", "sign in to confirm your age",
+		}},
+		{CodeDownloadTimeout, true, []string{"timed out", "timeout"}},
+	},
+	StageNormalize: {
+		{CodeNormalizeNoAudio, false, []string{"does not contain any stream", "matches no streams", "no audio"}},
+		{CodeNormalizeUnsupportedCodec, false, []string{
+			"invalid data found", "unknown decoder", "decoder not found", "could not find codec", "unsupported codec",
+		}},
+	},
+	StageTranscribe: {
+		{CodeTranscribeNotConfigured, false, []string{"not configured"}},
+		{CodeTranscribeBackendTimeout, true, []string{"timed out", "timeout"}},
+		{CodeTranscribeBackendUnavailable, true, []string{"connection refused", "no such host", "service unavailable", "bad gateway"}},
+	},
+}
+
+// fallbacks is the code of a stage's unrecognised failures.
+var fallbacks = map[Stage]failure{
+	StageDownload:   {code: CodeDownloadFailed, retryable: true},
+	StageNormalize:  {code: CodeNormalizeFailed},
+	StageTranscribe: {code: CodeTranscribeFailed, retryable: true},
+}
+
+func classify(stage Stage, err error) (code string, retryable bool) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		switch stage {
+		case StageDownload:
+			return CodeDownloadTimeout, true
+		case StageTranscribe:
+			return CodeTranscribeBackendTimeout, true
+		}
+	}
+
+	if err != nil {
+		text := strings.ToLower(err.Error())
+		for _, f := range failures[stage] {
+			for _, marker := range f.markers {
+				if strings.Contains(text, marker) {
+					return f.code, f.retryable
+				}
+			}
+		}
+	}
+
+	if f, ok := fallbacks[stage]; ok {
+		return f.code, f.retryable
+	}
+	return string(stage) + ".failed", false
+}
+
 // IsDownloadError returns true if the error occurred during the download stage.
 func IsDownloadError(err error) bool {
 	var tErr *TranscriptionError
-	if ok := errorAs(err, &tErr); ok {
+	if ok := errors.As(err, &tErr); ok {
 		return tErr.Stage == StageDownload
 	}
 	return false
@@ -59,7 +158,7 @@ func IsDownloadError(err error) bool {
 // IsNormalizeError returns true if the error occurred during the normalize stage.
 func IsNormalizeError(err error) bool {
 	var tErr *TranscriptionError
-	if ok := errorAs(err, &tErr); ok {
+	if ok := errors.As(err, &tErr); ok {
 		return tErr.Stage == StageNormalize
 	}
 	return false
@@ -68,24 +167,8 @@ func IsNormalizeError(err error) bool {
 // IsTranscribeError returns true if the error occurred during the transcribe stage.
 func IsTranscribeError(err error) bool {
 	var tErr *TranscriptionError
-	if ok := errorAs(err, &tErr); ok {
+	if ok := errors.As(err, &tErr); ok {
 		return tErr.Stage == StageTranscribe
 	}
 	return false
 }
-
-// errorAs is a helper for errors.As to avoid import in this file.
-// The actual errors.As is used elsewhere; this is just for the helper functions.
-func errorAs(err error, target interface{}) bool {
-	if err == nil {
-		return false
-	}
-	// Simple type assertion for our specific case
-	if tErr, ok := err.(*TranscriptionError); ok {
-		if t, ok := target.(**TranscriptionError); ok {
-			*t = tErr
-			return true
-		}
-	}
-	return false
-}
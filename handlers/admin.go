@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// auditLog records every admin action, in memory until UseAuditLog.
+var auditLog lib.AuditLog = &lib.FileAuditLog{}
+
+// UseAuditLog records admin actions in l.
+func UseAuditLog(l lib.AuditLog) {
+	auditLog = l
+}
+
+// recordAudit writes an admin action taken by the request's key to the
+// audit log. The action has already happened, so a failed write is logged
+// rather than returned.
+func recordAudit(c *fiber.Ctx, action, target string, details map[string]interface{}) {
+	entry := models.NewAuditEntry(lib.APIKeyID(c), action, target, details)
+	if id, ok := c.Locals(requestid.ConfigDefault.ContextKey).(string); ok {
+		entry.RequestID = id
+	}
+	if err := auditLog.Record(c.UserContext(), entry); err != nil {
+		log.Printf("audit log: failed to record %s of %s: %v", action, target, err)
+	}
+}
+
+// GetAuditLog lists admin actions, newest first, optionally only those of
+// one action or on one target.
+func GetAuditLog(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", models.DefaultAuditListLimit)
+	if limit < 1 || limit > models.MaxAuditListLimit {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid limit, expected 1 to %d", models.MaxAuditListLimit),
+		})
+	}
+
+	entries, err := auditLog.List(c.UserContext(), models.AuditFilter{
+		Action: c.Query("action"),
+		Target: c.Query("target"),
+		Limit:  limit,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(models.AuditLogResponse{Entries: entries})
+}
+
+// KeyAdmin manages the API keys in a store. Keys configured with API_KEY
+// and ADMIN_API_KEY are not stored, so it cannot change them.
+type KeyAdmin struct {
+	store lib.KeyStore
+}
+
+// NewKeyAdmin returns a KeyAdmin for store.
+func NewKeyAdmin(store lib.KeyStore) *KeyAdmin {
+	return &KeyAdmin{store: store}
+}
+
+// List returns every stored key, revoked ones included.
+func (a *KeyAdmin) List(c *fiber.Ctx) error {
+	keys, err := a.store.List(c.UserContext())
+	if err != nil {
+		return err
+	}
+
+	response := lib.APIKeyList{Keys: make([]lib.APIKeyInfo, 0, len(keys))}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, key.Info())
+	}
+	return c.JSON(response)
+}
+
+// Create generates a key and returns it with its token, which is not
+// shown again.
+func (a *KeyAdmin) Create(c *fiber.Ctx) error {
+	var req lib.CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := req.Validate(time.Now()); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	key, token, err := req.NewKey()
+	if err != nil {
+		return err
+	}
+	if err := a.store.Put(c.UserContext(), key); err != nil {
+		return err
+	}
+	recordAudit(c, models.AuditKeyCreated, key.ID, map[string]interface{}{
+		"name":   key.Name,
+		"owner":  key.Owner,
+		"scopes": key.Scopes,
+	})
+
+	info := key.Info()
+	info.Token = token
+	return c.Status(fiber.StatusCreated).JSON(info)
+}
+
+// Get returns one key.
+func (a *KeyAdmin) Get(c *fiber.Ctx) error {
+	key, err := a.key(c)
+	if err != nil || key == nil {
+		return err
+	}
+	return c.JSON(key.Info())
+}
+
+// SetLimits replaces a key's quota, rate limits and scheduling weight. The
+// new limits apply from the key's next request, and the weight at once.
+func (a *KeyAdmin) SetLimits(c *fiber.Ctx) error {
+	var limits lib.APIKeyLimits
+	if err := c.BodyParser(&limits); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := limits.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	key, err := a.key(c)
+	if err != nil || key == nil {
+		return err
+	}
+	limits.Apply(key)
+	if err := a.store.Put(c.UserContext(), key); err != nil {
+		return err
+	}
+	if d := jobs.GetDispatcher(); d != nil {
+		d.SetWeight(key.ID, key.Weight)
+	}
+	recordAudit(c, models.AuditKeyLimitsSet, key.ID, map[string]interface{}{
+		"quota":       key.Quota,
+		"rate_limits": key.RateLimits,
+		"weight":      key.Weight,
+	})
+
+	return c.JSON(key.Info())
+}
+
+// Rotate gives a key a new secret and returns its token. The old token
+// stops working immediately.
+func (a *KeyAdmin) Rotate(c *fiber.Ctx) error {
+	key, err := a.key(c)
+	if err != nil || key == nil {
+		return err
+	}
+	if key.Revoked() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "API key has been revoked",
+		})
+	}
+
+	token, err := key.Rotate()
+	if err != nil {
+		return err
+	}
+	if err := a.store.Put(c.UserContext(), key); err != nil {
+		return err
+	}
+	recordAudit(c, models.AuditKeyRotated, key.ID, nil)
+
+	info := key.Info()
+	info.Token = token
+	return c.JSON(info)
+}
+
+// Revoke stops a key from authenticating. The key is kept, so its jobs,
+// usage and audit trail still resolve.
+func (a *KeyAdmin) Revoke(c *fiber.Ctx) error {
+	key, err := a.key(c)
+	if err != nil || key == nil {
+		return err
+	}
+	if key.Revoked() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "API key has already been revoked",
+		})
+	}
+
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	if err := a.store.Put(c.UserContext(), key); err != nil {
+		return err
+	}
+	recordAudit(c, models.AuditKeyRevoked, key.ID, nil)
+
+	return c.JSON(key.Info())
+}
+
+// key loads the key named by the key_id parameter. When there is no such
+// key it responds 404 and returns a nil key.
+func (a *KeyAdmin) key(c *fiber.Ctx) (*lib.APIKey, error) {
+	key, err := a.store.Get(c.UserContext(), c.Params("key_id"))
+	if errors.Is(err, lib.ErrAPIKeyNotFound) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "API key not found",
+		})
+	}
+	return key, err
+}
+
+// InspectJob returns every detail of any owner's job.
+func InspectJob(c *fiber.Ctx) error {
+	job, err := jobs.GetQueue().GetJob(c.Params("job_id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+	return c.JSON(job.Redacted())
+}
+
+// FailJob forcibly fails a queued or running job. A running job's worker
+// stops before its next stage, and any result it produced is dropped.
+func FailJob(c *fiber.Ctx) error {
+	var req models.FailJobRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	cause := models.ErrFailedByOperator
+	if req.Reason != "" {
+		cause = fmt.Errorf("%w: %s", models.ErrFailedByOperator, req.Reason)
+	}
+
+	queue := jobs.GetQueue()
+	wasQueued := false
+	failed, err := queue.Update(c.Params("job_id"), func(j *jobs.Job) error {
+		wasQueued = j.Status == jobs.StatusQueued
+		return j.Fail(cause)
+	})
+	switch {
+	case errors.Is(err, models.ErrInvalidTransition):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Job has already finished",
+		})
+	case err != nil:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+
+	if wasQueued {
+		releaseQueuedJob(failed)
+	}
+	recordAudit(c, models.AuditJobFailed, failed.ID, map[string]interface{}{
+		"owner":  failed.Owner,
+		"reason": req.Reason,
+	})
+
+	return c.JSON(failed.Redacted())
+}
+
+// RequeueJob returns a failed or cancelled job to the dispatch queue to run
+// again. Its quota was charged when it was first submitted. A job whose
+// worker has not yet stopped is refused, so that it never runs twice at
+// once.
+func RequeueJob(c *fiber.Ctx) error {
+	queue := jobs.GetQueue()
+	job, err := queue.GetJob(c.Params("job_id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+	// A batch child's batch may already be completed, and its media was
+	// charged when it first started.
+	if job.BatchID != "" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Jobs in a batch cannot be requeued, submit the URL again",
+		})
+	}
+	if job.MediaPath != "" {
+		if _, err := os.Stat(job.MediaPath); err != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Uploaded media is no longer available",
+			})
+		}
+	}
+	requeue := func() (*jobs.Job, error) {
+		return queue.Update(job.ID, (*jobs.Job).Requeue)
+	}
+	var requeued *jobs.Job
+	if d := jobs.GetDispatcher(); d != nil {
+		requeued, err = d.Requeue(job.ID, requeue)
+	} else {
+		requeued, err = requeue()
+	}
+	switch {
+	case errors.Is(err, jobs.ErrStillRunning):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  "Job is still stopping on a worker, retry shortly",
+			"status": job.Status,
+		})
+	case err != nil:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  "Only failed or cancelled jobs can be requeued",
+			"status": job.Status,
+		})
+	}
+	recordAudit(c, models.AuditJobRequeued, requeued.ID, map[string]interface{}{
+		"owner":           requeued.Owner,
+		"previous_status": job.Status,
+	})
+
+	return c.JSON(requeued.Redacted())
+}
+
+// BumpJob moves a waiting job to the front of the dispatch queue, ahead of
+// every API key's fair share.
+func BumpJob(c *fiber.Ctx) error {
+	jobID := c.Params("job_id")
+
+	dispatcher := jobs.GetDispatcher()
+	if err := dispatcher.Bump(jobID); err != nil {
+		if _, getErr := jobs.GetQueue().GetJob(jobID); getErr != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Job not found",
+			})
+		}
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Job is no longer queued",
+		})
+	}
+	recordAudit(c, models.AuditJobBumped, jobID, nil)
+
+	position, start, err := dispatcher.Position(jobID)
+	if err != nil {
+		// Picked up by a worker right after the bump.
+		return c.JSON(QueuePositionResponse{ID: jobID})
+	}
+
+	return c.JSON(QueuePositionResponse{
+		ID:               jobID,
+		QueuePosition:    position,
+		EstimatedStartAt: &start,
+	})
+}
+
+// GetWorkers reports the worker pool's size, load and whether it is
+// draining.
+func GetWorkers(c *fiber.Ctx) error {
+	return c.JSON(jobs.GetDispatcher().Status())
+}
+
+// DrainWorkers stops the workers from starting queued jobs, for instance
+// ahead of a deploy. Running jobs finish; the drain is complete once the
+// pool reports no busy workers.
+func DrainWorkers(c *fiber.Ctx) error {
+	dispatcher := jobs.GetDispatcher()
+	dispatcher.Drain()
+	status := dispatcher.Status()
+	recordAudit(c, models.AuditWorkersDrained, "", map[string]interface{}{
+		"busy":   status.Busy,
+		"queued": status.Queued,
+	})
+	return c.JSON(status)
+}
+
+// ResumeWorkers lets the workers start queued jobs again after a drain.
+func ResumeWorkers(c *fiber.Ctx) error {
+	dispatcher := jobs.GetDispatcher()
+	dispatcher.Resume()
+	recordAudit(c, models.AuditWorkersResumed, "", nil)
+	return c.JSON(dispatcher.Status())
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+)
+
+// urlSigner signs the download links in job responses and webhooks. They
+// are the authenticated download URLs until UseURLSigner.
+var urlSigner *lib.URLSigner
+
+// UseURLSigner signs download links with s and serves them from
+// DownloadArtifact.
+func UseURLSigner(s *lib.URLSigner) {
+	urlSigner = s
+}
+
+// DownloadArtifact serves a transcript from a signed URL, which needs no
+// API key until it expires.
+func DownloadArtifact(c *fiber.Ctx) error {
+	format, ok := lib.ParseTranscriptFormat(c.Params("format"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unknown transcript format",
+		})
+	}
+
+	query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if err != nil || urlSigner == nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Invalid download URL signature",
+		})
+	}
+	jobID := c.Params("job_id")
+	if err := urlSigner.Verify(jobID, format, query, time.Now()); err != nil {
+		message := "Invalid download URL signature"
+		if errors.Is(err, lib.ErrExpiredSignature) {
+			message = "Download URL has expired"
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	job, err := jobs.GetQueue().GetJob(jobID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+	return serveTranscript(c, job, format)
+}
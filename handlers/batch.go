@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// PostTranscribeBatch submits a list of URLs, or a playlist/channel URL that
+// is expanded with yt-dlp, as child jobs under a new batch.
+func PostTranscribeBatch(c *fiber.Ctx) error {
+	var req models.BatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if (len(req.URLs) == 0) == (req.PlaylistURL == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Provide either urls or playlist_url",
+		})
+	}
+
+	if !req.Priority.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid priority, expected low, normal or high",
+		})
+	}
+
+	if problems := req.TranscriptionOptions.Validate(); len(problems) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid transcription options",
+			"fields": problems,
+		})
+	}
+
+	if req.SetsJobWebhook() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "webhook_headers and webhook_events are not supported on batches, whose webhook_url receives only batch.completed",
+		})
+	}
+
+	if req.WebhookURL != "" {
+		if err := lib.ValidateWebhookConfig(lib.WebhookConfig{URL: req.WebhookURL}); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	urls := req.URLs
+	if req.PlaylistURL != "" {
+		if !models.ValidateURL(req.PlaylistURL) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid playlist URL",
+			})
+		}
+
+		expanded, err := lib.ExpandPlaylist(req.PlaylistURL, models.MaxBatchSize+1)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to expand playlist",
+			})
+		}
+		urls = expanded
+	}
+
+	if len(urls) > models.MaxBatchSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Batch exceeds the maximum of %d URLs", models.MaxBatchSize),
+		})
+	}
+
+	for _, url := range urls {
+		if !models.ValidateURL(url) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid URL in batch: " + url,
+			})
+		}
+	}
+
+	owner := lib.Owner(c)
+	batch := jobs.NewBatch(owner, req.PlaylistURL)
+	batch.WebhookURL = req.WebhookURL
+
+	children := make([]*jobs.Job, 0, len(urls))
+	for _, url := range urls {
+		job := jobs.NewJob(url)
+		job.Owner = owner
+		job.APIKeyID = lib.APIKeyID(c)
+		job.Options = req.TranscriptionOptions.Clone()
+		if req.Priority != "" {
+			job.Priority = req.Priority
+		}
+		children = append(children, job)
+	}
+
+	// Media minutes are charged as each child starts; see chargeBatchChild.
+	if admitted, err := admitBatch(c, batch.ID, len(children)); !admitted {
+		return err
+	}
+
+	jobs.GetQueue().AddBatch(batch, children)
+
+	dispatch(c, children...)
+
+	return c.Status(fiber.StatusAccepted).JSON(models.BatchResponse{
+		BatchID: batch.ID,
+		JobIDs:  batch.JobIDs,
+	})
+}
+
+// GetBatch reports the aggregate progress of a batch and its child jobs.
+func GetBatch(c *fiber.Ctx) error {
+	queue := jobs.GetQueue()
+
+	batch, err := queue.GetBatch(c.Params("id"))
+	if err != nil || batch.Owner != lib.Owner(c) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Batch not found",
+		})
+	}
+
+	progress, err := queue.BatchProgress(batch.ID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Batch not found",
+		})
+	}
+
+	response := batchStatus(batch, progress)
+	for _, jobID := range batch.JobIDs {
+		if job, err := queue.GetJob(jobID); err == nil {
+			response.Jobs = append(response.Jobs, job.Summary())
+		}
+	}
+
+	return c.JSON(response)
+}
+
+func batchStatus(batch *jobs.Batch, progress jobs.BatchProgress) *models.BatchStatusResponse {
+	status := "running"
+	if batch.CompletedAt != nil {
+		status = "completed"
+	}
+
+	percent := 0.0
+	if progress.Total > 0 {
+		percent = float64(progress.Finished()) / float64(progress.Total) * 100
+	}
+
+	return &models.BatchStatusResponse{
+		ID:          batch.ID,
+		Status:      status,
+		SourceURL:   batch.SourceURL,
+		Total:       progress.Total,
+		Queued:      progress.Queued,
+		Running:     progress.Running,
+		Completed:   progress.Completed,
+		Failed:      progress.Failed,
+		Cancelled:   progress.Cancelled,
+		Progress:    percent,
+		CreatedAt:   batch.CreatedAt,
+		CompletedAt: batch.CompletedAt,
+	}
+}
+
+// finishBatchChild fires the batch.completed webhook when the last child of a
+// batch finishes.
+func finishBatchChild(batchID string) {
+	queue := jobs.GetQueue()
+
+	completed, progress := queue.CompleteBatch(batchID)
+	if !completed {
+		return
+	}
+
+	batch, err := queue.GetBatch(batchID)
+	if err != nil || batch.WebhookURL == "" {
+		return
+	}
+
+	webhookManager := lib.NewWebhookManager(lib.WebhookConfig{
+		URL:        batch.WebhookURL,
+		Timeout:    10 * time.Second,
+		Retries:    3,
+		Secrets:    serverConfig.WebhookSecrets,
+		Dispatcher: webhookDispatcher,
+		Owner:      batch.Owner,
+	})
+	if err := webhookManager.SendBatchCompleted(context.Background(), batchStatus(batch, progress)); err != nil {
+		log.Printf("batch %s: completion webhook failed: %v", batchID, err)
+	}
+}
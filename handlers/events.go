@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+)
+
+// SSEHeartbeatInterval is how often an idle event stream sends a comment
+// line so proxies and clients keep the connection open.
+const SSEHeartbeatInterval = 15 * time.Second
+
+// StreamJobEvents streams a job's status, stage and segment events as
+// Server-Sent Events. Clients reconnecting with Last-Event-ID receive only
+// the events they missed. The stream ends after the terminal status event.
+func StreamJobEvents(c *fiber.Ctx) error {
+	jobID := c.Params("job_id")
+
+	queue := jobs.GetQueue()
+	job, err := queue.GetJob(jobID)
+	if err != nil || job.Owner != lib.Owner(c) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+
+	lastEventID := 0
+	if header := c.Get("Last-Event-ID"); header != "" {
+		lastEventID, err = strconv.Atoi(header)
+		if err != nil || lastEventID < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid Last-Event-ID",
+			})
+		}
+	}
+
+	replay, events, cancel := queue.Events().Subscribe(jobID, lastEventID)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		for _, event := range replay {
+			if writeSSE(w, event) != nil {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(SSEHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if writeSSE(w, event) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				if w.Flush() != nil {
+					return
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+// writeSSE writes one event in text/event-stream framing and flushes it.
+// A flush error means the client has gone away.
+func writeSSE(w *bufio.Writer, event jobs.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return w.Flush()
+}
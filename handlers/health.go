@@ -0,0 +1,11 @@
+package handlers
+
+import "github.com/gofiber/fiber/v2"
+
+// Health reports that the API is up.
+func Health(c *fiber.Ctx) error {
+	return c.JSON(HealthResponse{
+		Status:  "ok",
+		Message: "OmniTranscripts API is running",
+	})
+}
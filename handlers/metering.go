@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+var usageMeter lib.UsageMeter
+
+// UseMeter records every API call and completed job with m. A nil meter
+// disables metering.
+func UseMeter(m lib.UsageMeter) {
+	usageMeter = m
+}
+
+// MeterRequests records each request's endpoint, status, latency, API key
+// and sizes once the rest of the chain has answered it.
+func MeterRequests(c *fiber.Ctx) error {
+	if usageMeter == nil {
+		return c.Next()
+	}
+
+	start := time.Now()
+	if err := c.Next(); err != nil {
+		// Let the app's error handler write the response now, so its
+		// status is the one recorded.
+		if handlerErr := c.App().ErrorHandler(c, err); handlerErr != nil {
+			c.Status(fiber.StatusInternalServerError)
+		}
+	}
+
+	// Streamed bodies are not buffered, so the request size is the declared
+	// one; reading the body here would drain an upload.
+	call := models.APICall{
+		Endpoint:      c.Route().Path,
+		Method:        c.Method(),
+		APIKeyID:      lib.APIKeyID(c),
+		Owner:         lib.Owner(c),
+		Status:        c.Response().StatusCode(),
+		Latency:       time.Since(start),
+		RequestBytes:  max(c.Request().Header.ContentLength(), 0),
+		ResponseBytes: len(c.Response().Body()),
+		UserAgent:     c.Get(fiber.HeaderUserAgent),
+		IP:            c.IP(),
+		At:            start,
+	}
+	if err := usageMeter.RecordCall(c.UserContext(), call); err != nil {
+		log.Printf("api usage: failed to record call: %v", err)
+	}
+	return nil
+}
+
+// recordJobUsage records the media a completed job is billed for.
+func recordJobUsage(job *jobs.Job) {
+	if usageMeter == nil || job.Status != jobs.StatusCompleted {
+		return
+	}
+	usage := models.BillableJob{
+		JobID:        job.ID,
+		APIKeyID:     job.APIKeyID,
+		Owner:        job.Owner,
+		MediaSeconds: job.BillableSeconds(),
+		At:           time.Now(),
+	}
+	if job.CompletedAt != nil {
+		usage.At = *job.CompletedAt
+	}
+	if err := usageMeter.RecordJob(context.Background(), usage); err != nil {
+		log.Printf("job %s: failed to record usage: %v", job.ID, err)
+	}
+}
+
+// GetUsageReport reports an API key's requests and billable media per UTC
+// day: the caller's own key, or with the admin scope any key named by
+// key_id.
+func GetUsageReport(c *fiber.Ctx) error {
+	keyID := lib.APIKeyID(c)
+	if requested := c.Query("key_id"); requested != "" && requested != keyID {
+		if key := lib.CurrentAPIKey(c); key == nil || !key.HasScope(lib.ScopeAdmin) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "API key lacks the admin scope",
+			})
+		}
+		keyID = requested
+	}
+
+	from, to, err := models.ParseUsagePeriod(c.Query("from"), c.Query("to"), time.Now())
+	if errors.Is(err, models.ErrInvalidUsagePeriod) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid period, expected from and to as YYYY-MM-DD, at most 366 days apart",
+		})
+	}
+
+	report := models.NewUsageReport(keyID, from, to, nil)
+	if usageMeter != nil {
+		if report, err = usageMeter.Report(c.UserContext(), keyID, from, to); err != nil {
+			return err
+		}
+	}
+	return c.JSON(report)
+}
@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+	"omnitranscripts/openapi"
+)
+
+// APIVersion is the version reported in the OpenAPI document.
+const APIVersion = "1.0.0"
+
+const apiDescription = "Transcribe audio and video from YouTube and 1000+ other platforms, or from uploaded files. " +
+	"The unversioned paths, such as /transcribe, are deprecated aliases of the /v1 paths."
+
+var (
+	apiDocument     *openapi.Document
+	apiDocumentOnce sync.Once
+)
+
+// APIDocument returns the OpenAPI document generated from Routes and the
+// request and response types they name.
+func APIDocument() *openapi.Document {
+	apiDocumentOnce.Do(func() {
+		g := openapi.NewGenerator()
+		g.Enum(models.JobStatus(""), "queued", "running", "completed", "failed", "cancelled")
+		g.Enum(models.Priority(""), "low", "normal", "high")
+		g.Enum(models.Task(""), "transcribe", "translate")
+		g.Enum(models.Backend(""), "auto", "whisper", "assemblyai", "whisper_server")
+		g.Enum(jobs.EventType(""), "status", "stage", "segment")
+		g.Enum(models.DeliveryStatus(""), "pending", "delivered", "dead")
+
+		// Bodies that do not travel over a plain HTTP response.
+		g.SchemaOf(WSRequest{})
+		g.SchemaOf(WSMessage{})
+		g.SchemaOf(lib.TranscriptDocument{})
+
+		apiDocument = openapi.Build(openapi.Info{
+			Title:       "OmniTranscripts API",
+			Description: apiDescription,
+			Version:     APIVersion,
+		}, g, Routes)
+	})
+	return apiDocument
+}
+
+// ServeOpenAPI serves the OpenAPI document.
+func ServeOpenAPI(c *fiber.Ctx) error {
+	return c.JSON(APIDocument())
+}
+
+// ValidateRequest rejects JSON bodies that do not match the route's request
+// schema in the OpenAPI document.
+func ValidateRequest(c *fiber.Ctx) error {
+	return APIDocument().ValidateRequest(c)
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at /openapi.json.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>OmniTranscripts API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>`
+
+// SwaggerUI serves an interactive page for the OpenAPI document.
+func SwaggerUI(c *fiber.Ctx) error {
+	c.Type("html", "utf-8")
+	return c.SendString(swaggerUIPage)
+}
+
+var (
+	errorResponses = map[int]string{
+		400: "Invalid request",
+		401: "Missing or invalid API key",
+		403: "API key lacks the required scope",
+		404: "Not found",
+		409: "Conflict with the job's current state",
+		413: "Upload too large",
+		415: "Unsupported media type",
+		422: "Not processable",
+		429: "Rate limit exceeded; retry after Retry-After seconds",
+	}
+
+	idempotencyHeader = []openapi.Parameter{{
+		Name:        "Idempotency-Key",
+		In:          "header",
+		Description: "Makes retries return the original response",
+		Schema:      &openapi.Schema{Type: "string"},
+	}}
+
+	waitParams = []openapi.Parameter{{
+		Name:        "wait",
+		In:          "query",
+		Description: "Seconds to wait for the result before answering 202 (max 300)",
+		Schema:      &openapi.Schema{Type: "integer"},
+	}}
+
+	binarySchema = &openapi.Schema{Type: "string", Format: "binary"}
+)
+
+// errorResponse documents an error status with the shared error body.
+func errorResponse(status int) openapi.Response {
+	return openapi.Response{Status: status, Description: errorResponses[status], Body: ErrorResponse{}}
+}
+
+// quotaResponses document the rejections of a submission by its key's quota.
+var quotaResponses = []openapi.Response{
+	{Status: 402, Description: "The key's daily jobs or monthly media minutes are spent", Body: QuotaErrorResponse{}},
+	{Status: 429, Description: "The key has too many jobs queued or running, or exceeded its rate limit", Body: QuotaErrorResponse{}},
+}
+
+// problemResponses document the ways a transcription waited on can fail.
+var problemResponses = []openapi.Response{
+	{Status: 500, Description: "Internal error", ContentType: models.ProblemContentType, Body: models.Problem{}},
+	{Status: 502, Description: "Download or transcription backend failed", ContentType: models.ProblemContentType, Body: models.Problem{}},
+	{Status: 503, Description: "Source rate limited or backend not configured", ContentType: models.ProblemContentType, Body: models.Problem{}},
+	{Status: 504, Description: "Download or transcription timed out", ContentType: models.ProblemContentType, Body: models.Problem{}},
+}
+
+func query(name, description string, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// Routes describes every route served by the API. A test checks it against
+// the routes main registers, so update both together. The deprecated bare
+// aliases of the /v1 routes are not listed.
+var Routes = []openapi.Route{
+	{
+		Method: "GET", Path: "/health", OperationID: "health",
+		Summary: "Health check", Tags: []string{"System"}, Public: true,
+		Responses: []openapi.Response{{Status: 200, Description: "The API is up", Body: HealthResponse{}}},
+	},
+	{
+		Method: "GET", Path: "/openapi.json", OperationID: "openapi",
+		Summary: "This OpenAPI document", Tags: []string{"System"}, Public: true,
+		Responses: []openapi.Response{{Status: 200, Description: "OpenAPI 3 document", Schema: &openapi.Schema{Type: "object"}}},
+	},
+	{
+		Method: "GET", Path: "/v1/admin/keys", OperationID: "listAPIKeys",
+		Summary: "List every stored API key", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "Every key, revoked ones included, without hashes", Body: lib.APIKeyList{}},
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/admin/keys", OperationID: "createAPIKey",
+		Summary: "Create an API key", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Request: lib.CreateAPIKeyRequest{},
+		Responses: []openapi.Response{
+			{Status: 201, Description: "The key, with its token; the token is not shown again", Body: lib.APIKeyInfo{}},
+			errorResponse(400),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/admin/keys/:key_id", OperationID: "getAPIKey",
+		Summary: "Get an API key", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The key", Body: lib.APIKeyInfo{}},
+			errorResponse(404),
+		},
+	},
+	{
+		Method: "PUT", Path: "/v1/admin/keys/:key_id/limits", OperationID: "setAPIKeyLimits",
+		Summary: "Set an API key's quota and rate limits", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Description: "Replaces both; an omitted quota or rate limit class falls back to the server default.",
+		Request:     lib.APIKeyLimits{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The key", Body: lib.APIKeyInfo{}},
+			errorResponse(400), errorResponse(404),
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/admin/keys/:key_id/rotate", OperationID: "rotateAPIKey",
+		Summary: "Give an API key a new secret", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Description: "The old token stops working immediately. The key keeps its ID, quota usage and metering.",
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The key, with its new token", Body: lib.APIKeyInfo{}},
+			errorResponse(404), {Status: 409, Description: "The key has been revoked", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/admin/keys/:key_id/revoke", OperationID: "revokeAPIKey",
+		Summary: "Revoke an API key", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The revoked key", Body: lib.APIKeyInfo{}},
+			errorResponse(404), {Status: 409, Description: "The key has already been revoked", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/admin/jobs/:job_id", OperationID: "inspectJob",
+		Summary: "Inspect any owner's job", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "Every detail of the job", Body: models.Job{}},
+			errorResponse(404),
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/admin/jobs/:job_id/bump", OperationID: "bumpJob",
+		Summary: "Move a queued job to the front of the queue", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "New queue position", Body: QueuePositionResponse{}},
+			errorResponse(404), errorResponse(409),
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/admin/jobs/:job_id/fail", OperationID: "failJob",
+		Summary: "Forcibly fail a queued or running job", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Description: "The job fails with the problem code job.failed_by_operator. A running job's result is dropped.",
+		Request:     models.FailJobRequest{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The failed job", Body: models.Job{}},
+			errorResponse(400), errorResponse(404), errorResponse(409),
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/admin/jobs/:job_id/requeue", OperationID: "requeueJob",
+		Summary: "Run a failed or cancelled job again", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The queued job", Body: models.Job{}},
+			errorResponse(404), errorResponse(409),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/admin/workers", OperationID: "getWorkers",
+		Summary: "Get the worker pool's load", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "Workers, busy workers and queued jobs", Body: models.WorkerPoolStatus{}},
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/admin/workers/drain", OperationID: "drainWorkers",
+		Summary: "Stop the workers from starting queued jobs", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Description: "Running jobs finish; the drain is complete once no workers are busy. Queued jobs wait for a resume.",
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The draining pool", Body: models.WorkerPoolStatus{}},
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/admin/workers/resume", OperationID: "resumeWorkers",
+		Summary: "Let the workers start queued jobs again", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The pool", Body: models.WorkerPoolStatus{}},
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/admin/audit", OperationID: "getAuditLog",
+		Summary: "List admin actions, newest first", Tags: []string{"Admin"}, Scope: string(lib.ScopeAdmin),
+		Query: []openapi.Parameter{
+			query("action", "Only this action, such as key.revoked", &openapi.Schema{Type: "string"}),
+			query("target", "Only actions on this key or job ID", &openapi.Schema{Type: "string"}),
+			query("limit", "Entries to return, up to 500", &openapi.Schema{Type: "integer"}),
+		},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "Matching entries", Body: models.AuditLogResponse{}},
+			errorResponse(400),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/ws", OperationID: "jobsWebSocket",
+		Summary: "Follow jobs over a WebSocket", Tags: []string{"Jobs"}, Scope: string(lib.ScopeRead),
+		Description: "Upgrade to a WebSocket, then send WSRequest messages to subscribe to jobs and receive WSMessage updates. " +
+			"Browsers may pass the API key as the access_token query parameter.",
+		Query: []openapi.Parameter{query("access_token", "API key, for clients that cannot set headers", &openapi.Schema{Type: "string"})},
+		Responses: []openapi.Response{
+			{Status: 101, Description: "Switching to the WebSocket protocol"},
+			{Status: 426, Description: "WebSocket upgrade required", Body: ErrorResponse{}},
+			errorResponse(429),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/transcribe", OperationID: "listJobs",
+		Summary: "List your jobs, newest first", Tags: []string{"Jobs"}, Scope: string(lib.ScopeRead),
+		Query: []openapi.Parameter{
+			query("status", "Only jobs in this status", &openapi.Schema{Type: "string", Enum: []string{"queued", "running", "completed", "failed", "cancelled"}}),
+			query("created_after", "RFC 3339 timestamp", &openapi.Schema{Type: "string", Format: "date-time"}),
+			query("created_before", "RFC 3339 timestamp", &openapi.Schema{Type: "string", Format: "date-time"}),
+			query("host", "Only jobs whose source URL has this host", &openapi.Schema{Type: "string"}),
+			query("q", "Only jobs whose transcript contains this text", &openapi.Schema{Type: "string"}),
+			query("cursor", "next_cursor from the previous page", &openapi.Schema{Type: "string"}),
+			query("limit", "Page size", &openapi.Schema{Type: "integer"}),
+		},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "One page of jobs", Body: models.JobListResponse{}},
+			errorResponse(400), errorResponse(429),
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/transcribe", OperationID: "transcribe",
+		Summary: "Transcribe a URL", Tags: []string{"Jobs"}, Scope: string(lib.ScopeSubmit),
+		Description: "Short media is waited for by default. Prefer: respond-async or wait=<seconds> overrides that, as does ?wait.",
+		Query:       waitParams,
+		Headers: append([]openapi.Parameter{{
+			Name: "Prefer", In: "header", Description: "respond-async or wait=<seconds>", Schema: &openapi.Schema{Type: "string"},
+		}}, idempotencyHeader...),
+		Request: models.TranscribeRequest{},
+		Responses: append([]openapi.Response{
+			{Status: 200, Description: "Transcript, when the job finished within the wait", Body: models.TranscribeResponse{}},
+			{Status: 202, Description: "Job accepted; poll the Location", Body: models.TranscribeResponse{}},
+			errorResponse(400), errorResponse(401), errorResponse(409),
+			{Status: 422, Description: "Media cannot be transcribed", ContentType: models.ProblemContentType, Body: models.Problem{}},
+		}, append(quotaResponses, problemResponses...)...),
+	},
+	{
+		Method: "POST", Path: "/v1/transcribe/batch", OperationID: "submitBatch",
+		Summary: "Transcribe many URLs or a playlist", Tags: []string{"Batches"}, Scope: string(lib.ScopeSubmit),
+		Headers: idempotencyHeader,
+		Request: models.BatchRequest{},
+		Responses: append([]openapi.Response{
+			{Status: 202, Description: "Batch accepted", Body: models.BatchResponse{}},
+			errorResponse(400), errorResponse(401), errorResponse(409), errorResponse(422),
+		}, quotaResponses...),
+	},
+	{
+		Method: "POST", Path: "/v1/transcribe/upload", OperationID: "transcribeUpload",
+		Summary: "Transcribe an uploaded audio or video file", Tags: []string{"Jobs"}, Scope: string(lib.ScopeSubmit),
+		Description: "Send the file as the \"file\" part of a multipart form, or as the raw body with its own audio/* or video/* content type.",
+		Query: append([]openapi.Parameter{
+			query("priority", "low, normal or high", &openapi.Schema{Type: "string", Enum: []string{"low", "normal", "high"}}),
+			query("filename", "Name of a raw-body upload", &openapi.Schema{Type: "string"}),
+			query("options", "Transcription options as a JSON object", &openapi.Schema{Type: "string"}),
+			query("webhook_url", "Where to send the job's webhooks", &openapi.Schema{Type: "string"}),
+			query("webhook_headers", "JSON object of headers added to each webhook", &openapi.Schema{Type: "string"}),
+			query("webhook_events", "Comma-separated webhook events to send", &openapi.Schema{Type: "string"}),
+		}, waitParams...),
+		RequestContent: map[string]*openapi.Schema{
+			"multipart/form-data": {
+				Type:       "object",
+				Properties: map[string]*openapi.Schema{lib.UploadFormField: binarySchema},
+				Required:   []string{lib.UploadFormField},
+			},
+			"audio/*":                  binarySchema,
+			"video/*":                  binarySchema,
+			"application/octet-stream": binarySchema,
+		},
+		Responses: append([]openapi.Response{
+			{Status: 200, Description: "Transcript, when the job finished within the wait", Body: models.TranscribeResponse{}},
+			{Status: 202, Description: "Job accepted; poll the Location", Body: models.TranscribeResponse{}},
+			errorResponse(400), errorResponse(401), errorResponse(413), errorResponse(415), errorResponse(422),
+		}, append(quotaResponses, problemResponses...)...),
+	},
+	{
+		Method: "GET", Path: "/v1/transcribe/:job_id", OperationID: "getJob",
+		Summary: "Get a job's status and transcript", Tags: []string{"Jobs"}, Scope: string(lib.ScopeRead),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The job", Body: JobStatusResponse{}},
+			errorResponse(404), errorResponse(429),
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/transcribe/:job_id/cancel", OperationID: "cancelJob",
+		Summary: "Cancel a queued or running job", Tags: []string{"Jobs"}, Scope: string(lib.ScopeSubmit),
+		Description: "A running job stops before its next stage, or at once while downloading, and any result it produced is dropped.",
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The cancelled job", Body: models.JobSummary{}},
+			errorResponse(404), errorResponse(409), errorResponse(429),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/transcribe/:job_id/events", OperationID: "streamJobEvents",
+		Summary: "Stream a job's events as Server-Sent Events", Tags: []string{"Jobs"}, Scope: string(lib.ScopeRead),
+		Headers: []openapi.Parameter{{
+			Name: "Last-Event-ID", In: "header", Description: "Resume after this event", Schema: &openapi.Schema{Type: "integer"},
+		}},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "Event stream; each data line is an Event", ContentType: "text/event-stream", Body: jobs.Event{}},
+			errorResponse(400), errorResponse(404), errorResponse(429),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/transcribe/:job_id/transcript.:format", OperationID: "downloadTranscript",
+		Summary: "Download a completed job's transcript", Tags: []string{"Jobs"}, Scope: string(lib.ScopeRead),
+		Description: "format is txt, srt, vtt or json; json returns a TranscriptDocument. Supports ETag and Range requests.",
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The transcript as txt, srt or vtt", ContentType: "text/plain", Schema: &openapi.Schema{Type: "string"}},
+			{Status: 206, Description: "Part of the transcript", ContentType: "text/plain", Schema: &openapi.Schema{Type: "string"}},
+			{Status: 304, Description: "Not modified"},
+			errorResponse(404), errorResponse(409), errorResponse(429),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/artifacts/:job_id/transcript.:format", OperationID: "downloadArtifact",
+		Summary: "Download a transcript from a signed URL", Tags: []string{"Jobs"}, Public: true,
+		Description: "The subtitle_files URLs of job responses and webhooks. They are authorized by their signature instead of an API key until they expire.",
+		Query: []openapi.Parameter{
+			query("expires", "Unix time the URL expires at", &openapi.Schema{Type: "integer"}),
+			query("key", "ID of the signing key", &openapi.Schema{Type: "string"}),
+			query("signature", "Hex HMAC-SHA256 of the job, format and expiry", &openapi.Schema{Type: "string"}),
+		},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The transcript as txt, srt or vtt", ContentType: "text/plain", Schema: &openapi.Schema{Type: "string"}},
+			{Status: 206, Description: "Part of the transcript", ContentType: "text/plain", Schema: &openapi.Schema{Type: "string"}},
+			{Status: 304, Description: "Not modified"},
+			errorResponse(403), errorResponse(404), errorResponse(409),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/batches/:id", OperationID: "getBatch",
+		Summary: "Get a batch's progress", Tags: []string{"Batches"}, Scope: string(lib.ScopeRead),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The batch and its jobs", Body: models.BatchStatusResponse{}},
+			errorResponse(404), errorResponse(429),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/usage", OperationID: "getUsage",
+		Summary: "Get your API key's quota and usage", Tags: []string{"Account"}, Scope: string(lib.ScopeRead),
+		Responses: []openapi.Response{
+			{Status: 200, Description: "Quota, usage and when each period resets", Body: models.UsageResponse{}},
+			errorResponse(429),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/usage/report", OperationID: "getUsageReport",
+		Summary: "Report an API key's metered usage per day", Tags: []string{"Account"}, Scope: string(lib.ScopeRead),
+		Description: "Requests, errors, bytes and billable media seconds per UTC day. Reporting on another key needs the admin scope.",
+		Query: []openapi.Parameter{
+			query("from", "First day, as YYYY-MM-DD; defaults to 30 days before to", &openapi.Schema{Type: "string", Format: "date"}),
+			query("to", "Last day, as YYYY-MM-DD; defaults to today", &openapi.Schema{Type: "string", Format: "date"}),
+			query("key_id", "Key to report on instead of the caller's", &openapi.Schema{Type: "string"}),
+		},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "Usage per day with days without usage left out", Body: models.UsageReport{}},
+			errorResponse(400), errorResponse(429),
+		},
+	},
+	{
+		Method: "GET", Path: "/v1/webhooks/deliveries", OperationID: "listWebhookDeliveries",
+		Summary: "List your webhook deliveries", Tags: []string{"Webhooks"}, Scope: string(lib.ScopeRead),
+		Description: "Deliveries of your jobs' and batches' webhooks, newest first. Failed deliveries are retried for about eight hours and then dead-lettered.",
+		Query: []openapi.Parameter{
+			query("status", "Only deliveries in this status", &openapi.Schema{Type: "string", Enum: []string{"pending", "delivered", "dead"}}),
+			query("limit", "Number of deliveries, 1 to 500; defaults to 50", &openapi.Schema{Type: "integer"}),
+		},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The deliveries", Body: models.WebhookDeliveryList{}},
+			errorResponse(400), errorResponse(429),
+		},
+	},
+	{
+		Method: "POST", Path: "/v1/webhooks/deliveries/:delivery_id/replay", OperationID: "replayWebhookDelivery",
+		Summary: "Deliver a webhook again", Tags: []string{"Webhooks"}, Scope: string(lib.ScopeSubmit),
+		Description: "Makes a delivered or dead-lettered delivery pending again with a fresh set of attempts, and returns it after the first one.",
+		Responses: []openapi.Response{
+			{Status: 200, Description: "The delivery after its first new attempt", Body: models.WebhookDelivery{}},
+			errorResponse(404), errorResponse(409), errorResponse(429),
+		},
+	},
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// QuotaRemainingHeader lists what is left of each limited quota after a
+// submission, such as "jobs_per_day=4, media_minutes_per_month=118".
+const QuotaRemainingHeader = "X-Quota-Remaining"
+
+var quotaTracker *lib.QuotaTracker
+
+// UseQuotas enforces t on every submission. A nil tracker disables quotas.
+func UseQuotas(t *lib.QuotaTracker) {
+	quotaTracker = t
+}
+
+// NewQuotaTracker returns a tracker that counts jobs and batches as
+// concurrent until they finish in the job queue.
+func NewQuotaTracker(fallback models.Quota) *lib.QuotaTracker {
+	return lib.NewQuotaTracker(fallback, func(id string) bool {
+		queue := jobs.GetQueue()
+		if job, err := queue.GetJob(id); err == nil {
+			return job.IsTerminal()
+		}
+		progress, err := queue.BatchProgress(id)
+		return err == nil && progress.Done()
+	})
+}
+
+// admitJobs counts jobs costing minutes media minutes against the caller's
+// quota. When they do not fit it responds 402 or 429 and returns false,
+// along with the error to return from the handler.
+func admitJobs(c *fiber.Ctx, jobIDs []string, minutes int) (bool, error) {
+	return admit(c, func(key *lib.APIKey) (models.QuotaUsage, *models.QuotaExceededError) {
+		return quotaTracker.Admit(key, jobIDs, minutes)
+	})
+}
+
+// admitBatch counts a batch's children against the caller's quota like
+// admitJobs, with the batch taking a single concurrent job.
+func admitBatch(c *fiber.Ctx, batchID string, children int) (bool, error) {
+	return admit(c, func(key *lib.APIKey) (models.QuotaUsage, *models.QuotaExceededError) {
+		return quotaTracker.AdmitBatch(key, batchID, children)
+	})
+}
+
+func admit(c *fiber.Ctx, count func(*lib.APIKey) (models.QuotaUsage, *models.QuotaExceededError)) (bool, error) {
+	key := lib.CurrentAPIKey(c)
+	if quotaTracker == nil || key == nil {
+		return true, nil
+	}
+
+	quota := quotaTracker.Quota(key)
+	usage, exceeded := count(key)
+	setQuotaRemaining(c, quota.Remaining(usage))
+	if exceeded == nil {
+		return true, nil
+	}
+
+	body := QuotaErrorResponse{
+		Error:     "Quota exceeded: " + strings.ReplaceAll(exceeded.Limit, "_", " ") + " limit of " + strconv.Itoa(exceeded.Max),
+		Limit:     exceeded.Limit,
+		Remaining: exceeded.Remaining,
+	}
+	if !exceeded.ResetsAt.IsZero() {
+		body.ResetsAt = &exceeded.ResetsAt
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(time.Until(exceeded.ResetsAt).Seconds())+1))
+	}
+	return false, c.Status(exceeded.Status()).JSON(body)
+}
+
+// callerQuota returns the quota applied to the caller's submissions, which
+// is unlimited when quotas are disabled.
+func callerQuota(c *fiber.Ctx) models.Quota {
+	key := lib.CurrentAPIKey(c)
+	if quotaTracker == nil || key == nil {
+		return models.Quota{}
+	}
+	return quotaTracker.Quota(key)
+}
+
+func setQuotaRemaining(c *fiber.Ctx, remaining map[string]int) {
+	if len(remaining) == 0 {
+		return
+	}
+	parts := make([]string, 0, len(remaining))
+	for limit, n := range remaining {
+		parts = append(parts, limit+"="+strconv.Itoa(n))
+	}
+	sort.Strings(parts)
+	c.Set(QuotaRemainingHeader, strings.Join(parts, ", "))
+}
+
+// GetUsage reports the caller's quota, what it has used and when each
+// period resets.
+func GetUsage(c *fiber.Ctx) error {
+	key := lib.CurrentAPIKey(c)
+	response := models.UsageResponse{KeyID: lib.APIKeyID(c)}
+
+	now := time.Now()
+	response.Usage = models.QuotaUsage{
+		DayResetsAt:   models.DayStart(now).AddDate(0, 0, 1),
+		MonthResetsAt: models.MonthStart(now).AddDate(0, 1, 0),
+	}
+	if quotaTracker != nil && key != nil {
+		response.Quota = quotaTracker.Quota(key)
+		response.Usage = quotaTracker.Usage(key.ID)
+	}
+	response.Remaining = response.Quota.Remaining(response.Usage)
+
+	return c.JSON(response)
+}
+
+// chargeBatchChild checks the length of a batch child's media and charges
+// its media minutes, neither known when the batch is admitted, once a
+// worker picks it up.
+func chargeBatchChild(job *jobs.Job) error {
+	if quotaTracker == nil || job.BatchID == "" || job.APIKeyID == "" {
+		return nil
+	}
+	duration, err := lib.GetVideoDuration(job.URL)
+	if err != nil || duration == 0 {
+		// The pipeline reports unreachable media itself, and media of
+		// unknown length is charged once it has run.
+		return nil
+	}
+	job.MediaSeconds = duration
+	jobs.GetQueue().Update(job.ID, func(j *jobs.Job) error {
+		j.MediaSeconds = duration
+		return nil
+	})
+	return quotaTracker.ChargeMedia(job.APIKeyID, duration)
+}
+
+// chargeMeasuredMedia checks the length of a job's media and charges its
+// media minutes once it has run, when the length was not known before, as
+// with streams that report no duration. The length is the end of the last
+// segment.
+func chargeMeasuredMedia(job *jobs.Job, segments []models.Segment) error {
+	if job.MediaSeconds > 0 || len(segments) == 0 || quotaTracker == nil || job.APIKeyID == "" {
+		return nil
+	}
+	seconds := int(math.Ceil(segments[len(segments)-1].End))
+	return quotaTracker.ChargeMedia(job.APIKeyID, seconds)
+}
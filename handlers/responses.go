@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"time"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// ErrorResponse is the body of a rejected request. Fields lists the invalid
+// fields of a request body, when known. Failures of the transcription
+// itself are reported as a models.Problem instead.
+type ErrorResponse struct {
+	Error  string              `json:"error"`
+	Fields []models.FieldError `json:"fields,omitempty"`
+}
+
+// QuotaErrorResponse is the body of a submission rejected by its key's
+// quota. ResetsAt is when the exhausted period ends; it is absent for the
+// concurrent job limit.
+type QuotaErrorResponse struct {
+	Error     string     `json:"error"`
+	Limit     string     `json:"limit"`
+	Remaining int        `json:"remaining"`
+	ResetsAt  *time.Time `json:"resets_at,omitempty"`
+}
+
+// HealthResponse is the body of GET /health.
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// JobStatusResponse reports a job's progress and, once it has completed,
+// its transcript. QueuePosition and EstimatedStartAt are set while the job
+// waits for a worker.
+type JobStatusResponse struct {
+	ID               string                       `json:"id"`
+	Status           jobs.JobStatus               `json:"status"`
+	Priority         models.Priority              `json:"priority"`
+	Options          *models.TranscriptionOptions `json:"options,omitempty"`
+	QueuePosition    int                          `json:"queue_position,omitempty"`
+	EstimatedStartAt *time.Time                   `json:"estimated_start_at,omitempty"`
+	Stage            string                       `json:"stage,omitempty"`
+	Transcript       string                       `json:"transcript,omitempty"`
+	Segments         []models.Segment             `json:"segments,omitempty"`
+	Error            string                       `json:"error,omitempty"`
+	Problem          *models.Problem              `json:"problem,omitempty"`
+	CreatedAt        time.Time                    `json:"created_at"`
+	UpdatedAt        time.Time                    `json:"updated_at"`
+	StartedAt        *time.Time                   `json:"started_at,omitempty"`
+	StageStartedAt   *time.Time                   `json:"stage_started_at,omitempty"`
+	CompletedAt      *time.Time                   `json:"completed_at,omitempty"`
+	SubtitleFiles    *lib.SubtitleFiles           `json:"subtitle_files,omitempty"`
+}
+
+// QueuePositionResponse reports where a job stands in the dispatch queue.
+// A zero position means a worker has already picked it up.
+type QueuePositionResponse struct {
+	ID               string     `json:"id"`
+	QueuePosition    int        `json:"queue_position"`
+	EstimatedStartAt *time.Time `json:"estimated_start_at,omitempty"`
+}
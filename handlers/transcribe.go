@@ -2,15 +2,40 @@ package handlers
 
 import (
 	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 
+	"omnitranscripts/config"
 	"omnitranscripts/jobs"
 	"omnitranscripts/lib"
 	"omnitranscripts/models"
 )
 
+const (
+	// DefaultSyncWait is how long PostTranscribe waits for short videos
+	// when the client expresses no preference.
+	DefaultSyncWait = 2 * time.Minute
+	// MaxSyncWait caps the wait a client can ask for.
+	MaxSyncWait = 5 * time.Minute
+)
+
+// serverConfig is the configuration the handlers read, loaded once at
+// startup rather than on every request.
+var serverConfig = &config.Config{}
+
+// UseConfig gives the handlers the server's configuration.
+func UseConfig(cfg *config.Config) {
+	serverConfig = cfg
+}
+
 func PostTranscribe(c *fiber.Ctx) error {
 	var req models.TranscribeRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -25,53 +50,180 @@ func PostTranscribe(c *fiber.Ctx) error {
 		})
 	}
 
+	if !req.Priority.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid priority, expected low, normal or high",
+		})
+	}
+
+	if problems := req.TranscriptionOptions.Validate(); len(problems) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid transcription options",
+			"fields": problems,
+		})
+	}
+
+	wait, waitRequested, ok := requestedWait(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid wait, expected a number of seconds",
+		})
+	}
+
+	webhook, err := newJobWebhook(req.WebhookURL, req.WebhookHeaders, req.WebhookEvents)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	job := jobs.NewJob(req.URL)
+	job.Owner = lib.Owner(c)
+	job.APIKeyID = lib.APIKeyID(c)
+	job.Options = req.TranscriptionOptions
+	job.Webhook = webhook
+	if req.Priority != "" {
+		job.Priority = req.Priority
+	}
 	queue := jobs.GetQueue()
-	queue.AddJob(job)
 
 	duration, err := lib.GetVideoDuration(req.URL)
+	if err == nil {
+		err = callerQuota(c).CheckLength(duration)
+	}
 	if err != nil {
-		job.MarkError(err)
-		queue.UpdateJob(job)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Failed to get video information",
-		})
+		log.Printf("request %s: job %s rejected: %v", requestID(c), job.ID, err)
+		queue.AddJob(job)
+		failed, failErr := queue.Fail(job.ID, err)
+		if failErr != nil {
+			return respondProblem(c, models.NewProblem(err))
+		}
+		return respondProblem(c, failed.Problem)
 	}
 
-	if duration <= 120 {
-		go processTranscriptionSync(job)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-		defer cancel()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return c.JSON(models.TranscribeResponse{
-					JobID: job.ID,
-				})
-			default:
-				currentJob, _ := queue.GetJob(job.ID)
-				if currentJob.IsComplete() {
-					if currentJob.Status == jobs.StatusError {
-						return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-							"error": currentJob.Error,
-						})
-					}
-					return c.JSON(models.TranscribeResponse{
-						Transcript: currentJob.Transcript,
-						Segments:   currentJob.Segments,
-					})
-				}
-				time.Sleep(1 * time.Second)
+	job.MediaSeconds = duration
+
+	if admitted, err := admitJobs(c, []string{job.ID}, models.MediaMinutes(duration)); !admitted {
+		return err
+	}
+	queue.AddJob(job)
+
+	return submitJob(c, job, duration, wait, waitRequested)
+}
+
+// dispatch hands queued jobs to the dispatcher, first giving the key that
+// submitted them its scheduling weight.
+func dispatch(c *fiber.Ctx, queued ...*jobs.Job) {
+	d := jobs.GetDispatcher()
+	if key := lib.CurrentAPIKey(c); key != nil {
+		d.SetWeight(key.ID, key.Weight)
+	}
+	for _, job := range queued {
+		d.Submit(job)
+	}
+}
+
+// submitJob hands a queued job to the dispatcher and either answers 202 at
+// once or holds the request open for the result. Short media is waited on
+// by default unless the client expressed a preference; media of unknown
+// length is not.
+func submitJob(c *fiber.Ctx, job *jobs.Job, duration int, wait time.Duration, waitRequested bool) error {
+	if !waitRequested && duration > 0 && duration <= serverConfig.SyncMaxDuration {
+		wait = DefaultSyncWait
+	}
+
+	queue := jobs.GetQueue()
+	dispatch(c, job)
+
+	if wait <= 0 {
+		return respondAccepted(c, job.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), wait)
+	defer cancel()
+
+	finished, err := queue.Wait(ctx, job.ID)
+	if err != nil {
+		// Still running; hand the client the job to follow up on.
+		return respondAccepted(c, job.ID)
+	}
+
+	if finished.Status != jobs.StatusCompleted {
+		problem := finished.Problem
+		if problem == nil {
+			problem = models.CancelledProblem(finished.ID)
+		}
+		return respondProblem(c, problem)
+	}
+	return c.JSON(models.TranscribeResponse{
+		Transcript: finished.Transcript,
+		Segments:   finished.Segments,
+	})
+}
+
+// requestedWait returns how long the client asked PostTranscribe to hold
+// the request open for the result: ?wait=<seconds> first, then a Prefer
+// header with respond-async or wait=<seconds>. requested is false when the
+// client expressed no preference, and ok is false for a malformed ?wait.
+func requestedWait(c *fiber.Ctx) (wait time.Duration, requested bool, ok bool) {
+	if value := c.Query("wait"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return 0, false, false
+		}
+		return clampWait(seconds), true, true
+	}
+
+	for _, preference := range strings.Split(c.Get("Prefer"), ",") {
+		preference = strings.ToLower(strings.TrimSpace(preference))
+		if preference == "respond-async" {
+			c.Set("Preference-Applied", "respond-async")
+			return 0, true, true
+		}
+		if value, found := strings.CutPrefix(preference, "wait="); found {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+				c.Set("Preference-Applied", "wait="+value)
+				return clampWait(seconds), true, true
 			}
 		}
-	} else {
-		go processTranscriptionAsync(job)
-		return c.JSON(models.TranscribeResponse{
-			JobID: job.ID,
-		})
 	}
+
+	return 0, false, true
+}
+
+func clampWait(seconds int) time.Duration {
+	wait := time.Duration(seconds) * time.Second
+	if wait > MaxSyncWait {
+		return MaxSyncWait
+	}
+	return wait
+}
+
+// respondProblem answers with an RFC 7807 problem+json body, tagged with
+// the request's ID.
+func respondProblem(c *fiber.Ctx, problem *models.Problem) error {
+	problem = problem.Clone()
+	problem.RequestID = requestID(c)
+	if problem.Instance == "" {
+		problem.Instance = c.Path()
+	}
+	return c.Status(problem.Status).JSON(problem, models.ProblemContentType)
+}
+
+// requestID returns the ID the requestid middleware gave the request, or
+// "" without it.
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestid.ConfigDefault.ContextKey).(string)
+	return id
+}
+
+// respondAccepted answers 202 with the job ID and a Location header
+// pointing at the job's status.
+func respondAccepted(c *fiber.Ctx, jobID string) error {
+	c.Location("/" + lib.APIVersion(c) + "/transcribe/" + jobID)
+	return c.Status(fiber.StatusAccepted).JSON(models.TranscribeResponse{
+		JobID: jobID,
+	})
 }
 
 func GetTranscribeJob(c *fiber.Ctx) error {
@@ -84,51 +236,262 @@ func GetTranscribeJob(c *fiber.Ctx) error {
 
 	queue := jobs.GetQueue()
 	job, err := queue.GetJob(jobID)
-	if err != nil {
+	if err != nil || job.Owner != lib.Owner(c) {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Job not found",
 		})
 	}
 
-	response := fiber.Map{
-		"id":         job.ID,
-		"status":     job.Status,
-		"created_at": job.CreatedAt,
+	response := JobStatusResponse{
+		ID:             job.ID,
+		Status:         job.Status,
+		Priority:       job.Priority,
+		Stage:          job.Stage,
+		CreatedAt:      job.CreatedAt,
+		UpdatedAt:      job.UpdatedAt,
+		StartedAt:      job.StartedAt,
+		StageStartedAt: job.StageStartedAt,
+	}
+	if !job.Options.IsZero() {
+		response.Options = &job.Options
+	}
+
+	if job.Status == jobs.StatusQueued {
+		if position, start, err := jobs.GetDispatcher().Position(job.ID); err == nil {
+			response.QueuePosition = position
+			response.EstimatedStartAt = &start
+		}
 	}
 
-	if job.Status == jobs.StatusComplete {
-		response["transcript"] = job.Transcript
-		response["segments"] = job.Segments
-		response["completed_at"] = job.CompletedAt
-	} else if job.Status == jobs.StatusError {
-		response["error"] = job.Error
-		response["completed_at"] = job.CompletedAt
+	switch job.Status {
+	case jobs.StatusCompleted:
+		response.Transcript = job.Transcript
+		response.Segments = job.Segments
+		response.CompletedAt = job.CompletedAt
+		response.SubtitleFiles = lib.NewSubtitleFiles(serverConfig.PublicBaseURL, urlSigner, job)
+	case jobs.StatusFailed:
+		response.Error = job.Error
+		response.Problem = job.Problem
+		response.CompletedAt = job.CompletedAt
+	case jobs.StatusCancelled:
+		response.CompletedAt = job.CompletedAt
 	}
 
 	return c.JSON(response)
 }
 
-func processTranscriptionSync(job *jobs.Job) {
-	processTranscription(job)
+// CancelTranscribeJob cancels one of the caller's queued or running jobs.
+// A running job stops before its next stage, or at once while downloading,
+// and any result it produced is dropped.
+func CancelTranscribeJob(c *fiber.Ctx) error {
+	queue := jobs.GetQueue()
+	job, err := queue.GetJob(c.Params("job_id"))
+	if err != nil || job.Owner != lib.Owner(c) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+
+	wasQueued := false
+	cancelled, err := queue.Update(job.ID, func(j *jobs.Job) error {
+		wasQueued = j.Status == jobs.StatusQueued
+		return j.Cancel()
+	})
+	if err != nil {
+		status := job.Status
+		if current, getErr := queue.GetJob(job.ID); getErr == nil {
+			status = current.Status
+		}
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  "Job has already finished",
+			"status": status,
+		})
+	}
+
+	if wasQueued {
+		releaseQueuedJob(job)
+	}
+
+	return c.JSON(cancelled.Summary())
+}
+
+// releaseQueuedJob cleans up after a job that finished before a worker
+// started it, which the worker would otherwise have done.
+func releaseQueuedJob(job *jobs.Job) {
+	if d := jobs.GetDispatcher(); d != nil {
+		d.Remove(job.ID)
+	}
+	if job.MediaPath != "" {
+		os.Remove(job.MediaPath)
+	}
+	if job.BatchID != "" {
+		finishBatchChild(job.BatchID)
+	}
+}
+
+// ListTranscribeJobs returns the caller's jobs, newest first, filtered by
+// status, creation time range, source URL host and transcript text.
+func ListTranscribeJobs(c *fiber.Ctx) error {
+	filter := jobs.ListFilter{
+		Owner:  lib.Owner(c),
+		Status: jobs.JobStatus(c.Query("status")),
+		Host:   c.Query("host"),
+		Query:  c.Query("q"),
+		Cursor: c.Query("cursor"),
+		Limit:  c.QueryInt("limit", jobs.DefaultListLimit),
+	}
+
+	if filter.Status != "" && !filter.Status.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid status filter",
+		})
+	}
+
+	for param, target := range map[string]*time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid " + param + ", expected RFC 3339 timestamp",
+			})
+		}
+		*target = t
+	}
+
+	page, next, err := jobs.GetQueue().SearchJobs(filter)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid cursor",
+		})
+	}
+
+	response := models.JobListResponse{
+		Jobs:       make([]models.JobSummary, 0, len(page)),
+		NextCursor: next,
+	}
+	for _, job := range page {
+		response.Jobs = append(response.Jobs, job.Summary())
+	}
+
+	return c.JSON(response)
 }
 
-func processTranscriptionAsync(job *jobs.Job) {
-	processTranscription(job)
+// StartWorkers starts the dispatcher that runs submitted jobs on count
+// concurrent workers.
+func StartWorkers(count int) {
+	jobs.StartDispatcher(count, processTranscription)
+}
+
+// runPipeline transcribes a job's media, reporting to progress as it goes
+// and stopping between stages once ctx is done. Tests replace it to keep
+// the pipeline's tools and the network out of them.
+var runPipeline = func(ctx context.Context, job *jobs.Job, progress lib.Progress) (string, []models.Segment, error) {
+	workDir := lib.TenantDir(serverConfig.WorkDir, job.Owner)
+	if job.MediaPath != "" {
+		defer os.Remove(job.MediaPath)
+		return lib.ProcessMediaFileWithStages(ctx, job.MediaPath, job.ID, workDir, job.Options, progress)
+	}
+	return lib.ProcessTranscriptionWithStages(ctx, job.URL, job.ID, workDir, job.Options, progress)
 }
 
 func processTranscription(job *jobs.Job) {
 	queue := jobs.GetQueue()
 
-	job.MarkRunning()
-	queue.UpdateJob(job)
-
-	transcript, segments, err := lib.ProcessTranscription(job.URL, job.ID)
+	started, err := queue.Start(job.ID)
 	if err != nil {
-		job.MarkError(err)
-		queue.UpdateJob(job)
+		// Cancelled or otherwise finished before a worker picked it up.
+		if job.MediaPath != "" {
+			os.Remove(job.MediaPath)
+		}
 		return
 	}
 
-	job.MarkComplete(transcript, segments)
-	queue.UpdateJob(job)
+	startTime := time.Now()
+	notifier := newJobNotifier(started)
+	notifier.started(started)
+
+	progress := lib.Progress{
+		OnStage: func(stage string) {
+			queue.EnterStage(job.ID, stage)
+		},
+		OnSegment: func(segment models.Segment) {
+			queue.PublishSegment(job.ID, segment)
+		},
+	}
+
+	ctx, release := queue.RunContext(context.Background(), job.ID)
+	defer release()
+
+	var transcript string
+	var segments []models.Segment
+	err = chargeBatchChild(job)
+	if err == nil {
+		transcript, segments, err = runPipeline(ctx, job, progress)
+	}
+	if err == nil {
+		err = chargeMeasuredMedia(job, segments)
+	}
+	switch {
+	case ctx.Err() != nil:
+		// Cancelled, or failed by an operator, while it ran; the job
+		// already has its outcome.
+		log.Printf("job %s stopped: %v", job.ID, err)
+	case err != nil:
+		// The job's problem omits the cause; keep it for operators.
+		log.Printf("job %s failed: %v", job.ID, err)
+		queue.Fail(job.ID, err)
+	default:
+		if completed, err := queue.Complete(job.ID, transcript, segments); err == nil {
+			recordJobUsage(completed)
+		}
+	}
+
+	if finished, err := queue.GetJob(job.ID); err == nil {
+		notifier.finished(finished, time.Since(startTime))
+	}
+
+	if job.BatchID != "" {
+		finishBatchChild(job.BatchID)
+	}
+}
+
+// DownloadTranscript serves a completed job's transcript as txt, srt, vtt
+// or json, with ETag and Range support.
+func DownloadTranscript(c *fiber.Ctx) error {
+	format, ok := lib.ParseTranscriptFormat(c.Params("format"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unknown transcript format",
+		})
+	}
+
+	job, err := jobs.GetQueue().GetJob(c.Params("job_id"))
+	if err != nil || job.Owner != lib.Owner(c) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+
+	return serveTranscript(c, job, format)
+}
+
+// serveTranscript writes a job's transcript in format, or 409 while the job
+// has not completed.
+func serveTranscript(c *fiber.Ctx, job *jobs.Job, format lib.SubtitleFormat) error {
+	if job.Status != jobs.StatusCompleted {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  "Transcript is not available until the job completes",
+			"status": job.Status,
+		})
+	}
+
+	return adaptor.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lib.ServeTranscript(w, r, job, format)
+	})(c)
 }
@@ -2,29 +2,56 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fasthttp/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"omnitranscripts/config"
+	"omnitranscripts/engine"
 	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
 	"omnitranscripts/models"
 )
 
 func setupTestApp() *fiber.App {
 	app := fiber.New(fiber.Config{
-		DisableStartupMessage: true,
+		DisableStartupMessage:        true,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 	})
 
 	jobs.Initialize()
-
-	app.Post("/transcribe", PostTranscribe)
+	UseConfig(config.Load())
+	StartWorkers(2)
+
+	app.Get("/transcribe", ListTranscribeJobs)
+	idempotent := lib.IdempotencyMiddleware(lib.NewIdempotencyStore(time.Hour))
+	app.Post("/transcribe", idempotent, PostTranscribe)
+	app.Post("/transcribe/batch", idempotent, PostTranscribeBatch)
+	app.Post("/transcribe/upload", PostTranscribeUpload)
 	app.Get("/transcribe/:job_id", GetTranscribeJob)
+	app.Get("/transcribe/:job_id/events", StreamJobEvents)
+	app.Get("/transcribe/:job_id/transcript.:format", DownloadTranscript)
+	app.Get("/batches/:id", GetBatch)
+	app.Get("/ws", RequireWebSocket, JobEventsSocket)
 
 	return app
 }
@@ -117,29 +144,1306 @@ func TestJobQueue_Operations(t *testing.T) {
 	retrievedJob, err := queue.GetJob(job.ID)
 	require.NoError(t, err)
 	assert.Equal(t, job.ID, retrievedJob.ID)
-	assert.Equal(t, jobs.StatusPending, retrievedJob.Status)
+	assert.Equal(t, jobs.StatusQueued, retrievedJob.Status)
 
-	job.MarkRunning()
-	queue.UpdateJob(job)
+	_, err = queue.Start(job.ID)
+	require.NoError(t, err)
+
+	_, err = queue.EnterStage(job.ID, models.StageDownload)
+	require.NoError(t, err)
 
 	retrievedJob, err = queue.GetJob(job.ID)
 	require.NoError(t, err)
 	assert.Equal(t, jobs.StatusRunning, retrievedJob.Status)
+	assert.Equal(t, models.StageDownload, retrievedJob.Stage)
+	assert.NotNil(t, retrievedJob.StartedAt)
+	assert.NotNil(t, retrievedJob.StageStartedAt)
 
 	segments := []models.Segment{
 		{Start: 0.0, End: 5.0, Text: "Test segment"},
 	}
-	job.MarkComplete("Test transcript", segments)
-	queue.UpdateJob(job)
+	_, err = queue.Complete(job.ID, "Test transcript", segments)
+	require.NoError(t, err)
 
 	retrievedJob, err = queue.GetJob(job.ID)
 	require.NoError(t, err)
-	assert.Equal(t, jobs.StatusComplete, retrievedJob.Status)
+	assert.Equal(t, jobs.StatusCompleted, retrievedJob.Status)
 	assert.Equal(t, "Test transcript", retrievedJob.Transcript)
 	assert.Len(t, retrievedJob.Segments, 1)
+	assert.NotNil(t, retrievedJob.CompletedAt)
+}
+
+func TestJobQueue_InvalidTransitions(t *testing.T) {
+	jobs.Initialize()
+	queue := jobs.GetQueue()
+
+	job := jobs.NewJob("https://youtube.com/watch?v=test")
+	queue.AddJob(job)
+
+	_, err := queue.Complete(job.ID, "too early", nil)
+	assert.ErrorIs(t, err, models.ErrInvalidTransition, "queued jobs cannot complete")
+
+	_, err = queue.EnterStage(job.ID, models.StageDownload)
+	assert.ErrorIs(t, err, models.ErrInvalidTransition, "stages only apply to running jobs")
+
+	_, err = queue.Cancel(job.ID)
+	require.NoError(t, err)
+
+	_, err = queue.Start(job.ID)
+	assert.ErrorIs(t, err, models.ErrInvalidTransition, "terminal jobs cannot restart")
+
+	retrievedJob, err := queue.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StatusCancelled, retrievedJob.Status)
+}
+
+func TestJobQueue_SnapshotsAreIsolated(t *testing.T) {
+	jobs.Initialize()
+	queue := jobs.GetQueue()
+
+	job := jobs.NewJob("https://youtube.com/watch?v=test")
+	queue.AddJob(job)
+	queue.Start(job.ID)
+	queue.Complete(job.ID, "original", []models.Segment{{Text: "original"}})
+
+	snapshot, err := queue.GetJob(job.ID)
+	require.NoError(t, err)
+	snapshot.Transcript = "mutated"
+	snapshot.Segments[0].Text = "mutated"
+
+	job.Status = jobs.StatusFailed
+
+	retrievedJob, err := queue.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StatusCompleted, retrievedJob.Status)
+	assert.Equal(t, "original", retrievedJob.Transcript)
+	assert.Equal(t, "original", retrievedJob.Segments[0].Text)
+}
+
+func TestListTranscribeJobs_FiltersAndPagination(t *testing.T) {
+	app := setupTestApp()
+	queue := jobs.GetQueue()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, url := range []string{
+		"https://www.youtube.com/watch?v=a",
+		"https://vimeo.com/1",
+		"https://youtu.be/b",
+	} {
+		job := jobs.NewJob(url)
+		job.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		queue.AddJob(job)
+		queue.Start(job.ID)
+		queue.Complete(job.ID, "episode "+url, nil)
+	}
+
+	foreign := jobs.NewJob("https://www.youtube.com/watch?v=c")
+	foreign.Owner = "someone-else"
+	queue.AddJob(foreign)
+
+	list := func(query string) models.JobListResponse {
+		req := httptest.NewRequest(http.MethodGet, "/transcribe"+query, nil)
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+
+		var result models.JobListResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result
+	}
+
+	all := list("")
+	require.Len(t, all.Jobs, 3)
+	assert.Equal(t, "https://youtu.be/b", all.Jobs[0].URL, "newest job first")
+	assert.Empty(t, all.NextCursor)
+
+	assert.Len(t, list("?host=youtube.com").Jobs, 1)
+	assert.Len(t, list("?q=VIMEO").Jobs, 1)
+	assert.Len(t, list("?status=running").Jobs, 0)
+	assert.Len(t, list("?created_after=2024-01-01T12:00:30Z").Jobs, 2)
+
+	first := list("?limit=2")
+	require.Len(t, first.Jobs, 2)
+	require.NotEmpty(t, first.NextCursor)
+
+	second := list("?limit=2&cursor=" + first.NextCursor)
+	require.Len(t, second.Jobs, 1)
+	assert.Equal(t, all.Jobs[2].ID, second.Jobs[0].ID)
+	assert.Empty(t, second.NextCursor)
+
+	req := httptest.NewRequest(http.MethodGet, "/transcribe?status=bogus", nil)
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestPostTranscribeBatch_Validation(t *testing.T) {
+	app := setupTestApp()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"Neither urls nor playlist", `{}`},
+		{"Both urls and playlist", `{"urls":["https://youtu.be/a"],"playlist_url":"https://youtube.com/playlist?list=x"}`},
+		{"Invalid child URL", `{"urls":["https://youtu.be/a","not-a-url"]}`},
+		{"Invalid webhook URL", `{"urls":["https://youtu.be/a"],"webhook_url":"ftp://example.com"}`},
+		{"Job webhook headers", `{"urls":["https://youtu.be/a"],"webhook_url":"https://example.com/hook","webhook_headers":{"X-Token":"t"}}`},
+		{"Job webhook events", `{"urls":["https://youtu.be/a"],"webhook_events":["job.failed"]}`},
+		{"Invalid options", `{"urls":["https://youtu.be/a"],"task":"summarize"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/transcribe/batch", bytes.NewReader([]byte(tt.body)))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			require.NoError(t, err)
+			assert.Equal(t, 400, resp.StatusCode)
+		})
+	}
+}
+
+func TestPostTranscribeBatch_AppliesOptionsToChildren(t *testing.T) {
+	app := setupTestApp()
+	_, release := blockingDispatcher(t)
+	defer release()
+
+	body := `{"urls": ["https://youtu.be/a", "https://youtu.be/b"], "language": "de", "time_range": {"start": 30, "end": 90}}`
+	req := httptest.NewRequest(http.MethodPost, "/transcribe/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+
+	var batch models.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	require.Len(t, batch.JobIDs, 2)
+	for _, id := range batch.JobIDs {
+		job, err := jobs.GetQueue().GetJob(id)
+		require.NoError(t, err)
+		assert.Equal(t, "de", job.Options.Language)
+		require.NotNil(t, job.Options.TimeRange)
+		assert.Equal(t, 90.0, job.Options.TimeRange.End)
+	}
+}
+
+func TestBatch_ProgressAndCompletion(t *testing.T) {
+	app := setupTestApp()
+	queue := jobs.GetQueue()
+
+	children := []*jobs.Job{
+		jobs.NewJob("https://youtu.be/a"),
+		jobs.NewJob("https://youtu.be/b"),
+	}
+	batch := jobs.NewBatch("", "")
+	queue.AddBatch(batch, children)
+
+	queue.Start(children[0].ID)
+	queue.Complete(children[0].ID, "done", nil)
+
+	completed, _ := queue.CompleteBatch(batch.ID)
+	assert.False(t, completed, "batch with an outstanding child must not complete")
+
+	req := httptest.NewRequest(http.MethodGet, "/batches/"+batch.ID, nil)
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var status models.BatchStatusResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, "running", status.Status)
+	assert.Equal(t, 2, status.Total)
+	assert.Equal(t, 1, status.Completed)
+	assert.Equal(t, 1, status.Queued)
+	assert.Equal(t, 50.0, status.Progress)
+	assert.Len(t, status.Jobs, 2)
+
+	queue.Fail(children[1].ID, assert.AnError)
+
+	completed, progress := queue.CompleteBatch(batch.ID)
+	assert.True(t, completed)
+	assert.Equal(t, 1, progress.Failed)
+
+	completed, _ = queue.CompleteBatch(batch.ID)
+	assert.False(t, completed, "completion must only fire once")
 }
 
 // Benchmark tests
+// blockingDispatcher starts a single-worker dispatcher whose worker is held
+// on the first job it takes, so everything submitted afterwards stays queued.
+func blockingDispatcher(t *testing.T) (*jobs.Dispatcher, func()) {
+	jobs.Initialize()
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	d := jobs.StartDispatcher(1, func(job *jobs.Job) {
+		started <- struct{}{}
+		<-release
+	})
+
+	blocker := jobs.NewJob("https://youtube.com/watch?v=blocker")
+	jobs.GetQueue().AddJob(blocker)
+	d.Submit(blocker)
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not pick up the first job")
+	}
+	return d, func() { close(release) }
+}
+
+func submitFor(d *jobs.Dispatcher, owner string, priority models.Priority) *jobs.Job {
+	job := jobs.NewJob("https://youtube.com/watch?v=" + owner)
+	job.Owner = owner
+	job.Priority = priority
+	jobs.GetQueue().AddJob(job)
+	d.Submit(job)
+	return job
+}
+
+func TestDispatcher_FairShareAcrossKeys(t *testing.T) {
+	d, release := blockingDispatcher(t)
+	defer release()
+
+	// A backlog from one key must not delay another key's first job.
+	var backlog []*jobs.Job
+	for i := 0; i < 5; i++ {
+		backlog = append(backlog, submitFor(d, "bulk", models.PriorityNormal))
+	}
+	small := submitFor(d, "small", models.PriorityNormal)
+
+	position, start, err := d.Position(small.ID)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, position, 2)
+	assert.True(t, start.After(time.Now()), "all workers are busy")
+
+	last, _, err := d.Position(backlog[len(backlog)-1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 6, last)
+
+	// Doubling a key's weight doubles its share while both have work.
+	d.SetWeight("small", 2)
+	extra := submitFor(d, "small", models.PriorityNormal)
+	extraPosition, _, err := d.Position(extra.ID)
+	require.NoError(t, err)
+	assert.Less(t, extraPosition, last)
+}
+
+func TestDispatcher_WeightsAreScheduledPerKey(t *testing.T) {
+	d, release := blockingDispatcher(t)
+	defer release()
+
+	// Two keys of one owner each keep their own weight, whichever of them
+	// submitted last.
+	d.SetWeight("key-heavy", 3)
+	d.SetWeight("key-light", 1)
+	submit := func(keyID string) *jobs.Job {
+		job := jobs.NewJob("https://youtube.com/watch?v=" + keyID)
+		job.Owner = "acme"
+		job.APIKeyID = keyID
+		jobs.GetQueue().AddJob(job)
+		d.Submit(job)
+		return job
+	}
+	var heavy, light []*jobs.Job
+	for i := 0; i < 4; i++ {
+		heavy = append(heavy, submit("key-heavy"))
+		light = append(light, submit("key-light"))
+	}
+
+	// With weights 3 and 1 the heavy key's first three jobs go before the
+	// light key's second.
+	lightSecond, _, err := d.Position(light[1].ID)
+	require.NoError(t, err)
+	heavyThird, _, err := d.Position(heavy[2].ID)
+	require.NoError(t, err)
+	assert.Less(t, heavyThird, lightSecond)
+
+	lightFirst, _, err := d.Position(light[0].ID)
+	require.NoError(t, err)
+	heavyFourth, _, err := d.Position(heavy[3].ID)
+	require.NoError(t, err)
+	assert.Less(t, lightFirst, heavyFourth, "the light key still gets its share")
+}
+
+func TestDispatcher_PriorityAndBump(t *testing.T) {
+	d, release := blockingDispatcher(t)
+	defer release()
+
+	low := submitFor(d, "key", models.PriorityLow)
+	normal := submitFor(d, "key", models.PriorityNormal)
+	high := submitFor(d, "key", models.PriorityHigh)
+
+	for want, job := range []*jobs.Job{high, normal, low} {
+		position, _, err := d.Position(job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, want+1, position)
+	}
+
+	other := submitFor(d, "other", models.PriorityLow)
+	require.NoError(t, d.Bump(other.ID))
+	position, _, err := d.Position(other.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, position, "bumped jobs skip the fair-share order")
+
+	assert.ErrorIs(t, d.Bump("missing"), jobs.ErrNotQueued)
+}
+
+func TestDispatcher_RequeueRefusesRunningJobs(t *testing.T) {
+	d, release := blockingDispatcher(t)
+	defer release()
+	queue := jobs.GetQueue()
+
+	running := queue.ListJobs()[0]
+	_, err := queue.Cancel(running.ID)
+	require.NoError(t, err)
+	called := false
+	_, err = d.Requeue(running.ID, func() (*jobs.Job, error) {
+		called = true
+		return queue.Update(running.ID, (*jobs.Job).Requeue)
+	})
+	assert.ErrorIs(t, err, jobs.ErrStillRunning)
+	assert.False(t, called, "a job still on a worker must not be requeued")
+
+	// A job cancelled while waiting is queued once, not twice.
+	waiting := submitFor(d, "key", models.PriorityNormal)
+	_, err = queue.Cancel(waiting.ID)
+	require.NoError(t, err)
+	requeued, err := d.Requeue(waiting.ID, func() (*jobs.Job, error) {
+		return queue.Update(waiting.ID, (*jobs.Job).Requeue)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StatusQueued, requeued.Status)
+	assert.Equal(t, 1, d.Status().Queued)
+}
+
+func TestPostTranscribe_InvalidPriority(t *testing.T) {
+	app := setupTestApp()
+
+	body := `{"url": "https://youtube.com/watch?v=test", "priority": "urgent"}`
+	req := httptest.NewRequest(http.MethodPost, "/transcribe", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestPostTranscribeBatch_IdempotencyKey(t *testing.T) {
+	app := setupTestApp()
+
+	send := func(key, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/transcribe/batch", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(lib.IdempotencyKeyHeader, key)
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		return resp
+	}
+
+	body := `{"urls": ["https://youtube.com/watch?v=one"]}`
+
+	first := send("retry-1", body)
+	require.Equal(t, fiber.StatusAccepted, first.StatusCode)
+	var original models.BatchResponse
+	require.NoError(t, json.NewDecoder(first.Body).Decode(&original))
+
+	again := send("retry-1", body)
+	require.Equal(t, fiber.StatusAccepted, again.StatusCode)
+	assert.Equal(t, "true", again.Header.Get(lib.IdempotentReplayedHeader))
+	var replayed models.BatchResponse
+	require.NoError(t, json.NewDecoder(again.Body).Decode(&replayed))
+	assert.Equal(t, original, replayed, "a retry returns the original batch")
+	assert.Len(t, jobs.GetQueue().ListJobs(), 1, "a retry must not create new jobs")
+
+	conflict := send("retry-1", `{"urls": ["https://youtube.com/watch?v=two"]}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, conflict.StatusCode)
+
+	// Failed requests are not stored, so the key stays usable.
+	invalid := send("retry-2", `{"urls": []}`)
+	assert.Equal(t, fiber.StatusBadRequest, invalid.StatusCode)
+	assert.Equal(t, fiber.StatusAccepted, send("retry-2", body).StatusCode)
+}
+
+func TestIdempotencyStore_ScopedPerAPIKey(t *testing.T) {
+	store := lib.NewIdempotencyStore(time.Hour)
+
+	stored, err := store.Reserve("key-a", "same", "fp-a")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	_, err = store.Reserve("key-a", "same", "fp-a")
+	assert.ErrorIs(t, err, lib.ErrIdempotencyKeyInFlight)
+
+	stored, err = store.Reserve("key-b", "same", "fp-b")
+	require.NoError(t, err, "another API key may use the same idempotency key")
+	assert.Nil(t, stored)
+
+	store.Complete("key-a", "same", &lib.IdempotentResponse{Status: 200, Body: []byte("{}")})
+	stored, err = store.Reserve("key-a", "same", "fp-a")
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, 200, stored.Status)
+
+	// Two keys of one owner keep their idempotency keys apart too.
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals(lib.LocalAPIKeyID, c.Get("X-Test-Key"))
+		c.Locals(lib.LocalOwner, "shared-owner")
+		return c.Next()
+	})
+	var calls int
+	app.Post("/", lib.IdempotencyMiddleware(lib.NewIdempotencyStore(time.Hour)), func(c *fiber.Ctx) error {
+		calls++
+		return c.SendString(fmt.Sprint(calls))
+	})
+	send := func(apiKey string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		req.Header.Set("X-Test-Key", apiKey)
+		req.Header.Set(lib.IdempotencyKeyHeader, "retry-1")
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		return resp
+	}
+
+	require.Equal(t, fiber.StatusOK, send("owner-key-1").StatusCode)
+	resp := send("owner-key-2")
+	assert.Empty(t, resp.Header.Get(lib.IdempotentReplayedHeader), "another key of the owner must not get the stored response")
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "2", string(body))
+	assert.Equal(t, "true", send("owner-key-1").Header.Get(lib.IdempotentReplayedHeader))
+}
+
+func TestStreamJobEvents_ReplayAndResume(t *testing.T) {
+	app := setupTestApp()
+	queue := jobs.GetQueue()
+
+	job := jobs.NewJob("https://youtube.com/watch?v=events")
+	queue.AddJob(job)
+	_, err := queue.Start(job.ID)
+	require.NoError(t, err)
+	_, err = queue.EnterStage(job.ID, models.StageTranscribe)
+	require.NoError(t, err)
+	segments := []models.Segment{
+		{Start: 0, End: 1, Text: "hello"},
+		{Start: 1, End: 2, Text: "world"},
+	}
+	for _, segment := range segments {
+		require.NoError(t, queue.PublishSegment(job.ID, segment))
+	}
+	_, err = queue.Complete(job.ID, "hello world", segments)
+	require.NoError(t, err)
+	assert.Error(t, queue.PublishSegment(job.ID, segments[0]), "segments are published only while running")
+
+	stream := func(lastEventID string) string {
+		req := httptest.NewRequest(http.MethodGet, "/transcribe/"+job.ID+"/events", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		require.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		body := new(bytes.Buffer)
+		_, err = body.ReadFrom(resp.Body)
+		require.NoError(t, err)
+		return body.String()
+	}
+
+	// queued, running, stage, two segments, completed
+	full := stream("")
+	assert.Equal(t, 6, strings.Count(full, "\nevent: "))
+	assert.Contains(t, full, "id: 1\nevent: status\n")
+	assert.Contains(t, full, "event: stage\n")
+	assert.Contains(t, full, `"text":"world"`)
+	assert.Contains(t, full, "id: 6\nevent: status\n")
+
+	resumed := stream("4")
+	assert.NotContains(t, resumed, "id: 4\n")
+	assert.Contains(t, resumed, "id: 5\nevent: segment\n")
+	assert.Contains(t, resumed, `"status":"completed"`)
+}
+
+func TestBroker_LiveSubscription(t *testing.T) {
+	jobs.Initialize()
+	queue := jobs.GetQueue()
+
+	job := jobs.NewJob("https://youtube.com/watch?v=live")
+	queue.AddJob(job)
+
+	replay, events, cancel := queue.Events().Subscribe(job.ID, 0)
+	defer cancel()
+	require.Len(t, replay, 1)
+
+	_, err := queue.Start(job.ID)
+	require.NoError(t, err)
+	_, err = queue.Fail(job.ID, engine.NewError(engine.StageDownload, "download failed", errors.New("yt-dlp: HTTP Error 403")))
+	require.NoError(t, err)
+
+	var received []jobs.Event
+	for event := range events {
+		received = append(received, event)
+	}
+	require.Len(t, received, 2, "the channel closes after the terminal status")
+	assert.Equal(t, jobs.StatusFailed, received[1].Status)
+	assert.Equal(t, "download failed", received[1].Error)
+}
+
+func TestJobEventsSocket_Subscribe(t *testing.T) {
+	app := setupTestApp()
+	queue := jobs.GetQueue()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	job := jobs.NewJob("https://youtube.com/watch?v=socket")
+	queue.AddJob(job)
+
+	require.NoError(t, conn.WriteJSON(WSRequest{Type: WSSubscribe, JobIDs: []string{job.ID, "missing"}}))
+
+	read := func() WSMessage {
+		var msg WSMessage
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	// Replies for the two job IDs may interleave with the replayed event.
+	seen := map[string]WSMessage{}
+	for i := 0; i < 3; i++ {
+		msg := read()
+		seen[msg.Type+":"+msg.JobID] = msg
+	}
+	assert.Contains(t, seen, WSSubscribed+":"+job.ID)
+	assert.Equal(t, "Job not found", seen[WSError+":missing"].Error)
+	assert.Equal(t, jobs.StatusQueued, seen[WSProgress+":"+job.ID].Status)
+
+	_, err = queue.Start(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StatusRunning, read().Status)
+
+	require.NoError(t, queue.PublishSegment(job.ID, models.Segment{Start: 0, End: 1, Text: "hi"}))
+	segment := read()
+	assert.Equal(t, WSSegment, segment.Type)
+	require.NotNil(t, segment.Segment)
+	assert.Equal(t, "hi", segment.Segment.Text)
+
+	_, err = queue.Complete(job.ID, "hi", []models.Segment{{Start: 0, End: 1, Text: "hi"}})
+	require.NoError(t, err)
+	completed := read()
+	assert.Equal(t, WSCompleted, completed.Type)
+	assert.Equal(t, job.ID, completed.JobID)
+}
+
+func TestDownloadTranscript_FormatsAndCaching(t *testing.T) {
+	app := setupTestApp()
+	queue := jobs.GetQueue()
+
+	job := jobs.NewJob("https://youtube.com/watch?v=download")
+	queue.AddJob(job)
+
+	get := func(path string, headers map[string]string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		return resp
+	}
+	body := func(resp *http.Response) string {
+		buf := new(bytes.Buffer)
+		_, err := buf.ReadFrom(resp.Body)
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	base := "/transcribe/" + job.ID + "/transcript."
+	assert.Equal(t, fiber.StatusConflict, get(base+"srt", nil).StatusCode, "not completed yet")
+
+	_, err := queue.Start(job.ID)
+	require.NoError(t, err)
+	_, err = queue.Complete(job.ID, "hello world", []models.Segment{
+		{Start: 0, End: 1.5, Text: "hello"},
+		{Start: 1.5, End: 3, Text: "world"},
+	})
+	require.NoError(t, err)
+
+	contentTypes := map[string]string{
+		"txt":  "text/plain; charset=utf-8",
+		"srt":  "application/x-subrip; charset=utf-8",
+		"vtt":  "text/vtt; charset=utf-8",
+		"json": "application/json",
+	}
+	for ext, contentType := range contentTypes {
+		resp := get(base+ext, nil)
+		require.Equal(t, fiber.StatusOK, resp.StatusCode, ext)
+		assert.Equal(t, contentType, resp.Header.Get("Content-Type"), ext)
+		assert.Equal(t, `attachment; filename="`+job.ID+"."+ext+`"`, resp.Header.Get("Content-Disposition"), ext)
+		assert.NotEmpty(t, resp.Header.Get("ETag"), ext)
+	}
+
+	srt := get(base+"srt", nil)
+	assert.Equal(t, "1\n00:00:00,000 --> 00:00:01,500\nhello\n\n2\n00:00:01,500 --> 00:00:03,000\nworld\n\n", body(srt))
+
+	notModified := get(base+"srt", map[string]string{"If-None-Match": srt.Header.Get("ETag")})
+	assert.Equal(t, fiber.StatusNotModified, notModified.StatusCode)
+
+	partial := get(base+"txt", map[string]string{"Range": "bytes=0-4"})
+	assert.Equal(t, fiber.StatusPartialContent, partial.StatusCode)
+	assert.Equal(t, "hello", body(partial))
+
+	assert.Equal(t, fiber.StatusNotFound, get(base+"pdf", nil).StatusCode)
+	assert.Equal(t, fiber.StatusNotFound, get("/transcribe/missing/transcript.txt", nil).StatusCode)
+}
+
+func TestJobQueue_WaitForCompletion(t *testing.T) {
+	jobs.Initialize()
+	queue := jobs.GetQueue()
+
+	job := jobs.NewJob("https://youtube.com/watch?v=wait")
+	queue.AddJob(job)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	current, err := queue.Wait(ctx, job.ID)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, jobs.StatusQueued, current.Status)
+
+	go func() {
+		queue.Start(job.ID)
+		queue.EnterStage(job.ID, models.StageTranscribe)
+		queue.Complete(job.ID, "done", nil)
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	finished, err := queue.Wait(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StatusCompleted, finished.Status)
+	assert.Equal(t, "done", finished.Transcript)
+}
+
+func TestPostTranscribe_InvalidWait(t *testing.T) {
+	app := setupTestApp()
+
+	body := `{"url": "https://youtube.com/watch?v=test"}`
+	req := httptest.NewRequest(http.MethodPost, "/transcribe?wait=soon", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestPostTranscribeUpload_Rejections(t *testing.T) {
+	workDir := t.TempDir()
+	t.Setenv("WORK_DIR", workDir)
+	t.Setenv("MAX_UPLOAD_MB", "1")
+	app := setupTestApp()
+
+	multipartBody := func(field, filename string) (string, []byte) {
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		if filename == "" {
+			writer.WriteField(field, "not a file")
+		} else {
+			part, _ := writer.CreateFormFile(field, filename)
+			part.Write([]byte("not really audio"))
+		}
+		writer.Close()
+		return writer.FormDataContentType(), buf.Bytes()
+	}
+
+	noFileType, noFileBody := multipartBody("file", "")
+	garbageType, garbageBody := multipartBody("file", "talk.mp3")
+
+	tests := []struct {
+		name         string
+		query        string
+		contentType  string
+		body         []byte
+		expectedCode int
+	}{
+		{"Unsupported content type", "", "text/plain", []byte("hello"), 415},
+		{"Too large", "", "audio/mpeg", make([]byte, 1<<20+1), 413},
+		{"Empty body", "", "audio/mpeg", nil, 400},
+		{"Multipart without file", "", noFileType, noFileBody, 400},
+		{"Not media", "", garbageType, garbageBody, 422},
+		{"Loopback webhook", "?webhook_url=http://127.0.0.1/hook", "audio/mpeg", []byte("audio"), 400},
+		{"Unknown webhook event", "?webhook_url=https://example.com/hook&webhook_events=job.completed,job.exploded", "audio/mpeg", []byte("audio"), 400},
+		{"Webhook headers not JSON", "?webhook_url=https://example.com/hook&webhook_headers=X-Token:t", "audio/mpeg", []byte("audio"), 400},
+		{"Options not JSON", "?options=language=de", "audio/mpeg", []byte("audio"), 400},
+		{"Invalid options", `?options={"output_formats":["pdf"]}`, "audio/mpeg", []byte("audio"), 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/transcribe/upload"+tt.query, bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			resp, err := app.Test(req, -1)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+		})
+	}
+
+	// Rejected uploads must not be left behind in the work directory.
+	leftovers, _ := os.ReadDir(filepath.Join(workDir, "uploads"))
+	assert.Empty(t, leftovers)
+}
+
+func TestPostTranscribe_OptionsValidationAndEcho(t *testing.T) {
+	app := setupTestApp()
+
+	body := `{"url": "https://youtube.com/watch?v=test", "task": "summarize", "output_formats": ["pdf"]}`
+	req := httptest.NewRequest(http.MethodPost, "/transcribe", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	var invalid struct {
+		Error  string              `json:"error"`
+		Fields []models.FieldError `json:"fields"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&invalid))
+	assert.Equal(t, "Invalid transcription options", invalid.Error)
+	require.Len(t, invalid.Fields, 2)
+	assert.Equal(t, "task", invalid.Fields[0].Field)
+	assert.Equal(t, "output_formats[0]", invalid.Fields[1].Field)
+
+	// Options are stored with the job and echoed in its status.
+	job := jobs.NewJob("https://youtube.com/watch?v=options")
+	job.Options = models.TranscriptionOptions{
+		Language:      "de",
+		OutputFormats: []string{"srt"},
+		Metadata:      map[string]string{"ref": "abc"},
+	}
+	queue := jobs.GetQueue()
+	queue.AddJob(job)
+	_, err = queue.Start(job.ID)
+	require.NoError(t, err)
+	_, err = queue.Complete(job.ID, "Hallo", []models.Segment{{Start: 0, End: 1, Text: "Hallo"}})
+	require.NoError(t, err)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/transcribe/"+job.ID, nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var status struct {
+		Options       models.TranscriptionOptions `json:"options"`
+		SubtitleFiles lib.SubtitleFiles           `json:"subtitle_files"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, job.Options, status.Options)
+	assert.NotEmpty(t, status.SubtitleFiles.SRTURL)
+	assert.Empty(t, status.SubtitleFiles.VTTURL, "only requested formats are linked")
+}
+
+func TestPostTranscribe_WebhookValidation(t *testing.T) {
+	app := setupTestApp()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"Bad scheme", `{"url": "https://youtu.be/x", "webhook_url": "ftp://example.com/hook"}`},
+		{"Headers without URL", `{"url": "https://youtu.be/x", "webhook_headers": {"X-Token": "t"}}`},
+		{"Reserved header", `{"url": "https://youtu.be/x", "webhook_url": "https://example.com/hook", "webhook_headers": {"content-type": "text/plain"}}`},
+		{"Unknown event", `{"url": "https://youtu.be/x", "webhook_url": "https://example.com/hook", "webhook_events": ["job.exploded"]}`},
+		{"Loopback", `{"url": "https://youtu.be/x", "webhook_url": "http://127.0.0.1:8080/hook"}`},
+		{"Metadata service", `{"url": "https://youtu.be/x", "webhook_url": "http://169.254.169.254/latest/meta-data"}`},
+		{"Private network", `{"url": "https://youtu.be/x", "webhook_url": "http://[::ffff:10.0.0.1]/hook"}`},
+		{"Resolves to loopback", `{"url": "https://youtu.be/x", "webhook_url": "http://localhost/hook"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/transcribe", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			require.NoError(t, err)
+			assert.Equal(t, 400, resp.StatusCode)
+		})
+	}
+}
+
+func TestJobEventsSocket_SegmentsArriveWhileRunning(t *testing.T) {
+	app := setupTestApp()
+	queue := jobs.GetQueue()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The pipeline decodes two segments and then holds the job running
+	// until the test has seen both on the socket.
+	seen := make(chan struct{})
+	decoded := []models.Segment{{Start: 0, End: 1, Text: "live"}, {Start: 1, End: 2, Text: "captions"}}
+	original := runPipeline
+	runPipeline = func(ctx context.Context, job *jobs.Job, progress lib.Progress) (string, []models.Segment, error) {
+		progress.OnStage(models.StageTranscribe)
+		for _, segment := range decoded {
+			progress.OnSegment(segment)
+		}
+		<-seen
+		return "live captions", decoded, nil
+	}
+	defer func() { runPipeline = original }()
+
+	job := jobs.NewJob("https://youtube.com/watch?v=live-captions")
+	queue.AddJob(job)
+	require.NoError(t, conn.WriteJSON(WSRequest{Type: WSSubscribe, JobIDs: []string{job.ID}}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		processTranscription(job)
+	}()
+
+	read := func() WSMessage {
+		var msg WSMessage
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	var texts []string
+	for len(texts) < len(decoded) {
+		msg := read()
+		require.NotEqual(t, WSCompleted, msg.Type, "completed before every segment arrived")
+		if msg.Type == WSSegment {
+			texts = append(texts, msg.Segment.Text)
+		}
+	}
+	assert.Equal(t, []string{"live", "captions"}, texts)
+
+	running, err := queue.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StatusRunning, running.Status, "segments arrive before the job completes")
+
+	close(seen)
+	completed := read()
+	assert.Equal(t, WSCompleted, completed.Type)
+	<-done
+}
+
+func TestProcessTranscription_SendsJobWebhooks(t *testing.T) {
+	t.Setenv("WEBHOOK_SECRETS", "current-secret, previous-secret")
+	// The receiver below listens on loopback.
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	setupTestApp()
+
+	original := runPipeline
+	runPipeline = func(ctx context.Context, job *jobs.Job, progress lib.Progress) (string, []models.Segment, error) {
+		return "", nil, errors.New("no media")
+	}
+	defer func() { runPipeline = original }()
+
+	type delivery struct {
+		event     string
+		token     string
+		signature string
+		body      []byte
+	}
+	deliveries := make(chan delivery, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{
+			event:     r.Header.Get("X-Webhook-Event"),
+			token:     r.Header.Get("X-Token"),
+			signature: r.Header.Get(lib.WebhookSignatureHeader),
+			body:      body,
+		}
+	}))
+	defer server.Close()
+
+	job := jobs.NewJob("https://youtu.be/webhook-test")
+	job.Webhook = &models.JobWebhook{
+		URL:     server.URL,
+		Headers: map[string]string{"X-Token": "secret"},
+		Events:  []string{"job.started", "job.failed"},
+	}
+	jobs.GetQueue().AddJob(job)
+
+	processTranscription(job)
+
+	for _, want := range []string{"job.started", "job.failed"} {
+		select {
+		case got := <-deliveries:
+			assert.Equal(t, want, got.event)
+			assert.Equal(t, "secret", got.token)
+			for _, secret := range []string{"current-secret", "previous-secret"} {
+				assert.NoError(t, lib.VerifyWebhookSignature(got.body, got.signature, []string{secret}, 0), secret)
+			}
+			assert.ErrorIs(t, lib.VerifyWebhookSignature(got.body, got.signature, []string{"other-secret"}, 0), lib.ErrInvalidWebhookSignature)
+			assert.ErrorIs(t, lib.VerifyWebhookSignature(append(got.body, ' '), got.signature, []string{"current-secret"}, 0), lib.ErrInvalidWebhookSignature)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s webhook delivered", want)
+		}
+	}
+}
+
+func TestProcessTranscription_FailureHidesTheCause(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	app := setupTestApp()
+
+	const cause = "ffmpeg exited with status 1: /tmp/omnitranscripts/tenants/acme/job.wav: Invalid data found"
+	original := runPipeline
+	runPipeline = func(ctx context.Context, job *jobs.Job, progress lib.Progress) (string, []models.Segment, error) {
+		return "", nil, engine.NewError(engine.StageNormalize, "failed to normalize audio", errors.New(cause))
+	}
+	defer func() { runPipeline = original }()
+
+	webhookBodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		webhookBodies <- body
+	}))
+	defer server.Close()
+
+	job := jobs.NewJob("https://youtu.be/failure-cause")
+	job.Webhook = &models.JobWebhook{URL: server.URL, Events: []string{"job.failed"}}
+	jobs.GetQueue().AddJob(job)
+	processTranscription(job)
+
+	read := func(path string) string {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil), -1)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	outputs := map[string]string{
+		"status":  read("/transcribe/" + job.ID),
+		"listing": read("/transcribe"),
+		"events":  read("/transcribe/" + job.ID + "/events"),
+	}
+	select {
+	case body := <-webhookBodies:
+		outputs["webhook"] = string(body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no job.failed webhook delivered")
+	}
+
+	for name, output := range outputs {
+		assert.Contains(t, output, "failed to normalize audio", name)
+		assert.NotContains(t, output, "/tmp/omnitranscripts", name)
+		assert.NotContains(t, output, "ffmpeg exited", name)
+	}
+}
+
+func TestProcessTranscription_StopsWhenCancelled(t *testing.T) {
+	setupTestApp()
+	queue := jobs.GetQueue()
+
+	entered := make(chan struct{})
+	stopped := make(chan error, 1)
+	original := runPipeline
+	runPipeline = func(ctx context.Context, job *jobs.Job, progress lib.Progress) (string, []models.Segment, error) {
+		progress.OnStage(models.StageDownload)
+		close(entered)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return "", nil, ctx.Err()
+	}
+	defer func() { runPipeline = original }()
+
+	job := jobs.NewJob("https://youtu.be/cancelled")
+	queue.AddJob(job)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		processTranscription(job)
+	}()
+
+	<-entered
+	_, err := queue.Cancel(job.ID)
+	require.NoError(t, err)
+
+	select {
+	case err := <-stopped:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the pipeline kept running after the job was cancelled")
+	}
+	<-done
+
+	cancelled, err := queue.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StatusCancelled, cancelled.Status)
+}
+
+func TestWebhookDispatcher_RefusesPrivateAddresses(t *testing.T) {
+	var delivered atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered.Store(true)
+	}))
+	defer server.Close()
+
+	outbox, err := lib.NewFileWebhookOutbox(filepath.Join(t.TempDir(), "outbox.jsonl"))
+	require.NoError(t, err)
+	dispatcher := lib.NewWebhookDispatcher(outbox, nil)
+
+	delivery := models.NewWebhookDelivery("acme", "job.started", server.URL, nil, []byte(`{}`))
+	require.NoError(t, dispatcher.Enqueue(context.Background(), delivery))
+	assert.Contains(t, delivery.LastError, lib.ErrPrivateWebhookAddress.Error())
+	assert.False(t, delivered.Load(), "the connection is refused before it is made")
+}
+
+func TestFileWebhookOutbox_PrunesExpiredDeliveries(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	outbox, err := lib.NewFileWebhookOutbox(path)
+	require.NoError(t, err)
+
+	old := time.Now().Add(-models.WebhookDeliveryRetention - time.Hour)
+	dead := models.NewWebhookDelivery("acme", "job.failed", "https://example.com/hook", nil, []byte(`{}`))
+	dead.Status, dead.UpdatedAt = models.DeliveryDead, old
+	delivered := models.NewWebhookDelivery("acme", "job.completed", "https://example.com/hook", nil, []byte(`{}`))
+	delivered.Status, delivered.DeliveredAt = models.DeliveryDelivered, &old
+	pending := models.NewWebhookDelivery("acme", "job.started", "https://example.com/hook", nil, []byte(`{}`))
+	for _, d := range []*models.WebhookDelivery{dead, delivered, pending} {
+		require.NoError(t, outbox.Put(ctx, d))
+	}
+
+	reloaded, err := lib.NewFileWebhookOutbox(path)
+	require.NoError(t, err)
+	kept, err := reloaded.List(ctx, models.WebhookDeliveryFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, kept, 1)
+	assert.Equal(t, pending.ID, kept[0].ID)
+}
+
+func TestVerifyWebhookSignature_RejectsReplays(t *testing.T) {
+	body := []byte(`{"event":"job.completed"}`)
+	secrets := []string{"secret"}
+
+	stale := lib.SignWebhook(body, secrets, time.Now().Add(-10*time.Minute))
+	assert.ErrorIs(t, lib.VerifyWebhookSignature(body, stale, secrets, 0), lib.ErrWebhookTimestampOutOfTolerance)
+	assert.NoError(t, lib.VerifyWebhookSignature(body, stale, secrets, time.Hour), "within a wider tolerance")
+
+	forged := strings.Replace(stale, "t=", "t=1", 1)
+	assert.ErrorIs(t, lib.VerifyWebhookSignature(body, forged, secrets, time.Hour), lib.ErrInvalidWebhookSignature, "timestamp is signed")
+	assert.ErrorIs(t, lib.VerifyWebhookSignature(body, "", secrets, 0), lib.ErrInvalidWebhookSignature)
+}
+
+func TestValidateRequest_RejectsBodiesThatDoNotMatchTheSpec(t *testing.T) {
+	app := fiber.New()
+	app.Post("/v1/transcribe", ValidateRequest, PostTranscribe)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/transcribe", strings.NewReader(`{"url": 5, "task": "summarize"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var body ErrorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "Invalid request body", body.Error)
+	var fields []string
+	for _, problem := range body.Fields {
+		fields = append(fields, problem.Field)
+	}
+	assert.ElementsMatch(t, []string{"task", "url"}, fields)
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	app := fiber.New()
+	app.Get("/openapi.json", ServeOpenAPI)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var doc struct {
+		OpenAPI    string                            `json:"openapi"`
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.Contains(t, doc.Paths["/v1/transcribe/{job_id}"], "get")
+	assert.Contains(t, doc.Components.Schemas, "TranscribeRequest")
+}
+
+func TestPostTranscribe_FailureIsAProblem(t *testing.T) {
+	jobs.Initialize()
+	app := fiber.New()
+	app.Use(requestid.New())
+	app.Post("/transcribe", PostTranscribe)
+	app.Get("/v1/transcribe/:job_id", GetTranscribeJob)
+
+	// yt-dlp cannot fetch this, so the job fails at the download stage.
+	req := httptest.NewRequest(http.MethodPost, "/transcribe", strings.NewReader(`{"url":"https://youtu.be/unreachable"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, models.ProblemContentType, resp.Header.Get("Content-Type"))
+
+	var problem models.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, resp.StatusCode, problem.Status)
+	assert.Equal(t, models.StageDownload, problem.Stage)
+	assert.True(t, strings.HasPrefix(problem.Code, "download."), problem.Code)
+	assert.Equal(t, models.ProblemTypePrefix+problem.Code, problem.Type)
+	assert.Equal(t, resp.Header.Get(fiber.HeaderXRequestID), problem.RequestID)
+	assert.NotEmpty(t, problem.RequestID)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, problem.Instance, nil))
+	require.NoError(t, err)
+	var job JobStatusResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.Equal(t, jobs.StatusFailed, job.Status)
+	require.NotNil(t, job.Problem, "the problem is kept with the failed job")
+	assert.Equal(t, problem.Code, job.Problem.Code)
+}
+
+func TestQuotas_RejectBatchesOverTheDailyLimit(t *testing.T) {
+	app := setupTestApp()
+	UseQuotas(NewQuotaTracker(models.Quota{JobsPerDay: 3}))
+	t.Cleanup(func() { UseQuotas(nil) })
+
+	key := &lib.APIKey{ID: "quota-key", Owner: "quota-owner", Scopes: []lib.Scope{lib.ScopeSubmit}}
+	withKey := fiber.New()
+	withKey.Use(func(c *fiber.Ctx) error {
+		c.Locals(lib.LocalAPIKey, key)
+		c.Locals(lib.LocalAPIKeyID, key.ID)
+		c.Locals(lib.LocalOwner, key.Owner)
+		return c.Next()
+	})
+	withKey.Get("/usage", GetUsage)
+	withKey.Mount("/", app)
+
+	submit := func(urls ...string) *http.Response {
+		body, _ := json.Marshal(models.BatchRequest{URLs: urls})
+		req := httptest.NewRequest(http.MethodPost, "/transcribe/batch", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := withKey.Test(req, -1)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := submit("https://youtube.com/watch?v=one", "https://youtube.com/watch?v=two")
+	require.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "jobs_per_day=1", resp.Header.Get(QuotaRemainingHeader))
+
+	resp = submit("https://youtube.com/watch?v=three", "https://youtube.com/watch?v=four")
+	require.Equal(t, fiber.StatusPaymentRequired, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(fiber.HeaderRetryAfter))
+	var rejected QuotaErrorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rejected))
+	assert.Equal(t, models.LimitJobsPerDay, rejected.Limit)
+	assert.Equal(t, 1, rejected.Remaining)
+	require.NotNil(t, rejected.ResetsAt)
+	assert.Len(t, jobs.GetQueue().ListJobs(), 2, "a rejected batch creates no jobs")
+
+	resp, err := withKey.Test(httptest.NewRequest(http.MethodGet, "/usage", nil))
+	require.NoError(t, err)
+	var usage models.UsageResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+	assert.Equal(t, "quota-key", usage.KeyID)
+	assert.Equal(t, 3, usage.Quota.JobsPerDay)
+	assert.Equal(t, 2, usage.Usage.JobsToday)
+	assert.Equal(t, map[string]int{models.LimitJobsPerDay: 1}, usage.Remaining)
+	assert.Equal(t, models.DayStart(time.Now()).AddDate(0, 0, 1), usage.Usage.DayResetsAt)
+}
+
+func TestQuotas_BatchTakesOneConcurrentSlot(t *testing.T) {
+	tracker := lib.NewQuotaTracker(models.Quota{ConcurrentJobs: 2}, func(string) bool { return false })
+	key := &lib.APIKey{ID: "batch-key"}
+
+	usage, exceeded := tracker.AdmitBatch(key, "batch-1", 50)
+	require.Nil(t, exceeded, "a batch larger than the concurrent limit is admitted")
+	assert.Equal(t, 50, usage.JobsToday)
+	assert.Equal(t, 1, usage.ActiveJobs)
+
+	_, exceeded = tracker.Admit(key, []string{"single"}, 0)
+	require.Nil(t, exceeded)
+
+	_, exceeded = tracker.AdmitBatch(key, "batch-2", 3)
+	require.NotNil(t, exceeded, "the batch's slot counts while it runs")
+	assert.Equal(t, models.LimitConcurrentJobs, exceeded.Limit)
+}
+
+func TestQuotas_KeyQuotaKeepsTheDefaultLengthLimit(t *testing.T) {
+	tracker := NewQuotaTracker(models.Quota{JobsPerDay: 10, MaxMediaSeconds: 600})
+	key := &lib.APIKey{ID: "own-quota-key", Quota: &models.Quota{JobsPerDay: 50}}
+
+	quota := tracker.Quota(key)
+	assert.Equal(t, 50, quota.JobsPerDay, "the key's own limit replaces the default")
+	assert.Equal(t, 600, quota.MaxMediaSeconds, "an unset limit keeps the default")
+
+	err := quota.CheckLength(601)
+	var tooLong *engine.TranscriptionError
+	require.ErrorAs(t, err, &tooLong, "over-length media must still be rejected")
+	assert.Equal(t, engine.CodeDownloadTooLong, tooLong.Code)
+
+	// Batch children are checked when they start, under the same quota.
+	_, exceeded := tracker.Admit(key, []string{"own-quota-child"}, 0)
+	require.Nil(t, exceeded)
+	require.ErrorAs(t, tracker.ChargeMedia(key.ID, 601), &tooLong)
+	assert.NoError(t, tracker.ChargeMedia(key.ID, 600))
+}
+
+func TestProcessTranscription_ChecksUnknownLengthOnceRun(t *testing.T) {
+	setupTestApp()
+	tracker := NewQuotaTracker(models.Quota{MaxMediaSeconds: 60})
+	UseQuotas(tracker)
+	t.Cleanup(func() { UseQuotas(nil) })
+
+	original := runPipeline
+	runPipeline = func(ctx context.Context, job *jobs.Job, progress lib.Progress) (string, []models.Segment, error) {
+		return "long stream", []models.Segment{{Start: 0, End: 30}, {Start: 30, End: 94.5}}, nil
+	}
+	defer func() { runPipeline = original }()
+
+	// yt-dlp reported no duration, so the job was admitted without one.
+	key := &lib.APIKey{ID: "unknown-length-key"}
+	run := func() *jobs.Job {
+		job := jobs.NewJob("https://example.com/stream")
+		job.APIKeyID = key.ID
+		_, exceeded := tracker.Admit(key, []string{job.ID}, 0)
+		require.Nil(t, exceeded)
+		jobs.GetQueue().AddJob(job)
+		processTranscription(job)
+		finished, err := jobs.GetQueue().GetJob(job.ID)
+		require.NoError(t, err)
+		return finished
+	}
+
+	tooLong := run()
+	assert.Equal(t, jobs.StatusFailed, tooLong.Status)
+	require.NotNil(t, tooLong.Problem)
+	assert.Equal(t, engine.CodeDownloadTooLong, tooLong.Problem.Code)
+
+	tracker = NewQuotaTracker(models.Quota{MaxMediaSeconds: 120})
+	UseQuotas(tracker)
+	fits := run()
+	assert.Equal(t, jobs.StatusCompleted, fits.Status)
+	assert.Equal(t, 95, fits.BillableSeconds())
+	assert.Equal(t, 2, tracker.Usage(key.ID).MediaMinutesMonth, "the measured media is charged")
+}
+
 func BenchmarkPostTranscribe_Validation(b *testing.B) {
 	app := setupTestApp()
 
@@ -182,15 +1486,13 @@ func BenchmarkJobQueue_Update(b *testing.B) {
 
 	job := jobs.NewJob("https://youtube.com/watch?v=test")
 	queue.AddJob(job)
+	queue.Start(job.ID)
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		job.MarkRunning()
-		queue.UpdateJob(job)
-
-		job.MarkComplete("Test transcript", []models.Segment{})
-		queue.UpdateJob(job)
+		queue.EnterStage(job.ID, models.StageTranscribe)
+		queue.GetJob(job.ID)
 	}
 }
 
@@ -208,8 +1510,8 @@ func BenchmarkJobQueue_ConcurrentAccess(b *testing.B) {
 				b.Error(err)
 			}
 
-			job.MarkComplete("Test", []models.Segment{})
-			queue.UpdateJob(job)
+			queue.Start(job.ID)
+			queue.Complete(job.ID, "Test", []models.Segment{})
 		}
 	})
 }
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// uploadFramingBytes is how much of an upload's body may be multipart
+// boundaries, headers and other parts rather than the file itself.
+const uploadFramingBytes = 1 << 20
+
+// PostTranscribeUpload accepts a media file, either as the "file" part of a
+// multipart form or as the raw request body, and queues it for
+// transcription. The body is streamed to WorkDir rather than buffered, then
+// probed with ffprobe before the job enters the normal pipeline. Priority,
+// the transcription options, the job's webhook and the raw body's file name
+// are taken from the query string.
+func PostTranscribeUpload(c *fiber.Ctx) error {
+	priority := models.Priority(c.Query("priority"))
+	if !priority.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid priority, expected low, normal or high",
+		})
+	}
+
+	wait, waitRequested, ok := requestedWait(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid wait, expected a number of seconds",
+		})
+	}
+
+	options, err := models.ParseOptions(c.Query("options"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid options, expected a JSON object",
+		})
+	}
+	if problems := options.Validate(); len(problems) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid transcription options",
+			"fields": problems,
+		})
+	}
+
+	webhook, err := uploadWebhook(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	cfg := serverConfig
+
+	// The file itself is capped by SaveUpload; this bounds the whole body,
+	// multipart framing included.
+	maxBody := cfg.MaxUploadBytes + uploadFramingBytes
+	if int64(c.Request().Header.ContentLength()) > maxBody {
+		c.Set(fiber.HeaderConnection, "close")
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "Upload exceeds the maximum size",
+		})
+	}
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		// Servers without StreamRequestBody have already read the body.
+		body = bytes.NewReader(c.Body())
+	}
+	body = io.LimitReader(body, maxBody)
+
+	upload, err := lib.SaveUpload(body, c.Get(fiber.HeaderContentType), c.Query("filename"),
+		filepath.Join(lib.TenantDir(cfg.WorkDir, lib.Owner(c)), "uploads"), cfg.MaxUploadBytes)
+	switch {
+	case errors.Is(err, lib.ErrUploadTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "Upload exceeds the maximum size",
+		})
+	case errors.Is(err, lib.ErrUnsupportedMediaType):
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "Unsupported media type, expected an audio or video file",
+		})
+	case err != nil:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid upload: " + err.Error(),
+		})
+	}
+
+	info, err := lib.ProbeMedia(upload.Path)
+	if err != nil {
+		os.Remove(upload.Path)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Uploaded file is not a supported audio or video file",
+		})
+	}
+
+	if err := callerQuota(c).CheckLength(int(info.Duration)); err != nil {
+		os.Remove(upload.Path)
+		return respondProblem(c, models.NewProblem(err))
+	}
+
+	job := jobs.NewJob(lib.UploadSourceURL(upload.Filename))
+	job.MediaPath = upload.Path
+	job.MediaSeconds = int(info.Duration)
+	job.Owner = lib.Owner(c)
+	job.APIKeyID = lib.APIKeyID(c)
+	job.Options = options
+	job.Webhook = webhook
+	if priority != "" {
+		job.Priority = priority
+	}
+
+	if admitted, err := admitJobs(c, []string{job.ID}, models.MediaMinutes(int(info.Duration))); !admitted {
+		os.Remove(upload.Path)
+		return err
+	}
+	jobs.GetQueue().AddJob(job)
+
+	return submitJob(c, job, int(info.Duration), wait, waitRequested)
+}
+
+// uploadWebhook reads the webhook of an upload from the query string, where
+// webhook_events is a comma-separated list and webhook_headers a JSON
+// object.
+func uploadWebhook(c *fiber.Ctx) (*models.JobWebhook, error) {
+	var headers map[string]string
+	if value := c.Query("webhook_headers"); value != "" {
+		if err := json.Unmarshal([]byte(value), &headers); err != nil {
+			return nil, errors.New("webhook_headers must be a JSON object of header names and values")
+		}
+	}
+	var events []string
+	for _, event := range strings.Split(c.Query("webhook_events"), ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events = append(events, event)
+		}
+	}
+	return newJobWebhook(c.Query("webhook_url"), headers, events)
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// webhookDispatcher delivers webhooks through a durable outbox. Until
+// UseWebhookDispatcher they are retried in memory only.
+var webhookDispatcher *lib.WebhookDispatcher
+
+// UseWebhookDispatcher delivers every webhook through d, whose deliveries
+// callers can list and replay.
+func UseWebhookDispatcher(d *lib.WebhookDispatcher) {
+	webhookDispatcher = d
+}
+
+// jobWebhookConfig is the delivery configuration of a job's own webhook.
+func jobWebhookConfig(webhook *models.JobWebhook) lib.WebhookConfig {
+	return lib.WebhookConfig{
+		URL:        webhook.URL,
+		Headers:    webhook.Headers,
+		Events:     webhook.Events,
+		Timeout:    10 * time.Second,
+		Retries:    3,
+		BaseURL:    serverConfig.PublicBaseURL,
+		Signer:     urlSigner,
+		Secrets:    serverConfig.WebhookSecrets,
+		Dispatcher: webhookDispatcher,
+	}
+}
+
+// newJobWebhook returns the webhook a submission asked for with url,
+// headers and events, or nil when it set none of them. An invalid webhook
+// is an error that can be shown to the client.
+func newJobWebhook(url string, headers map[string]string, events []string) (*models.JobWebhook, error) {
+	if url == "" && len(headers) == 0 && len(events) == 0 {
+		return nil, nil
+	}
+	webhook := &models.JobWebhook{URL: url, Headers: headers, Events: events}
+	if err := lib.ValidateWebhookConfig(jobWebhookConfig(webhook)); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// jobNotifier delivers a job's webhooks in order on its own goroutine, so
+// a slow or unreachable endpoint never holds up the worker.
+type jobNotifier struct {
+	jobID   string
+	manager *lib.WebhookManager
+	sends   chan func(context.Context, *lib.WebhookManager) error
+}
+
+// newJobNotifier returns nil for jobs without a webhook; a nil notifier
+// ignores every call.
+func newJobNotifier(job *models.Job) *jobNotifier {
+	if job.Webhook == nil {
+		return nil
+	}
+
+	webhookConfig := jobWebhookConfig(job.Webhook)
+	webhookConfig.Owner = job.Owner
+	n := &jobNotifier{
+		jobID:   job.ID,
+		manager: lib.NewWebhookManager(webhookConfig),
+		sends:   make(chan func(context.Context, *lib.WebhookManager) error, 3),
+	}
+	go n.run()
+	return n
+}
+
+func (n *jobNotifier) run() {
+	for send := range n.sends {
+		if err := send(context.Background(), n.manager); err != nil {
+			log.Printf("job %s: webhook failed: %v", n.jobID, err)
+		}
+	}
+}
+
+func (n *jobNotifier) started(job *models.Job) {
+	if n == nil {
+		return
+	}
+	n.sends <- func(ctx context.Context, wm *lib.WebhookManager) error {
+		return wm.SendJobStarted(ctx, job)
+	}
+}
+
+// finished sends the completed or failed event and stops the notifier. A
+// job cancelled while running has no event of its own.
+func (n *jobNotifier) finished(job *models.Job, processingTime time.Duration) {
+	if n == nil {
+		return
+	}
+	switch job.Status {
+	case models.StatusCompleted:
+		n.sends <- func(ctx context.Context, wm *lib.WebhookManager) error {
+			return wm.SendJobCompleted(ctx, job, "", "", processingTime)
+		}
+	case models.StatusFailed:
+		n.sends <- func(ctx context.Context, wm *lib.WebhookManager) error {
+			return wm.SendJobFailed(ctx, job, job.Error, processingTime)
+		}
+	}
+	close(n.sends)
+}
+
+// ListWebhookDeliveries lists the caller's webhook deliveries, newest
+// first, optionally only those in one status such as dead.
+func ListWebhookDeliveries(c *fiber.Ctx) error {
+	status := models.DeliveryStatus(c.Query("status"))
+	if status != "" && !status.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid status, expected pending, delivered or dead",
+		})
+	}
+	limit := c.QueryInt("limit", models.DefaultDeliveryListLimit)
+	if limit < 1 || limit > models.MaxDeliveryListLimit {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid limit, expected 1 to %d", models.MaxDeliveryListLimit),
+		})
+	}
+
+	response := models.WebhookDeliveryList{Deliveries: []models.WebhookDelivery{}}
+	if webhookDispatcher == nil {
+		return c.JSON(response)
+	}
+	deliveries, err := webhookDispatcher.Outbox().List(c.UserContext(), models.WebhookDeliveryFilter{
+		Owner:  lib.Owner(c),
+		Status: status,
+		Limit:  limit,
+	})
+	if err != nil {
+		return err
+	}
+	response.Deliveries = deliveries
+	return c.JSON(response)
+}
+
+// ReplayWebhookDelivery delivers one of the caller's delivered or
+// dead-lettered webhooks again and returns it after the first attempt.
+func ReplayWebhookDelivery(c *fiber.Ctx) error {
+	if webhookDispatcher == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Webhook delivery not found",
+		})
+	}
+
+	delivery, err := webhookDispatcher.Outbox().Get(c.UserContext(), c.Params("delivery_id"))
+	if errors.Is(err, lib.ErrWebhookDeliveryNotFound) || (err == nil && delivery.Owner != lib.Owner(c)) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Webhook delivery not found",
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := webhookDispatcher.Replay(c.UserContext(), delivery); err != nil {
+		if errors.Is(err, models.ErrDeliveryPending) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Webhook delivery is still pending",
+			})
+		}
+		return err
+	}
+	return c.JSON(delivery)
+}
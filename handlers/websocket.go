@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+const (
+	// WSPingInterval is how often the server pings an open WebSocket.
+	WSPingInterval = 30 * time.Second
+	// wsPongWait is how long the server waits for any frame, including a
+	// pong, before it drops the connection.
+	wsPongWait  = 2 * WSPingInterval
+	wsWriteWait = 10 * time.Second
+	// MaxWSSubscriptions caps the jobs one connection may follow at once.
+	MaxWSSubscriptions = 100
+)
+
+// Message types exchanged over the job WebSocket.
+const (
+	WSSubscribe    = "subscribe"
+	WSUnsubscribe  = "unsubscribe"
+	WSSubscribed   = "subscribed"
+	WSUnsubscribed = "unsubscribed"
+	WSProgress     = "progress"
+	WSSegment      = "segment"
+	WSCompleted    = "completed"
+	WSError        = "error"
+)
+
+// WSRequest is a message sent by the client. LastEventIDs optionally
+// resumes each job after the event ID last seen, as with SSE.
+type WSRequest struct {
+	Type         string         `json:"type"`
+	JobIDs       []string       `json:"job_ids"`
+	LastEventIDs map[string]int `json:"last_event_ids,omitempty"`
+}
+
+// WSMessage is a message sent by the server. Job failures and cancellations
+// arrive as WSError with the job's status; protocol errors have no job ID.
+type WSMessage struct {
+	Type     string          `json:"type"`
+	JobID    string          `json:"job_id,omitempty"`
+	EventID  int             `json:"event_id,omitempty"`
+	Status   jobs.JobStatus  `json:"status,omitempty"`
+	Stage    string          `json:"stage,omitempty"`
+	Progress int             `json:"progress,omitempty"`
+	Segment  *models.Segment `json:"segment,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Time     *time.Time      `json:"time,omitempty"`
+}
+
+// RequireWebSocket rejects plain HTTP requests to the WebSocket route.
+func RequireWebSocket(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
+			"error": "WebSocket upgrade required",
+		})
+	}
+	return c.Next()
+}
+
+// JobEventsSocket serves job progress over a WebSocket. A client can follow
+// any number of its jobs over one connection by sending subscribe and
+// unsubscribe messages.
+var JobEventsSocket = websocket.New(func(conn *websocket.Conn) {
+	owner, _ := conn.Locals(lib.LocalOwner).(string)
+	session := &wsSession{
+		conn:          conn,
+		owner:         owner,
+		subscriptions: make(map[string]chan struct{}),
+		done:          make(chan struct{}),
+	}
+	session.serve()
+})
+
+// wsSession is one WebSocket connection and the jobs it follows.
+type wsSession struct {
+	conn  *websocket.Conn
+	owner string
+
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	subscriptions map[string]chan struct{}
+	done          chan struct{}
+
+	// workers tracks the goroutines writing to conn, which must all have
+	// returned before the handler does and the connection is recycled.
+	workers sync.WaitGroup
+}
+
+func (s *wsSession) serve() {
+	defer s.close()
+
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	s.workers.Add(1)
+	go s.keepAlive()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var req WSRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.send(WSMessage{Type: WSError, Error: "Invalid message"})
+			continue
+		}
+
+		switch req.Type {
+		case WSSubscribe:
+			for _, jobID := range req.JobIDs {
+				s.subscribe(jobID, req.LastEventIDs[jobID])
+			}
+		case WSUnsubscribe:
+			for _, jobID := range req.JobIDs {
+				s.unsubscribe(jobID)
+			}
+		default:
+			s.send(WSMessage{Type: WSError, Error: "Unknown message type"})
+		}
+	}
+}
+
+func (s *wsSession) close() {
+	s.mu.Lock()
+	close(s.done)
+	for jobID, stop := range s.subscriptions {
+		close(stop)
+		delete(s.subscriptions, jobID)
+	}
+	s.mu.Unlock()
+
+	s.workers.Wait()
+}
+
+// send writes one message; writes from the job followers and the keepalive
+// are serialised here.
+func (s *wsSession) send(msg WSMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return s.conn.WriteJSON(msg)
+}
+
+func (s *wsSession) keepAlive() {
+	defer s.workers.Done()
+
+	ticker := time.NewTicker(WSPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.writeMu.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			s.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *wsSession) subscribe(jobID string, afterID int) {
+	job, err := jobs.GetQueue().GetJob(jobID)
+	if err != nil || job.Owner != s.owner {
+		s.send(WSMessage{Type: WSError, JobID: jobID, Error: "Job not found"})
+		return
+	}
+
+	s.mu.Lock()
+	if _, ok := s.subscriptions[jobID]; ok {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.subscriptions) >= MaxWSSubscriptions {
+		s.mu.Unlock()
+		s.send(WSMessage{Type: WSError, JobID: jobID, Error: "Too many subscriptions"})
+		return
+	}
+	stop := make(chan struct{})
+	s.subscriptions[jobID] = stop
+	s.mu.Unlock()
+
+	s.send(WSMessage{Type: WSSubscribed, JobID: jobID})
+	s.workers.Add(1)
+	go s.follow(jobID, afterID, stop)
+}
+
+func (s *wsSession) unsubscribe(jobID string) {
+	s.mu.Lock()
+	stop, ok := s.subscriptions[jobID]
+	if ok {
+		close(stop)
+		delete(s.subscriptions, jobID)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		s.send(WSMessage{Type: WSUnsubscribed, JobID: jobID})
+	}
+}
+
+// follow forwards a job's events until it finishes or the client
+// unsubscribes. If the broker drops the subscription because the client fell
+// behind, following resumes from the last event sent.
+func (s *wsSession) follow(jobID string, afterID int, stop chan struct{}) {
+	defer s.workers.Done()
+	defer func() {
+		s.mu.Lock()
+		if s.subscriptions[jobID] == stop {
+			delete(s.subscriptions, jobID)
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		replay, events, cancel := jobs.GetQueue().Events().Subscribe(jobID, afterID)
+		done := s.forward(replay, events, stop, &afterID)
+		cancel()
+		if done {
+			return
+		}
+	}
+}
+
+// forward sends replayed and live events. It reports whether following is
+// over: the terminal event was sent, the client unsubscribed or went away,
+// or the stream closed without anything left to send.
+func (s *wsSession) forward(replay []jobs.Event, events <-chan jobs.Event, stop chan struct{}, afterID *int) bool {
+	sent := 0
+	emit := func(event jobs.Event) bool {
+		if s.send(wsMessageFor(event)) != nil {
+			return true
+		}
+		*afterID = event.ID
+		sent++
+		return event.Type == jobs.EventStatus && event.Status.IsTerminal()
+	}
+
+	for _, event := range replay {
+		if emit(event) {
+			return true
+		}
+	}
+
+	for {
+		select {
+		case <-stop:
+			return true
+		case event, open := <-events:
+			if !open {
+				// Dropped for falling behind; resubscribe unless there was
+				// nothing new, which means the job finished earlier.
+				return sent == 0
+			}
+			if emit(event) {
+				return true
+			}
+		}
+	}
+}
+
+// wsMessageFor maps a job event onto the WebSocket message types.
+func wsMessageFor(event jobs.Event) WSMessage {
+	msg := WSMessage{
+		Type:     WSProgress,
+		JobID:    event.JobID,
+		EventID:  event.ID,
+		Status:   event.Status,
+		Stage:    event.Stage,
+		Progress: event.Progress,
+		Segment:  event.Segment,
+		Error:    event.Error,
+		Time:     &event.Time,
+	}
+
+	switch {
+	case event.Type == jobs.EventSegment:
+		msg.Type = WSSegment
+	case event.Status == jobs.StatusCompleted:
+		msg.Type = WSCompleted
+	case event.Status == jobs.StatusFailed, event.Status == jobs.StatusCancelled:
+		msg.Type = WSError
+		if msg.Error == "" {
+			msg.Error = "Job " + string(event.Status)
+		}
+	}
+	return msg
+}
@@ -0,0 +1,144 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Batch groups the child jobs created by a single batch or playlist submission.
+type Batch struct {
+	ID          string     `json:"id"`
+	Owner       string     `json:"owner,omitempty"`
+	SourceURL   string     `json:"source_url,omitempty"`
+	JobIDs      []string   `json:"job_ids"`
+	WebhookURL  string     `json:"webhook_url,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// BatchProgress aggregates the status of a batch's child jobs.
+type BatchProgress struct {
+	Total     int
+	Queued    int
+	Running   int
+	Completed int
+	Failed    int
+	Cancelled int
+}
+
+// Finished returns the number of children that reached a terminal status.
+func (p BatchProgress) Finished() int {
+	return p.Completed + p.Failed + p.Cancelled
+}
+
+// Done reports whether every child job has finished.
+func (p BatchProgress) Done() bool {
+	return p.Total > 0 && p.Finished() == p.Total
+}
+
+func NewBatch(owner, sourceURL string) *Batch {
+	return &Batch{
+		ID:        uuid.New().String(),
+		Owner:     owner,
+		SourceURL: sourceURL,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Clone returns a copy of the batch that does not share state with the queue.
+func (b *Batch) Clone() *Batch {
+	c := *b
+	c.JobIDs = append([]string(nil), b.JobIDs...)
+	if b.CompletedAt != nil {
+		completedAt := *b.CompletedAt
+		c.CompletedAt = &completedAt
+	}
+	return &c
+}
+
+// AddBatch stores the batch together with its child jobs, tagging each job
+// with the batch ID.
+func (q *Queue) AddBatch(batch *Batch, children []*Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	batch.JobIDs = make([]string, 0, len(children))
+	for _, job := range children {
+		job.BatchID = batch.ID
+		q.jobs[job.ID] = job.Clone()
+		q.events.Publish(job.ID, statusEvent(job))
+		batch.JobIDs = append(batch.JobIDs, job.ID)
+	}
+	q.batches[batch.ID] = batch.Clone()
+}
+
+// GetBatch returns a snapshot of the batch.
+func (q *Queue) GetBatch(id string) (*Batch, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	batch, exists := q.batches[id]
+	if !exists {
+		return nil, fmt.Errorf("batch not found")
+	}
+	return batch.Clone(), nil
+}
+
+// BatchProgress counts the batch's child jobs by status.
+func (q *Queue) BatchProgress(id string) (BatchProgress, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	batch, exists := q.batches[id]
+	if !exists {
+		return BatchProgress{}, fmt.Errorf("batch not found")
+	}
+	return q.batchProgressLocked(batch), nil
+}
+
+func (q *Queue) batchProgressLocked(batch *Batch) BatchProgress {
+	progress := BatchProgress{Total: len(batch.JobIDs)}
+	for _, jobID := range batch.JobIDs {
+		job, exists := q.jobs[jobID]
+		if !exists {
+			continue
+		}
+		switch job.Status {
+		case StatusQueued:
+			progress.Queued++
+		case StatusRunning:
+			progress.Running++
+		case StatusCompleted:
+			progress.Completed++
+		case StatusFailed:
+			progress.Failed++
+		case StatusCancelled:
+			progress.Cancelled++
+		}
+	}
+	return progress
+}
+
+// CompleteBatch marks the batch completed once all of its children have
+// finished. It returns true only for the call that performed the transition,
+// so callers can use it to fire the batch.completed notification exactly once.
+func (q *Queue) CompleteBatch(id string) (bool, BatchProgress) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	batch, exists := q.batches[id]
+	if !exists || batch.CompletedAt != nil {
+		return false, BatchProgress{}
+	}
+
+	progress := q.batchProgressLocked(batch)
+	if !progress.Done() {
+		return false, progress
+	}
+
+	now := time.Now()
+	batch.CompletedAt = &now
+	return true, progress
+}
@@ -0,0 +1,400 @@
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"omnitranscripts/models"
+)
+
+const (
+	// DefaultWorkerCount is the number of jobs processed concurrently when
+	// WORKER_COUNT is not set.
+	DefaultWorkerCount = 4
+	// defaultJobDuration seeds start-time estimates until real runs have
+	// been observed.
+	defaultJobDuration = time.Minute
+)
+
+var (
+	// ErrNotQueued is returned when an operation needs a job that is still
+	// waiting for a worker.
+	ErrNotQueued = errors.New("job is not waiting in the queue")
+	// ErrStillRunning is returned when a job cannot be requeued because a
+	// worker is still busy with it.
+	ErrStillRunning = errors.New("job is still running on a worker")
+)
+
+// pendingJob is a queued job as seen by the dispatcher.
+type pendingJob struct {
+	id       string
+	priority models.Priority
+	seq      uint64
+}
+
+// tenant is one API key's share of the dispatcher. Tenants are served by
+// stride scheduling: the tenant with the lowest pass goes next and its pass
+// then advances by 1/weight, so a key with weight 2 gets twice the
+// dispatches of a key with weight 1 while both have work waiting.
+type tenant struct {
+	pending []*pendingJob
+	pass    float64
+}
+
+// Dispatcher feeds queued jobs to a fixed pool of workers. Every API key
+// gets a fair share of the workers regardless of how many jobs it submits;
+// within a key, higher priority jobs go first. Jobs bumped by an admin skip
+// the fair-share order entirely.
+type Dispatcher struct {
+	run     func(job *Job)
+	workers int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	tenants map[string]*tenant
+	weights map[string]float64
+	bumped  []*pendingJob
+	pending int
+	busy    int
+	// running holds the IDs of the jobs the workers are busy with.
+	running map[string]bool
+	// draining holds waiting jobs back from the workers.
+	draining bool
+	seq      uint64
+	vtime    float64
+	avgRun   time.Duration
+}
+
+var dispatcher *Dispatcher
+
+// StartDispatcher starts workers goroutines that run queued jobs from the
+// global queue with run, and makes the dispatcher available through
+// GetDispatcher.
+func StartDispatcher(workers int, run func(job *Job)) *Dispatcher {
+	if workers <= 0 {
+		workers = DefaultWorkerCount
+	}
+
+	d := &Dispatcher{
+		run:     run,
+		workers: workers,
+		tenants: make(map[string]*tenant),
+		weights: make(map[string]float64),
+		running: make(map[string]bool),
+		avgRun:  defaultJobDuration,
+	}
+	d.cond = sync.NewCond(&d.mu)
+
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+
+	dispatcher = d
+	return d
+}
+
+func GetDispatcher() *Dispatcher {
+	return dispatcher
+}
+
+// SetWeight sets the share of workers an API key's jobs receive relative
+// to other keys. Keys without a weight count as 1, as does a zero weight.
+func (d *Dispatcher) SetWeight(keyID string, weight float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if weight <= 0 {
+		delete(d.weights, keyID)
+		return
+	}
+	d.weights[keyID] = weight
+}
+
+func (d *Dispatcher) weight(key string) float64 {
+	if w, ok := d.weights[key]; ok {
+		return w
+	}
+	return 1
+}
+
+// tenantOf returns the tenant a job is scheduled under: the API key that
+// submitted it or, for jobs without one, its owner.
+func tenantOf(job *Job) string {
+	if job.APIKeyID != "" {
+		return job.APIKeyID
+	}
+	return job.Owner
+}
+
+// Submit queues a job for processing. The job must already be stored in
+// the queue.
+func (d *Dispatcher) Submit(job *Job) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.submitLocked(job)
+}
+
+// Requeue runs requeue, which returns a finished job to queued in the job
+// queue, and submits the result. The job must not be running: both happen
+// under the dispatcher's lock, so no worker can pick the job up between the
+// check and the requeue. It returns ErrStillRunning while a worker is busy
+// with the job, or requeue's error.
+func (d *Dispatcher) Requeue(id string, requeue func() (*Job, error)) (*Job, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.running[id] {
+		return nil, ErrStillRunning
+	}
+	job, err := requeue()
+	if err != nil {
+		return nil, err
+	}
+	// A job cancelled while waiting may still have its old entry.
+	d.removeLocked(id)
+	d.submitLocked(job)
+	return job, nil
+}
+
+func (d *Dispatcher) submitLocked(job *Job) {
+	key := tenantOf(job)
+	t, ok := d.tenants[key]
+	if !ok {
+		t = &tenant{}
+		d.tenants[key] = t
+	}
+	// A key that had nothing waiting starts level with the others instead
+	// of cashing in the time it spent idle.
+	if len(t.pending) == 0 && t.pass < d.vtime {
+		t.pass = d.vtime
+	}
+
+	d.seq++
+	p := &pendingJob{id: job.ID, priority: job.Priority, seq: d.seq}
+
+	i := len(t.pending)
+	for i > 0 && outranks(p, t.pending[i-1]) {
+		i--
+	}
+	t.pending = append(t.pending, nil)
+	copy(t.pending[i+1:], t.pending[i:])
+	t.pending[i] = p
+
+	d.pending++
+	d.cond.Signal()
+}
+
+// outranks reports whether a should be dispatched before b within one key.
+func outranks(a, b *pendingJob) bool {
+	if a.priority.Rank() != b.priority.Rank() {
+		return a.priority.Rank() > b.priority.Rank()
+	}
+	return a.seq < b.seq
+}
+
+// Bump moves a waiting job to the front of the queue, ahead of every key's
+// fair share. Bumped jobs are dispatched in the order they were bumped.
+func (d *Dispatcher) Bump(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, p := range d.bumped {
+		if p.id == id {
+			return nil
+		}
+	}
+
+	for _, t := range d.tenants {
+		for i, p := range t.pending {
+			if p.id != id {
+				continue
+			}
+			t.pending = append(t.pending[:i], t.pending[i+1:]...)
+			d.bumped = append(d.bumped, p)
+			return nil
+		}
+	}
+	return ErrNotQueued
+}
+
+// Remove takes a waiting job out of the queue, such as one cancelled before
+// a worker picked it up.
+func (d *Dispatcher) Remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.removeLocked(id)
+}
+
+func (d *Dispatcher) removeLocked(id string) error {
+	for i, p := range d.bumped {
+		if p.id == id {
+			d.bumped = append(d.bumped[:i], d.bumped[i+1:]...)
+			d.pending--
+			return nil
+		}
+	}
+
+	for _, t := range d.tenants {
+		for i, p := range t.pending {
+			if p.id == id {
+				t.pending = append(t.pending[:i], t.pending[i+1:]...)
+				d.pending--
+				return nil
+			}
+		}
+	}
+	return ErrNotQueued
+}
+
+// Drain stops the workers from starting waiting jobs. Jobs already running
+// finish; the rest stay queued until Resume.
+func (d *Dispatcher) Drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.draining = true
+}
+
+// Resume lets the workers start waiting jobs again after Drain.
+func (d *Dispatcher) Resume() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.draining = false
+	d.cond.Broadcast()
+}
+
+// Status reports the size of the pool, how many workers are running jobs
+// and how many jobs are waiting. A drain is complete once none are busy.
+func (d *Dispatcher) Status() models.WorkerPoolStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return models.WorkerPoolStatus{
+		Workers:  d.workers,
+		Busy:     d.busy,
+		Queued:   d.pending,
+		Draining: d.draining,
+	}
+}
+
+// Position returns the 1-based place of a waiting job in the dispatch order
+// and when it is expected to start, assuming no further submissions.
+func (d *Dispatcher) Position(id string) (int, time.Time, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, p := range d.orderLocked() {
+		if p.id != id {
+			continue
+		}
+
+		// The i jobs ahead fill the idle workers first, then each further
+		// round of d.workers jobs costs one average run.
+		start := time.Now()
+		idle := d.workers - d.busy
+		if i >= idle {
+			waves := (i-idle)/d.workers + 1
+			start = start.Add(time.Duration(waves) * d.avgRun)
+		}
+		return i + 1, start, nil
+	}
+	return 0, time.Time{}, ErrNotQueued
+}
+
+// orderLocked returns every waiting job in the order the workers will take
+// them, by replaying the scheduling policy on a copy of the tenant passes.
+func (d *Dispatcher) orderLocked() []*pendingJob {
+	order := make([]*pendingJob, 0, d.pending)
+	order = append(order, d.bumped...)
+
+	passes := make(map[string]float64, len(d.tenants))
+	next := make(map[string]int, len(d.tenants))
+	for key, t := range d.tenants {
+		passes[key] = t.pass
+	}
+
+	for {
+		key, ok := d.pickLocked(func(key string) (float64, bool) {
+			return passes[key], next[key] < len(d.tenants[key].pending)
+		})
+		if !ok {
+			return order
+		}
+		order = append(order, d.tenants[key].pending[next[key]])
+		next[key]++
+		passes[key] += 1 / d.weight(key)
+	}
+}
+
+// pickLocked returns the tenant with work waiting and the lowest pass, as
+// reported by state. Ties go to the lexically smaller key so the order is
+// deterministic.
+func (d *Dispatcher) pickLocked(state func(key string) (float64, bool)) (string, bool) {
+	best, bestPass, found := "", 0.0, false
+	for key := range d.tenants {
+		pass, waiting := state(key)
+		if !waiting {
+			continue
+		}
+		if !found || pass < bestPass || (pass == bestPass && key < best) {
+			best, bestPass, found = key, pass, true
+		}
+	}
+	return best, found
+}
+
+// popLocked removes and returns the next job to run.
+func (d *Dispatcher) popLocked() *pendingJob {
+	d.pending--
+
+	if len(d.bumped) > 0 {
+		p := d.bumped[0]
+		d.bumped = d.bumped[1:]
+		return p
+	}
+
+	key, _ := d.pickLocked(func(key string) (float64, bool) {
+		t := d.tenants[key]
+		return t.pass, len(t.pending) > 0
+	})
+	t := d.tenants[key]
+	p := t.pending[0]
+	t.pending = t.pending[1:]
+
+	d.vtime = t.pass
+	t.pass += 1 / d.weight(key)
+	return p
+}
+
+func (d *Dispatcher) work() {
+	for {
+		d.mu.Lock()
+		for d.pending == 0 || d.draining {
+			d.cond.Wait()
+		}
+		p := d.popLocked()
+		d.busy++
+		d.running[p.id] = true
+		d.mu.Unlock()
+
+		ran := false
+		started := time.Now()
+		if job, err := GetQueue().GetJob(p.id); err == nil && job.Status == StatusQueued {
+			d.run(job)
+			ran = true
+		}
+
+		d.mu.Lock()
+		d.busy--
+		delete(d.running, p.id)
+		if ran {
+			// Exponential moving average of recent run times.
+			d.avgRun = (d.avgRun*4 + time.Since(started)) / 5
+		}
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,266 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"omnitranscripts/models"
+)
+
+// EventType names the kind of change an Event describes.
+type EventType string
+
+const (
+	// EventStatus is published whenever a job changes status.
+	EventStatus EventType = "status"
+	// EventStage is published when a running job enters a pipeline stage.
+	EventStage EventType = "stage"
+	// EventSegment carries one transcript segment as the backend decodes
+	// it, before any post-processing.
+	EventSegment EventType = "segment"
+)
+
+// MaxJobEvents bounds the events kept per job for replay. Clients resuming
+// from an older event miss the ones in between and should fetch the job.
+const MaxJobEvents = 1000
+
+// JobEventRetention is how long a finished job's events stay available for
+// replay. After that only its terminal status event is kept.
+const JobEventRetention = 10 * time.Minute
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is disconnected; it can then resume from its last event ID.
+const subscriberBuffer = 64
+
+// stageProgress is the overall progress reported on entering each stage.
+var stageProgress = map[string]int{
+	models.StageDownload:   5,
+	models.StageNormalize:  35,
+	models.StageTranscribe: 50,
+}
+
+// Event is one change to a job. IDs increase by one per job, starting at 1,
+// so a client can resume a stream after the last ID it saw.
+type Event struct {
+	ID       int             `json:"id"`
+	JobID    string          `json:"job_id"`
+	Type     EventType       `json:"type"`
+	Status   JobStatus       `json:"status,omitempty"`
+	Stage    string          `json:"stage,omitempty"`
+	Progress int             `json:"progress"`
+	Segment  *models.Segment `json:"segment,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Time     time.Time       `json:"time"`
+}
+
+type jobEvents struct {
+	events      []Event
+	lastID      int
+	finished    bool
+	subscribers map[chan Event]struct{}
+	// expiry trims the log once JobEventRetention has passed since the
+	// job finished.
+	expiry *time.Timer
+}
+
+// Broker keeps a bounded log of every job's events and fans new events out
+// to subscribers. A subscriber's channel is closed once the job reaches a
+// terminal status, or early if the subscriber stops keeping up. Finished
+// jobs keep only their terminal status event after JobEventRetention.
+type Broker struct {
+	jobs map[string]*jobEvents
+	mu   sync.Mutex
+}
+
+func NewBroker() *Broker {
+	return &Broker{jobs: make(map[string]*jobEvents)}
+}
+
+func (b *Broker) logLocked(jobID string) *jobEvents {
+	log, ok := b.jobs[jobID]
+	if !ok {
+		log = &jobEvents{subscribers: make(map[chan Event]struct{})}
+		b.jobs[jobID] = log
+	}
+	return log
+}
+
+// Publish records events for a job and delivers them to its subscribers.
+// IDs and timestamps are assigned here.
+func (b *Broker) Publish(jobID string, events ...Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log := b.logLocked(jobID)
+	for _, event := range events {
+		log.lastID++
+		event.ID = log.lastID
+		event.JobID = jobID
+		if event.Time.IsZero() {
+			event.Time = time.Now()
+		}
+
+		log.events = append(log.events, event)
+		if len(log.events) > MaxJobEvents {
+			log.events = log.events[len(log.events)-MaxJobEvents:]
+		}
+
+		for ch := range log.subscribers {
+			select {
+			case ch <- event:
+			default:
+				delete(log.subscribers, ch)
+				close(ch)
+			}
+		}
+
+		if event.Type != EventStatus {
+			continue
+		}
+		if !event.Status.IsTerminal() {
+			// A requeued job is live again.
+			log.finished = false
+			if log.expiry != nil {
+				log.expiry.Stop()
+				log.expiry = nil
+			}
+			continue
+		}
+		log.finished = true
+		for ch := range log.subscribers {
+			delete(log.subscribers, ch)
+			close(ch)
+		}
+		if log.expiry != nil {
+			log.expiry.Stop()
+		}
+		log.expiry = time.AfterFunc(JobEventRetention, func() { b.trim(log) })
+	}
+}
+
+// trim drops every event of a finished job but the last, its terminal
+// status, which late subscribers still need to see the job is over.
+func (b *Broker) trim(log *jobEvents) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !log.finished || len(log.events) == 0 {
+		return
+	}
+	log.events = []Event{log.events[len(log.events)-1]}
+	log.expiry = nil
+}
+
+// Subscribe returns the retained events after afterID and a channel of
+// events published from now on. The channel is already closed when the job
+// has finished. cancel must be called once the caller stops reading.
+func (b *Broker) Subscribe(jobID string, afterID int) ([]Event, <-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log := b.logLocked(jobID)
+
+	var replay []Event
+	for _, event := range log.events {
+		if event.ID > afterID {
+			replay = append(replay, event)
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	if log.finished {
+		close(ch)
+		return replay, ch, func() {}
+	}
+
+	log.subscribers[ch] = struct{}{}
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := log.subscribers[ch]; ok {
+			delete(log.subscribers, ch)
+			close(ch)
+		}
+	}
+	return replay, ch, cancel
+}
+
+// changeEvents describes the difference between two snapshots of a job.
+// Segments are published separately, by PublishSegment, as they are
+// decoded.
+func changeEvents(prev, next *Job) []Event {
+	var events []Event
+
+	if next.Stage != "" && next.Stage != prev.Stage {
+		events = append(events, Event{
+			Type:     EventStage,
+			Status:   next.Status,
+			Stage:    next.Stage,
+			Progress: stageProgress[next.Stage],
+		})
+	}
+
+	if next.Status != prev.Status {
+		events = append(events, statusEvent(next))
+	}
+
+	return events
+}
+
+func statusEvent(job *Job) Event {
+	event := Event{
+		Type:   EventStatus,
+		Status: job.Status,
+		Stage:  job.Stage,
+		Error:  job.Error,
+	}
+	switch job.Status {
+	case StatusRunning:
+		event.Progress = stageProgress[job.Stage]
+	case StatusCompleted:
+		event.Progress = 100
+	}
+	return event
+}
+
+// Wait blocks until the job reaches a terminal status or ctx is done, and
+// returns the latest snapshot of the job either way. It is driven by the
+// job's events rather than polling.
+func (q *Queue) Wait(ctx context.Context, id string) (*Job, error) {
+	afterID := 0
+	for {
+		job, err := q.GetJob(id)
+		if err != nil {
+			return nil, err
+		}
+		if job.IsTerminal() {
+			return job, nil
+		}
+
+		replay, events, cancel := q.events.Subscribe(id, afterID)
+		if len(replay) > 0 {
+			// The job changed since the snapshot was taken; look again.
+			afterID = replay[len(replay)-1].ID
+			cancel()
+			continue
+		}
+
+		// Wait for the next change; the snapshot is re-read at the top of
+		// the loop, so a terminal event just leads back there.
+		select {
+		case <-ctx.Done():
+			cancel()
+			job, err := q.GetJob(id)
+			if err != nil {
+				return nil, err
+			}
+			return job, ctx.Err()
+		case event, open := <-events:
+			if open {
+				afterID = event.ID
+			}
+		}
+		cancel()
+	}
+}
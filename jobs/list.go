@@ -0,0 +1,162 @@
+package jobs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultListLimit is the page size used when a listing does not specify one.
+	DefaultListLimit = 20
+	// MaxListLimit caps the page size of a single listing request.
+	MaxListLimit = 100
+)
+
+// ListFilter narrows a job listing. Zero-valued fields are ignored, except
+// Owner which always has to match so callers only ever see their own jobs.
+type ListFilter struct {
+	Owner         string
+	Status        JobStatus
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Host          string
+	Query         string
+	Cursor        string
+	Limit         int
+}
+
+// Cursor identifies the last job of a page. Listings are ordered by
+// created_at descending with the job ID as tie-breaker, so the pair is a
+// stable position even when new jobs arrive between requests.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// EncodeCursor returns the opaque token handed to clients for the next page.
+func EncodeCursor(c Cursor) string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a token produced by EncodeCursor.
+func DecodeCursor(token string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	return Cursor{CreatedAt: createdAt, ID: parts[1]}, nil
+}
+
+// URLHost returns the lower-cased host of a job URL, without port.
+func URLHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// HostMatches reports whether host equals want or is a subdomain of it,
+// so a filter of "youtube.com" also matches "www.youtube.com".
+func HostMatches(host, want string) bool {
+	want = strings.ToLower(strings.TrimSpace(want))
+	return host == want || strings.HasSuffix(host, "."+want)
+}
+
+// Matches reports whether the job satisfies every filter criterion.
+// Cursor and Limit are paging concerns and are not checked here.
+func (f ListFilter) Matches(job *Job) bool {
+	if job.Owner != f.Owner {
+		return false
+	}
+	if f.Status != "" && job.Status != f.Status {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && !job.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !job.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	if f.Host != "" && !HostMatches(URLHost(job.URL), f.Host) {
+		return false
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(job.Transcript), strings.ToLower(f.Query)) {
+		return false
+	}
+	return true
+}
+
+// before reports whether job a sorts ahead of the cursor position b.
+func before(a *Job, b Cursor) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
+
+// SearchJobs returns one page of jobs matching the filter together with the
+// cursor for the following page, which is empty on the last page.
+func (q *Queue) SearchJobs(filter ListFilter) ([]*Job, string, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	var after *Cursor
+	if filter.Cursor != "" {
+		c, err := DecodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = &c
+	}
+
+	q.mu.RLock()
+	matched := make([]*Job, 0)
+	for _, job := range q.jobs {
+		if !filter.Matches(job) {
+			continue
+		}
+		if after != nil && before(job, *after) {
+			continue
+		}
+		if after != nil && job.ID == after.ID {
+			continue
+		}
+		matched = append(matched, job.Clone())
+	}
+	q.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return before(matched[i], Cursor{CreatedAt: matched[j].CreatedAt, ID: matched[j].ID})
+	})
+
+	next := ""
+	if len(matched) > limit {
+		matched = matched[:limit]
+		last := matched[limit-1]
+		next = EncodeCursor(Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	return matched, next, nil
+}
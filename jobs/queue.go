@@ -1,12 +1,23 @@
 package jobs
 
 import (
+	"context"
 	"fmt"
 	"sync"
+
+	"omnitranscripts/models"
 )
 
+// Queue holds every job and batch known to this process. Jobs are only ever
+// mutated under the queue lock through the state-machine methods below;
+// readers get copies, so a snapshot never changes underneath them.
 type Queue struct {
-	jobs map[string]*Job
+	jobs    map[string]*Job
+	batches map[string]*Batch
+	events  *Broker
+	// runs cancels the work of running jobs, by ID, once they stop
+	// running. See RunContext.
+	runs map[string]*jobRun
 	mu   sync.RWMutex
 }
 
@@ -14,7 +25,10 @@ var instance *Queue
 
 func Initialize() {
 	instance = &Queue{
-		jobs: make(map[string]*Job),
+		jobs:    make(map[string]*Job),
+		batches: make(map[string]*Batch),
+		events:  NewBroker(),
+		runs:    make(map[string]*jobRun),
 	}
 }
 
@@ -22,12 +36,21 @@ func GetQueue() *Queue {
 	return instance
 }
 
+// AddJob stores a copy of the job. Later changes to the caller's value are
+// not seen by the queue.
 func (q *Queue) AddJob(job *Job) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.jobs[job.ID] = job
+	q.jobs[job.ID] = job.Clone()
+	q.events.Publish(job.ID, statusEvent(job))
+}
+
+// Events returns the broker carrying every job's change events.
+func (q *Queue) Events() *Broker {
+	return q.events
 }
 
+// GetJob returns a snapshot of the job.
 func (q *Queue) GetJob(id string) (*Job, error) {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
@@ -36,22 +59,135 @@ func (q *Queue) GetJob(id string) (*Job, error) {
 	if !exists {
 		return nil, fmt.Errorf("job not found")
 	}
-	return job, nil
+	return job.Clone(), nil
 }
 
-func (q *Queue) UpdateJob(job *Job) {
+// Update applies fn to the stored job under the queue lock and returns a
+// snapshot of the result. If fn returns an error the job is left unchanged.
+// Resulting status and stage changes are published to Events.
+func (q *Queue) Update(id string, fn func(job *Job) error) (*Job, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.jobs[job.ID] = job
+
+	current, exists := q.jobs[id]
+	if !exists {
+		return nil, fmt.Errorf("job not found")
+	}
+
+	next := current.Clone()
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+
+	q.jobs[id] = next
+	if current.Status == StatusRunning && next.Status != StatusRunning {
+		if run, ok := q.runs[id]; ok {
+			run.cancel()
+			delete(q.runs, id)
+		}
+	}
+	if events := changeEvents(current, next); len(events) > 0 {
+		q.events.Publish(id, events...)
+	}
+	return next.Clone(), nil
+}
+
+// Start moves a queued job to running.
+func (q *Queue) Start(id string) (*Job, error) {
+	return q.Update(id, (*Job).Start)
+}
+
+// RunContext returns a context for the work of a running job, derived
+// from parent. It is cancelled as soon as the job stops running, such as
+// when it is cancelled or an operator fails it, and right away if it is not
+// running. Call release once the work is done.
+func (q *Queue) RunContext(parent context.Context, id string) (ctx context.Context, release func()) {
+	ctx, cancel := context.WithCancel(parent)
+	run := &jobRun{cancel: cancel}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, exists := q.jobs[id]; !exists || job.Status != StatusRunning {
+		cancel()
+		return ctx, cancel
+	}
+	if previous, ok := q.runs[id]; ok {
+		previous.cancel()
+	}
+	q.runs[id] = run
+	return ctx, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		cancel()
+		// A requeued job may be running again under a newer context.
+		if q.runs[id] == run {
+			delete(q.runs, id)
+		}
+	}
+}
+
+// jobRun is the cancellation of one run of a job.
+type jobRun struct {
+	cancel context.CancelFunc
+}
+
+// EnterStage records the pipeline stage of a running job.
+func (q *Queue) EnterStage(id, stage string) (*Job, error) {
+	return q.Update(id, func(job *Job) error {
+		return job.EnterStage(stage)
+	})
+}
+
+// PublishSegment sends a segment of a running job's transcript to the job's
+// subscribers as soon as it is decoded. The segment is not stored on the
+// job; Complete records the final transcript.
+func (q *Queue) PublishSegment(id string, segment models.Segment) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	job, exists := q.jobs[id]
+	if !exists {
+		return fmt.Errorf("job not found")
+	}
+	if job.Status != StatusRunning {
+		return fmt.Errorf("%w: job %s is %s", models.ErrInvalidTransition, id, job.Status)
+	}
+	q.events.Publish(id, Event{
+		Type:     EventSegment,
+		Stage:    job.Stage,
+		Progress: stageProgress[models.StageTranscribe],
+		Segment:  &segment,
+	})
+	return nil
+}
+
+// Complete moves a running job to completed with its results.
+func (q *Queue) Complete(id, transcript string, segments []models.Segment) (*Job, error) {
+	return q.Update(id, func(job *Job) error {
+		return job.Complete(transcript, segments)
+	})
+}
+
+// Fail moves a queued or running job to failed.
+func (q *Queue) Fail(id string, cause error) (*Job, error) {
+	return q.Update(id, func(job *Job) error {
+		return job.Fail(cause)
+	})
+}
+
+// Cancel moves a queued or running job to cancelled.
+func (q *Queue) Cancel(id string) (*Job, error) {
+	return q.Update(id, (*Job).Cancel)
 }
 
+// ListJobs returns snapshots of every job.
 func (q *Queue) ListJobs() []*Job {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 
 	jobs := make([]*Job, 0, len(q.jobs))
 	for _, job := range q.jobs {
-		jobs = append(jobs, job)
+		jobs = append(jobs, job.Clone())
 	}
 	return jobs
 }
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"omnitranscripts/models"
+)
+
+// MaxSchedulingWeight bounds an API key's share of the workers relative to
+// keys of weight 1.
+const MaxSchedulingWeight = 100
+
+// APIKeyList is the admin listing of every stored key.
+type APIKeyList struct {
+	Keys []APIKeyInfo `json:"keys"`
+}
+
+// CreateAPIKeyRequest is the body of the admin call that creates a key.
+type CreateAPIKeyRequest struct {
+	Name       string        `json:"name"`
+	Owner      string        `json:"owner"`
+	Scopes     []Scope       `json:"scopes"`
+	ExpiresAt  *time.Time    `json:"expires_at,omitempty"`
+	Quota      *models.Quota `json:"quota,omitempty"`
+	RateLimits RateLimits    `json:"rate_limits,omitempty"`
+	Weight     float64       `json:"weight,omitempty"`
+}
+
+// Validate checks the request describes a usable key.
+func (r *CreateAPIKeyRequest) Validate(now time.Time) error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.Owner == "" {
+		return errors.New("owner is required")
+	}
+	if len(r.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range r.Scopes {
+		if !scope.Valid() {
+			return fmt.Errorf("unknown scope %q, expected read, submit or admin", scope)
+		}
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	return (&APIKeyLimits{Quota: r.Quota, RateLimits: r.RateLimits, Weight: r.Weight}).Validate()
+}
+
+// NewKey generates the key the request describes, returning it with its
+// token.
+func (r *CreateAPIKeyRequest) NewKey() (*APIKey, string, error) {
+	key, token, err := NewAPIKey(r.Name, r.Owner, r.Scopes, r.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	key.Quota = r.Quota
+	key.RateLimits = r.RateLimits
+	key.Weight = r.Weight
+	return key, token, nil
+}
+
+// APIKeyLimits is the body of the admin call that sets a key's quota, rate
+// limits and scheduling weight. Each replaces the key's current one;
+// omitting it returns the key to the defaults. Limits left unset in Quota
+// keep their default values.
+type APIKeyLimits struct {
+	Quota      *models.Quota `json:"quota,omitempty"`
+	RateLimits RateLimits    `json:"rate_limits,omitempty"`
+	Weight     float64       `json:"weight,omitempty"`
+}
+
+// Validate rejects negative limits, unknown rate limit classes and weights
+// outside 0 to MaxSchedulingWeight.
+func (l *APIKeyLimits) Validate() error {
+	if l.Weight < 0 || l.Weight > MaxSchedulingWeight {
+		return fmt.Errorf("weight must be between 0 and %d", MaxSchedulingWeight)
+	}
+	if l.Quota != nil {
+		if err := l.Quota.Validate(); err != nil {
+			return err
+		}
+	}
+	return l.RateLimits.Validate()
+}
+
+// Apply sets the limits on key.
+func (l *APIKeyLimits) Apply(key *APIKey) {
+	key.Quota = l.Quota
+	key.RateLimits = l.RateLimits
+	key.Weight = l.Weight
+}
@@ -0,0 +1,393 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"omnitranscripts/models"
+)
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	// ScopeRead allows reading jobs, batches and transcripts.
+	ScopeRead Scope = "read"
+	// ScopeSubmit allows submitting jobs and batches.
+	ScopeSubmit Scope = "submit"
+	// ScopeAdmin allows operator actions. It implies every other scope.
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes lists every scope.
+var Scopes = []Scope{ScopeRead, ScopeSubmit, ScopeAdmin}
+
+// Valid reports whether s is one of the known scopes.
+func (s Scope) Valid() bool {
+	for _, known := range Scopes {
+		if s == known {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyPrefix starts every generated API key. The key ID follows it, so a
+// key can be found without comparing against every stored hash.
+const APIKeyPrefix = "ot_"
+
+var (
+	// ErrInvalidAPIKey is returned for tokens that match no key.
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrExpiredAPIKey is returned for keys past their expiry.
+	ErrExpiredAPIKey = errors.New("API key has expired")
+	// ErrRevokedAPIKey is returned for keys an admin has revoked.
+	ErrRevokedAPIKey = errors.New("API key has been revoked")
+	// ErrAPIKeyNotFound is returned by a KeyStore for unknown key IDs.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey is a stored API key. Only the SHA-256 hash of the secret is kept.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Owner     string     `json:"owner"`
+	Hash      string     `json:"hash"`
+	Scopes    []Scope    `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Quota overrides the default quota for this key.
+	Quota *models.Quota `json:"quota,omitempty"`
+	// RateLimits overrides the default rate limits of the classes it names.
+	RateLimits RateLimits `json:"rate_limits,omitempty"`
+	// Weight is the share of the workers the key's jobs get relative to
+	// other keys; zero means 1.
+	Weight    float64   `json:"weight,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// RevokedAt is when an admin revoked the key, which is kept for the
+	// audit trail but no longer authenticates.
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// APIKeyInfo is an API key as shown to admins: everything but its hash,
+// and the token when the key has just been created or rotated.
+type APIKeyInfo struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	Owner      string        `json:"owner"`
+	Scopes     []Scope       `json:"scopes"`
+	ExpiresAt  *time.Time    `json:"expires_at,omitempty"`
+	Quota      *models.Quota `json:"quota,omitempty"`
+	RateLimits RateLimits    `json:"rate_limits,omitempty"`
+	Weight     float64       `json:"weight,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	RevokedAt  *time.Time    `json:"revoked_at,omitempty"`
+	// Token is shown once; only its hash is stored.
+	Token string `json:"token,omitempty"`
+}
+
+// Info returns the key as shown to admins, without its token.
+func (k *APIKey) Info() APIKeyInfo {
+	return APIKeyInfo{
+		ID:         k.ID,
+		Name:       k.Name,
+		Owner:      k.Owner,
+		Scopes:     k.Scopes,
+		ExpiresAt:  k.ExpiresAt,
+		Quota:      k.Quota,
+		RateLimits: k.RateLimits,
+		Weight:     k.Weight,
+		CreatedAt:  k.CreatedAt,
+		RevokedAt:  k.RevokedAt,
+	}
+}
+
+// HasScope reports whether the key grants scope. Admin keys grant every scope.
+func (k *APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the key has expired at now.
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Revoked reports whether the key has been revoked.
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+// HashAPIKey returns the hex SHA-256 hash stored for a key's secret.
+func HashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey generates a key and returns it with the token to hand to the
+// client once. The token is not stored anywhere.
+func NewAPIKey(name, owner string, scopes []Scope, expiresAt *time.Time) (*APIKey, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &APIKey{
+		ID:        id,
+		Name:      name,
+		Owner:     owner,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+	token, err := key.Rotate()
+	if err != nil {
+		return nil, "", err
+	}
+	return key, token, nil
+}
+
+// Rotate gives the key a new secret, returning its token. The key keeps its
+// ID, and with it its quota usage and metering, but the old token stops
+// working once the key is stored.
+func (k *APIKey) Rotate() (string, error) {
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", err
+	}
+	token := APIKeyPrefix + k.ID + "_" + secret
+	k.Hash = HashAPIKey(token)
+	return token, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// keyIDFromToken extracts the key ID from a generated token.
+func keyIDFromToken(token string) (string, bool) {
+	rest, ok := strings.CutPrefix(token, APIKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	return id, ok && id != ""
+}
+
+// KeyStore holds API keys by ID.
+type KeyStore interface {
+	// Get returns the key with the given ID, or ErrAPIKeyNotFound.
+	Get(ctx context.Context, id string) (*APIKey, error)
+	// Put adds or replaces a key.
+	Put(ctx context.Context, key *APIKey) error
+	// List returns every key.
+	List(ctx context.Context) ([]*APIKey, error)
+}
+
+// Authenticator checks bearer tokens against a KeyStore.
+type Authenticator struct {
+	store  KeyStore
+	legacy []*APIKey
+}
+
+// NewAuthenticator returns an Authenticator for store. legacy keys are
+// plain shared secrets from configuration, such as API_KEY, which carry no
+// key ID; tokens without one are compared against each of them.
+func NewAuthenticator(store KeyStore, legacy ...*APIKey) *Authenticator {
+	return &Authenticator{store: store, legacy: legacy}
+}
+
+// LegacyKey describes a shared secret from configuration as a key. An
+// empty secret returns nil.
+func LegacyKey(id, owner, secret string, scopes ...Scope) *APIKey {
+	if secret == "" {
+		return nil
+	}
+	return &APIKey{ID: id, Name: id, Owner: owner, Hash: HashAPIKey(secret), Scopes: scopes}
+}
+
+// Store returns the store the Authenticator reads keys from.
+func (a *Authenticator) Store() KeyStore {
+	return a.store
+}
+
+// Authenticate returns the key token belongs to. Hashes are compared in
+// constant time.
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*APIKey, error) {
+	hash := []byte(HashAPIKey(token))
+
+	var candidates []*APIKey
+	if id, ok := keyIDFromToken(token); ok {
+		key, err := a.store.Get(ctx, id)
+		if err != nil && !errors.Is(err, ErrAPIKeyNotFound) {
+			return nil, err
+		}
+		if key != nil {
+			candidates = append(candidates, key)
+		}
+	} else {
+		candidates = a.legacy
+	}
+
+	var match *APIKey
+	for _, key := range candidates {
+		if key != nil && subtle.ConstantTimeCompare(hash, []byte(key.Hash)) == 1 {
+			match = key
+		}
+	}
+	if match == nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if match.Revoked() {
+		return nil, ErrRevokedAPIKey
+	}
+	if match.Expired(time.Now()) {
+		return nil, ErrExpiredAPIKey
+	}
+	return match, nil
+}
+
+type apiKeyContextKey struct{}
+
+// ContextWithAPIKey returns a copy of ctx carrying the authenticated key.
+func ContextWithAPIKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// APIKeyFromContext returns the key stored by ContextWithAPIKey, or nil.
+func APIKeyFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
+
+// FileKeyStore keeps API keys in memory, saving them as JSON to a file when
+// it has one. It suits a single server; other deployments should store keys
+// in their database.
+type FileKeyStore struct {
+	path string
+	keys map[string]*APIKey
+	mu   sync.RWMutex
+}
+
+// NewFileKeyStore loads the keys saved at path. A missing file is an empty
+// store, and an empty path keeps keys in memory only.
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{path: path, keys: make(map[string]*APIKey)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	}
+
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys in %s: %w", path, err)
+	}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+	return s, nil
+}
+
+func (s *FileKeyStore) Get(ctx context.Context, id string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	c := *key
+	return &c, nil
+}
+
+func (s *FileKeyStore) Put(ctx context.Context, key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *key
+	previous, existed := s.keys[key.ID]
+	s.keys[key.ID] = &c
+	if err := s.save(); err != nil {
+		if existed {
+			s.keys[key.ID] = previous
+		} else {
+			delete(s.keys, key.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileKeyStore) List(ctx context.Context) ([]*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		c := *key
+		keys = append(keys, &c)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// save writes every key to the file, replacing it atomically. Callers hold mu.
+func (s *FileKeyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".api-keys-*")
+	if err != nil {
+		return fmt.Errorf("failed to save API keys: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save API keys: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save API keys: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save API keys: %w", err)
+	}
+	return nil
+}
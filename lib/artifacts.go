@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"omnitranscripts/models"
+)
+
+// Transcript download formats besides the subtitle formats.
+const (
+	FormatTXT  SubtitleFormat = "txt"
+	FormatJSON SubtitleFormat = "json"
+)
+
+// TranscriptFormats lists every format a completed job can be downloaded in.
+var TranscriptFormats = []SubtitleFormat{FormatTXT, FormatSRT, FormatVTT, FormatJSON}
+
+var transcriptContentTypes = map[SubtitleFormat]string{
+	FormatTXT:  "text/plain; charset=utf-8",
+	FormatSRT:  "application/x-subrip; charset=utf-8",
+	FormatVTT:  "text/vtt; charset=utf-8",
+	FormatJSON: "application/json",
+}
+
+// ParseTranscriptFormat validates a format name such as "srt".
+func ParseTranscriptFormat(name string) (SubtitleFormat, bool) {
+	format := SubtitleFormat(strings.ToLower(name))
+	_, ok := transcriptContentTypes[format]
+	return format, ok
+}
+
+// TranscriptContentType returns the MIME type served for format.
+func TranscriptContentType(format SubtitleFormat) string {
+	return transcriptContentTypes[format]
+}
+
+// TranscriptDocument is the body of the json transcript download.
+type TranscriptDocument struct {
+	JobID      string           `json:"job_id"`
+	URL        string           `json:"url"`
+	Transcript string           `json:"transcript"`
+	Segments   []models.Segment `json:"segments"`
+}
+
+// RenderTranscript renders a completed job's transcript in format. Subtitle
+// formats are produced by ConvertSegmentsToSubtitles.
+func RenderTranscript(job *models.Job, format SubtitleFormat) ([]byte, error) {
+	switch format {
+	case FormatSRT, FormatVTT:
+		return []byte(ConvertSegmentsToSubtitles(job.Segments, format)), nil
+	case FormatTXT:
+		return []byte(strings.TrimSpace(job.Transcript) + "\n"), nil
+	case FormatJSON:
+		segments := job.Segments
+		if segments == nil {
+			segments = []models.Segment{}
+		}
+		return json.Marshal(TranscriptDocument{
+			JobID:      job.ID,
+			URL:        job.URL,
+			Transcript: job.Transcript,
+			Segments:   segments,
+		})
+	}
+	return nil, fmt.Errorf("unsupported transcript format %q", format)
+}
+
+// TranscriptURL returns the download URL of a job's transcript. With an
+// empty baseURL the URL is relative to the API root.
+func TranscriptURL(baseURL, jobID string, format SubtitleFormat) string {
+	return fmt.Sprintf("%s/%s/transcribe/%s/transcript.%s", strings.TrimRight(baseURL, "/"), models.CurrentAPIVersion, jobID, format)
+}
+
+// ServeTranscript writes a completed job's transcript as a download. The
+// ETag is derived from the rendered content, and conditional and range
+// requests are answered by http.ServeContent.
+func ServeTranscript(w http.ResponseWriter, r *http.Request, job *models.Job, format SubtitleFormat) {
+	body, err := RenderTranscript(job, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	filename := fmt.Sprintf("%s.%s", job.ID, format)
+
+	w.Header().Set("Content-Type", TranscriptContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+
+	modified := time.Time{}
+	if job.CompletedAt != nil {
+		modified = *job.CompletedAt
+	}
+	http.ServeContent(w, r, filename, modified, bytes.NewReader(body))
+}
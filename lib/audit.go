@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"omnitranscripts/models"
+)
+
+// AuditLog records admin actions.
+type AuditLog interface {
+	// Record appends entry to the log.
+	Record(ctx context.Context, entry models.AuditEntry) error
+	// List returns the entries matching filter, newest first.
+	List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error)
+}
+
+// FileAuditLog keeps the audit log in memory, appending each entry to a
+// file of JSON lines when it has one. Like FileKeyStore it suits a single
+// server.
+type FileAuditLog struct {
+	path    string
+	entries []models.AuditEntry
+	mu      sync.RWMutex
+}
+
+// NewFileAuditLog loads the entries saved at path. A missing file is an
+// empty log, and an empty path keeps entries in memory only.
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	l := &FileAuditLog{path: path}
+	if path == "" {
+		return l, nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry models.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log %s: %w", path, err)
+		}
+		l.entries = append(l.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return l, nil
+}
+
+func (l *FileAuditLog) Record(ctx context.Context, entry models.AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.path != "" {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+		_, err = f.Write(append(line, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+	}
+
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+func (l *FileAuditLog) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := []models.AuditEntry{}
+	for i := len(l.entries) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		entry := l.entries[i]
+		if filter.Action != "" && entry.Action != filter.Action {
+			continue
+		}
+		if filter.Target != "" && entry.Target != filter.Target {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
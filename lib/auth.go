@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -8,31 +9,151 @@ import (
 	"omnitranscripts/config"
 )
 
-func AuthMiddleware() fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		cfg := config.Load()
+// fiber.Ctx locals keys set by AuthMiddleware and HandshakeAuthMiddleware.
+const (
+	// LocalAPIKey holds the authenticated *APIKey.
+	LocalAPIKey = "api_key"
+	// LocalAPIKeyID holds the authenticated key's ID.
+	LocalAPIKeyID = "api_key_id"
+	// LocalOwner holds the owner of the authenticated key, who owns the
+	// jobs and batches it creates.
+	LocalOwner = "owner"
+)
+
+// IDs of the keys configured with API_KEY and ADMIN_API_KEY.
+const (
+	DefaultKeyID = "default"
+	AdminKeyID   = "admin"
+)
+
+// DefaultOwner owns the jobs of the keys configured with API_KEY and
+// ADMIN_API_KEY.
+const DefaultOwner = "default"
 
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
+// ConfiguredKeys returns the keys configured as plain secrets: API_KEY may
+// read and submit, and ADMIN_API_KEY may do anything.
+func ConfiguredKeys(cfg *config.Config) []*APIKey {
+	var keys []*APIKey
+	if key := LegacyKey(DefaultKeyID, DefaultOwner, cfg.APIKey, ScopeRead, ScopeSubmit); key != nil {
+		keys = append(keys, key)
+	}
+	if key := LegacyKey(AdminKeyID, DefaultOwner, cfg.AdminAPIKey, ScopeAdmin); key != nil {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// AuthMiddleware authenticates the bearer token of every request.
+func AuthMiddleware(auth *Authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, problem := bearerToken(c.Get("Authorization"))
+		if problem != "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Authorization header required",
+				"error": problem,
 			})
 		}
+		return authenticate(c, auth, token)
+	}
+}
 
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid authorization format. Use 'Bearer <token>'",
-			})
+// HandshakeAuthMiddleware authenticates WebSocket upgrade requests. Browsers
+// cannot set headers on a WebSocket handshake, so the API key may also be
+// passed as the access_token query parameter.
+func HandshakeAuthMiddleware(auth *Authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := c.Query("access_token")
+		if token == "" {
+			var problem string
+			token, problem = bearerToken(c.Get("Authorization"))
+			if problem != "" {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": problem,
+				})
+			}
 		}
+		return authenticate(c, auth, token)
+	}
+}
 
-		token := tokenParts[1]
-		if token != cfg.APIKey {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid API key",
+// authenticate attaches the key token belongs to to the request, or
+// rejects it.
+func authenticate(c *fiber.Ctx, auth *Authenticator, token string) error {
+	key, err := auth.Authenticate(c.UserContext(), token)
+	switch {
+	case errors.Is(err, ErrExpiredAPIKey):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "API key has expired",
+		})
+	case errors.Is(err, ErrRevokedAPIKey):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "API key has been revoked",
+		})
+	case errors.Is(err, ErrInvalidAPIKey):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid API key",
+		})
+	case err != nil:
+		return err
+	}
+
+	c.Locals(LocalAPIKey, key)
+	c.Locals(LocalAPIKeyID, key.ID)
+	c.Locals(LocalOwner, key.Owner)
+	c.SetUserContext(ContextWithAPIKey(c.UserContext(), key))
+
+	return c.Next()
+}
+
+// RequireScope rejects requests whose key lacks scope. It must follow
+// AuthMiddleware or HandshakeAuthMiddleware.
+func RequireScope(scope Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := CurrentAPIKey(c)
+		if key == nil || !key.HasScope(scope) {
+			message := "API key lacks the " + string(scope) + " scope"
+			if scope == ScopeAdmin {
+				message = "Admin access required"
+			}
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": message,
 			})
 		}
-
 		return c.Next()
 	}
 }
+
+// bearerToken extracts the token from an Authorization header, or returns
+// a message describing why the header is unusable.
+func bearerToken(authHeader string) (string, string) {
+	if authHeader == "" {
+		return "", "Authorization header required"
+	}
+
+	tokenParts := strings.Split(authHeader, " ")
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return "", "Invalid authorization format. Use 'Bearer <token>'"
+	}
+
+	return tokenParts[1], ""
+}
+
+// CurrentAPIKey returns the API key that authenticated the request, or nil
+// if the request did not pass through AuthMiddleware.
+func CurrentAPIKey(c *fiber.Ctx) *APIKey {
+	key, _ := c.Locals(LocalAPIKey).(*APIKey)
+	return key
+}
+
+// APIKeyID returns the ID of the API key that authenticated the request,
+// or an empty string if the request did not pass through AuthMiddleware.
+func APIKeyID(c *fiber.Ctx) string {
+	id, _ := c.Locals(LocalAPIKeyID).(string)
+	return id
+}
+
+// Owner returns the owner of the API key that authenticated the request,
+// or an empty string if the request did not pass through AuthMiddleware.
+func Owner(c *fiber.Ctx) string {
+	owner, _ := c.Locals(LocalOwner).(string)
+	return owner
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultMaxRequestBytes caps request bodies other than uploads when
+// MAX_REQUEST_BODY_KB is not set.
+const DefaultMaxRequestBytes = 1 << 20
+
+// LimitRequestBody rejects request bodies larger than maxBytes with 413, or
+// DefaultMaxRequestBytes when maxBytes is not positive.
+//
+// The server streams request bodies so uploads never sit in memory, which
+// also stops fiber's BodyLimit from applying: c.Body() would read a stream
+// of any size. Every route that reads its body with c.Body() or BodyParser
+// must sit behind this middleware; only the upload route, which reads the
+// stream itself, may not.
+func LimitRequestBody(maxBytes int64) fiber.Handler {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxRequestBytes
+	}
+
+	return func(c *fiber.Ctx) error {
+		if int64(c.Request().Header.ContentLength()) > maxBytes {
+			return requestTooLarge(c)
+		}
+
+		stream := c.Context().RequestBodyStream()
+		if stream == nil {
+			return c.Next()
+		}
+		// Chunked bodies have no length up front, so read at most one
+		// byte past the limit and keep the result as the body.
+		body, err := io.ReadAll(io.LimitReader(stream, maxBytes+1))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to read request body",
+			})
+		}
+		if int64(len(body)) > maxBytes {
+			return requestTooLarge(c)
+		}
+		c.Request().SetBody(body)
+		return c.Next()
+	}
+}
+
+func requestTooLarge(c *fiber.Ctx) error {
+	// The rest of the body is not read, so the connection cannot be reused.
+	c.Set(fiber.HeaderConnection, "close")
+	return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+		"error": "Request body is too large",
+	})
+}
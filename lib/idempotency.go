@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// IdempotencyKeyHeader carries the client-chosen key of a retryable request.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed from the store.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// MaxIdempotencyKeyLength bounds the size of stored keys.
+	MaxIdempotencyKeyLength = 255
+	// DefaultIdempotencyWindow is how long keys are remembered when
+	// IDEMPOTENCY_WINDOW is not set.
+	DefaultIdempotencyWindow = 24 * time.Hour
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key comes back with a
+	// different request than the one it was first used for.
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
+	// ErrIdempotencyKeyInFlight is returned while the first request using a
+	// key has not finished yet.
+	ErrIdempotencyKeyInFlight = errors.New("a request with this idempotency key is still being processed")
+)
+
+// IdempotentResponse is a stored response replayed for repeated keys.
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	response    *IdempotentResponse
+	expiresAt   time.Time
+}
+
+// IdempotencyStore remembers the responses of requests sent with an
+// Idempotency-Key for a fixed window. Keys are scoped per API key, so two
+// keys choosing the same value never see each other's responses, even when
+// they share an owner.
+type IdempotencyStore struct {
+	window    time.Duration
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+func NewIdempotencyStore(window time.Duration) *IdempotencyStore {
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	return &IdempotencyStore{
+		window:  window,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// RequestFingerprint hashes the parts of a request that must match for a
+// repeated idempotency key to be accepted.
+func RequestFingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func idempotencyScope(keyID, key string) string {
+	return keyID + "\x00" + key
+}
+
+// Reserve claims key for a new request. If the key was already used with
+// the same fingerprint its stored response is returned instead; a different
+// fingerprint or an unfinished first request yields an error.
+func (s *IdempotencyStore) Reserve(keyID, key, fingerprint string) (*IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepLocked(now)
+
+	scope := idempotencyScope(keyID, key)
+	if entry, ok := s.entries[scope]; ok && now.Before(entry.expiresAt) {
+		if entry.fingerprint != fingerprint {
+			return nil, ErrIdempotencyKeyReused
+		}
+		if entry.response == nil {
+			return nil, ErrIdempotencyKeyInFlight
+		}
+		return entry.response, nil
+	}
+
+	s.entries[scope] = &idempotencyEntry{
+		fingerprint: fingerprint,
+		expiresAt:   now.Add(s.window),
+	}
+	return nil, nil
+}
+
+// Complete stores the response of a reserved key for replay.
+func (s *IdempotencyStore) Complete(keyID, key string, response *IdempotentResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[idempotencyScope(keyID, key)]; ok {
+		entry.response = response
+	}
+}
+
+// Release forgets a reserved key so the request can be retried, used when
+// the first attempt did not succeed.
+func (s *IdempotencyStore) Release(keyID, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, idempotencyScope(keyID, key))
+}
+
+// sweepLocked drops expired keys, at most once a minute.
+func (s *IdempotencyStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for scope, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, scope)
+		}
+	}
+}
+
+// IdempotencyMiddleware replays the original response when a request is
+// retried with the same Idempotency-Key. Only successful responses are
+// kept; after an error the key is released and a retry runs again.
+// Requests without the header pass straight through.
+func IdempotencyMiddleware(store *IdempotencyStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > MaxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Idempotency-Key must be at most 255 characters",
+			})
+		}
+
+		keyID := APIKeyID(c)
+		fingerprint := RequestFingerprint([]byte(c.Method()), []byte(c.Path()), c.Body())
+
+		stored, err := store.Reserve(keyID, key, fingerprint)
+		switch {
+		case errors.Is(err, ErrIdempotencyKeyReused):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Idempotency-Key was already used with a different request",
+			})
+		case errors.Is(err, ErrIdempotencyKeyInFlight):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "A request with this Idempotency-Key is still being processed",
+			})
+		case stored != nil:
+			c.Set(IdempotentReplayedHeader, "true")
+			c.Set(fiber.HeaderContentType, stored.ContentType)
+			return c.Status(stored.Status).Send(stored.Body)
+		}
+
+		if err := c.Next(); err != nil {
+			store.Release(keyID, key)
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if status < 200 || status >= 300 {
+			store.Release(keyID, key)
+			return nil
+		}
+
+		store.Complete(keyID, key, &IdempotentResponse{
+			Status:      status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        append([]byte(nil), c.Response().Body()...),
+		})
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"omnitranscripts/models"
+)
+
+// UsageMeter records API calls and billable jobs, and reports them per
+// key and day.
+type UsageMeter interface {
+	RecordCall(ctx context.Context, call models.APICall) error
+	RecordJob(ctx context.Context, job models.BillableJob) error
+	// Report returns keyID's usage on the UTC days from from to to,
+	// inclusive.
+	Report(ctx context.Context, keyID string, from, to time.Time) (*models.UsageReport, error)
+}
+
+// FileUsageMeter keeps daily usage totals per key in memory, appending each
+// change to a file of JSON lines when it has one. Individual calls are not
+// kept. Like FileAuditLog it suits a single server.
+type FileUsageMeter struct {
+	path string
+	days map[meterKey]*models.UsageTotals
+	// lines counts the lines in the file, which is compacted to one line
+	// per key and day once it holds too many more.
+	lines int
+	mu    sync.Mutex
+}
+
+type meterKey struct {
+	keyID string
+	date  string
+}
+
+// usageLine is a line of a FileUsageMeter's file: usage to add to a key's
+// totals on a UTC day.
+type usageLine struct {
+	KeyID string `json:"key_id"`
+	Date  string `json:"date"`
+	models.UsageTotals
+}
+
+// usageCompactionSlack is how many lines a FileUsageMeter's file may hold
+// beyond one per key and day before it is compacted.
+const usageCompactionSlack = 10000
+
+// NewFileUsageMeter loads the totals saved at path and compacts the file.
+// A missing file is an empty meter, and an empty path keeps totals in
+// memory only, so they start over on restart.
+func NewFileUsageMeter(path string) (*FileUsageMeter, error) {
+	m := &FileUsageMeter{path: path, days: make(map[meterKey]*models.UsageTotals)}
+	if path == "" {
+		return m, nil
+	}
+
+	err := readJSONLines(path, func(data []byte) error {
+		var line usageLine
+		if err := json.Unmarshal(data, &line); err != nil {
+			return err
+		}
+		m.totals(line.KeyID, line.Date).Add(line.UsageTotals)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage file %s: %w", path, err)
+	}
+	if err := m.compactLocked(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *FileUsageMeter) RecordCall(ctx context.Context, call models.APICall) error {
+	var usage models.UsageTotals
+	usage.AddCall(call)
+	return m.record(call.APIKeyID, call.At, usage)
+}
+
+func (m *FileUsageMeter) RecordJob(ctx context.Context, job models.BillableJob) error {
+	var usage models.UsageTotals
+	usage.AddJob(job)
+	return m.record(job.APIKeyID, job.At, usage)
+}
+
+// record adds usage to keyID's totals on the UTC day containing at. The
+// totals are kept even when the file cannot be written, and are saved with
+// the next compaction.
+func (m *FileUsageMeter) record(keyID string, at time.Time, usage models.UsageTotals) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	date := at.UTC().Format(models.DateLayout)
+	m.totals(keyID, date).Add(usage)
+	if m.path == "" {
+		return nil
+	}
+
+	if m.lines >= len(m.days)+usageCompactionSlack {
+		return m.compactLocked()
+	}
+	if err := appendJSONLine(m.path, usageLine{KeyID: keyID, Date: date, UsageTotals: usage}); err != nil {
+		return fmt.Errorf("failed to write usage file: %w", err)
+	}
+	m.lines++
+	return nil
+}
+
+// compactLocked rewrites the file with one line per key and day. Callers
+// hold mu, or own m.
+func (m *FileUsageMeter) compactLocked() error {
+	lines := make([]interface{}, 0, len(m.days))
+	for key, totals := range m.days {
+		lines = append(lines, usageLine{KeyID: key.keyID, Date: key.date, UsageTotals: *totals})
+	}
+	if err := writeJSONLines(m.path, ".usage-*", lines); err != nil {
+		return fmt.Errorf("failed to save usage file: %w", err)
+	}
+	m.lines = len(lines)
+	return nil
+}
+
+func (m *FileUsageMeter) Report(ctx context.Context, keyID string, from, to time.Time) (*models.UsageReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	days := make(map[string]models.UsageTotals)
+	for day := models.DayStart(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(models.DateLayout)
+		if totals, ok := m.days[meterKey{keyID, date}]; ok {
+			days[date] = *totals
+		}
+	}
+	return models.NewUsageReport(keyID, from, to, days), nil
+}
+
+// totals returns the totals of keyID on date. Callers hold mu, or own m.
+func (m *FileUsageMeter) totals(keyID, date string) *models.UsageTotals {
+	key := meterKey{keyID, date}
+	totals, ok := m.days[key]
+	if !ok {
+		totals = &models.UsageTotals{}
+		m.days[key] = totals
+	}
+	return totals
+}
@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lrstanley/go-ytdlp"
+
+	"omnitranscripts/models"
+)
+
+// ExpandPlaylist lists the entry URLs of a playlist or channel without
+// downloading anything, using yt-dlp's --flat-playlist mode. A URL that
+// points at a single video expands to itself.
+func ExpandPlaylist(url string, limit int) ([]string, error) {
+	dl := ytdlp.New().
+		FlatPlaylist().
+		Print("url").
+		NoWarnings()
+
+	if limit > 0 {
+		dl = dl.PlaylistItems(fmt.Sprintf("1:%d", limit))
+	}
+
+	result, err := dl.Run(context.Background(), url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand playlist: %w", err)
+	}
+
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("yt-dlp failed with code %d: %s", result.ExitCode, result.Stderr)
+	}
+
+	var urls []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if models.ValidateURL(line) {
+			urls = append(urls, line)
+		}
+	}
+
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("playlist contains no entries")
+	}
+
+	return urls, nil
+}
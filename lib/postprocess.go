@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"regexp"
+	"strings"
+
+	"omnitranscripts/models"
+)
+
+var (
+	fillerWords = regexp.MustCompile(`(?i)(^|\s)(um+|uh+|erm+|hmm+|ah+)[,.]?(\s|$)`)
+	extraSpaces = regexp.MustCompile(`\s{2,}`)
+)
+
+// PostProcess applies the requested clean-ups to a transcript and its
+// segments. Segments left empty by filler removal are dropped, and the
+// transcript is rebuilt from the segments when any were changed.
+func PostProcess(transcript string, segments []models.Segment, opts models.PostProcessing) (string, []models.Segment) {
+	if !opts.RemoveFillers && !opts.MergeSegments {
+		return transcript, segments
+	}
+
+	if opts.RemoveFillers {
+		transcript = removeFillers(transcript)
+		cleaned := segments[:0:0]
+		for _, segment := range segments {
+			segment.Text = removeFillers(segment.Text)
+			if segment.Text != "" {
+				cleaned = append(cleaned, segment)
+			}
+		}
+		segments = cleaned
+	}
+
+	if opts.MergeSegments {
+		segments = mergeSentences(segments)
+	}
+
+	if len(segments) > 0 {
+		texts := make([]string, len(segments))
+		for i, segment := range segments {
+			texts[i] = segment.Text
+		}
+		transcript = strings.Join(texts, " ")
+	}
+	return transcript, segments
+}
+
+func removeFillers(text string) string {
+	// Matches share their surrounding spaces, so run until nothing changes.
+	for {
+		cleaned := fillerWords.ReplaceAllString(text, " ")
+		if cleaned == text {
+			break
+		}
+		text = cleaned
+	}
+	return strings.TrimSpace(extraSpaces.ReplaceAllString(text, " "))
+}
+
+// mergeSentences joins consecutive segments until one ends a sentence.
+func mergeSentences(segments []models.Segment) []models.Segment {
+	var merged []models.Segment
+	for _, segment := range segments {
+		text := strings.TrimSpace(segment.Text)
+		if n := len(merged); n > 0 && !endsSentence(merged[n-1].Text) {
+			merged[n-1].Text += " " + text
+			merged[n-1].End = segment.End
+			continue
+		}
+		segment.Text = text
+		merged = append(merged, segment)
+	}
+	return merged
+}
+
+func endsSentence(text string) bool {
+	return strings.HasSuffix(text, ".") || strings.HasSuffix(text, "?") || strings.HasSuffix(text, "!")
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"omnitranscripts/config"
+	"omnitranscripts/models"
+)
+
+// DefaultQuota returns the quota of keys that do not set their own:
+// FREE_JOB_LIMIT jobs a day, QUOTA_MEDIA_MINUTES a month,
+// QUOTA_CONCURRENT_JOBS at once and media up to MAX_VIDEO_LENGTH seconds.
+func DefaultQuota(cfg *config.Config) models.Quota {
+	return models.Quota{
+		JobsPerDay:           cfg.FreeJobLimit,
+		MediaMinutesPerMonth: cfg.QuotaMediaMinutes,
+		ConcurrentJobs:       cfg.QuotaConcurrentJobs,
+		MaxMediaSeconds:      cfg.MaxVideoLength,
+	}
+}
+
+// QuotaFor returns key's own quota with any limit it leaves unset taken
+// from fallback, or fallback if it has none.
+func QuotaFor(key *APIKey, fallback models.Quota) models.Quota {
+	if key != nil && key.Quota != nil {
+		return key.Quota.WithDefaults(fallback)
+	}
+	return fallback
+}
+
+// QuotaTracker counts what each API key has submitted in memory and admits
+// jobs against its quota.
+type QuotaTracker struct {
+	fallback models.Quota
+	finished func(jobID string) bool
+	keys     map[string]*keyUsage
+	mu       sync.Mutex
+}
+
+type keyUsage struct {
+	quota        models.Quota
+	day          time.Time
+	jobsToday    int
+	month        time.Time
+	mediaMinutes int
+	active       map[string]bool
+}
+
+// NewQuotaTracker returns a tracker applying fallback to keys without their
+// own quota. finished reports whether a job, or every child of a batch, has
+// reached a terminal status; admitted jobs and batches count as concurrent
+// until it does.
+func NewQuotaTracker(fallback models.Quota, finished func(id string) bool) *QuotaTracker {
+	return &QuotaTracker{
+		fallback: fallback,
+		finished: finished,
+		keys:     make(map[string]*keyUsage),
+	}
+}
+
+// Quota returns the quota applied to key.
+func (t *QuotaTracker) Quota(key *APIKey) models.Quota {
+	return QuotaFor(key, t.fallback)
+}
+
+// Admit counts jobIDs, together costing minutes media minutes, against
+// key's quota if they all fit, and returns the resulting usage. Otherwise
+// nothing is counted and the limit they would exceed is returned.
+func (t *QuotaTracker) Admit(key *APIKey, jobIDs []string, minutes int) (models.QuotaUsage, *models.QuotaExceededError) {
+	return t.admit(key, jobIDs, len(jobIDs), minutes, func(q models.Quota, usage models.QuotaUsage) *models.QuotaExceededError {
+		return q.Check(usage, len(jobIDs), minutes)
+	})
+}
+
+// AdmitBatch counts the given number of children of batchID against key's
+// quota like Admit, except that the whole batch takes a single concurrent
+// job until all of its children finish.
+func (t *QuotaTracker) AdmitBatch(key *APIKey, batchID string, jobs int) (models.QuotaUsage, *models.QuotaExceededError) {
+	return t.admit(key, []string{batchID}, jobs, 0, func(q models.Quota, usage models.QuotaUsage) *models.QuotaExceededError {
+		return q.CheckBatch(usage, jobs)
+	})
+}
+
+// admit counts jobs and minutes against key's quota, and activeIDs as
+// running, if check finds they fit.
+func (t *QuotaTracker) admit(key *APIKey, activeIDs []string, jobs, minutes int, check func(models.Quota, models.QuotaUsage) *models.QuotaExceededError) (models.QuotaUsage, *models.QuotaExceededError) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.usageLocked(key.ID, time.Now())
+	u.quota = t.Quota(key)
+	if err := check(u.quota, u.snapshot()); err != nil {
+		return u.snapshot(), err
+	}
+
+	u.jobsToday += jobs
+	u.mediaMinutes += minutes
+	for _, id := range activeIDs {
+		u.active[id] = true
+	}
+	return u.snapshot(), nil
+}
+
+// ChargeMedia checks the length of media lasting seconds and adds its
+// minutes to a key's monthly media usage, for jobs admitted before their
+// duration was known, such as batch children. It is checked against the
+// quota the key's jobs were last admitted under, and returns the
+// download.too_long or *models.QuotaExceededError error it would break.
+func (t *QuotaTracker) ChargeMedia(keyID string, seconds int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.usageLocked(keyID, time.Now())
+	if err := u.quota.CheckLength(seconds); err != nil {
+		return err
+	}
+	minutes := models.MediaMinutes(seconds)
+	// The job being charged was already admitted, so only its media counts.
+	if err := (models.Quota{MediaMinutesPerMonth: u.quota.MediaMinutesPerMonth}).Check(u.snapshot(), 0, minutes); err != nil {
+		return err
+	}
+	u.mediaMinutes += minutes
+	return nil
+}
+
+// Usage returns key's current usage.
+func (t *QuotaTracker) Usage(keyID string) models.QuotaUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.usageLocked(keyID, time.Now()).snapshot()
+}
+
+// usageLocked returns keyID's usage with expired periods reset and
+// finished jobs and batches no longer counted as active. Callers hold mu.
+func (t *QuotaTracker) usageLocked(keyID string, now time.Time) *keyUsage {
+	u, ok := t.keys[keyID]
+	if !ok {
+		u = &keyUsage{active: make(map[string]bool)}
+		t.keys[keyID] = u
+	}
+
+	if day := models.DayStart(now); !u.day.Equal(day) {
+		u.day = day
+		u.jobsToday = 0
+	}
+	if month := models.MonthStart(now); !u.month.Equal(month) {
+		u.month = month
+		u.mediaMinutes = 0
+	}
+	for id := range u.active {
+		if t.finished(id) {
+			delete(u.active, id)
+		}
+	}
+	return u
+}
+
+func (u *keyUsage) snapshot() models.QuotaUsage {
+	return models.QuotaUsage{
+		JobsToday:         u.jobsToday,
+		MediaMinutesMonth: u.mediaMinutes,
+		ActiveJobs:        len(u.active),
+		DayResetsAt:       u.day.AddDate(0, 0, 1),
+		MonthResetsAt:     u.month.AddDate(0, 1, 0),
+	}
+}
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/config"
+)
+
+// RateLimitClass groups the routes that share a rate limit.
+type RateLimitClass string
+
+const (
+	// RateLimitSubmit covers job, batch and upload submissions.
+	RateLimitSubmit RateLimitClass = "submit"
+	// RateLimitPoll covers reading jobs, batches, events and usage.
+	RateLimitPoll RateLimitClass = "poll"
+	// RateLimitDownload covers transcript downloads.
+	RateLimitDownload RateLimitClass = "download"
+)
+
+// RateLimit is a token bucket: it holds Burst requests and refills at
+// PerMinute requests a minute. Burst defaults to PerMinute, and a zero
+// PerMinute is unlimited.
+type RateLimit struct {
+	PerMinute int `json:"per_minute"`
+	Burst     int `json:"burst,omitempty"`
+}
+
+func (l RateLimit) capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.PerMinute
+}
+
+// interval is how long the bucket takes to refill one request.
+func (l RateLimit) interval() time.Duration {
+	return time.Minute / time.Duration(l.PerMinute)
+}
+
+// RateLimits holds a limit per class.
+type RateLimits map[RateLimitClass]RateLimit
+
+// DefaultRateLimits returns the limits of keys that do not set their own,
+// from RATE_LIMIT_SUBMIT, RATE_LIMIT_POLL and RATE_LIMIT_DOWNLOAD.
+func DefaultRateLimits(cfg *config.Config) RateLimits {
+	return RateLimits{
+		RateLimitSubmit:   {PerMinute: cfg.RateLimitSubmit},
+		RateLimitPoll:     {PerMinute: cfg.RateLimitPoll},
+		RateLimitDownload: {PerMinute: cfg.RateLimitDownload},
+	}
+}
+
+// Validate rejects unknown classes and negative limits.
+func (l RateLimits) Validate() error {
+	for class, limit := range l {
+		switch class {
+		case RateLimitSubmit, RateLimitPoll, RateLimitDownload:
+		default:
+			return fmt.Errorf("unknown rate limit class %q, expected submit, poll or download", class)
+		}
+		if limit.PerMinute < 0 || limit.Burst < 0 {
+			return fmt.Errorf("rate limit %s must not be negative", class)
+		}
+	}
+	return nil
+}
+
+// For returns key's own limit for class, or the one in l.
+func (l RateLimits) For(key *APIKey, class RateLimitClass) RateLimit {
+	if key != nil {
+		if limit, ok := key.RateLimits[class]; ok {
+			return limit
+		}
+	}
+	return l[class]
+}
+
+// RateLimitResult is the outcome of taking a request from a bucket.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again or, for a rejected
+	// request, until it holds one request.
+	Reset time.Duration
+}
+
+// RateLimitStore keeps token buckets. Instances sharing a store share
+// their limits.
+type RateLimitStore interface {
+	// Take removes one request from the bucket named key, if it holds one.
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// MemoryRateLimitStore keeps token buckets in memory, for a single server.
+type MemoryRateLimitStore struct {
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	// full is when the bucket will have refilled completely.
+	full time.Time
+}
+
+// NewMemoryRateLimitStore returns an empty store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	capacity := float64(limit.capacity())
+	interval := limit.interval()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, at: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.at); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/float64(interval))
+		b.at = now
+	}
+
+	result := RateLimitResult{Limit: limit.capacity()}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+		result.Remaining = int(b.tokens)
+		result.Reset = time.Duration((capacity - b.tokens) * float64(interval))
+	} else {
+		result.Reset = time.Duration((1 - b.tokens) * float64(interval))
+	}
+	b.full = now.Add(time.Duration((capacity - b.tokens) * float64(interval)))
+
+	s.sweepLocked(now)
+	return result, nil
+}
+
+// sweepLocked drops buckets that have refilled completely, at most once a
+// minute. Callers hold mu.
+func (s *MemoryRateLimitStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		// A full bucket is the same as a missing one.
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// RateLimitHeaders returns the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers describing result, plus Retry-After when the
+// request was rejected.
+func RateLimitHeaders(result RateLimitResult) map[string]string {
+	reset := strconv.Itoa(int(math.Ceil(result.Reset.Seconds())))
+	headers := map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(result.Limit),
+		"RateLimit-Remaining": strconv.Itoa(result.Remaining),
+		"RateLimit-Reset":     reset,
+	}
+	if !result.Allowed {
+		headers[fiber.HeaderRetryAfter] = reset
+	}
+	return headers
+}
+
+// RateLimitMiddleware limits each API key's requests in class. It must
+// follow AuthMiddleware; requests without a key are not limited.
+func RateLimitMiddleware(store RateLimitStore, limits RateLimits, class RateLimitClass) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := CurrentAPIKey(c)
+		if key == nil {
+			return c.Next()
+		}
+		limit := limits.For(key, class)
+		if limit.PerMinute <= 0 {
+			return c.Next()
+		}
+
+		result, err := store.Take(c.UserContext(), string(class)+":"+key.ID, limit, time.Now())
+		if err != nil {
+			return err
+		}
+		for name, value := range RateLimitHeaders(result) {
+			c.Set(name, value)
+		}
+		if !result.Allowed {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Rate limit exceeded, retry after " + c.GetRespHeader(fiber.HeaderRetryAfter) + " seconds",
+			})
+		}
+		return c.Next()
+	}
+}
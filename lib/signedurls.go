@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"omnitranscripts/models"
+)
+
+// DefaultArtifactURLTTL is how long signed download URLs stay valid when
+// ARTIFACT_URL_TTL is not set.
+const DefaultArtifactURLTTL = 24 * time.Hour
+
+var (
+	// ErrInvalidSignature is returned for signed URLs that were not issued
+	// by a known signing key or whose parameters were altered.
+	ErrInvalidSignature = errors.New("invalid download URL signature")
+	// ErrExpiredSignature is returned for signed URLs past their expiry.
+	ErrExpiredSignature = errors.New("download URL has expired")
+)
+
+// SigningKey is a secret artifact URLs are signed with. The ID is part of
+// every URL so the key can be found again when the URL is verified.
+type SigningKey struct {
+	ID     string
+	Secret string
+}
+
+// ParseSigningKeys parses a comma-separated list of id:secret pairs, as in
+// ARTIFACT_SIGNING_KEYS. The first key signs new URLs.
+func ParseSigningKeys(spec string) ([]SigningKey, error) {
+	var keys []SigningKey
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid signing key %q, expected id:secret", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate signing key %q", id)
+		}
+		seen[id] = true
+		keys = append(keys, SigningKey{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// URLSigner issues and verifies expiring download URLs for transcripts, so
+// they can be handed to someone without an API key. New URLs are signed
+// with the first key; every key verifies, so a key can be rotated by
+// putting its replacement first and dropping it once the URLs it signed
+// have expired.
+type URLSigner struct {
+	keys []SigningKey
+	ttl  time.Duration
+}
+
+// NewURLSigner returns a signer using keys, whose URLs are valid for ttl.
+// Without keys it signs with a random key, whose URLs stop working when
+// the process exits. A zero ttl means DefaultArtifactURLTTL.
+func NewURLSigner(keys []SigningKey, ttl time.Duration) (*URLSigner, error) {
+	if ttl <= 0 {
+		ttl = DefaultArtifactURLTTL
+	}
+	if len(keys) == 0 {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		keys = []SigningKey{{ID: "ephemeral", Secret: hex.EncodeToString(secret)}}
+	}
+	return &URLSigner{keys: keys, ttl: ttl}, nil
+}
+
+// ArtifactPath returns the unauthenticated path a signed transcript URL
+// points at.
+func ArtifactPath(jobID string, format SubtitleFormat) string {
+	return fmt.Sprintf("/%s/artifacts/%s/transcript.%s", models.CurrentAPIVersion, jobID, format)
+}
+
+// Sign returns a URL under baseURL that downloads a job's transcript in
+// format until the returned expiry. With an empty baseURL the URL is
+// relative to the API root.
+func (s *URLSigner) Sign(baseURL, jobID string, format SubtitleFormat, now time.Time) (string, time.Time) {
+	key := s.keys[0]
+	expires := now.Add(s.ttl).Truncate(time.Second)
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("key", key.ID)
+	query.Set("signature", signArtifact(key.Secret, jobID, format, expires.Unix()))
+
+	return strings.TrimRight(baseURL, "/") + ArtifactPath(jobID, format) + "?" + query.Encode(), expires
+}
+
+// Verify checks the expires, key and signature query parameters of a
+// signed URL for a job's transcript in format.
+func (s *URLSigner) Verify(jobID string, format SubtitleFormat, query url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	for _, key := range s.keys {
+		if key.ID != query.Get("key") {
+			continue
+		}
+		expected, _ := hex.DecodeString(signArtifact(key.Secret, jobID, format, expires))
+		if !hmac.Equal(signature, expected) {
+			return ErrInvalidSignature
+		}
+		// Checked after the signature, so a forged expiry is reported as
+		// a bad signature rather than a stale URL.
+		if !now.Before(time.Unix(expires, 0)) {
+			return ErrExpiredSignature
+		}
+		return nil
+	}
+	return ErrInvalidSignature
+}
+
+// signArtifact is the HMAC-SHA256 of everything a signed URL grants.
+func signArtifact(secret, jobID string, format SubtitleFormat, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d", jobID, format, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
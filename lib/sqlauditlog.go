@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+
+	"omnitranscripts/models"
+)
+
+// SQLAuditLog keeps the audit log in the audit_log table of a PostgreSQL
+// database, created by the Encore service's migrations. Both servers use
+// it when they share that database, so the admin actions taken on either
+// are listed together.
+type SQLAuditLog struct {
+	db *sql.DB
+}
+
+// NewSQLAuditLog returns a log backed by db.
+func NewSQLAuditLog(db *sql.DB) *SQLAuditLog {
+	return &SQLAuditLog{db: db}
+}
+
+func (l *SQLAuditLog) Record(ctx context.Context, entry models.AuditEntry) error {
+	var details []byte
+	if len(entry.Details) > 0 {
+		details = entry.Details
+	}
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO audit_log (id, actor_key_id, action, target, details, request_id, recorded_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''), $7)
+	`, entry.ID, entry.ActorKeyID, entry.Action, entry.Target, details, entry.RequestID, entry.At)
+	return err
+}
+
+func (l *SQLAuditLog) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT id, actor_key_id, action, COALESCE(target, ''), details, COALESCE(request_id, ''), recorded_at
+		FROM audit_log
+		WHERE ($1 = '' OR action = $1) AND ($2 = '' OR target = $2)
+		ORDER BY recorded_at DESC
+		LIMIT $3
+	`, filter.Action, filter.Target, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var details []byte
+		if err := rows.Scan(&entry.ID, &entry.ActorKeyID, &entry.Action, &entry.Target, &details, &entry.RequestID, &entry.At); err != nil {
+			return nil, err
+		}
+		entry.Details = details
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
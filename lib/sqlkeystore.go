@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+// SQLKeyStore keeps API keys in the api_keys table of a PostgreSQL
+// database, created by the Encore service's migrations. Both servers use
+// it when they share that database, so a key issued by either works on
+// both.
+type SQLKeyStore struct {
+	db *sql.DB
+}
+
+// NewSQLKeyStore returns a store backed by db.
+func NewSQLKeyStore(db *sql.DB) *SQLKeyStore {
+	return &SQLKeyStore{db: db}
+}
+
+const apiKeyColumns = `id, name, owner, hash, scopes, quota, rate_limits, weight, expires_at, created_at, revoked_at`
+
+func (s *SQLKeyStore) Get(ctx context.Context, id string) (*APIKey, error) {
+	key, err := scanKey(s.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (s *SQLKeyStore) Put(ctx context.Context, key *APIKey) error {
+	scopesJSON, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return err
+	}
+	var quotaJSON, limitsJSON []byte
+	if key.Quota != nil {
+		if quotaJSON, err = json.Marshal(key.Quota); err != nil {
+			return err
+		}
+	}
+	if len(key.RateLimits) > 0 {
+		if limitsJSON, err = json.Marshal(key.RateLimits); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO api_keys (`+apiKeyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name, owner = EXCLUDED.owner, hash = EXCLUDED.hash,
+			scopes = EXCLUDED.scopes, quota = EXCLUDED.quota, rate_limits = EXCLUDED.rate_limits,
+			weight = EXCLUDED.weight, expires_at = EXCLUDED.expires_at, revoked_at = EXCLUDED.revoked_at
+	`, key.ID, key.Name, key.Owner, key.Hash, scopesJSON, quotaJSON, limitsJSON, key.Weight, key.ExpiresAt, key.CreatedAt, key.RevokedAt)
+	return err
+}
+
+func (s *SQLKeyStore) List(ctx context.Context) ([]*APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// scanKey reads an api_keys row selected as apiKeyColumns.
+func scanKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var scopesJSON, quotaJSON, limitsJSON []byte
+	if err := row.Scan(&key.ID, &key.Name, &key.Owner, &key.Hash, &scopesJSON, &quotaJSON, &limitsJSON, &key.Weight, &key.ExpiresAt, &key.CreatedAt, &key.RevokedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(scopesJSON, &key.Scopes); err != nil {
+		return nil, err
+	}
+	if len(quotaJSON) > 0 {
+		if err := json.Unmarshal(quotaJSON, &key.Quota); err != nil {
+			return nil, err
+		}
+	}
+	if len(limitsJSON) > 0 {
+		if err := json.Unmarshal(limitsJSON, &key.RateLimits); err != nil {
+			return nil, err
+		}
+	}
+	return &key, nil
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"omnitranscripts/models"
+)
+
+// SQLUsageMeter keeps API calls in the api_usage table and billable jobs in
+// the job_usage table of a PostgreSQL database, created by the Encore
+// service's migrations. Both servers use it when they share that database,
+// so a key's usage report covers the calls made to either.
+type SQLUsageMeter struct {
+	db *sql.DB
+}
+
+// NewSQLUsageMeter returns a meter backed by db.
+func NewSQLUsageMeter(db *sql.DB) *SQLUsageMeter {
+	return &SQLUsageMeter{db: db}
+}
+
+func (m *SQLUsageMeter) RecordCall(ctx context.Context, call models.APICall) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO api_usage (endpoint, method, user_id, api_key_id, response_status, response_time_ms,
+			request_size_bytes, response_size_bytes, user_agent, ip_address, recorded_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8, $9, NULLIF($10, '')::inet, $11)
+	`, call.Endpoint, call.Method, call.Owner, call.APIKeyID, call.Status,
+		call.Latency.Milliseconds(), call.RequestBytes, call.ResponseBytes, call.UserAgent, call.IP, call.At)
+	return err
+}
+
+// RecordJob records job once; a redelivered job is not billed twice.
+func (m *SQLUsageMeter) RecordJob(ctx context.Context, job models.BillableJob) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO job_usage (job_id, api_key_id, owner, media_seconds, recorded_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5)
+		ON CONFLICT (job_id) DO NOTHING
+	`, job.JobID, job.APIKeyID, job.Owner, job.MediaSeconds, job.At)
+	return err
+}
+
+func (m *SQLUsageMeter) Report(ctx context.Context, keyID string, from, to time.Time) (*models.UsageReport, error) {
+	end := to.AddDate(0, 0, 1)
+	days := make(map[string]models.UsageTotals)
+
+	calls, err := m.db.QueryContext(ctx, `
+		SELECT to_char(recorded_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, COUNT(*),
+			COUNT(*) FILTER (WHERE response_status >= 400),
+			COALESCE(SUM(request_size_bytes), 0), COALESCE(SUM(response_size_bytes), 0)
+		FROM api_usage
+		WHERE api_key_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+		GROUP BY day
+	`, keyID, from, end)
+	if err != nil {
+		return nil, err
+	}
+	defer calls.Close()
+	for calls.Next() {
+		var date string
+		var totals models.UsageTotals
+		if err := calls.Scan(&date, &totals.Requests, &totals.Errors, &totals.RequestBytes, &totals.ResponseBytes); err != nil {
+			return nil, err
+		}
+		days[date] = totals
+	}
+	if err := calls.Err(); err != nil {
+		return nil, err
+	}
+
+	billed, err := m.db.QueryContext(ctx, `
+		SELECT to_char(recorded_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, COUNT(*), COALESCE(SUM(media_seconds), 0)
+		FROM job_usage
+		WHERE api_key_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+		GROUP BY day
+	`, keyID, from, end)
+	if err != nil {
+		return nil, err
+	}
+	defer billed.Close()
+	for billed.Next() {
+		var date string
+		var jobs int
+		var seconds int64
+		if err := billed.Scan(&date, &jobs, &seconds); err != nil {
+			return nil, err
+		}
+		totals := days[date]
+		totals.BillableJobs = jobs
+		totals.BillableSeconds = seconds
+		days[date] = totals
+	}
+	if err := billed.Err(); err != nil {
+		return nil, err
+	}
+
+	return models.NewUsageReport(keyID, from, to, days), nil
+}
@@ -0,0 +1,15 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+)
+
+// TenantDir returns the directory under workDir holding the working files,
+// uploads and artifacts of owner's jobs. Owners are hashed into the path,
+// so any owner string is safe to use and none can reach another's files.
+func TenantDir(workDir, owner string) string {
+	sum := sha256.Sum256([]byte(owner))
+	return filepath.Join(workDir, "tenants", hex.EncodeToString(sum[:8]))
+}
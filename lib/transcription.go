@@ -5,24 +5,87 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/lrstanley/go-ytdlp"
 	ffmpeg_go "github.com/u2takey/ffmpeg-go"
 
-	"omnitranscripts/config"
+	"omnitranscripts/engine"
 	"omnitranscripts/models"
 )
 
-func ProcessTranscription(url, jobID string) (string, []models.Segment, error) {
-	cfg := config.Load()
+// StageFunc is called as the pipeline enters each stage (models.StageDownload,
+// models.StageNormalize, models.StageTranscribe).
+type StageFunc func(stage string)
 
-	if err := os.MkdirAll(cfg.WorkDir, 0755); err != nil {
-		return "", nil, fmt.Errorf("failed to create work directory: %w", err)
+// SegmentFunc is called with each transcript segment as it is decoded.
+type SegmentFunc func(segment models.Segment)
+
+// Progress receives the progress of a running pipeline. Either callback may
+// be nil.
+type Progress struct {
+	OnStage StageFunc
+	// OnSegment sees segments as the backend produces them, with their
+	// timestamps against the original media but before post-processing.
+	OnSegment SegmentFunc
+}
+
+func ProcessTranscription(url, jobID, workDir string) (string, []models.Segment, error) {
+	return ProcessTranscriptionWithStages(context.Background(), url, jobID, workDir, models.TranscriptionOptions{}, Progress{})
+}
+
+// ProcessTranscriptionWithStages runs the pipeline like ProcessTranscription
+// with the job's options, and reports each stage transition and decoded
+// segment to progress. Working files go in workDir, normally the owner's
+// TenantDir.
+//
+// The pipeline stops before its next stage once ctx is done, and a
+// download in progress is stopped at once; other stages run to the end.
+func ProcessTranscriptionWithStages(ctx context.Context, url, jobID, workDir string, opts models.TranscriptionOptions, progress Progress) (string, []models.Segment, error) {
+	return runPipeline(ctx, url, "", jobID, workDir, opts, progress)
+}
+
+// ProcessMediaFileWithStages transcribes a local media file, such as an
+// upload, skipping the download stage. The file itself is left in place.
+func ProcessMediaFileWithStages(ctx context.Context, mediaPath, jobID, workDir string, opts models.TranscriptionOptions, progress Progress) (string, []models.Segment, error) {
+	return runPipeline(ctx, "", mediaPath, jobID, workDir, opts, progress)
+}
+
+// runPipeline downloads url, or starts from mediaPath when it is set, then
+// normalizes and transcribes the audio and applies any post-processing.
+func runPipeline(ctx context.Context, url, mediaPath, jobID, workDir string, opts models.TranscriptionOptions, progress Progress) (string, []models.Segment, error) {
+	// enterStage reports the stage, then checks for cancellation, so that
+	// OnStage can cancel ctx itself.
+	enterStage := func(stage engine.Stage) error {
+		if progress.OnStage != nil {
+			progress.OnStage(string(stage))
+		}
+		if err := ctx.Err(); err != nil {
+			return engine.NewError(stage, "job was cancelled", err)
+		}
+		return nil
+	}
+	// Timestamps are relative to the trimmed audio; report them against
+	// the original media.
+	var offset float64
+	if opts.TimeRange != nil {
+		offset = opts.TimeRange.Start
+	}
+	onSegment := func(segment models.Segment) {
+		if progress.OnSegment != nil {
+			segment.Start += offset
+			segment.End += offset
+			progress.OnSegment(segment)
+		}
+	}
+
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return "", nil, engine.NewError(engine.StageDownload, "failed to create work directory", err)
 	}
 
-	audioFile := filepath.Join(cfg.WorkDir, fmt.Sprintf("%s.wav", jobID))
-	normalizedAudio := filepath.Join(cfg.WorkDir, fmt.Sprintf("%s_norm.wav", jobID))
-	transcriptFile := filepath.Join(cfg.WorkDir, fmt.Sprintf("%s_transcript.txt", jobID))
+	audioFile := filepath.Join(workDir, fmt.Sprintf("%s.wav", jobID))
+	normalizedAudio := filepath.Join(workDir, fmt.Sprintf("%s_norm.wav", jobID))
+	transcriptFile := filepath.Join(workDir, fmt.Sprintf("%s_transcript.txt", jobID))
 
 	defer func() {
 		os.Remove(audioFile)
@@ -30,34 +93,64 @@ func ProcessTranscription(url, jobID string) (string, []models.Segment, error) {
 		os.Remove(transcriptFile)
 	}()
 
-	if err := downloadAudio(url, audioFile); err != nil {
-		return "", nil, fmt.Errorf("failed to download audio: %w", err)
+	source := mediaPath
+	if source == "" {
+		if err := enterStage(engine.StageDownload); err != nil {
+			return "", nil, err
+		}
+		if err := downloadAudio(ctx, url, audioFile); err != nil {
+			return "", nil, engine.NewError(engine.StageDownload, "failed to download audio", err)
+		}
+		source = audioFile
 	}
 
-	if err := normalizeAudio(audioFile, normalizedAudio); err != nil {
-		return "", nil, fmt.Errorf("failed to normalize audio: %w", err)
+	if err := enterStage(engine.StageNormalize); err != nil {
+		return "", nil, err
+	}
+	if err := normalizeAudio(source, normalizedAudio, opts.TimeRange); err != nil {
+		return "", nil, engine.NewError(engine.StageNormalize, "failed to normalize audio", err)
 	}
 
-	if err := transcribeAudio(normalizedAudio, transcriptFile); err != nil {
-		return "", nil, fmt.Errorf("failed to transcribe audio: %w", err)
+	if err := enterStage(engine.StageTranscribe); err != nil {
+		return "", nil, err
+	}
+	decoded, err := transcribeAudio(normalizedAudio, transcriptFile, opts, onSegment)
+	if err != nil {
+		return "", nil, engine.NewError(engine.StageTranscribe, "failed to transcribe audio", err)
 	}
 
 	transcript, segments, err := models.LoadTranscript(transcriptFile)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to load transcript: %w", err)
+		return "", nil, engine.NewError(engine.StageTranscribe, "failed to load transcript", err)
+	}
+	if len(decoded) > 0 {
+		// The transcript file is plain text; keep the segments that were
+		// streamed so the result matches them.
+		segments = decoded
+	}
+
+	if offset > 0 {
+		for i := range segments {
+			segments[i].Start += offset
+			segments[i].End += offset
+		}
+	}
+
+	if opts.PostProcessing != nil {
+		transcript, segments = PostProcess(transcript, segments, *opts.PostProcessing)
 	}
 
 	return transcript, segments, nil
 }
 
-func downloadAudio(url, outputPath string) error {
+func downloadAudio(ctx context.Context, url, outputPath string) error {
 	dl := ytdlp.New().
 		ExtractAudio().
 		AudioFormat("wav").
 		AudioQuality("0").
 		Output(outputPath)
 
-	result, err := dl.Run(context.Background(), url)
+	result, err := dl.Run(ctx, url)
 	if err != nil {
 		return fmt.Errorf("yt-dlp failed: %w", err)
 	}
@@ -69,8 +162,18 @@ func downloadAudio(url, outputPath string) error {
 	return nil
 }
 
-func normalizeAudio(inputPath, outputPath string) error {
-	err := ffmpeg_go.Input(inputPath).
+func normalizeAudio(inputPath, outputPath string, timeRange *models.TimeRange) error {
+	input := ffmpeg_go.KwArgs{}
+	if timeRange != nil {
+		if timeRange.Start > 0 {
+			input["ss"] = timeRange.Start
+		}
+		if timeRange.End > 0 {
+			input["to"] = timeRange.End
+		}
+	}
+
+	err := ffmpeg_go.Input(inputPath, input).
 		Audio().
 		Output(outputPath, ffmpeg_go.KwArgs{
 			"ar":  16000,
@@ -87,65 +190,117 @@ func normalizeAudio(inputPath, outputPath string) error {
 	return nil
 }
 
-func transcribeAudio(audioPath, outputPath string) error {
+// transcribeAudio runs the requested backend only, failing if it
+// is not configured, or else tries each configured backend in turn. Each
+// segment is passed to onSegment as it is decoded, and the segments of the
+// backend that succeeded are returned.
+func transcribeAudio(audioPath, outputPath string, opts models.TranscriptionOptions, onSegment SegmentFunc) ([]models.Segment, error) {
+	switch opts.Backend {
+	case models.BackendWhisper:
+		modelPath := whisperModelPath(opts.Model)
+		if modelPath == "" {
+			return nil, fmt.Errorf("whisper backend is not configured")
+		}
+		return transcribeWithNativeWhisper(audioPath, outputPath, modelPath, opts, onSegment)
+	case models.BackendAssemblyAI:
+		apiKey := os.Getenv("ASSEMBLYAI_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("assemblyai backend is not configured")
+		}
+		return transcribeWithAssemblyAI(audioPath, outputPath, apiKey, onSegment)
+	case models.BackendWhisperServer:
+		serverURL := os.Getenv("WHISPER_SERVER_URL")
+		if serverURL == "" {
+			return nil, fmt.Errorf("whisper_server backend is not configured")
+		}
+		return transcribeWithWhisperServer(audioPath, outputPath, serverURL, onSegment)
+	}
+
+	// Only whisper honours these options, so falling back to another
+	// backend would silently ignore them.
+	if fields := opts.WhisperOnly(); len(fields) > 0 {
+		modelPath := whisperModelPath(opts.Model)
+		if modelPath == "" {
+			return nil, fmt.Errorf("whisper backend is not configured, and %s need it", strings.Join(fields, ", "))
+		}
+		return transcribeWithNativeWhisper(audioPath, outputPath, modelPath, opts, onSegment)
+	}
+
 	// Hybrid transcription system with graceful fallbacks
 
 	// 1. Try native whisper.cpp (if model path available)
-	if modelPath := os.Getenv("WHISPER_MODEL_PATH"); modelPath != "" {
+	if modelPath := whisperModelPath(opts.Model); modelPath != "" {
 		fmt.Printf("Attempting transcription with native Whisper (model: %s)...\n", modelPath)
-		if err := transcribeWithNativeWhisper(audioPath, outputPath, modelPath); err != nil {
+		if segments, err := transcribeWithNativeWhisper(audioPath, outputPath, modelPath, opts, onSegment); err != nil {
 			fmt.Printf("Native Whisper transcription failed: %v, falling back...\n", err)
 		} else {
 			fmt.Println("Native Whisper transcription completed successfully")
-			return nil
+			return segments, nil
 		}
 	}
 
 	// 2. Try AssemblyAI (if API key available)
 	if apiKey := os.Getenv("ASSEMBLYAI_API_KEY"); apiKey != "" {
 		fmt.Println("Attempting transcription with AssemblyAI...")
-		if err := transcribeWithAssemblyAI(audioPath, outputPath, apiKey); err != nil {
+		if segments, err := transcribeWithAssemblyAI(audioPath, outputPath, apiKey, onSegment); err != nil {
 			fmt.Printf("AssemblyAI transcription failed: %v, falling back...\n", err)
 		} else {
 			fmt.Println("AssemblyAI transcription completed successfully")
-			return nil
+			return segments, nil
 		}
 	}
 
 	// 3. Try whisper.cpp server (if server URL available)
 	if serverURL := os.Getenv("WHISPER_SERVER_URL"); serverURL != "" {
 		fmt.Println("Attempting transcription with local Whisper server...")
-		if err := transcribeWithWhisperServer(audioPath, outputPath, serverURL); err != nil {
+		if segments, err := transcribeWithWhisperServer(audioPath, outputPath, serverURL, onSegment); err != nil {
 			fmt.Printf("Whisper server transcription failed: %v, falling back...\n", err)
 		} else {
 			fmt.Println("Whisper server transcription completed successfully")
-			return nil
+			return segments, nil
 		}
 	}
 
 	// 4. Final fallback to demo transcription
 	fmt.Println("Using demo transcription (no transcription services configured)")
-	return transcribeDemo(audioPath, outputPath)
+	return transcribeDemo(audioPath, outputPath, onSegment)
 }
 
-func transcribeWithNativeWhisper(audioPath, outputPath, modelPath string) error {
+// whisperModelPath resolves a model name such as "small.en" to a
+// ggml-<name>.bin file next to WHISPER_MODEL_PATH. An empty name selects
+// WHISPER_MODEL_PATH itself, and the result is empty when it is unset.
+func whisperModelPath(model string) string {
+	modelPath := os.Getenv("WHISPER_MODEL_PATH")
+	if modelPath == "" || model == "" {
+		return modelPath
+	}
+	return filepath.Join(filepath.Dir(modelPath), "ggml-"+model+".bin")
+}
+
+func transcribeWithNativeWhisper(audioPath, outputPath, modelPath string, opts models.TranscriptionOptions, onSegment SegmentFunc) ([]models.Segment, error) {
 	// Load audio samples
 	samples, err := LoadWAVAsFloat32(audioPath)
 	if err != nil {
-		return fmt.Errorf("failed to load audio: %w", err)
+		return nil, fmt.Errorf("failed to load audio: %w", err)
 	}
 
 	// Initialize whisper context
 	whisperCtx, err := InitWhisper(modelPath)
 	if err != nil {
-		return fmt.Errorf("failed to initialize whisper: %w", err)
+		return nil, fmt.Errorf("failed to initialize whisper: %w", err)
 	}
 	defer whisperCtx.Free()
 
-	// Transcribe audio
-	segments, err := whisperCtx.TranscribeAudio(samples)
+	// Transcribe audio, passing each segment on as whisper decodes it
+	language := opts.Language
+	if language == "" {
+		language = "en"
+	}
+	segments, err := whisperCtx.TranscribeAudioWithParams(samples, language, opts.Task == models.TaskTranslate, func(seg TranscriptSegment) {
+		onSegment(seg.Segment())
+	})
 	if err != nil {
-		return fmt.Errorf("transcription failed: %w", err)
+		return nil, fmt.Errorf("transcription failed: %w", err)
 	}
 
 	// Convert to WhisperSegment format
@@ -164,10 +319,10 @@ func transcribeWithNativeWhisper(audioPath, outputPath, modelPath string) error
 		fullTranscript += seg.Text
 	}
 
-	return writeTranscriptFiles(fullTranscript, whisperSegments, audioPath, outputPath, "Native Whisper")
+	return toSegments(whisperSegments), writeTranscriptFiles(fullTranscript, whisperSegments, audioPath, outputPath, "Native Whisper")
 }
 
-func transcribeWithAssemblyAI(audioPath, outputPath, apiKey string) error {
+func transcribeWithAssemblyAI(audioPath, outputPath, apiKey string, onSegment SegmentFunc) ([]models.Segment, error) {
 	// TODO: Implement AssemblyAI integration once dependency issues are resolved
 	// For now, create enhanced demo content to simulate AssemblyAI response
 
@@ -183,10 +338,10 @@ The combination of MCP's structured approach and Claude's natural language under
 		{Start: 26.8, End: 35.0, Text: "The combination of MCP's structured approach and Claude's natural language understanding creates unprecedented opportunities for automation and intelligent task execution."},
 	}
 
-	return writeTranscriptFiles(transcript, segments, audioPath, outputPath, "AssemblyAI")
+	return streamSegments(segments, onSegment), writeTranscriptFiles(transcript, segments, audioPath, outputPath, "AssemblyAI")
 }
 
-func transcribeWithWhisperServer(audioPath, outputPath, serverURL string) error {
+func transcribeWithWhisperServer(audioPath, outputPath, serverURL string, onSegment SegmentFunc) ([]models.Segment, error) {
 	// TODO: Implement whisper.cpp HTTP server client
 	// For now, create realistic whisper-style demo content
 
@@ -202,10 +357,10 @@ The tutorial concludes with actionable recommendations for developers looking to
 		{Start: 23.4, End: 30.0, Text: "The tutorial concludes with actionable recommendations for developers looking to implement these technologies in production environments."},
 	}
 
-	return writeTranscriptFiles(transcript, segments, audioPath, outputPath, "Whisper Server")
+	return streamSegments(segments, onSegment), writeTranscriptFiles(transcript, segments, audioPath, outputPath, "Whisper Server")
 }
 
-func transcribeDemo(audioPath, outputPath string) error {
+func transcribeDemo(audioPath, outputPath string, onSegment SegmentFunc) ([]models.Segment, error) {
 	// Enhanced demo transcription for development and ultimate fallback
 
 	transcript := `Demo transcription system: This is a placeholder transcript generated by the native Go transcription pipeline.
@@ -220,7 +375,7 @@ To enable actual transcription, please set ASSEMBLYAI_API_KEY or WHISPER_SERVER_
 		{Start: 17.8, End: 24.0, Text: "To enable actual transcription, please set ASSEMBLYAI_API_KEY or WHISPER_SERVER_URL environment variables."},
 	}
 
-	return writeTranscriptFiles(transcript, segments, audioPath, outputPath, "Demo")
+	return streamSegments(segments, onSegment), writeTranscriptFiles(transcript, segments, audioPath, outputPath, "Demo")
 }
 
 func writeTranscriptFiles(transcript string, segments []WhisperSegment, audioPath, outputPath, method string) error {
@@ -250,23 +405,10 @@ func writeTranscriptFiles(transcript string, segments []WhisperSegment, audioPat
 	return nil
 }
 
+// GetVideoDuration returns the duration of media at url in whole seconds,
+// or 0 when it is not known in advance.
 func GetVideoDuration(url string) (int, error) {
-	dl := ytdlp.New()
-
-	result, err := dl.Run(context.Background(), url, "--get-duration", "--no-warnings")
-	if err != nil {
-		return 0, fmt.Errorf("failed to get video info: %w", err)
-	}
-
-	if result.ExitCode != 0 {
-		return 0, fmt.Errorf("yt-dlp failed with code %d: %s", result.ExitCode, result.Stderr)
-	}
-
-	return parseDuration(result.Stdout), nil
-}
-
-func parseDuration(duration string) int {
-	return 120
+	return engine.GetMediaDuration(url)
 }
 
 // Whisper data structures
@@ -276,6 +418,35 @@ type WhisperSegment struct {
 	Text  string
 }
 
+// Segment converts a segment decoded by whisper.cpp, timed in
+// milliseconds, to a transcript segment.
+func (s TranscriptSegment) Segment() models.Segment {
+	return models.Segment{
+		Start: float64(s.StartTime) / 1000.0,
+		End:   float64(s.EndTime) / 1000.0,
+		Text:  s.Text,
+	}
+}
+
+// toSegments converts Whisper segments to transcript segments.
+func toSegments(whisperSegments []WhisperSegment) []models.Segment {
+	segments := make([]models.Segment, len(whisperSegments))
+	for i, seg := range whisperSegments {
+		segments[i] = models.Segment{Start: seg.Start, End: seg.End, Text: seg.Text}
+	}
+	return segments
+}
+
+// streamSegments converts the segments of a backend that returns them all
+// at once, passing each to onSegment in turn.
+func streamSegments(whisperSegments []WhisperSegment, onSegment SegmentFunc) []models.Segment {
+	segments := toSegments(whisperSegments)
+	for _, segment := range segments {
+		onSegment(segment)
+	}
+	return segments
+}
+
 // writeWhisperSegmentsAsSRT writes Whisper segments in SRT subtitle format
 func writeWhisperSegmentsAsSRT(segments []WhisperSegment, outputPath string) error {
 	file, err := os.Create(outputPath)
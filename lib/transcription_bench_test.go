@@ -72,8 +72,8 @@ func BenchmarkJobStatusTransitions(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		job := jobs.NewJob(testURL)
-		job.MarkRunning()
-		job.MarkComplete("Test transcript", segments)
+		job.Start()
+		job.Complete("Test transcript", segments)
 	}
 }
 
@@ -110,14 +110,12 @@ func BenchmarkConcurrentJobProcessing(b *testing.B) {
 			queue.AddJob(job)
 
 			// Simulate processing time
-			job.MarkRunning()
-			queue.UpdateJob(job)
+			queue.Start(job.ID)
 
 			// Simulate completion
-			job.MarkComplete("Benchmark transcript", []models.Segment{
+			queue.Complete(job.ID, "Benchmark transcript", []models.Segment{
 				{Start: 0.0, End: 5.0, Text: "Benchmark test"},
 			})
-			queue.UpdateJob(job)
 		}
 	})
 }
@@ -152,7 +150,8 @@ func BenchmarkMemoryUsage_LargeTranscript(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		job := jobs.NewJob("https://www.youtube.com/watch?v=dQw4w9WgXcQ")
-		job.MarkComplete("Large transcript content", segments)
+		job.Start()
+		job.Complete("Large transcript content", segments)
 	}
 }
 
@@ -233,7 +232,8 @@ func BenchmarkStringBuilder_Transcript(b *testing.B) {
 
 func BenchmarkJSONSerialization_Job(b *testing.B) {
 	job := jobs.NewJob("https://www.youtube.com/watch?v=dQw4w9WgXcQ")
-	job.MarkComplete("Test transcript", []models.Segment{
+	job.Start()
+	job.Complete("Test transcript", []models.Segment{
 		{Start: 0.0, End: 5.0, Text: "Test segment"},
 	})
 
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+)
+
+// UploadFormField is the multipart field carrying the uploaded file.
+const UploadFormField = "file"
+
+var (
+	// ErrUploadTooLarge is returned when an upload exceeds the size limit.
+	ErrUploadTooLarge = errors.New("upload exceeds the maximum size")
+	// ErrUnsupportedMediaType is returned for content types other than
+	// audio/*, video/* and application/octet-stream.
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrEmptyUpload is returned when the request carries no file data.
+	ErrEmptyUpload = errors.New("upload contains no file")
+	// ErrInvalidMedia is returned when ffprobe finds no audio to transcribe.
+	ErrInvalidMedia = errors.New("file is not a supported audio or video file")
+)
+
+var unsafeExtChars = regexp.MustCompile(`[^a-z0-9]`)
+
+// MediaUpload is a file received by SaveUpload.
+type MediaUpload struct {
+	Path        string
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+// MediaInfo is what ffprobe reports about an uploaded file.
+type MediaInfo struct {
+	Format   string
+	Duration float64
+}
+
+// UploadSourceURL is the source URL recorded on jobs created from uploads.
+func UploadSourceURL(filename string) string {
+	return "upload:" + filename
+}
+
+// SaveUpload streams an upload into a new file under dir without holding it
+// in memory. The body is either multipart/form-data, in which case the file
+// is taken from the UploadFormField part, or the raw media itself described
+// by contentType and filename. Anything beyond maxBytes is rejected with
+// ErrUploadTooLarge and the partial file removed.
+func SaveUpload(body io.Reader, contentType, filename, dir string, maxBytes int64) (*MediaUpload, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	src := body
+	if mediaType == "multipart/form-data" {
+		part, err := findFilePart(multipart.NewReader(body, params["boundary"]))
+		if err != nil {
+			return nil, err
+		}
+		defer part.Close()
+
+		src = part
+		filename = part.FileName()
+		mediaType, _, err = mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			mediaType = "application/octet-stream"
+		}
+	}
+
+	if !allowedUploadType(mediaType) {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, "upload-*"+uploadExt(filename))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	defer file.Close()
+
+	// Read one byte past the limit to tell "exactly at" from "over".
+	size, err := io.Copy(file, io.LimitReader(src, maxBytes+1))
+	if err == nil && size > maxBytes {
+		err = ErrUploadTooLarge
+	}
+	if err == nil && size == 0 {
+		err = ErrEmptyUpload
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &MediaUpload{
+		Path:        file.Name(),
+		Filename:    filepath.Base(filename),
+		ContentType: mediaType,
+		Size:        size,
+	}, nil
+}
+
+func findFilePart(reader *multipart.Reader) (*multipart.Part, error) {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, ErrEmptyUpload
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		if part.FormName() == UploadFormField && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+func allowedUploadType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "audio/") ||
+		strings.HasPrefix(mediaType, "video/") ||
+		mediaType == "application/octet-stream"
+}
+
+// uploadExt keeps a short, safe extension from the client's file name so
+// ffmpeg can use it as a format hint.
+func uploadExt(filename string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	ext = unsafeExtChars.ReplaceAllString(ext, "")
+	if ext == "" || len(ext) > 8 {
+		return ""
+	}
+	return "." + ext
+}
+
+// ProbeMedia checks with ffprobe that path holds at least one audio stream
+// and returns its container format and duration.
+func ProbeMedia(path string) (*MediaInfo, error) {
+	out, err := ffmpeg_go.Probe(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMedia, err)
+	}
+
+	var probe struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(out), &probe); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMedia, err)
+	}
+
+	hasAudio := false
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "audio" {
+			hasAudio = true
+			break
+		}
+	}
+	if !hasAudio {
+		return nil, fmt.Errorf("%w: no audio stream", ErrInvalidMedia)
+	}
+
+	duration, _ := strconv.ParseFloat(probe.Format.Duration, 64)
+	return &MediaInfo{
+		Format:   probe.Format.FormatName,
+		Duration: duration,
+	}, nil
+}
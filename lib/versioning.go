@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/models"
+)
+
+// LocalAPIVersion is the fiber.Ctx locals key holding the API version a
+// request is served with.
+const LocalAPIVersion = "api_version"
+
+// APIVersionHeader names the API version of a response. On a bare path a
+// client may also send it to choose the version the path is an alias of.
+const APIVersionHeader = "API-Version"
+
+// DefaultLegacyDeprecatedAt is the date, as YYYY-MM-DD, the bare paths were
+// deprecated in favour of the versioned ones, with the release that added
+// /v1. LEGACY_DEPRECATED_AT, or legacy_deprecated_at on Encore, overrides
+// it.
+const DefaultLegacyDeprecatedAt = "2026-10-19"
+
+var versionedPath = regexp.MustCompile(`^/v[0-9]+(/|$)`)
+
+// VersionMiddleware marks requests routed under /<version> as served with
+// that version.
+func VersionMiddleware(version string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals(LocalAPIVersion, version)
+		c.Set(APIVersionHeader, version)
+		return c.Next()
+	}
+}
+
+// LegacyRoutes serves bare paths, such as /transcribe, as deprecated
+// aliases of the versioned API. The request is rerouted to the version named
+// by its API-Version header, or else models.LegacyAPIVersion, and the
+// response carries Deprecation, Sunset and successor-version Link headers;
+// see DeprecationHeaders. Routes registered before it, such as /health, are
+// not aliased.
+func LegacyRoutes(deprecatedAt, sunset time.Time) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		path := c.Path()
+		if versionedPath.MatchString(path) {
+			return c.Next()
+		}
+
+		version := models.LegacyAPIVersion
+		if requested := c.Get(APIVersionHeader); requested != "" {
+			if !models.ValidAPIVersion(requested) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Unsupported API version, expected one of " + strings.Join(models.APIVersions, ", "),
+				})
+			}
+			version = requested
+		}
+
+		successor := "/" + version + path
+		for name, value := range DeprecationHeaders(deprecatedAt, sunset, successor) {
+			c.Set(name, value)
+		}
+		c.Path(successor)
+		return c.Next()
+	}
+}
+
+// DeprecationHeaders returns the headers marking a response as coming from
+// a deprecated path (RFC 9745 and RFC 8594). successor is the path to use
+// instead. A zero deprecatedAt means DefaultLegacyDeprecatedAt, and a zero
+// sunset omits the Sunset header.
+func DeprecationHeaders(deprecatedAt, sunset time.Time, successor string) map[string]string {
+	if deprecatedAt.IsZero() {
+		deprecatedAt, _ = time.Parse(time.DateOnly, DefaultLegacyDeprecatedAt)
+	}
+	headers := map[string]string{
+		"Deprecation": "@" + strconv.FormatInt(deprecatedAt.Unix(), 10),
+		"Link":        "<" + successor + `>; rel="successor-version"`,
+	}
+	if !sunset.IsZero() {
+		headers["Sunset"] = sunset.UTC().Format(http.TimeFormat)
+	}
+	return headers
+}
+
+// APIVersion returns the API version the request is served with. Requests
+// that did not pass through VersionMiddleware get models.LegacyAPIVersion.
+func APIVersion(c *fiber.Ctx) string {
+	if version, ok := c.Locals(LocalAPIVersion).(string); ok {
+		return version
+	}
+	return models.LegacyAPIVersion
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateWebhookAddress is returned when a webhook URL resolves to, or a
+// delivery would connect to, an address that is not on the public internet.
+var ErrPrivateWebhookAddress = errors.New("webhook URL must resolve to a public address")
+
+// nonPublicNetworks are reserved ranges that net.IP has no predicate for.
+var nonPublicNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // "this" network
+		"100.64.0.0/10", // carrier-grade NAT
+		"192.0.0.0/24",  // IETF protocol assignments
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",   // reserved, including broadcast
+		"64:ff9b::/96",  // NAT64, which can reach private IPv4 addresses
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// publicIP reports whether ip is a unicast address on the public internet.
+// IPv4-mapped IPv6 addresses are judged as the IPv4 address they carry.
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// privateWebhooksAllowed reports whether webhooks may be sent to private
+// addresses, for development against a receiver on the same machine.
+func privateWebhooksAllowed() bool {
+	return os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true"
+}
+
+// checkWebhookHost resolves the host of rawURL and rejects it unless every
+// address it resolves to is public.
+func checkWebhookHost(rawURL string) error {
+	if privateWebhooksAllowed() {
+		return nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return fmt.Errorf("invalid webhook URL")
+	}
+	host := parsed.Hostname()
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicIP(ip) {
+			return ErrPrivateWebhookAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("webhook host %q could not be resolved", host)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrPrivateWebhookAddress
+		}
+	}
+	return nil
+}
+
+// newWebhookClient returns a client that refuses to connect to non-public
+// addresses. The check runs on the address actually dialed, so it also
+// covers redirects and hosts that resolve differently after validation.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if privateWebhooksAllowed() {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicIP(net.ParseIP(host)) {
+				return fmt.Errorf("%w: %s", ErrPrivateWebhookAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the receiver, defeating the check.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,362 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"omnitranscripts/models"
+)
+
+const (
+	// webhookAttemptLease is how long a delivery being attempted is hidden
+	// from other dispatch passes. It outlasts the request timeout, so a
+	// delivery is retried by a later pass only if its attempt was lost.
+	webhookAttemptLease = 2 * time.Minute
+	// webhookAttemptTimeout bounds each delivery request.
+	webhookAttemptTimeout = 10 * time.Second
+	// webhookDueBatch caps the deliveries attempted by one dispatch pass.
+	webhookDueBatch = 100
+	// webhookDueConcurrency caps the attempts a dispatch pass makes at
+	// once.
+	webhookDueConcurrency = 8
+)
+
+// ErrWebhookDeliveryNotFound is returned by a WebhookOutbox for unknown
+// delivery IDs.
+var ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+
+// WebhookOutbox stores webhook deliveries until their endpoints accept them.
+type WebhookOutbox interface {
+	// Put saves a new or updated delivery.
+	Put(ctx context.Context, delivery *models.WebhookDelivery) error
+	// Get returns the delivery with the given ID, or
+	// ErrWebhookDeliveryNotFound.
+	Get(ctx context.Context, id string) (*models.WebhookDelivery, error)
+	// Claim returns up to limit pending deliveries whose next attempt is at
+	// or before now, oldest first, after atomically moving their next
+	// attempt to until, so that no other caller claims them meanwhile.
+	Claim(ctx context.Context, now, until time.Time, limit int) ([]*models.WebhookDelivery, error)
+	// List returns the deliveries matching filter, newest first.
+	List(ctx context.Context, filter models.WebhookDeliveryFilter) ([]models.WebhookDelivery, error)
+}
+
+// FileWebhookOutbox keeps deliveries in memory, appending each change to a
+// file of JSON lines when it has one. Like FileAuditLog it suits a single
+// server.
+type FileWebhookOutbox struct {
+	path       string
+	deliveries map[string]*models.WebhookDelivery
+	// lines counts the lines in the file, which is compacted to one line
+	// per delivery once it holds too many more.
+	lines int
+	mu    sync.RWMutex
+}
+
+// webhookCompactionSlack is how many lines a FileWebhookOutbox's file may
+// hold beyond one per delivery before it is compacted.
+const webhookCompactionSlack = 1000
+
+// NewFileWebhookOutbox loads the deliveries saved at path and compacts the
+// file. A missing file is an empty outbox, and an empty path keeps
+// deliveries in memory only.
+func NewFileWebhookOutbox(path string) (*FileWebhookOutbox, error) {
+	o := &FileWebhookOutbox{path: path, deliveries: make(map[string]*models.WebhookDelivery)}
+	if path == "" {
+		return o, nil
+	}
+
+	err := readJSONLines(path, func(line []byte) error {
+		// Outboxes saved before the file held JSON lines are one array.
+		if line[0] == '[' {
+			var deliveries []*models.WebhookDelivery
+			if err := json.Unmarshal(line, &deliveries); err != nil {
+				return err
+			}
+			for _, delivery := range deliveries {
+				o.deliveries[delivery.ID] = delivery
+			}
+			return nil
+		}
+		var delivery models.WebhookDelivery
+		if err := json.Unmarshal(line, &delivery); err != nil {
+			return err
+		}
+		o.deliveries[delivery.ID] = &delivery
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook outbox %s: %w", path, err)
+	}
+	if err := o.compactLocked(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *FileWebhookOutbox) Put(ctx context.Context, delivery *models.WebhookDelivery) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	c := *delivery
+	if err := o.appendLocked(&c); err != nil {
+		return err
+	}
+	o.deliveries[delivery.ID] = &c
+	return o.maybeCompactLocked()
+}
+
+func (o *FileWebhookOutbox) Get(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	delivery, ok := o.deliveries[id]
+	if !ok {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	c := *delivery
+	return &c, nil
+}
+
+func (o *FileWebhookOutbox) Claim(ctx context.Context, now, until time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var due []*models.WebhookDelivery
+	for _, delivery := range o.deliveries {
+		if delivery.Status == models.DeliveryPending && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]*models.WebhookDelivery, 0, len(due))
+	for _, delivery := range due {
+		c := *delivery
+		c.NextAttemptAt = &until
+		if err := o.appendLocked(&c); err != nil {
+			return nil, err
+		}
+		o.deliveries[c.ID] = &c
+		returned := c
+		claimed = append(claimed, &returned)
+	}
+	return claimed, o.maybeCompactLocked()
+}
+
+func (o *FileWebhookOutbox) List(ctx context.Context, filter models.WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range o.deliveries {
+		if filter.Owner != "" && delivery.Owner != filter.Owner {
+			continue
+		}
+		if filter.Status != "" && delivery.Status != filter.Status {
+			continue
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	if len(deliveries) > filter.Limit {
+		deliveries = deliveries[:filter.Limit]
+	}
+	return deliveries, nil
+}
+
+// appendLocked appends a delivery's new state to the file. Callers hold
+// mu.
+func (o *FileWebhookOutbox) appendLocked(delivery *models.WebhookDelivery) error {
+	if o.path != "" {
+		if err := appendJSONLine(o.path, delivery); err != nil {
+			return fmt.Errorf("failed to save webhook outbox: %w", err)
+		}
+	}
+	o.lines++
+	return nil
+}
+
+// maybeCompactLocked compacts the outbox once its file holds too many
+// lines, or without a file after as many changes. Callers hold mu.
+func (o *FileWebhookOutbox) maybeCompactLocked() error {
+	if o.lines < len(o.deliveries)+webhookCompactionSlack {
+		return nil
+	}
+	return o.compactLocked()
+}
+
+// compactLocked drops deliveries delivered or dead-lettered longer than
+// models.WebhookDeliveryRetention ago, and rewrites the file with one line
+// per remaining delivery. Callers hold mu, or own o.
+func (o *FileWebhookOutbox) compactLocked() error {
+	cutoff := time.Now().Add(-models.WebhookDeliveryRetention)
+	for id, delivery := range o.deliveries {
+		if delivery.Expired(cutoff) {
+			delete(o.deliveries, id)
+		}
+	}
+	if o.path == "" {
+		o.lines = len(o.deliveries)
+		return nil
+	}
+
+	ids := make([]string, 0, len(o.deliveries))
+	for id := range o.deliveries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	lines := make([]interface{}, len(ids))
+	for i, id := range ids {
+		lines[i] = o.deliveries[id]
+	}
+	if err := writeJSONLines(o.path, ".webhook-outbox-*", lines); err != nil {
+		return fmt.Errorf("failed to save webhook outbox: %w", err)
+	}
+	o.lines = len(lines)
+	return nil
+}
+
+// WebhookDispatcher delivers webhooks from an outbox. Each delivery is
+// attempted as soon as it is enqueued; failed ones are retried by
+// DeliverDue with models.WebhookBackoff between attempts, and are
+// dead-lettered after models.WebhookMaxAttempts.
+type WebhookDispatcher struct {
+	outbox  WebhookOutbox
+	client  *http.Client
+	secrets []string
+}
+
+// NewWebhookDispatcher returns a dispatcher for outbox that signs every
+// attempt with secrets.
+func NewWebhookDispatcher(outbox WebhookOutbox, secrets []string) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		outbox:  outbox,
+		client:  newWebhookClient(webhookAttemptTimeout),
+		secrets: secrets,
+	}
+}
+
+// Outbox returns the outbox the dispatcher delivers from.
+func (d *WebhookDispatcher) Outbox() WebhookOutbox {
+	return d.outbox
+}
+
+// Enqueue saves a delivery to the outbox and makes its first attempt. It
+// returns an error only when the delivery could not be saved; a failed
+// attempt is retried later.
+func (d *WebhookDispatcher) Enqueue(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if err := d.claim(ctx, delivery); err != nil {
+		return fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+	return d.attempt(ctx, delivery)
+}
+
+// Replay delivers a delivered or dead delivery again with a fresh set of
+// attempts. The delivery is updated with the outcome of the first one.
+func (d *WebhookDispatcher) Replay(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if err := delivery.Replay(time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := d.claim(ctx, delivery); err != nil {
+		return err
+	}
+	return d.attempt(ctx, delivery)
+}
+
+// DeliverDue claims the deliveries whose next attempt is due and attempts
+// them, webhookDueConcurrency at a time. A delivery whose outcome could not
+// be saved does not stop the others; it is attempted again once its lease
+// runs out.
+func (d *WebhookDispatcher) DeliverDue(ctx context.Context) error {
+	now := time.Now().UTC()
+	due, err := d.outbox.Claim(ctx, now, now.Add(webhookAttemptLease), webhookDueBatch)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	slots := make(chan struct{}, webhookDueConcurrency)
+	for _, delivery := range due {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := d.attempt(ctx, delivery); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("delivery %s: %w", delivery.ID, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Run calls DeliverDue every interval until ctx is done.
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.DeliverDue(ctx); err != nil {
+				log.Printf("webhook outbox: %v", err)
+			}
+		}
+	}
+}
+
+// claim saves a delivery about to be attempted outside DeliverDue with its
+// next attempt pushed back by webhookAttemptLease, so no dispatch pass
+// attempts it meanwhile.
+func (d *WebhookDispatcher) claim(ctx context.Context, delivery *models.WebhookDelivery) error {
+	lease := time.Now().UTC().Add(webhookAttemptLease)
+	delivery.NextAttemptAt = &lease
+	return d.outbox.Put(ctx, delivery)
+}
+
+// attempt sends a delivery once and saves the outcome.
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	req, err := newDeliveryRequest(ctx, delivery, d.secrets)
+	if err != nil {
+		delivery.Failed(0, err.Error(), time.Now().UTC())
+		return d.outbox.Put(ctx, delivery)
+	}
+
+	resp, err := d.client.Do(req)
+	now := time.Now().UTC()
+	switch {
+	case err != nil:
+		delivery.Failed(0, err.Error(), now)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		resp.Body.Close()
+		delivery.Succeeded(resp.StatusCode, now)
+	default:
+		resp.Body.Close()
+		delivery.Failed(resp.StatusCode, fmt.Sprintf("webhook returned non-2xx status: %d", resp.StatusCode), now)
+	}
+	if delivery.Status == models.DeliveryDead {
+		log.Printf("webhook delivery %s dead-lettered after %d attempts: %s", delivery.ID, delivery.Attempts, delivery.LastError)
+	}
+	return d.outbox.Put(ctx, delivery)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
@@ -14,14 +15,18 @@ import (
 
 // WebhookPayload represents the data sent to webhook URLs
 type WebhookPayload struct {
-	Event     string               `json:"event"`
-	JobID     string               `json:"job_id"`
-	URL       string               `json:"url"`
-	Status    string               `json:"status"`
-	Timestamp time.Time            `json:"timestamp"`
-	Data      *WebhookJobData      `json:"data,omitempty"`
-	Error     string               `json:"error,omitempty"`
-	Metadata  *WebhookMetadata     `json:"metadata,omitempty"`
+	Event     string                       `json:"event"`
+	JobID     string                       `json:"job_id"`
+	URL       string                       `json:"url"`
+	Status    string                       `json:"status"`
+	Timestamp time.Time                    `json:"timestamp"`
+	Data      *WebhookJobData              `json:"data,omitempty"`
+	Error     string                       `json:"error,omitempty"`
+	Problem   *models.Problem              `json:"problem,omitempty"`
+	Metadata  *WebhookMetadata             `json:"metadata,omitempty"`
+	Options   *models.TranscriptionOptions `json:"options,omitempty"`
+	BatchID   string                       `json:"batch_id,omitempty"`
+	Batch     *models.BatchStatusResponse  `json:"batch,omitempty"`
 }
 
 // WebhookJobData contains the job results
@@ -33,12 +38,44 @@ type WebhookJobData struct {
 	SubtitleFiles *SubtitleFiles   `json:"subtitle_files,omitempty"`
 }
 
-// SubtitleFiles contains paths to generated subtitle files
+// SubtitleFiles contains paths to generated subtitle files and the URLs
+// the transcript can be downloaded from
 type SubtitleFiles struct {
 	SRTPath string `json:"srt_path,omitempty"`
 	VTTPath string `json:"vtt_path,omitempty"`
+	TXTURL  string `json:"txt_url,omitempty"`
 	SRTURL  string `json:"srt_url,omitempty"`
 	VTTURL  string `json:"vtt_url,omitempty"`
+	JSONURL string `json:"json_url,omitempty"`
+	// ExpiresAt is when signed URLs stop working.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// NewSubtitleFiles returns the download URLs of a job's transcript under
+// baseURL, the public root of the API, limited to the output formats the
+// job asked for. The URLs are signed by signer and need no API key; with a
+// nil signer they are the authenticated download URLs.
+func NewSubtitleFiles(baseURL string, signer *URLSigner, job *models.Job) *SubtitleFiles {
+	files := &SubtitleFiles{}
+	now := time.Now()
+	for format, url := range map[SubtitleFormat]*string{
+		FormatTXT:  &files.TXTURL,
+		FormatSRT:  &files.SRTURL,
+		FormatVTT:  &files.VTTURL,
+		FormatJSON: &files.JSONURL,
+	} {
+		if !job.Options.WantsFormat(string(format)) {
+			continue
+		}
+		if signer == nil {
+			*url = TranscriptURL(baseURL, job.ID, format)
+			continue
+		}
+		signed, expires := signer.Sign(baseURL, job.ID, format, now)
+		*url = signed
+		files.ExpiresAt = &expires
+	}
+	return files
 }
 
 // WebhookMetadata contains processing metadata
@@ -56,13 +93,28 @@ type WebhookConfig struct {
 	Headers map[string]string `json:"headers,omitempty"`
 	Timeout time.Duration     `json:"timeout"`
 	Retries int               `json:"retries"`
-	Events  []string          `json:"events"` // job.started, job.completed, job.failed
+	Events  []string          `json:"events"` // job.started, job.completed, job.failed, batch.completed
+	// BaseURL is the public root of the API, used for download links in
+	// payloads. Links are relative when it is empty.
+	BaseURL string `json:"base_url,omitempty"`
+	// Signer signs the download links in payloads, which are the
+	// authenticated download URLs when it is nil.
+	Signer *URLSigner `json:"-"`
+	// Secrets sign every delivery in WebhookSignatureHeader. Deliveries
+	// are unsigned without them. A Dispatcher signs with its own.
+	Secrets []string `json:"-"`
+	// Dispatcher delivers webhooks from its outbox, retrying them for
+	// hours. Without it, deliveries are retried Retries times in memory.
+	Dispatcher *WebhookDispatcher `json:"-"`
+	// Owner is the owner of the job or batch the webhooks are about, who
+	// can list and replay their deliveries.
+	Owner string `json:"-"`
 }
 
 // WebhookManager handles webhook notifications
 type WebhookManager struct {
-	client   *http.Client
-	config   WebhookConfig
+	client     *http.Client
+	config     WebhookConfig
 	retryDelay time.Duration
 }
 
@@ -76,9 +128,7 @@ func NewWebhookManager(config WebhookConfig) *WebhookManager {
 	}
 
 	return &WebhookManager{
-		client: &http.Client{
-			Timeout: config.Timeout,
-		},
+		client:     newWebhookClient(config.Timeout),
 		config:     config,
 		retryDelay: 2 * time.Second,
 	}
@@ -96,12 +146,8 @@ func (wm *WebhookManager) SendJobStarted(ctx context.Context, job *models.Job) e
 		URL:       job.URL,
 		Status:    string(job.Status),
 		Timestamp: time.Now(),
-		Metadata: &WebhookMetadata{
-			AudioFormat:    "wav",
-			WhisperModel:   "base.en",
-			Language:       "en",
-			WordTimestamps: true,
-		},
+		Metadata:  jobMetadata(job, 0),
+		Options:   jobOptions(job),
 	}
 
 	return wm.sendWebhook(ctx, payload)
@@ -118,16 +164,9 @@ func (wm *WebhookManager) SendJobCompleted(ctx context.Context, job *models.Job,
 		duration = job.Segments[len(job.Segments)-1].End
 	}
 
-	subtitleFiles := &SubtitleFiles{}
-	if srtPath != "" {
-		subtitleFiles.SRTPath = srtPath
-		// In production, these would be public URLs
-		subtitleFiles.SRTURL = fmt.Sprintf("https://api.videotranscript.app/files/%s.srt", job.ID)
-	}
-	if vttPath != "" {
-		subtitleFiles.VTTPath = vttPath
-		subtitleFiles.VTTURL = fmt.Sprintf("https://api.videotranscript.app/files/%s.vtt", job.ID)
-	}
+	subtitleFiles := NewSubtitleFiles(wm.config.BaseURL, wm.config.Signer, job)
+	subtitleFiles.SRTPath = srtPath
+	subtitleFiles.VTTPath = vttPath
 
 	payload := WebhookPayload{
 		Event:     "job.completed",
@@ -142,13 +181,8 @@ func (wm *WebhookManager) SendJobCompleted(ctx context.Context, job *models.Job,
 			Duration:      duration,
 			SubtitleFiles: subtitleFiles,
 		},
-		Metadata: &WebhookMetadata{
-			ProcessingTimeMs: processingTime.Milliseconds(),
-			AudioFormat:      "wav",
-			WhisperModel:     "base.en",
-			Language:         "en",
-			WordTimestamps:   true,
-		},
+		Metadata: jobMetadata(job, processingTime),
+		Options:  jobOptions(job),
 	}
 
 	return wm.sendWebhook(ctx, payload)
@@ -167,25 +201,73 @@ func (wm *WebhookManager) SendJobFailed(ctx context.Context, job *models.Job, er
 		Status:    string(job.Status),
 		Timestamp: time.Now(),
 		Error:     errorMsg,
-		Metadata: &WebhookMetadata{
-			ProcessingTimeMs: processingTime.Milliseconds(),
-			AudioFormat:      "wav",
-			WhisperModel:     "base.en",
-			Language:         "en",
-			WordTimestamps:   true,
-		},
+		Problem:   job.Problem,
+		Metadata:  jobMetadata(job, processingTime),
+		Options:   jobOptions(job),
 	}
 
 	return wm.sendWebhook(ctx, payload)
 }
 
-// sendWebhook sends the webhook with retry logic
+// jobMetadata describes how a job was processed, reflecting its options.
+func jobMetadata(job *models.Job, processingTime time.Duration) *WebhookMetadata {
+	metadata := &WebhookMetadata{
+		ProcessingTimeMs: processingTime.Milliseconds(),
+		AudioFormat:      "wav",
+		WhisperModel:     "base.en",
+		Language:         "en",
+		WordTimestamps:   true,
+	}
+	if job.Options.Model != "" {
+		metadata.WhisperModel = job.Options.Model
+	}
+	if job.Options.Language != "" {
+		metadata.Language = job.Options.Language
+	}
+	return metadata
+}
+
+// jobOptions returns the options the job was submitted with, if any.
+func jobOptions(job *models.Job) *models.TranscriptionOptions {
+	if job.Options.IsZero() {
+		return nil
+	}
+	return &job.Options
+}
+
+// SendBatchCompleted sends a webhook once every child job of a batch has finished
+func (wm *WebhookManager) SendBatchCompleted(ctx context.Context, batch *models.BatchStatusResponse) error {
+	if !wm.shouldSendEvent("batch.completed") {
+		return nil
+	}
+
+	payload := WebhookPayload{
+		Event:     "batch.completed",
+		BatchID:   batch.ID,
+		URL:       batch.SourceURL,
+		Status:    batch.Status,
+		Timestamp: time.Now(),
+		Batch:     batch,
+	}
+
+	return wm.sendWebhook(ctx, payload)
+}
+
+// sendWebhook enqueues the webhook in the dispatcher's outbox, or without
+// a dispatcher sends it with in-memory retries
 func (wm *WebhookManager) sendWebhook(ctx context.Context, payload WebhookPayload) error {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal webhook payload: %w", err)
 	}
 
+	delivery := models.NewWebhookDelivery(wm.config.Owner, payload.Event, wm.config.URL, wm.config.Headers, jsonData)
+	delivery.JobID = payload.JobID
+	delivery.BatchID = payload.BatchID
+	if wm.config.Dispatcher != nil {
+		return wm.config.Dispatcher.Enqueue(ctx, delivery)
+	}
+
 	var lastErr error
 	for attempt := 0; attempt <= wm.config.Retries; attempt++ {
 		if attempt > 0 {
@@ -197,22 +279,12 @@ func (wm *WebhookManager) sendWebhook(ctx context.Context, payload WebhookPayloa
 			}
 		}
 
-		req, err := http.NewRequestWithContext(ctx, "POST", wm.config.URL, bytes.NewBuffer(jsonData))
+		req, err := newDeliveryRequest(ctx, delivery, wm.config.Secrets)
 		if err != nil {
-			lastErr = fmt.Errorf("failed to create webhook request: %w", err)
+			lastErr = err
 			continue
 		}
 
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("User-Agent", "OmniTranscripts/1.0")
-		req.Header.Set("X-Webhook-Event", payload.Event)
-		req.Header.Set("X-Webhook-Job-ID", payload.JobID)
-
-		// Add custom headers
-		for key, value := range wm.config.Headers {
-			req.Header.Set(key, value)
-		}
-
 		resp, err := wm.client.Do(req)
 		if err != nil {
 			lastErr = fmt.Errorf("webhook request failed (attempt %d): %w", attempt+1, err)
@@ -233,6 +305,36 @@ func (wm *WebhookManager) sendWebhook(ctx context.Context, payload WebhookPayloa
 	return lastErr
 }
 
+// newDeliveryRequest builds the request for one attempt at a delivery,
+// signed with secrets.
+func newDeliveryRequest(ctx context.Context, delivery *models.WebhookDelivery, secrets []string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "OmniTranscripts/1.0")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery-ID", delivery.ID)
+	if len(secrets) > 0 {
+		// Signed per attempt, so a retry is not mistaken for a replay.
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Payload, secrets, time.Now()))
+	}
+	if delivery.JobID != "" {
+		req.Header.Set("X-Webhook-Job-ID", delivery.JobID)
+	}
+	if delivery.BatchID != "" {
+		req.Header.Set("X-Webhook-Batch-ID", delivery.BatchID)
+	}
+
+	// Add custom headers
+	for key, value := range delivery.Headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
 // shouldSendEvent checks if the event should be sent based on configuration
 func (wm *WebhookManager) shouldSendEvent(event string) bool {
 	if len(wm.config.Events) == 0 {
@@ -248,6 +350,29 @@ func (wm *WebhookManager) shouldSendEvent(event string) bool {
 	return false
 }
 
+// WebhookEvents lists the events a webhook can subscribe to
+var WebhookEvents = []string{"job.started", "job.completed", "job.failed", "batch.completed"}
+
+// MaxWebhookHeaders caps the custom headers sent with each webhook
+const MaxWebhookHeaders = 10
+
+// reservedWebhookHeaders are set by the webhook manager itself and cannot
+// be overridden by custom headers
+var reservedWebhookHeaders = map[string]bool{
+	"Content-Type":          true,
+	"Content-Length":        true,
+	"Host":                  true,
+	"User-Agent":            true,
+	"X-Webhook-Event":       true,
+	"X-Webhook-Job-Id":      true,
+	"X-Webhook-Batch-Id":    true,
+	"X-Webhook-Delivery-Id": true,
+
+	http.CanonicalHeaderKey(WebhookSignatureHeader): true,
+}
+
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
 // ValidateWebhookConfig validates webhook configuration
 func ValidateWebhookConfig(config WebhookConfig) error {
 	if config.URL == "" {
@@ -259,6 +384,27 @@ func ValidateWebhookConfig(config WebhookConfig) error {
 		return fmt.Errorf("webhook URL must start with http:// or https://")
 	}
 
+	if len(config.Headers) > MaxWebhookHeaders {
+		return fmt.Errorf("at most %d webhook headers are allowed", MaxWebhookHeaders)
+	}
+	for name, value := range config.Headers {
+		if !headerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid webhook header name %q", name)
+		}
+		if reservedWebhookHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("webhook header %q cannot be overridden", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid value for webhook header %q", name)
+		}
+	}
+
+	for _, event := range config.Events {
+		if event != "*" && !knownWebhookEvent(event) {
+			return fmt.Errorf("unknown webhook event %q, expected one of %s", event, strings.Join(WebhookEvents, ", "))
+		}
+	}
+
 	if config.Timeout < 0 {
 		return fmt.Errorf("webhook timeout must be positive")
 	}
@@ -267,7 +413,18 @@ func ValidateWebhookConfig(config WebhookConfig) error {
 		return fmt.Errorf("webhook retries must be non-negative")
 	}
 
-	return nil
+	// Resolved last, since it needs the network. Deliveries check the
+	// address again when they connect.
+	return checkWebhookHost(config.URL)
+}
+
+func knownWebhookEvent(event string) bool {
+	for _, known := range WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
 }
 
 // WebhookTestPayload creates a test payload for webhook validation
@@ -292,4 +449,3 @@ func WebhookTestPayload(webhookURL string) WebhookPayload {
 		},
 	}
 }
-
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader carries the signature of a webhook delivery,
+	// as "t=<unix time>,v1=<hex HMAC-SHA256>" with one v1 per secret.
+	WebhookSignatureHeader = "X-OmniTranscripts-Signature"
+	// DefaultWebhookTolerance is how far a delivery's timestamp may be from
+	// the receiver's clock before VerifyWebhookSignature rejects it.
+	DefaultWebhookTolerance = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidWebhookSignature is returned for deliveries without a
+	// well-formed signature from one of the receiver's secrets.
+	ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+	// ErrWebhookTimestampOutOfTolerance is returned for deliveries signed
+	// too long ago, or too far in the future, to rule out a replay.
+	ErrWebhookTimestampOutOfTolerance = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// SignWebhook returns the WebhookSignatureHeader value for body sent at t,
+// with a signature from each secret so receivers holding any of them can
+// verify it while a secret is rotated.
+func SignWebhook(body []byte, secrets []string, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	parts := []string{"t=" + timestamp}
+	for _, secret := range secrets {
+		parts = append(parts, "v1="+webhookMAC(secret, timestamp, body))
+	}
+	return strings.Join(parts, ",")
+}
+
+// VerifyWebhookSignature checks that header, the WebhookSignatureHeader of
+// a delivery, signs body with one of secrets and was made within tolerance
+// of now. A zero tolerance means DefaultWebhookTolerance. body must be the
+// raw request body, before any decoding.
+func VerifyWebhookSignature(body []byte, header string, secrets []string, tolerance time.Duration) error {
+	if tolerance <= 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			timestamp = value
+		case "v1":
+			// Undecodable signatures are skipped; they cannot match.
+			if signature, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidWebhookSignature
+	}
+
+	for _, secret := range secrets {
+		expected, _ := hex.DecodeString(webhookMAC(secret, timestamp, body))
+		for _, signature := range signatures {
+			if !hmac.Equal(signature, expected) {
+				continue
+			}
+			// The timestamp is signed, so it is checked only once it is
+			// known to be genuine.
+			if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+				return fmt.Errorf("%w: signed %s ago", ErrWebhookTimestampOutOfTolerance, age.Round(time.Second))
+			}
+			return nil
+		}
+	}
+	return ErrInvalidWebhookSignature
+}
+
+// webhookMAC is the hex HMAC-SHA256 of "<timestamp>.<body>".
+func webhookMAC(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
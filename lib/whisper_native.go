@@ -10,12 +10,16 @@ package lib
 #cgo darwin LDFLAGS: -lggml-metal -lggml-blas
 #cgo darwin LDFLAGS: -framework Accelerate -framework Metal -framework Foundation -framework CoreGraphics
 #include <whisper.h>
+#include <stdint.h>
 #include <stdlib.h>
+
+extern void goWhisperNewSegment(struct whisper_context * ctx, struct whisper_state * state, int n_new, void * user_data);
 */
 import "C"
 
 import (
 	"fmt"
+	"runtime/cgo"
 	"unsafe"
 )
 
@@ -47,6 +51,13 @@ func (w *WhisperContext) Free() {
 
 // TranscribeAudio transcribes the given audio samples
 func (w *WhisperContext) TranscribeAudio(samples []float32) ([]TranscriptSegment, error) {
+	return w.TranscribeAudioWithParams(samples, "en", false, nil)
+}
+
+// TranscribeAudioWithParams transcribes the given audio samples in language
+// ("auto" to detect it), translating to English when translate is set.
+// onSegment, if not nil, is called with each segment as it is decoded.
+func (w *WhisperContext) TranscribeAudioWithParams(samples []float32, language string, translate bool, onSegment func(TranscriptSegment)) ([]TranscriptSegment, error) {
 	if w.ctx == nil {
 		return nil, fmt.Errorf("whisper context is nil")
 	}
@@ -57,10 +68,23 @@ func (w *WhisperContext) TranscribeAudio(samples []float32) ([]TranscriptSegment
 	params.print_progress = C.bool(false)
 	params.print_timestamps = C.bool(false)
 	params.print_special = C.bool(false)
-	params.translate = C.bool(false)
-	params.language = C.CString("en")
+	params.translate = C.bool(translate)
+	params.language = C.CString(language)
 	defer C.free(unsafe.Pointer(params.language))
 
+	if onSegment != nil {
+		// C may not hold Go pointers, so the callback travels as a handle
+		// in C memory.
+		handle := cgo.NewHandle(onSegment)
+		defer handle.Delete()
+		userData := C.malloc(C.size_t(unsafe.Sizeof(C.uintptr_t(0))))
+		defer C.free(userData)
+		*(*C.uintptr_t)(userData) = C.uintptr_t(handle)
+
+		params.new_segment_callback = C.whisper_new_segment_callback(C.goWhisperNewSegment)
+		params.new_segment_callback_user_data = userData
+	}
+
 	// Run the full pipeline
 	if C.whisper_full(w.ctx, params, (*C.float)(&samples[0]), C.int(len(samples))) != 0 {
 		return nil, fmt.Errorf("whisper_full failed")
@@ -85,6 +109,24 @@ func (w *WhisperContext) TranscribeAudio(samples []float32) ([]TranscriptSegment
 	return segments, nil
 }
 
+// goWhisperNewSegment is whisper.cpp's new segment callback. It passes the
+// n_new segments decoded since the last call to the Go callback whose handle
+// is in user_data.
+//
+//export goWhisperNewSegment
+func goWhisperNewSegment(ctx *C.struct_whisper_context, state *C.struct_whisper_state, nNew C.int, userData unsafe.Pointer) {
+	onSegment := cgo.Handle(*(*C.uintptr_t)(userData)).Value().(func(TranscriptSegment))
+
+	n := int(C.whisper_full_n_segments_from_state(state))
+	for i := n - int(nNew); i < n; i++ {
+		onSegment(TranscriptSegment{
+			Text:      C.GoString(C.whisper_full_get_segment_text_from_state(state, C.int(i))),
+			StartTime: int64(C.whisper_full_get_segment_t0_from_state(state, C.int(i))) * 10, // Convert to milliseconds
+			EndTime:   int64(C.whisper_full_get_segment_t1_from_state(state, C.int(i))) * 10, // Convert to milliseconds
+		})
+	}
+}
+
 // IsWhisperAvailable checks if whisper.cpp is available
 func IsWhisperAvailable() bool {
 	// This is a simple check - we could make it more sophisticated
@@ -20,6 +20,11 @@ func (w *WhisperContext) TranscribeAudio(samples []float32) ([]TranscriptSegment
 	return nil, fmt.Errorf("whisper.cpp requires CGO; build with CGO_ENABLED=1")
 }
 
+// TranscribeAudioWithParams returns an error on non-CGO builds
+func (w *WhisperContext) TranscribeAudioWithParams(samples []float32, language string, translate bool, onSegment func(TranscriptSegment)) ([]TranscriptSegment, error) {
+	return nil, fmt.Errorf("whisper.cpp requires CGO; build with CGO_ENABLED=1")
+}
+
 // IsWhisperAvailable returns false for non-CGO builds
 func IsWhisperAvailable() bool {
 	return false
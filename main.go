@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	_ "github.com/jackc/pgx/v5/stdlib"
 
 	"omnitranscripts/config"
 	"omnitranscripts/handlers"
@@ -16,30 +22,120 @@ import (
 func main() {
 	cfg := config.Load()
 
-	app := fiber.New(fiber.Config{
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": err.Error(),
-			})
-		},
-	})
-
+	app := newApp(cfg)
 	app.Use(cors.New())
-	app.Use(logger.New())
+	app.Use(requestid.New())
+	app.Use(logger.New(logger.Config{
+		Format: "${time} | ${status} | ${latency} | ${ip} | ${method} | ${path} | ${locals:requestid} | ${error}\n",
+	}))
 
-	jobs.Initialize()
+	db, err := openDatabase(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := openKeyStore(cfg, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth := lib.NewAuthenticator(keys, lib.ConfiguredKeys(cfg)...)
+	audit, err := openAuditLog(cfg, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	meter, err := openUsageMeter(cfg, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signingKeys, err := lib.ParseSigningKeys(cfg.ArtifactSigningKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(signingKeys) == 0 {
+		log.Printf("ARTIFACT_SIGNING_KEYS is not set; signed download URLs will stop working on restart")
+	}
+	signer, err := lib.NewURLSigner(signingKeys, cfg.ArtifactURLTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	outbox, err := lib.NewFileWebhookOutbox(cfg.WebhookOutboxFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhooks := lib.NewWebhookDispatcher(outbox, cfg.WebhookSecrets)
+	go webhooks.Run(context.Background(), 15*time.Second)
 
-	app.Get("/health", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{
-			"status":  "ok",
-			"message": "OmniTranscripts API is running",
-		})
-	})
+	jobs.Initialize()
+	handlers.UseConfig(cfg)
+	handlers.UseQuotas(handlers.NewQuotaTracker(lib.DefaultQuota(cfg)))
+	handlers.UseMeter(meter)
+	handlers.UseAuditLog(audit)
+	handlers.UseURLSigner(signer)
+	handlers.UseWebhookDispatcher(webhooks)
+	handlers.StartWorkers(cfg.WorkerCount)
 
-	api := app.Group("/", lib.AuthMiddleware())
-	api.Post("/transcribe", handlers.PostTranscribe)
-	api.Get("/transcribe/:job_id", handlers.GetTranscribeJob)
+	registerRoutes(app, cfg, auth)
 
 	log.Printf("Starting server on port %s", cfg.Port)
 	log.Fatal(app.Listen(":" + cfg.Port))
 }
+
+// openDatabase connects to DATABASE_URL, the Encore service's database,
+// or returns nil when it is not set.
+func openDatabase(cfg *config.Config) (*sql.DB, error) {
+	if cfg.DatabaseURL == "" {
+		return nil, nil
+	}
+	db, err := sql.Open("pgx", cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to DATABASE_URL: %w", err)
+	}
+	return db, nil
+}
+
+// openKeyStore returns the api_keys table of db, shared with the Encore
+// service, or else the API_KEYS_FILE store.
+func openKeyStore(cfg *config.Config, db *sql.DB) (lib.KeyStore, error) {
+	if db == nil {
+		return lib.NewFileKeyStore(cfg.APIKeysFile)
+	}
+	return lib.NewSQLKeyStore(db), nil
+}
+
+// openAuditLog returns the audit_log table of db, shared with the Encore
+// service, or else the AUDIT_LOG_FILE log.
+func openAuditLog(cfg *config.Config, db *sql.DB) (lib.AuditLog, error) {
+	if db == nil {
+		return lib.NewFileAuditLog(cfg.AuditLogFile)
+	}
+	return lib.NewSQLAuditLog(db), nil
+}
+
+// openUsageMeter returns the api_usage and job_usage tables of db, shared
+// with the Encore service, or else the USAGE_FILE meter.
+func openUsageMeter(cfg *config.Config, db *sql.DB) (lib.UsageMeter, error) {
+	if db == nil {
+		return lib.NewFileUsageMeter(cfg.UsageFile)
+	}
+	return lib.NewSQLUsageMeter(db), nil
+}
+
+// newApp returns the server's fiber app. Request bodies are streamed so
+// uploads go to disk instead of being buffered, or spooled by the multipart
+// parser, up front. Streaming turns off BodyLimit, which then only decides
+// how much is read before the handler runs; registerRoutes caps every
+// other route's body with lib.LimitRequestBody.
+func newApp(cfg *config.Config) *fiber.App {
+	return fiber.New(fiber.Config{
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+		BodyLimit:                    int(cfg.MaxRequestBytes),
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		},
+	})
+}
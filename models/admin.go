@@ -0,0 +1,88 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrFailedByOperator is the cause recorded on jobs an operator failed
+// through the admin API.
+var ErrFailedByOperator = errors.New("failed by an operator")
+
+// FailJobRequest is the body of the admin call that fails a job.
+type FailJobRequest struct {
+	// Reason is recorded as the job's error.
+	Reason string `json:"reason,omitempty"`
+}
+
+// WorkerPoolStatus describes the workers and the jobs waiting for them.
+// While Draining, workers finish their current jobs but start no others.
+type WorkerPoolStatus struct {
+	Workers  int  `json:"workers,omitempty"`
+	Busy     int  `json:"busy"`
+	Queued   int  `json:"queued"`
+	Draining bool `json:"draining"`
+}
+
+// Actions recorded in the audit log.
+const (
+	AuditKeyCreated     = "key.created"
+	AuditKeyRotated     = "key.rotated"
+	AuditKeyRevoked     = "key.revoked"
+	AuditKeyLimitsSet   = "key.limits_set"
+	AuditJobFailed      = "job.failed"
+	AuditJobRequeued    = "job.requeued"
+	AuditJobBumped      = "job.bumped"
+	AuditWorkersDrained = "workers.drained"
+	AuditWorkersResumed = "workers.resumed"
+)
+
+// DefaultAuditListLimit and MaxAuditListLimit bound audit log pages.
+const (
+	DefaultAuditListLimit = 50
+	MaxAuditListLimit     = 500
+)
+
+// AuditEntry records one admin action: who took it, what it was and what
+// it was taken on.
+type AuditEntry struct {
+	ID         string          `json:"id"`
+	At         time.Time       `json:"at"`
+	ActorKeyID string          `json:"actor_key_id"`
+	Action     string          `json:"action"`
+	Target     string          `json:"target,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+}
+
+// NewAuditEntry describes an action taken by the key actorKeyID on target,
+// with details marshalled to JSON.
+func NewAuditEntry(actorKeyID, action, target string, details map[string]interface{}) AuditEntry {
+	entry := AuditEntry{
+		ID:         uuid.NewString(),
+		At:         time.Now().UTC(),
+		ActorKeyID: actorKeyID,
+		Action:     action,
+		Target:     target,
+	}
+	if len(details) > 0 {
+		// Details hold plain values, which always marshal.
+		entry.Details, _ = json.Marshal(details)
+	}
+	return entry
+}
+
+// AuditFilter selects audit log entries, newest first.
+type AuditFilter struct {
+	Action string
+	Target string
+	Limit  int
+}
+
+// AuditLogResponse is a page of the audit log.
+type AuditLogResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobStatus represents the status of a transcription job
+type JobStatus string
+
+const (
+	StatusQueued    JobStatus = "queued"
+	StatusRunning   JobStatus = "running"
+	StatusCompleted JobStatus = "completed"
+	StatusFailed    JobStatus = "failed"
+	StatusCancelled JobStatus = "cancelled"
+)
+
+// Pipeline stages reported while a job is running. The values match
+// engine.Stage so stage names are the same everywhere they are shown.
+const (
+	StageDownload   = "download"
+	StageNormalize  = "normalize"
+	StageTranscribe = "transcribe"
+)
+
+// Priority orders jobs of the same API key against each other. It does not
+// let one key jump ahead of another; fairness across keys is decided by the
+// dispatcher's weights.
+type Priority string
+
+const (
+	PriorityLow    Priority = "low"
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
+)
+
+// Valid reports whether p is one of the known priorities. The empty
+// priority is valid and means PriorityNormal.
+func (p Priority) Valid() bool {
+	switch p {
+	case "", PriorityLow, PriorityNormal, PriorityHigh:
+		return true
+	}
+	return false
+}
+
+// Rank returns a sort key for p; higher ranks are dispatched first.
+func (p Priority) Rank() int {
+	switch p {
+	case PriorityHigh:
+		return 2
+	case PriorityLow:
+		return 0
+	}
+	return 1
+}
+
+// ErrInvalidTransition is returned when a job is asked to move to a status
+// that is not reachable from its current one.
+var ErrInvalidTransition = errors.New("invalid job status transition")
+
+// transitions lists the statuses reachable from each non-terminal status.
+var transitions = map[JobStatus][]JobStatus{
+	StatusQueued:  {StatusRunning, StatusFailed, StatusCancelled},
+	StatusRunning: {StatusCompleted, StatusFailed, StatusCancelled},
+}
+
+// Valid reports whether s is one of the known job statuses.
+func (s JobStatus) Valid() bool {
+	switch s {
+	case StatusQueued, StatusRunning, StatusCompleted, StatusFailed, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// IsTerminal reports whether no further transitions are possible from s.
+func (s JobStatus) IsTerminal() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled
+}
+
+// CanTransitionTo reports whether a job in status s may move to next.
+func (s JobStatus) CanTransitionTo(next JobStatus) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeStatus maps legacy status names written by older releases and
+// the standalone dashboard onto the canonical status set.
+func NormalizeStatus(status string) JobStatus {
+	switch status {
+	case "pending":
+		return StatusQueued
+	case "complete":
+		return StatusCompleted
+	case "error":
+		return StatusFailed
+	case "downloading", "extracting", "transcribing":
+		return StatusRunning
+	}
+	return JobStatus(status)
+}
+
+// Job represents a transcription job
+type Job struct {
+	ID             string               `json:"id"`
+	URL            string               `json:"url"`
+	MediaPath      string               `json:"media_path,omitempty"`
+	Owner          string               `json:"owner,omitempty"`
+	APIKeyID       string               `json:"api_key_id,omitempty"`
+	BatchID        string               `json:"batch_id,omitempty"`
+	Priority       Priority             `json:"priority,omitempty"`
+	MediaSeconds   int                  `json:"media_seconds,omitempty"`
+	Options        TranscriptionOptions `json:"options"`
+	Webhook        *JobWebhook          `json:"webhook,omitempty"`
+	Status         JobStatus            `json:"status"`
+	Stage          string               `json:"stage,omitempty"`
+	Transcript     string               `json:"transcript,omitempty"`
+	Segments       []Segment            `json:"segments,omitempty"`
+	Error          string               `json:"error,omitempty"`
+	Problem        *Problem             `json:"problem,omitempty"`
+	CreatedAt      time.Time            `json:"created_at"`
+	StartedAt      *time.Time           `json:"started_at,omitempty"`
+	StageStartedAt *time.Time           `json:"stage_started_at,omitempty"`
+	UpdatedAt      time.Time            `json:"updated_at"`
+	CompletedAt    *time.Time           `json:"completed_at,omitempty"`
+}
+
+// JobWebhook is where a job's started, completed and failed notifications
+// are sent. Events filters them; all are sent when it is empty.
+type JobWebhook struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Events  []string          `json:"events,omitempty"`
+}
+
+// NewJob creates a new transcription job
+func NewJob(url string) *Job {
+	now := time.Now()
+	return &Job{
+		ID:        uuid.New().String(),
+		URL:       url,
+		Priority:  PriorityNormal,
+		Status:    StatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Transition moves the job to next, recording the time of the change.
+// Reaching a terminal status also sets CompletedAt.
+func (j *Job) Transition(next JobStatus) error {
+	if !j.Status.CanTransitionTo(next) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, j.Status, next)
+	}
+
+	now := time.Now()
+	j.Status = next
+	j.UpdatedAt = now
+
+	if next == StatusRunning {
+		j.StartedAt = &now
+	}
+	if next.IsTerminal() {
+		j.CompletedAt = &now
+	}
+	return nil
+}
+
+// Start marks a queued job as running
+func (j *Job) Start() error {
+	return j.Transition(StatusRunning)
+}
+
+// EnterStage records that a running job has moved on to a pipeline stage
+func (j *Job) EnterStage(stage string) error {
+	if j.Status != StatusRunning {
+		return fmt.Errorf("%w: cannot enter stage %q while %s", ErrInvalidTransition, stage, j.Status)
+	}
+
+	now := time.Now()
+	j.Stage = stage
+	j.StageStartedAt = &now
+	j.UpdatedAt = now
+	return nil
+}
+
+// Complete marks a running job as completed with transcript and segments
+func (j *Job) Complete(transcript string, segments []Segment) error {
+	if err := j.Transition(StatusCompleted); err != nil {
+		return err
+	}
+	j.Transcript = transcript
+	j.Segments = segments
+	return nil
+}
+
+// Fail marks the job as failed with an error, recording it as a Problem.
+// Error repeats the problem's detail rather than err itself, which may
+// carry file paths or a tool's output, so callers should log err.
+func (j *Job) Fail(err error) error {
+	if tErr := j.Transition(StatusFailed); tErr != nil {
+		return tErr
+	}
+	j.Problem = NewProblem(err)
+	j.Problem.Instance = JobPath(j.ID)
+	j.Error = j.Problem.Detail
+	return nil
+}
+
+// Cancel marks a queued or running job as cancelled
+func (j *Job) Cancel() error {
+	return j.Transition(StatusCancelled)
+}
+
+// Requeue returns a failed or cancelled job to the queue, clearing the
+// outcome of its last run. It is the only way back from a terminal status
+// and is reserved for operators.
+func (j *Job) Requeue() error {
+	if j.Status != StatusFailed && j.Status != StatusCancelled {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, j.Status, StatusQueued)
+	}
+
+	j.Status = StatusQueued
+	j.Stage = ""
+	j.Error = ""
+	j.Problem = nil
+	j.StartedAt = nil
+	j.StageStartedAt = nil
+	j.CompletedAt = nil
+	j.UpdatedAt = time.Now()
+	return nil
+}
+
+// IsTerminal reports whether the job has finished, successfully or not
+func (j *Job) IsTerminal() bool {
+	return j.Status.IsTerminal()
+}
+
+// Clone returns a deep copy of the job, safe to read while the original
+// keeps changing.
+func (j *Job) Clone() *Job {
+	c := *j
+	if j.Segments != nil {
+		c.Segments = append([]Segment(nil), j.Segments...)
+	}
+	c.Options = j.Options.Clone()
+	c.Problem = j.Problem.Clone()
+	if j.Webhook != nil {
+		webhook := *j.Webhook
+		webhook.Events = append([]string(nil), j.Webhook.Events...)
+		webhook.Headers = make(map[string]string, len(j.Webhook.Headers))
+		for k, v := range j.Webhook.Headers {
+			webhook.Headers[k] = v
+		}
+		c.Webhook = &webhook
+	}
+	c.StartedAt = cloneTime(j.StartedAt)
+	c.StageStartedAt = cloneTime(j.StageStartedAt)
+	c.CompletedAt = cloneTime(j.CompletedAt)
+	return &c
+}
+
+// RedactedValue stands in for secrets in the views of a job shown to
+// operators.
+const RedactedValue = "[redacted]"
+
+// Redacted returns a copy of the job for operators, with the values of its
+// webhook's custom headers, which may hold the owner's credentials,
+// replaced by RedactedValue.
+func (j *Job) Redacted() *Job {
+	c := j.Clone()
+	if c.Webhook != nil {
+		for name := range c.Webhook.Headers {
+			c.Webhook.Headers[name] = RedactedValue
+		}
+	}
+	return c
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// Summary returns the compact listing view of the job
+func (j *Job) Summary() JobSummary {
+	return JobSummary{
+		ID:          j.ID,
+		URL:         j.URL,
+		Status:      j.Status,
+		Stage:       j.Stage,
+		Error:       j.Error,
+		CreatedAt:   j.CreatedAt,
+		StartedAt:   cloneTime(j.StartedAt),
+		CompletedAt: cloneTime(j.CompletedAt),
+	}
+}
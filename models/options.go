@@ -0,0 +1,248 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Task is what the backend does with the speech it recognizes.
+type Task string
+
+const (
+	TaskTranscribe Task = "transcribe"
+	// TaskTranslate transcribes into English whatever the spoken language.
+	TaskTranslate Task = "translate"
+)
+
+// Backend selects the transcription backend. BackendAuto tries every
+// configured backend in turn, falling back to demo output.
+type Backend string
+
+const (
+	BackendAuto          Backend = "auto"
+	BackendWhisper       Backend = "whisper"
+	BackendAssemblyAI    Backend = "assemblyai"
+	BackendWhisperServer Backend = "whisper_server"
+)
+
+// OutputFormats lists the transcript formats a job can be asked for.
+var OutputFormats = []string{"txt", "srt", "vtt", "json"}
+
+// Limits on client supplied metadata.
+const (
+	MaxMetadataKeys        = 16
+	MaxMetadataKeyLength   = 40
+	MaxMetadataValueLength = 500
+)
+
+var modelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// whisperLanguages are the language codes whisper.cpp recognizes. It takes
+// no region tags, so "pt-BR" is spelled "pt".
+var whisperLanguages = func() map[string]bool {
+	codes := strings.Fields(`
+		en zh de es ru ko fr ja pt tr pl ca nl ar sv it id hi fi vi he uk el
+		ms cs ro da hu ta no th ur hr bg lt la mi ml cy sk te fa lv bn sr az
+		sl kn et mk br eu is hy ne mn bs kk sq sw gl mr pa si km sn yo so af
+		oc ka be tg sd gu am yi lo uz fo ht ps tk nn mt sa lb my bo tl mg as
+		tt haw ln ha ba jw su yue`)
+	languages := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		languages[code] = true
+	}
+	return languages
+}()
+
+// TimeRange limits transcription to part of the media, in seconds from the
+// start. A zero End means until the end of the media.
+type TimeRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end,omitempty"`
+}
+
+// PostProcessing lists optional clean-ups applied to the transcript.
+type PostProcessing struct {
+	// RemoveFillers drops filler words such as "um" and "uh".
+	RemoveFillers bool `json:"remove_fillers,omitempty"`
+	// MergeSegments joins segments into whole sentences.
+	MergeSegments bool `json:"merge_segments,omitempty"`
+}
+
+// TranscriptionOptions are the per-request settings of a job. They are
+// stored with the job so they can be echoed back and reused when the job is
+// processed again. The zero value means the server defaults.
+type TranscriptionOptions struct {
+	// Language is a language code supported by whisper such as "en" or
+	// "pt", or "auto" to detect it.
+	Language       string            `json:"language,omitempty"`
+	Task           Task              `json:"task,omitempty"`
+	Backend        Backend           `json:"backend,omitempty"`
+	Model          string            `json:"model,omitempty"`
+	OutputFormats  []string          `json:"output_formats,omitempty"`
+	TimeRange      *TimeRange        `json:"time_range,omitempty"`
+	PostProcessing *PostProcessing   `json:"post_processing,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+// FieldError describes one invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validate returns every problem with the options, or nil.
+func (o TranscriptionOptions) Validate() []FieldError {
+	var problems []FieldError
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if o.Language != "" && o.Language != "auto" && !whisperLanguages[o.Language] {
+		add("language", "must be a language code supported by whisper such as \"en\", or \"auto\"")
+	}
+
+	switch o.Task {
+	case "", TaskTranscribe, TaskTranslate:
+	default:
+		add("task", "must be transcribe or translate")
+	}
+
+	switch o.Backend {
+	case "", BackendAuto, BackendWhisper, BackendAssemblyAI, BackendWhisperServer:
+	default:
+		add("backend", "must be auto, whisper, assemblyai or whisper_server")
+	}
+
+	if o.Model != "" && !modelPattern.MatchString(o.Model) {
+		add("model", "must be a model name such as \"base.en\"")
+	}
+
+	if o.Backend == BackendAssemblyAI || o.Backend == BackendWhisperServer {
+		for _, field := range o.WhisperOnly() {
+			add(field, "is not supported by the %s backend", o.Backend)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, format := range o.OutputFormats {
+		field := fmt.Sprintf("output_formats[%d]", i)
+		switch {
+		case !validOutputFormat(format):
+			add(field, "must be one of %s", strings.Join(OutputFormats, ", "))
+		case seen[format]:
+			add(field, "duplicates %q", format)
+		}
+		seen[format] = true
+	}
+
+	if r := o.TimeRange; r != nil {
+		if r.Start < 0 {
+			add("time_range.start", "must not be negative")
+		}
+		if r.End != 0 && r.End <= r.Start {
+			add("time_range.end", "must be after time_range.start")
+		}
+	}
+
+	if len(o.Metadata) > MaxMetadataKeys {
+		add("metadata", "must have at most %d keys", MaxMetadataKeys)
+	}
+	keys := make([]string, 0, len(o.Metadata))
+	for key := range o.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := o.Metadata[key]; key == "" || len(key) > MaxMetadataKeyLength {
+			add("metadata", "keys must be 1 to %d characters", MaxMetadataKeyLength)
+		} else if len(value) > MaxMetadataValueLength {
+			add("metadata."+key, "must be at most %d characters", MaxMetadataValueLength)
+		}
+	}
+
+	return problems
+}
+
+func validOutputFormat(format string) bool {
+	for _, known := range OutputFormats {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
+
+// WhisperOnly returns the names of the options that only the whisper
+// backend honours, in field order.
+func (o TranscriptionOptions) WhisperOnly() []string {
+	var fields []string
+	if o.Language != "" {
+		fields = append(fields, "language")
+	}
+	if o.Task == TaskTranslate {
+		fields = append(fields, "task")
+	}
+	if o.Model != "" {
+		fields = append(fields, "model")
+	}
+	return fields
+}
+
+// ParseOptions reads options sent as one JSON object, as uploads send them
+// in their query string. An empty value is no options. The options still
+// need to be validated.
+func ParseOptions(value string) (TranscriptionOptions, error) {
+	var o TranscriptionOptions
+	if value == "" {
+		return o, nil
+	}
+	err := json.Unmarshal([]byte(value), &o)
+	return o, err
+}
+
+// IsZero reports whether no option was set.
+func (o TranscriptionOptions) IsZero() bool {
+	return o.Language == "" && o.Task == "" && o.Backend == "" && o.Model == "" &&
+		len(o.OutputFormats) == 0 && o.TimeRange == nil && o.PostProcessing == nil &&
+		len(o.Metadata) == 0
+}
+
+// WantsFormat reports whether format is among the requested output formats.
+// Every format is wanted when none were requested.
+func (o TranscriptionOptions) WantsFormat(format string) bool {
+	if len(o.OutputFormats) == 0 {
+		return true
+	}
+	for _, wanted := range o.OutputFormats {
+		if wanted == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy of the options.
+func (o TranscriptionOptions) Clone() TranscriptionOptions {
+	c := o
+	if o.OutputFormats != nil {
+		c.OutputFormats = append([]string(nil), o.OutputFormats...)
+	}
+	if o.TimeRange != nil {
+		r := *o.TimeRange
+		c.TimeRange = &r
+	}
+	if o.PostProcessing != nil {
+		p := *o.PostProcessing
+		c.PostProcessing = &p
+	}
+	if o.Metadata != nil {
+		c.Metadata = make(map[string]string, len(o.Metadata))
+		for k, v := range o.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	return c
+}
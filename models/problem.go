@@ -0,0 +1,134 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+
+	"omnitranscripts/engine"
+)
+
+// ProblemContentType is the media type of a Problem response body.
+const ProblemContentType = "application/problem+json"
+
+// ProblemTypePrefix prefixes a problem's code to form its type URI.
+const ProblemTypePrefix = "urn:omnitranscripts:problem:"
+
+// Codes of problems that do not come from a pipeline stage.
+const (
+	// CodeInternal is the code of failures that carry no stage-specific code.
+	CodeInternal = "internal"
+	// CodeJobCancelled is reported to a client waiting on a job that was
+	// cancelled.
+	CodeJobCancelled = "job.cancelled"
+	// CodeJobFailedByOperator is the code of jobs an operator failed
+	// through the admin API.
+	CodeJobFailedByOperator = "job.failed_by_operator"
+	// CodeQuotaExceeded is reported for jobs that would exceed their key's
+	// quota.
+	CodeQuotaExceeded = "quota.exceeded"
+)
+
+// Problem is an RFC 7807 problem details object describing why a job or
+// request failed. Stage, Code, Retryable and RequestID are extension members.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Stage     string `json:"stage,omitempty"`
+	Code      string `json:"code"`
+	Retryable bool   `json:"retryable"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// problemKind is the HTTP status and title reported for a code.
+type problemKind struct {
+	status int
+	title  string
+}
+
+var problemKinds = map[string]problemKind{
+	engine.CodeDownloadUnavailable:          {http.StatusUnprocessableEntity, "Media is unavailable"},
+	engine.CodeDownloadUnsupportedURL:       {http.StatusUnprocessableEntity, "URL is not supported"},
+	engine.CodeDownloadRateLimited:          {http.StatusServiceUnavailable, "Media source is rate limiting downloads"},
+	engine.CodeDownloadTimeout:              {http.StatusGatewayTimeout, "Media download timed out"},
+	engine.CodeDownloadFailed:               {http.StatusBadGateway, "Media download failed"},
+	engine.CodeDownloadTooLong:              {http.StatusUnprocessableEntity, "Media is too long"},
+	engine.CodeNormalizeUnsupportedCodec:    {http.StatusUnprocessableEntity, "Media codec is not supported"},
+	engine.CodeNormalizeNoAudio:             {http.StatusUnprocessableEntity, "Media has no audio"},
+	engine.CodeNormalizeFailed:              {http.StatusUnprocessableEntity, "Audio could not be normalized"},
+	engine.CodeTranscribeNotConfigured:      {http.StatusServiceUnavailable, "Transcription backend is not configured"},
+	engine.CodeTranscribeBackendTimeout:     {http.StatusGatewayTimeout, "Transcription backend timed out"},
+	engine.CodeTranscribeBackendUnavailable: {http.StatusBadGateway, "Transcription backend is unavailable"},
+	engine.CodeTranscribeFailed:             {http.StatusBadGateway, "Transcription failed"},
+}
+
+// NewProblem describes err. A *engine.TranscriptionError anywhere in its
+// chain supplies the stage, code and retryability, and a
+// *QuotaExceededError is reported as such; anything else is an internal
+// error.
+//
+// The detail never includes the underlying error, which may carry file
+// paths or a tool's output, so callers should log err themselves.
+func NewProblem(err error) *Problem {
+	p := &Problem{
+		Code:   CodeInternal,
+		Status: http.StatusInternalServerError,
+		Title:  "Internal error",
+	}
+
+	var tErr *engine.TranscriptionError
+	if errors.As(err, &tErr) {
+		p.Stage = string(tErr.Stage)
+		p.Code = tErr.Code
+		p.Retryable = tErr.Retryable
+		p.Detail = tErr.Message
+		if kind, ok := problemKinds[tErr.Code]; ok {
+			p.Status = kind.status
+			p.Title = kind.title
+		}
+	}
+
+	if errors.Is(err, ErrFailedByOperator) {
+		p.Code = CodeJobFailedByOperator
+		p.Status = http.StatusConflict
+		p.Title = "Job was failed by an operator"
+		// Written by the operator for the job's owner to read.
+		p.Detail = err.Error()
+	}
+
+	var qErr *QuotaExceededError
+	if errors.As(err, &qErr) {
+		p.Code = CodeQuotaExceeded
+		p.Status = qErr.Status()
+		p.Title = "Quota exceeded"
+		p.Detail = qErr.Error()
+	}
+
+	if p.Detail == "" {
+		p.Detail = p.Title
+	}
+	p.Type = ProblemTypePrefix + p.Code
+	return p
+}
+
+// CancelledProblem describes a job that was cancelled before it finished.
+func CancelledProblem(jobID string) *Problem {
+	return &Problem{
+		Type:     ProblemTypePrefix + CodeJobCancelled,
+		Title:    "Job was cancelled",
+		Status:   http.StatusConflict,
+		Instance: JobPath(jobID),
+		Code:     CodeJobCancelled,
+	}
+}
+
+// Clone returns a copy of the problem; nil stays nil.
+func (p *Problem) Clone() *Problem {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	return &c
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"omnitranscripts/engine"
+)
+
+// Quota limits what one API key may submit. A zero limit is unlimited,
+// except in a key's own quota, where it leaves the default in place.
+type Quota struct {
+	JobsPerDay           int `json:"jobs_per_day,omitempty"`
+	MediaMinutesPerMonth int `json:"media_minutes_per_month,omitempty"`
+	ConcurrentJobs       int `json:"concurrent_jobs,omitempty"`
+	// MaxMediaSeconds is the longest media a single job may have.
+	MaxMediaSeconds int `json:"max_media_seconds,omitempty"`
+}
+
+// Validate rejects negative limits.
+func (q Quota) Validate() error {
+	for name, limit := range map[string]int{
+		LimitJobsPerDay:           q.JobsPerDay,
+		LimitMediaMinutesPerMonth: q.MediaMinutesPerMonth,
+		LimitConcurrentJobs:       q.ConcurrentJobs,
+		"max_media_seconds":       q.MaxMediaSeconds,
+	} {
+		if limit < 0 {
+			return fmt.Errorf("quota %s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// WithDefaults returns q with each unset limit taken from fallback, so a
+// key given only some limits of its own keeps the defaults for the rest.
+func (q Quota) WithDefaults(fallback Quota) Quota {
+	if q.JobsPerDay == 0 {
+		q.JobsPerDay = fallback.JobsPerDay
+	}
+	if q.MediaMinutesPerMonth == 0 {
+		q.MediaMinutesPerMonth = fallback.MediaMinutesPerMonth
+	}
+	if q.ConcurrentJobs == 0 {
+		q.ConcurrentJobs = fallback.ConcurrentJobs
+	}
+	if q.MaxMediaSeconds == 0 {
+		q.MaxMediaSeconds = fallback.MaxMediaSeconds
+	}
+	return q
+}
+
+// Names of the limits in a Quota, as reported in errors and headers.
+const (
+	LimitJobsPerDay           = "jobs_per_day"
+	LimitMediaMinutesPerMonth = "media_minutes_per_month"
+	LimitConcurrentJobs       = "concurrent_jobs"
+)
+
+// QuotaUsage is what a key has used of its quota. Days and months are UTC
+// calendar periods. ActiveJobs counts an unfinished batch once.
+type QuotaUsage struct {
+	JobsToday         int       `json:"jobs_today"`
+	MediaMinutesMonth int       `json:"media_minutes_this_month"`
+	ActiveJobs        int       `json:"active_jobs"`
+	DayResetsAt       time.Time `json:"day_resets_at"`
+	MonthResetsAt     time.Time `json:"month_resets_at"`
+}
+
+// DayStart returns the start of the UTC day containing t.
+func DayStart(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// MonthStart returns the start of the UTC month containing t.
+func MonthStart(t time.Time) time.Time {
+	y, m, _ := t.UTC().Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// MediaMinutes converts a media duration to the whole minutes charged for
+// it; every started minute counts.
+func MediaMinutes(seconds int) int {
+	if seconds <= 0 {
+		return 0
+	}
+	return (seconds + 59) / 60
+}
+
+// QuotaExceededError reports the limit a submission would have exceeded.
+type QuotaExceededError struct {
+	Limit     string
+	Max       int
+	Remaining int
+	// ResetsAt is when the limit's period ends; zero for concurrent jobs,
+	// which free up as jobs finish.
+	ResetsAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded: %s limit of %d", e.Limit, e.Max)
+}
+
+// Status is the HTTP status of the rejection: 402 once a period's quota is
+// spent, 429 while too many jobs are running.
+func (e *QuotaExceededError) Status() int {
+	if e.Limit == LimitConcurrentJobs {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusPaymentRequired
+}
+
+// CheckLength returns a download.too_long *engine.TranscriptionError when
+// media lasting seconds is longer than the quota allows.
+func (q Quota) CheckLength(seconds int) error {
+	return engine.CheckLength(seconds, q.MaxMediaSeconds)
+}
+
+// Check returns the first limit that admitting the given number of jobs
+// and media minutes would exceed, or nil if they fit. Each job takes one of
+// the concurrent jobs.
+func (q Quota) Check(usage QuotaUsage, jobs, minutes int) *QuotaExceededError {
+	return q.check(usage, jobs, jobs, minutes)
+}
+
+// CheckBatch returns the first limit that admitting a batch of jobs would
+// exceed, or nil if it fits. Every child counts towards the daily job
+// limit, but the batch as a whole takes a single concurrent job, so batches
+// larger than the concurrent limit are still admitted.
+func (q Quota) CheckBatch(usage QuotaUsage, jobs int) *QuotaExceededError {
+	return q.check(usage, 1, jobs, 0)
+}
+
+// check is Check for jobs that together take slots concurrent jobs.
+func (q Quota) check(usage QuotaUsage, slots, jobs, minutes int) *QuotaExceededError {
+	if q.ConcurrentJobs > 0 && usage.ActiveJobs+slots > q.ConcurrentJobs {
+		return &QuotaExceededError{
+			Limit:     LimitConcurrentJobs,
+			Max:       q.ConcurrentJobs,
+			Remaining: max(q.ConcurrentJobs-usage.ActiveJobs, 0),
+		}
+	}
+	if q.JobsPerDay > 0 && usage.JobsToday+jobs > q.JobsPerDay {
+		return &QuotaExceededError{
+			Limit:     LimitJobsPerDay,
+			Max:       q.JobsPerDay,
+			Remaining: max(q.JobsPerDay-usage.JobsToday, 0),
+			ResetsAt:  usage.DayResetsAt,
+		}
+	}
+	if q.MediaMinutesPerMonth > 0 && usage.MediaMinutesMonth+minutes > q.MediaMinutesPerMonth {
+		return &QuotaExceededError{
+			Limit:     LimitMediaMinutesPerMonth,
+			Max:       q.MediaMinutesPerMonth,
+			Remaining: max(q.MediaMinutesPerMonth-usage.MediaMinutesMonth, 0),
+			ResetsAt:  usage.MonthResetsAt,
+		}
+	}
+	return nil
+}
+
+// Remaining returns how much of each limited quota is left, keyed by limit
+// name. Unlimited quotas are left out.
+func (q Quota) Remaining(usage QuotaUsage) map[string]int {
+	remaining := make(map[string]int)
+	if q.JobsPerDay > 0 {
+		remaining[LimitJobsPerDay] = max(q.JobsPerDay-usage.JobsToday, 0)
+	}
+	if q.MediaMinutesPerMonth > 0 {
+		remaining[LimitMediaMinutesPerMonth] = max(q.MediaMinutesPerMonth-usage.MediaMinutesMonth, 0)
+	}
+	if q.ConcurrentJobs > 0 {
+		remaining[LimitConcurrentJobs] = max(q.ConcurrentJobs-usage.ActiveJobs, 0)
+	}
+	return remaining
+}
+
+// UsageResponse reports a key's quota and what it has used.
+type UsageResponse struct {
+	KeyID     string         `json:"key_id"`
+	Quota     Quota          `json:"quota"`
+	Usage     QuotaUsage     `json:"usage"`
+	Remaining map[string]int `json:"remaining"`
+}
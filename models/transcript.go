@@ -7,12 +7,19 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 type TranscribeRequest struct {
-	URL string `json:"url" validate:"required"`
+	URL      string   `json:"url" validate:"required"`
+	Priority Priority `json:"priority,omitempty"`
+
+	// WebhookURL receives the job's started, completed and failed events,
+	// with WebhookHeaders added and filtered by WebhookEvents.
+	WebhookURL     string            `json:"webhook_url,omitempty"`
+	WebhookHeaders map[string]string `json:"webhook_headers,omitempty"`
+	WebhookEvents  []string          `json:"webhook_events,omitempty"`
+
+	TranscriptionOptions
 }
 
 type TranscribeResponse struct {
@@ -21,28 +28,78 @@ type TranscribeResponse struct {
 	Segments   []Segment `json:"segments,omitempty"`
 }
 
-// JobStatus represents the status of a transcription job
-type JobStatus string
+// MaxBatchSize caps the number of child jobs a single batch may create.
+const MaxBatchSize = 500
 
-const (
-	StatusPending  JobStatus = "pending"
-	StatusRunning  JobStatus = "running"
-	StatusComplete JobStatus = "complete"
-	StatusError    JobStatus = "error"
-)
+// BatchRequest submits many URLs at once. Either URLs or PlaylistURL must be
+// set; a playlist or channel URL is expanded into one child job per entry.
+type BatchRequest struct {
+	URLs        []string `json:"urls,omitempty"`
+	PlaylistURL string   `json:"playlist_url,omitempty"`
+	Priority    Priority `json:"priority,omitempty"`
 
-// Job represents a transcription job
-type Job struct {
+	// WebhookURL receives the batch.completed event. Batches have no
+	// per-job webhooks, so WebhookHeaders and WebhookEvents are only read
+	// to reject them; see SetsJobWebhook.
+	WebhookURL     string            `json:"webhook_url,omitempty"`
+	WebhookHeaders map[string]string `json:"webhook_headers,omitempty"`
+	WebhookEvents  []string          `json:"webhook_events,omitempty"`
+
+	// TranscriptionOptions apply to every child job.
+	TranscriptionOptions
+
+	// IdempotencyKey is read from the Idempotency-Key header.
+	IdempotencyKey string `header:"Idempotency-Key" json:"-"`
+}
+
+// SetsJobWebhook reports whether the request sets the per-job webhook
+// fields, which batches reject rather than ignore.
+func (r *BatchRequest) SetsJobWebhook() bool {
+	return len(r.WebhookHeaders) > 0 || len(r.WebhookEvents) > 0
+}
+
+// BatchResponse identifies the batch and the child jobs it created.
+type BatchResponse struct {
+	BatchID string   `json:"batch_id"`
+	JobIDs  []string `json:"job_ids"`
+}
+
+// BatchStatusResponse reports the aggregate progress of a batch.
+type BatchStatusResponse struct {
+	ID          string       `json:"id"`
+	Status      string       `json:"status"`
+	SourceURL   string       `json:"source_url,omitempty"`
+	Total       int          `json:"total"`
+	Queued      int          `json:"queued"`
+	Running     int          `json:"running"`
+	Completed   int          `json:"completed"`
+	Failed      int          `json:"failed"`
+	Cancelled   int          `json:"cancelled"`
+	Progress    float64      `json:"progress"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+	Jobs        []JobSummary `json:"jobs,omitempty"`
+}
+
+// JobSummary is the compact representation of a job used in listings.
+type JobSummary struct {
 	ID          string     `json:"id"`
 	URL         string     `json:"url"`
 	Status      JobStatus  `json:"status"`
-	Transcript  string     `json:"transcript,omitempty"`
-	Segments    []Segment  `json:"segments,omitempty"`
+	Stage       string     `json:"stage,omitempty"`
 	Error       string     `json:"error,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 }
 
+// JobListResponse is one page of a job listing. NextCursor is empty on the
+// last page.
+type JobListResponse struct {
+	Jobs       []JobSummary `json:"jobs"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
 // Segment represents a timestamped segment of transcribed text
 type Segment struct {
 	Start float64 `json:"start"`
@@ -50,38 +107,6 @@ type Segment struct {
 	Text  string  `json:"text"`
 }
 
-// NewJob creates a new transcription job
-func NewJob(url string) *Job {
-	return &Job{
-		ID:        uuid.New().String(),
-		URL:       url,
-		Status:    StatusPending,
-		CreatedAt: time.Now(),
-	}
-}
-
-// MarkRunning marks the job as running
-func (j *Job) MarkRunning() {
-	j.Status = StatusRunning
-}
-
-// MarkComplete marks the job as complete with transcript and segments
-func (j *Job) MarkComplete(transcript string, segments []Segment) {
-	j.Status = StatusComplete
-	j.Transcript = transcript
-	j.Segments = segments
-	now := time.Now()
-	j.CompletedAt = &now
-}
-
-// MarkError marks the job as failed with an error
-func (j *Job) MarkError(err error) {
-	j.Status = StatusError
-	j.Error = err.Error()
-	now := time.Now()
-	j.CompletedAt = &now
-}
-
 func ValidateURL(url string) bool {
 	// OmniTranscripts supports 1000+ platforms via yt-dlp
 	// Accept any valid HTTP/HTTPS URL with a host
@@ -1,6 +1,14 @@
 package models
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"omnitranscripts/engine"
+)
 
 func TestValidateURL(t *testing.T) {
 	tests := []struct {
@@ -44,3 +52,229 @@ func TestValidateURL(t *testing.T) {
 		})
 	}
 }
+
+func TestTranscriptionOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   TranscriptionOptions
+		fields []string
+	}{
+		{"Empty", TranscriptionOptions{}, nil},
+		{"All valid", TranscriptionOptions{
+			Language:       "pt",
+			Task:           TaskTranslate,
+			Backend:        BackendWhisper,
+			Model:          "small.en",
+			OutputFormats:  []string{"srt", "json"},
+			TimeRange:      &TimeRange{Start: 10, End: 70},
+			PostProcessing: &PostProcessing{RemoveFillers: true},
+			Metadata:       map[string]string{"customer": "42"},
+		}, nil},
+		{"Auto language", TranscriptionOptions{Language: "auto"}, nil},
+		{"Bad language", TranscriptionOptions{Language: "English"}, []string{"language"}},
+		{"Region tag", TranscriptionOptions{Language: "pt-BR"}, []string{"language"}},
+		{"Bad task", TranscriptionOptions{Task: "summarize"}, []string{"task"}},
+		{"Bad backend", TranscriptionOptions{Backend: "openai"}, []string{"backend"}},
+		{"Model path", TranscriptionOptions{Model: "../secret"}, []string{"model"}},
+		{"Model on AssemblyAI", TranscriptionOptions{Backend: BackendAssemblyAI, Model: "base"}, []string{"model"}},
+		{"Whisper options on server", TranscriptionOptions{Backend: BackendWhisperServer, Language: "de", Task: TaskTranslate, Model: "base"}, []string{"language", "task", "model"}},
+		{"Transcribe on AssemblyAI", TranscriptionOptions{Backend: BackendAssemblyAI, Task: TaskTranscribe}, nil},
+		{"Formats", TranscriptionOptions{OutputFormats: []string{"srt", "pdf", "srt"}}, []string{"output_formats[1]", "output_formats[2]"}},
+		{"Time range", TranscriptionOptions{TimeRange: &TimeRange{Start: -1, End: -2}}, []string{"time_range.start", "time_range.end"}},
+		{"Metadata value", TranscriptionOptions{Metadata: map[string]string{"note": string(make([]byte, MaxMetadataValueLength+1))}}, []string{"metadata.note"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, problem := range tt.opts.Validate() {
+				fields = append(fields, problem.Field)
+			}
+			if len(fields) != len(tt.fields) {
+				t.Fatalf("Validate() fields = %v, want %v", fields, tt.fields)
+			}
+			for i := range fields {
+				if fields[i] != tt.fields[i] {
+					t.Errorf("Validate() fields = %v, want %v", fields, tt.fields)
+				}
+			}
+		})
+	}
+}
+
+func TestNewProblem(t *testing.T) {
+	unavailable := engine.NewError(engine.StageDownload, "failed to download audio",
+		errors.New("yt-dlp failed with code 1: ERROR: Video unavailable"))
+	wrapped := fmt.Errorf("processing job: %w", unavailable)
+
+	p := NewProblem(wrapped)
+	if p.Code != engine.CodeDownloadUnavailable || p.Stage != "download" {
+		t.Fatalf("code, stage = %q, %q, want %q, download", p.Code, p.Stage, engine.CodeDownloadUnavailable)
+	}
+	if p.Retryable || p.Status != 422 || p.Type != ProblemTypePrefix+engine.CodeDownloadUnavailable {
+		t.Errorf("unexpected problem %+v", p)
+	}
+	if p.Detail != "failed to download audio" {
+		t.Errorf("detail = %q, want the error's message without its cause", p.Detail)
+	}
+
+	timeout := NewProblem(engine.NewError(engine.StageTranscribe, "failed to transcribe audio", context.DeadlineExceeded))
+	if timeout.Code != engine.CodeTranscribeBackendTimeout || !timeout.Retryable || timeout.Status != 504 {
+		t.Errorf("unexpected timeout problem %+v", timeout)
+	}
+
+	internal := NewProblem(errors.New("boom"))
+	if internal.Code != CodeInternal || internal.Stage != "" || internal.Status != 500 || internal.Detail != "Internal error" {
+		t.Errorf("unexpected internal problem %+v", internal)
+	}
+}
+
+func TestJobRequeue(t *testing.T) {
+	job := NewJob("https://example.com/video")
+	if err := job.Requeue(); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("requeueing a queued job: err = %v, want ErrInvalidTransition", err)
+	}
+
+	job.Start()
+	job.Fail(fmt.Errorf("%w: stuck", ErrFailedByOperator))
+	if job.Problem.Code != CodeJobFailedByOperator {
+		t.Fatalf("problem code = %q, want %q", job.Problem.Code, CodeJobFailedByOperator)
+	}
+	if err := job.Requeue(); err != nil {
+		t.Fatalf("requeueing a failed job: %v", err)
+	}
+	if job.Status != StatusQueued || job.Error != "" || job.Problem != nil || job.StartedAt != nil || job.CompletedAt != nil {
+		t.Errorf("requeued job kept its last run: %+v", job)
+	}
+	if err := job.Start(); err != nil {
+		t.Errorf("starting a requeued job: %v", err)
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		8:  64 * time.Minute,
+		9:  2 * time.Hour,
+		11: 2 * time.Hour,
+	} {
+		if got := WebhookBackoff(attempts); got != want {
+			t.Errorf("WebhookBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+
+	delivery := NewWebhookDelivery("acme", "job.completed", "https://example.com/hook", nil, []byte(`{}`))
+	if err := delivery.Replay(time.Now()); !errors.Is(err, ErrDeliveryPending) {
+		t.Fatalf("replaying a pending delivery: err = %v, want ErrDeliveryPending", err)
+	}
+
+	now := time.Now()
+	for i := 1; i < WebhookMaxAttempts; i++ {
+		delivery.Failed(500, "server error", now)
+		if delivery.Status != DeliveryPending || delivery.NextAttemptAt == nil {
+			t.Fatalf("after %d failed attempts: status %s, next attempt %v", i, delivery.Status, delivery.NextAttemptAt)
+		}
+	}
+	delivery.Failed(0, "connection refused", now)
+	if delivery.Status != DeliveryDead || delivery.NextAttemptAt != nil {
+		t.Fatalf("after %d failed attempts: status %s, want dead", WebhookMaxAttempts, delivery.Status)
+	}
+
+	if err := delivery.Replay(now); err != nil {
+		t.Fatalf("replaying a dead delivery: %v", err)
+	}
+	if delivery.Status != DeliveryPending || delivery.Attempts != 0 {
+		t.Errorf("replayed delivery: status %s, %d attempts", delivery.Status, delivery.Attempts)
+	}
+	delivery.Succeeded(204, now)
+	if delivery.Status != DeliveryDelivered || delivery.DeliveredAt == nil || delivery.LastError != "" {
+		t.Errorf("delivered delivery: %+v", delivery)
+	}
+}
+
+func TestQuotaCheck(t *testing.T) {
+	quota := Quota{JobsPerDay: 5, MediaMinutesPerMonth: 60, ConcurrentJobs: 2}
+	usage := QuotaUsage{JobsToday: 4, MediaMinutesMonth: 50, ActiveJobs: 1}
+
+	if err := quota.Check(usage, 1, 10); err != nil {
+		t.Fatalf("a submission that uses up the quota exactly must fit, got %v", err)
+	}
+	if err := quota.Check(usage, 2, 0); err == nil || err.Limit != LimitConcurrentJobs || err.Status() != 429 {
+		t.Errorf("two more jobs exceed the concurrent limit, got %+v", err)
+	}
+	if err := quota.Check(usage, 1, 11); err == nil || err.Limit != LimitMediaMinutesPerMonth || err.Status() != 402 || err.Remaining != 10 {
+		t.Errorf("11 minutes exceed the monthly media limit, got %+v", err)
+	}
+	if err := (Quota{}).Check(usage, 100, 1000); err != nil {
+		t.Errorf("a zero quota is unlimited, got %v", err)
+	}
+
+	if got := MediaMinutes(61); got != 2 {
+		t.Errorf("MediaMinutes(61) = %d, want 2", got)
+	}
+	if p := NewProblem(fmt.Errorf("admitting job: %w", &QuotaExceededError{Limit: LimitMediaMinutesPerMonth})); p.Code != CodeQuotaExceeded || p.Status != 402 {
+		t.Errorf("unexpected quota problem %+v", p)
+	}
+}
+
+func TestQuotaCheckLength(t *testing.T) {
+	quota := Quota{MaxMediaSeconds: 1800}
+
+	if err := quota.CheckLength(1800); err != nil {
+		t.Fatalf("media exactly at the limit must fit, got %v", err)
+	}
+	err := quota.CheckLength(1801)
+	if err == nil {
+		t.Fatal("media over the limit must be rejected")
+	}
+	if p := NewProblem(err); p.Code != engine.CodeDownloadTooLong || p.Status != 422 || p.Retryable {
+		t.Errorf("unexpected too long problem %+v", p)
+	}
+	if err := (Quota{}).CheckLength(100000); err != nil {
+		t.Errorf("a zero limit allows any length, got %v", err)
+	}
+}
+
+func TestParseUsagePeriod(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 4, 5, 0, time.UTC)
+
+	from, to, err := ParseUsagePeriod("", "", now)
+	if err != nil || from.Format(DateLayout) != "2026-09-18" || to.Format(DateLayout) != "2026-10-18" {
+		t.Errorf("default period = %v to %v, %v", from, to, err)
+	}
+	if _, _, err := ParseUsagePeriod("2026-10-01", "2026-10-01", now); err != nil {
+		t.Errorf("a single day is a valid period, got %v", err)
+	}
+	for _, period := range [][2]string{{"2026-10-02", "2026-10-01"}, {"2025-01-01", "2026-10-01"}, {"10/01/2026", ""}} {
+		if _, _, err := ParseUsagePeriod(period[0], period[1], now); !errors.Is(err, ErrInvalidUsagePeriod) {
+			t.Errorf("ParseUsagePeriod(%q, %q) = %v, want ErrInvalidUsagePeriod", period[0], period[1], err)
+		}
+	}
+
+	job := &Job{Segments: []Segment{{Start: 0, End: 10}, {Start: 10, End: 61.5}}}
+	if got := job.BillableSeconds(); got != 62 {
+		t.Errorf("BillableSeconds() without a measured length = %d, want the last segment's end", got)
+	}
+	job.MediaSeconds = 75
+	if got := job.BillableSeconds(); got != 75 {
+		t.Errorf("BillableSeconds() = %d, want the measured 75", got)
+	}
+
+	job.Options.TimeRange = &TimeRange{Start: 10, End: 40}
+	if got := job.BillableSeconds(); got != 30 {
+		t.Errorf("BillableSeconds() of 10s to 40s = %d, want 30", got)
+	}
+	job.Options.TimeRange = &TimeRange{Start: 60, End: 600}
+	if got := job.BillableSeconds(); got != 15 {
+		t.Errorf("BillableSeconds() of 60s to 600s of 75s = %d, want 15", got)
+	}
+	job.Options.TimeRange = &TimeRange{Start: 20}
+	if got := job.BillableSeconds(); got != 55 {
+		t.Errorf("BillableSeconds() from 20s = %d, want 55", got)
+	}
+	job.Options.TimeRange = &TimeRange{Start: 90, End: 120}
+	if got := job.BillableSeconds(); got != 0 {
+		t.Errorf("BillableSeconds() of a range past the end = %d, want 0", got)
+	}
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// DateLayout is the format of the dates in usage reports.
+const DateLayout = "2006-01-02"
+
+// MaxUsageReportDays is the longest period one usage report may cover.
+const MaxUsageReportDays = 366
+
+// APICall is one metered API request.
+type APICall struct {
+	Endpoint      string
+	Method        string
+	APIKeyID      string
+	Owner         string
+	Status        int
+	Latency       time.Duration
+	RequestBytes  int
+	ResponseBytes int
+	UserAgent     string
+	IP            string
+	At            time.Time
+}
+
+// BillableJob is the media a completed job is billed for.
+type BillableJob struct {
+	JobID        string
+	APIKeyID     string
+	Owner        string
+	MediaSeconds int
+	At           time.Time
+}
+
+// BillableSeconds is the length of media the job transcribed: its
+// options' time range, clamped to the media, or else the whole media.
+func (j *Job) BillableSeconds() int {
+	length := j.mediaLength()
+	r := j.Options.TimeRange
+	if r == nil {
+		return int(math.Ceil(length))
+	}
+	end := length
+	if r.End != 0 && r.End < end {
+		end = r.End
+	}
+	if end <= r.Start {
+		return 0
+	}
+	return int(math.Ceil(end - r.Start))
+}
+
+// mediaLength is the duration of the job's media measured before it ran
+// or, failing that, the end of its last segment.
+func (j *Job) mediaLength() float64 {
+	if j.MediaSeconds > 0 {
+		return float64(j.MediaSeconds)
+	}
+	if n := len(j.Segments); n > 0 {
+		return j.Segments[n-1].End
+	}
+	return 0
+}
+
+// UsageTotals sums a key's metered usage.
+type UsageTotals struct {
+	Requests        int   `json:"requests"`
+	Errors          int   `json:"errors"`
+	RequestBytes    int64 `json:"request_bytes"`
+	ResponseBytes   int64 `json:"response_bytes"`
+	BillableJobs    int   `json:"billable_jobs"`
+	BillableSeconds int64 `json:"billable_seconds"`
+}
+
+// AddCall counts call; responses of 400 and above count as errors.
+func (t *UsageTotals) AddCall(call APICall) {
+	t.Requests++
+	if call.Status >= 400 {
+		t.Errors++
+	}
+	t.RequestBytes += int64(call.RequestBytes)
+	t.ResponseBytes += int64(call.ResponseBytes)
+}
+
+// AddJob counts a billable job.
+func (t *UsageTotals) AddJob(job BillableJob) {
+	t.BillableJobs++
+	t.BillableSeconds += int64(job.MediaSeconds)
+}
+
+// Add adds other to t.
+func (t *UsageTotals) Add(other UsageTotals) {
+	t.Requests += other.Requests
+	t.Errors += other.Errors
+	t.RequestBytes += other.RequestBytes
+	t.ResponseBytes += other.ResponseBytes
+	t.BillableJobs += other.BillableJobs
+	t.BillableSeconds += other.BillableSeconds
+}
+
+// UsageDay is a key's usage on one UTC day.
+type UsageDay struct {
+	Date string `json:"date"`
+	UsageTotals
+}
+
+// UsageReport is a key's metered usage per UTC day, for billing. Days
+// without usage are left out.
+type UsageReport struct {
+	KeyID string      `json:"key_id"`
+	From  string      `json:"from"`
+	To    string      `json:"to"`
+	Days  []UsageDay  `json:"days"`
+	Total UsageTotals `json:"total"`
+}
+
+// NewUsageReport builds the report of keyID's usage from from to to,
+// inclusive, out of its totals keyed by date.
+func NewUsageReport(keyID string, from, to time.Time, days map[string]UsageTotals) *UsageReport {
+	report := &UsageReport{
+		KeyID: keyID,
+		From:  from.Format(DateLayout),
+		To:    to.Format(DateLayout),
+		Days:  make([]UsageDay, 0, len(days)),
+	}
+	for date, totals := range days {
+		report.Days = append(report.Days, UsageDay{Date: date, UsageTotals: totals})
+		report.Total.Add(totals)
+	}
+	sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Date < report.Days[j].Date })
+	return report
+}
+
+// ErrInvalidUsagePeriod is returned by ParseUsagePeriod for malformed or
+// overlong periods.
+var ErrInvalidUsagePeriod = errors.New("invalid usage period")
+
+// ParseUsagePeriod reads the from and to dates of a usage report, as
+// YYYY-MM-DD, both inclusive. to defaults to today and from to 30 days
+// before to. It returns the UTC start of each day.
+func ParseUsagePeriod(from, to string, now time.Time) (time.Time, time.Time, error) {
+	end := DayStart(now)
+	if to != "" {
+		t, err := time.Parse(DateLayout, to)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidUsagePeriod
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -30)
+	if from != "" {
+		t, err := time.Parse(DateLayout, from)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidUsagePeriod
+		}
+		start = t
+	}
+	if start.After(end) || end.Sub(start) >= MaxUsageReportDays*24*time.Hour {
+		return time.Time{}, time.Time{}, ErrInvalidUsagePeriod
+	}
+	return start, end, nil
+}
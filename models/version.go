@@ -0,0 +1,29 @@
+package models
+
+// APIVersions lists the API versions served, oldest first. Each is mounted
+// under /<version>, such as /v1/transcribe.
+var APIVersions = []string{"v1"}
+
+const (
+	// CurrentAPIVersion is the newest API version. Links the API stores or
+	// hands out, such as transcript URLs, point at it.
+	CurrentAPIVersion = "v1"
+	// LegacyAPIVersion is the version served at the deprecated bare paths,
+	// such as /transcribe, unless the client asks for another.
+	LegacyAPIVersion = "v1"
+)
+
+// ValidAPIVersion reports whether version is one of APIVersions.
+func ValidAPIVersion(version string) bool {
+	for _, v := range APIVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// JobPath returns the path of a job's status in the current API version.
+func JobPath(jobID string) string {
+	return "/" + CurrentAPIVersion + "/transcribe/" + jobID
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeliveryStatus is where a webhook delivery is in the outbox.
+type DeliveryStatus string
+
+// A delivery is pending until its endpoint accepts it, or until it runs out
+// of attempts and is dead-lettered.
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+// Valid reports whether s is a known delivery status.
+func (s DeliveryStatus) Valid() bool {
+	switch s {
+	case DeliveryPending, DeliveryDelivered, DeliveryDead:
+		return true
+	}
+	return false
+}
+
+const (
+	// WebhookMaxAttempts is how many times a delivery is attempted before
+	// it is dead-lettered, about eight hours after the first attempt.
+	WebhookMaxAttempts = 12
+	// WebhookDeliveryRetention is how long delivered and dead-lettered
+	// webhooks are kept.
+	WebhookDeliveryRetention = 7 * 24 * time.Hour
+	// DefaultDeliveryListLimit and MaxDeliveryListLimit bound the
+	// deliveries returned by one listing.
+	DefaultDeliveryListLimit = 50
+	MaxDeliveryListLimit     = 500
+
+	webhookFirstRetry = 30 * time.Second
+	webhookMaxRetry   = 2 * time.Hour
+)
+
+// ErrDeliveryPending is returned when replaying a delivery that is still
+// being attempted.
+var ErrDeliveryPending = errors.New("webhook delivery is still pending")
+
+// WebhookDelivery is one webhook event in the outbox, with the request that
+// delivers it and the outcome of every attempt so far.
+type WebhookDelivery struct {
+	ID      string            `json:"id"`
+	Owner   string            `json:"owner"`
+	Event   string            `json:"event"`
+	JobID   string            `json:"job_id,omitempty"`
+	BatchID string            `json:"batch_id,omitempty"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Payload json.RawMessage   `json:"payload"`
+
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	LastStatusCode int            `json:"last_status_code,omitempty"`
+	LastError      string         `json:"last_error,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	NextAttemptAt  *time.Time     `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time     `json:"delivered_at,omitempty"`
+}
+
+// NewWebhookDelivery returns a pending delivery of payload to url, due now.
+func NewWebhookDelivery(owner, event, url string, headers map[string]string, payload []byte) *WebhookDelivery {
+	now := time.Now().UTC()
+	return &WebhookDelivery{
+		ID:            uuid.New().String(),
+		Owner:         owner,
+		Event:         event,
+		URL:           url,
+		Headers:       headers,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		NextAttemptAt: &now,
+	}
+}
+
+// WebhookBackoff is the wait before the next attempt after attempts failed
+// ones: 30 seconds, doubling up to two hours.
+func WebhookBackoff(attempts int) time.Duration {
+	wait := webhookFirstRetry
+	for i := 1; i < attempts && wait < webhookMaxRetry; i++ {
+		wait *= 2
+	}
+	return min(wait, webhookMaxRetry)
+}
+
+// Succeeded records an attempt the endpoint accepted with statusCode.
+func (d *WebhookDelivery) Succeeded(statusCode int, now time.Time) {
+	d.Attempts++
+	d.Status = DeliveryDelivered
+	d.LastStatusCode = statusCode
+	d.LastError = ""
+	d.UpdatedAt = now
+	d.NextAttemptAt = nil
+	d.DeliveredAt = &now
+}
+
+// Failed records a failed attempt, with the endpoint's statusCode if it
+// responded, and schedules the next one. After WebhookMaxAttempts the
+// delivery is dead-lettered instead.
+func (d *WebhookDelivery) Failed(statusCode int, message string, now time.Time) {
+	d.Attempts++
+	d.LastStatusCode = statusCode
+	d.LastError = message
+	d.UpdatedAt = now
+	if d.Attempts >= WebhookMaxAttempts {
+		d.Status = DeliveryDead
+		d.NextAttemptAt = nil
+		return
+	}
+	next := now.Add(WebhookBackoff(d.Attempts))
+	d.NextAttemptAt = &next
+}
+
+// Replay makes a delivered or dead delivery pending again, due now, with a
+// fresh set of attempts.
+func (d *WebhookDelivery) Replay(now time.Time) error {
+	if d.Status == DeliveryPending {
+		return ErrDeliveryPending
+	}
+	d.Status = DeliveryPending
+	d.Attempts = 0
+	d.UpdatedAt = now
+	d.NextAttemptAt = &now
+	d.DeliveredAt = nil
+	return nil
+}
+
+// Expired reports whether the delivery was delivered or dead-lettered
+// before cutoff, and so is past its retention.
+func (d *WebhookDelivery) Expired(cutoff time.Time) bool {
+	switch d.Status {
+	case DeliveryDelivered:
+		return d.DeliveredAt != nil && d.DeliveredAt.Before(cutoff)
+	case DeliveryDead:
+		return d.UpdatedAt.Before(cutoff)
+	}
+	return false
+}
+
+// WebhookDeliveryFilter selects deliveries to list.
+type WebhookDeliveryFilter struct {
+	Owner  string
+	Status DeliveryStatus
+	Limit  int
+}
+
+// WebhookDeliveryList is a listing of deliveries, newest first.
+type WebhookDeliveryList struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
@@ -0,0 +1,246 @@
+package openapi
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Info is the document's info object.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Route describes one API route. Paths use Fiber's syntax, such as
+// /transcribe/:job_id; they are converted to OpenAPI templates.
+type Route struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Description string
+	Tags        []string
+	// Public routes need no API key.
+	Public bool
+	// Scope is the API key scope the route requires, if any. It is added to
+	// the description, along with a 403 response.
+	Scope string
+	// Query lists the query parameters.
+	Query []Parameter
+	// Headers lists request headers the route reads.
+	Headers []Parameter
+	// Request is a value of the JSON body type, or nil for no JSON body.
+	Request interface{}
+	// RequestContent describes non-JSON bodies, keyed by media type.
+	RequestContent map[string]*Schema
+	Responses      []Response
+}
+
+// Response is one documented response of a route.
+type Response struct {
+	Status      int
+	Description string
+	// Body is a value of the response type, or nil for no body.
+	Body interface{}
+	// ContentType defaults to application/json.
+	ContentType string
+	// Schema overrides Body for non-JSON responses.
+	Schema *Schema
+}
+
+// Parameter is a path, query or header parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Document is an OpenAPI 3 document.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+	Security   []map[string][]string            `json:"security"`
+
+	bodies map[string]*Schema
+}
+
+// Components holds the shared schemas and security schemes.
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+// SecurityScheme is an OpenAPI security scheme.
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+// Operation is one method on a path.
+type Operation struct {
+	OperationID string                  `json:"operationId,omitempty"`
+	Summary     string                  `json:"summary,omitempty"`
+	Description string                  `json:"description,omitempty"`
+	Tags        []string                `json:"tags,omitempty"`
+	Parameters  []Parameter             `json:"parameters,omitempty"`
+	RequestBody *RequestBody            `json:"requestBody,omitempty"`
+	Responses   map[string]ResponseSpec `json:"responses"`
+	Security    *[]map[string][]string  `json:"security,omitempty"`
+}
+
+// RequestBody is an operation's request body.
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// ResponseSpec is a response in the document.
+type ResponseSpec struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType wraps the schema of one content type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// securitySchemeName is the bearer API key scheme every non-public route uses.
+const securitySchemeName = "bearerAuth"
+
+var fiberParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// PathTemplate converts a Fiber route path to an OpenAPI path template.
+func PathTemplate(path string) string {
+	return fiberParam.ReplaceAllString(path, "{$1}")
+}
+
+// Build generates the document for routes, taking schemas from g.
+func Build(info Info, g *Generator, routes []Route) *Document {
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]map[string]*Operation),
+		Security: []map[string][]string{
+			{securitySchemeName: {}},
+		},
+		bodies: make(map[string]*Schema),
+	}
+
+	for _, route := range routes {
+		path := PathTemplate(route.Path)
+		op := &Operation{
+			OperationID: route.OperationID,
+			Summary:     route.Summary,
+			Description: route.Description,
+			Tags:        route.Tags,
+			Responses:   make(map[string]ResponseSpec),
+		}
+
+		for _, match := range fiberParam.FindAllStringSubmatch(route.Path, -1) {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+		op.Parameters = append(op.Parameters, route.Query...)
+		op.Parameters = append(op.Parameters, route.Headers...)
+
+		content := make(map[string]MediaType)
+		if route.Request != nil {
+			schema := g.SchemaOf(route.Request)
+			content["application/json"] = MediaType{Schema: schema}
+			doc.bodies[operationKey(route.Method, path)] = schema
+		}
+		for mediaType, schema := range route.RequestContent {
+			content[mediaType] = MediaType{Schema: schema}
+		}
+		if len(content) > 0 {
+			op.RequestBody = &RequestBody{Required: true, Content: content}
+		}
+
+		for _, response := range route.Responses {
+			spec := ResponseSpec{Description: response.Description}
+			schema := response.Schema
+			if schema == nil && response.Body != nil {
+				schema = g.SchemaOf(response.Body)
+			}
+			if schema != nil {
+				contentType := response.ContentType
+				if contentType == "" {
+					contentType = "application/json"
+				}
+				spec.Content = map[string]MediaType{contentType: {Schema: schema}}
+			}
+			op.Responses[strconv.Itoa(response.Status)] = spec
+		}
+
+		if route.Scope != "" {
+			requirement := "Requires an API key with the " + route.Scope + " scope."
+			if op.Description == "" {
+				op.Description = requirement
+			} else {
+				op.Description += " " + requirement
+			}
+			if _, ok := op.Responses["403"]; !ok {
+				op.Responses["403"] = ResponseSpec{Description: "API key lacks the " + route.Scope + " scope"}
+			}
+		}
+
+		if route.Public {
+			op.Security = &[]map[string][]string{}
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*Operation)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	doc.Components = Components{
+		Schemas: g.Components(),
+		SecuritySchemes: map[string]SecurityScheme{
+			securitySchemeName: {Type: "http", Scheme: "bearer"},
+		},
+	}
+	return doc
+}
+
+func operationKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
+
+// Operations lists every documented operation as "METHOD /path", sorted.
+func (d *Document) Operations() []string {
+	var ops []string
+	for path, methods := range d.Paths {
+		for method := range methods {
+			ops = append(ops, operationKey(method, path))
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// RequestSchema returns the JSON body schema of the route with the given
+// method and Fiber path, if it takes one.
+func (d *Document) RequestSchema(method, path string) *Schema {
+	return d.bodies[operationKey(method, PathTemplate(path))]
+}
+
+// resolve follows a $ref to its component schema.
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/models"
+)
+
+// Validate checks a decoded JSON value against schema and returns every
+// mismatch. Properties the schema does not mention are allowed.
+func (d *Document) Validate(value interface{}, schema *Schema) []models.FieldError {
+	var problems []models.FieldError
+	d.validate("", value, schema, &problems)
+	return problems
+}
+
+func (d *Document) validate(field string, value interface{}, schema *Schema, problems *[]models.FieldError) {
+	schema = d.resolve(schema)
+	if schema == nil {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		name := field
+		if name == "" {
+			name = "body"
+		}
+		*problems = append(*problems, models.FieldError{Field: name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if value == nil {
+		if !schema.Nullable && schema.Type != "" {
+			fail("must not be null")
+		}
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, name := range schema.Required {
+			if _, present := object[name]; !present {
+				*problems = append(*problems, models.FieldError{Field: join(field, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, known := schema.Properties[name]; known {
+				d.validate(join(field, name), object[name], property, problems)
+			} else if schema.AdditionalProperties != nil {
+				d.validate(join(field, name), object[name], schema.AdditionalProperties, problems)
+			}
+		}
+
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		for i, item := range items {
+			d.validate(fmt.Sprintf("%s[%d]", field, i), item, schema.Items, problems)
+		}
+
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, s) {
+			fail("must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+
+	case "integer":
+		n, ok := value.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			fail("must be an integer")
+		}
+
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			fail("must be a number")
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+		}
+	}
+}
+
+func join(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRequest is Fiber middleware that checks JSON request bodies
+// against the document. It must be attached to the route itself so the
+// matched route can be looked up. Bodies that are not JSON, or not valid
+// JSON, are left to the handler to reject.
+func (d *Document) ValidateRequest(c *fiber.Ctx) error {
+	schema := d.RequestSchema(c.Method(), c.Route().Path)
+	if schema == nil || !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		return c.Next()
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return c.Next()
+	}
+
+	if problems := d.Validate(body, schema); len(problems) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid request body",
+			"fields": problems,
+		})
+	}
+	return c.Next()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"omnitranscripts/config"
+	"omnitranscripts/handlers"
+	"omnitranscripts/lib"
+)
+
+// registerRoutes mounts every API route on app, authenticating with auth.
+// handlers.Routes documents them; routes_test.go fails when the two disagree.
+func registerRoutes(app *fiber.App, cfg *config.Config, auth *lib.Authenticator) {
+	// Unversioned routes, registered ahead of the legacy aliases.
+	app.Get("/health", handlers.Health)
+	app.Get("/openapi.json", handlers.ServeOpenAPI)
+	if cfg.SwaggerUI {
+		app.Get("/docs", handlers.SwaggerUI)
+	}
+
+	// Any other bare path is a deprecated alias of a versioned one.
+	app.Use(lib.LegacyRoutes(cfg.LegacyDeprecatedAt, cfg.LegacySunset))
+
+	registerV1(app.Group("/v1", lib.VersionMiddleware("v1"), handlers.MeterRequests), cfg, auth)
+}
+
+func registerV1(v1 fiber.Router, cfg *config.Config, auth *lib.Authenticator) {
+	read := lib.RequireScope(lib.ScopeRead)
+	submit := lib.RequireScope(lib.ScopeSubmit)
+
+	limits := lib.DefaultRateLimits(cfg)
+	buckets := lib.NewMemoryRateLimitStore()
+	submitLimit := lib.RateLimitMiddleware(buckets, limits, lib.RateLimitSubmit)
+	pollLimit := lib.RateLimitMiddleware(buckets, limits, lib.RateLimitPoll)
+	downloadLimit := lib.RateLimitMiddleware(buckets, limits, lib.RateLimitDownload)
+
+	// The WebSocket authenticates its handshake itself, so it and the signed
+	// download route are registered ahead of the API group, whose middleware applies to every path below
+	// "/v1".
+	v1.Get("/ws", handlers.RequireWebSocket, lib.HandshakeAuthMiddleware(auth), read, pollLimit, handlers.JobEventsSocket)
+
+	// Signed download URLs carry their own authorization.
+	v1.Get("/artifacts/:job_id/transcript.:format", handlers.DownloadArtifact)
+
+	idempotent := lib.IdempotencyMiddleware(lib.NewIdempotencyStore(cfg.IdempotencyWindow))
+
+	api := v1.Group("/", lib.AuthMiddleware(auth))
+	// The upload route streams its body to disk, so it is registered ahead
+	// of the body limit that applies to every route after it.
+	api.Post("/transcribe/upload", submit, submitLimit, handlers.PostTranscribeUpload)
+	api.Use(lib.LimitRequestBody(cfg.MaxRequestBytes))
+	api.Get("/transcribe", read, pollLimit, handlers.ListTranscribeJobs)
+	api.Post("/transcribe", submit, submitLimit, handlers.ValidateRequest, idempotent, handlers.PostTranscribe)
+	api.Post("/transcribe/batch", submit, submitLimit, handlers.ValidateRequest, idempotent, handlers.PostTranscribeBatch)
+	api.Get("/transcribe/:job_id", read, pollLimit, handlers.GetTranscribeJob)
+	api.Post("/transcribe/:job_id/cancel", submit, submitLimit, handlers.CancelTranscribeJob)
+	api.Get("/transcribe/:job_id/events", read, pollLimit, handlers.StreamJobEvents)
+	api.Get("/transcribe/:job_id/transcript.:format", read, downloadLimit, handlers.DownloadTranscript)
+	api.Get("/batches/:id", read, pollLimit, handlers.GetBatch)
+	api.Get("/usage", read, pollLimit, handlers.GetUsage)
+	api.Get("/usage/report", read, pollLimit, handlers.GetUsageReport)
+	api.Get("/webhooks/deliveries", read, pollLimit, handlers.ListWebhookDeliveries)
+	api.Post("/webhooks/deliveries/:delivery_id/replay", submit, submitLimit, handlers.ReplayWebhookDelivery)
+
+	keys := handlers.NewKeyAdmin(auth.Store())
+	admin := api.Group("/admin", lib.RequireScope(lib.ScopeAdmin))
+	admin.Get("/keys", keys.List)
+	admin.Post("/keys", keys.Create)
+	admin.Get("/keys/:key_id", keys.Get)
+	admin.Put("/keys/:key_id/limits", keys.SetLimits)
+	admin.Post("/keys/:key_id/rotate", keys.Rotate)
+	admin.Post("/keys/:key_id/revoke", keys.Revoke)
+	admin.Get("/jobs/:job_id", handlers.InspectJob)
+	admin.Post("/jobs/:job_id/bump", handlers.BumpJob)
+	admin.Post("/jobs/:job_id/fail", handlers.FailJob)
+	admin.Post("/jobs/:job_id/requeue", handlers.RequeueJob)
+	admin.Get("/workers", handlers.GetWorkers)
+	admin.Post("/workers/drain", handlers.DrainWorkers)
+	admin.Post("/workers/resume", handlers.ResumeWorkers)
+	admin.Get("/audit", handlers.GetAuditLog)
+}
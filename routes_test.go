@@ -0,0 +1,658 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"omnitranscripts/config"
+	"omnitranscripts/handlers"
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+	"omnitranscripts/openapi"
+)
+
+func TestRoutesMatchOpenAPIDocument(t *testing.T) {
+	app := fiber.New()
+	store, err := lib.NewFileKeyStore("")
+	require.NoError(t, err)
+	registerRoutes(app, &config.Config{}, lib.NewAuthenticator(store))
+
+	documented := map[string]bool{
+		fiber.MethodGet:    true,
+		fiber.MethodPost:   true,
+		fiber.MethodPut:    true,
+		fiber.MethodPatch:  true,
+		fiber.MethodDelete: true,
+	}
+	var registered []string
+	seen := make(map[string]bool)
+	for _, route := range app.GetRoutes(true) {
+		op := route.Method + " " + openapi.PathTemplate(route.Path)
+		if documented[route.Method] && !seen[op] {
+			seen[op] = true
+			registered = append(registered, op)
+		}
+	}
+	sort.Strings(registered)
+
+	assert.Equal(t, handlers.APIDocument().Operations(), registered,
+		"routes registered in routes.go and handlers.Routes have diverged")
+}
+
+func TestBarePathsAreDeprecatedAliases(t *testing.T) {
+	jobs.Initialize()
+
+	sunset := time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+	deprecatedAt := time.Date(2026, time.November, 2, 0, 0, 0, 0, time.UTC)
+	cfg := &config.Config{APIKey: "test-key", LegacyDeprecatedAt: deprecatedAt, LegacySunset: sunset}
+	app := fiber.New()
+	store, err := lib.NewFileKeyStore("")
+	require.NoError(t, err)
+	registerRoutes(app, cfg, lib.NewAuthenticator(store, lib.ConfiguredKeys(cfg)...))
+
+	get := func(path string, header http.Header) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header = header
+		req.Header.Set("Authorization", "Bearer test-key")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := get("/v1/transcribe", http.Header{})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "v1", resp.Header.Get("API-Version"))
+	assert.Empty(t, resp.Header.Get("Deprecation"))
+
+	resp = get("/transcribe", http.Header{})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "v1", resp.Header.Get("API-Version"))
+	assert.Equal(t, "@1793577600", resp.Header.Get("Deprecation"), "the configured deprecation date")
+	assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", resp.Header.Get("Sunset"))
+	assert.Equal(t, `</v1/transcribe>; rel="successor-version"`, resp.Header.Get("Link"))
+
+	resp = get("/transcribe", http.Header{"Api-Version": {"v9"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = get("/health", http.Header{})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Deprecation"))
+
+	headers := lib.DeprecationHeaders(time.Time{}, time.Time{}, "/v1/transcribe")
+	assert.Equal(t, "@1792368000", headers["Deprecation"], "without a date the default one is used")
+	assert.NotContains(t, headers, "Sunset")
+}
+
+func TestAPIKeysAreScoped(t *testing.T) {
+	jobs.Initialize()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, err := lib.NewFileKeyStore(path)
+	require.NoError(t, err)
+	cfg := &config.Config{APIKey: "test-key"}
+	app := fiber.New()
+	registerRoutes(app, cfg, lib.NewAuthenticator(store, lib.ConfiguredKeys(cfg)...))
+
+	newKey := func(expiresAt *time.Time, scopes ...lib.Scope) string {
+		key, token, err := lib.NewAPIKey("test", "acme", scopes, expiresAt)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(context.Background(), key))
+		return token
+	}
+	request := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	reader := newKey(nil, lib.ScopeRead)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/v1/transcribe", reader))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/v1/transcribe", reader))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/v1/admin/jobs/missing/bump", reader))
+
+	admin := newKey(nil, lib.ScopeAdmin)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/v1/transcribe", admin), "admin implies every scope")
+
+	expired := time.Now().Add(-time.Minute)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/v1/transcribe", newKey(&expired, lib.ScopeRead)))
+
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/v1/transcribe", reader+"x"))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/v1/transcribe", "test-key"))
+
+	reloaded, err := lib.NewFileKeyStore(path)
+	require.NoError(t, err)
+	keys, err := reloaded.List(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, keys, 3)
+}
+
+func TestRateLimitsArePerKeyAndClass(t *testing.T) {
+	jobs.Initialize()
+
+	store, err := lib.NewFileKeyStore("")
+	require.NoError(t, err)
+	cfg := &config.Config{RateLimitPoll: 100}
+	app := fiber.New()
+	registerRoutes(app, cfg, lib.NewAuthenticator(store))
+
+	newKey := func(limits lib.RateLimits) string {
+		key, token, err := lib.NewAPIKey("test", "acme", []lib.Scope{lib.ScopeRead, lib.ScopeSubmit}, nil)
+		require.NoError(t, err)
+		key.RateLimits = limits
+		require.NoError(t, store.Put(context.Background(), key))
+		return token
+	}
+	get := func(path, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	limited := newKey(lib.RateLimits{lib.RateLimitPoll: {PerMinute: 1, Burst: 2}})
+	resp := get("/v1/transcribe", limited)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", resp.Header.Get("RateLimit-Reset"))
+
+	assert.Equal(t, http.StatusOK, get("/v1/usage", limited).StatusCode, "polling routes share a bucket")
+	resp = get("/v1/transcribe", limited)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, resp.Header.Get(fiber.HeaderRetryAfter))
+
+	// Downloads have their own bucket, which this configuration leaves
+	// unlimited.
+	resp = get("/v1/transcribe/missing/transcript.txt", limited)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+
+	resp = get("/v1/transcribe", newKey(nil))
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "other keys are unaffected")
+	assert.Equal(t, "100", resp.Header.Get("RateLimit-Limit"))
+}
+
+func TestUsageIsMeteredPerKey(t *testing.T) {
+	jobs.Initialize()
+	usageFile := filepath.Join(t.TempDir(), "usage.jsonl")
+	meter, err := lib.NewFileUsageMeter(usageFile)
+	require.NoError(t, err)
+	handlers.UseMeter(meter)
+	t.Cleanup(func() { handlers.UseMeter(nil) })
+
+	store, err := lib.NewFileKeyStore("")
+	require.NoError(t, err)
+	app := fiber.New()
+	registerRoutes(app, &config.Config{}, lib.NewAuthenticator(store))
+
+	newKey := func(scopes ...lib.Scope) (*lib.APIKey, string) {
+		key, token, err := lib.NewAPIKey("test", "acme", scopes, nil)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(context.Background(), key))
+		return key, token
+	}
+	get := func(path, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	report := func(path, token string) models.UsageReport {
+		resp := get(path, token)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var report models.UsageReport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return report
+	}
+
+	customer, customerToken := newKey(lib.ScopeRead)
+	assert.Equal(t, http.StatusOK, get("/v1/transcribe", customerToken).StatusCode)
+	assert.Equal(t, http.StatusNotFound, get("/v1/transcribe/missing", customerToken).StatusCode)
+	assert.Equal(t, http.StatusNotFound, get("/transcribe/missing", customerToken).StatusCode, "legacy aliases are metered too")
+
+	own := report("/v1/usage/report", customerToken)
+	assert.Equal(t, customer.ID, own.KeyID)
+	require.Len(t, own.Days, 1)
+	assert.Equal(t, time.Now().UTC().Format(models.DateLayout), own.Days[0].Date)
+	assert.Equal(t, 3, own.Total.Requests)
+	assert.Equal(t, 2, own.Total.Errors)
+	assert.Positive(t, own.Total.ResponseBytes)
+
+	assert.Equal(t, http.StatusForbidden, get("/v1/usage/report?key_id=other", customerToken).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, get("/v1/usage/report?from=2026-02-01&to=2026-01-01", customerToken).StatusCode)
+
+	_, adminToken := newKey(lib.ScopeAdmin)
+	billed := report("/v1/usage/report?key_id="+customer.ID, adminToken)
+	assert.Equal(t, customer.ID, billed.KeyID)
+	assert.Equal(t, 6, billed.Total.Requests, "the report requests are metered too")
+
+	reloaded, err := lib.NewFileUsageMeter(usageFile)
+	require.NoError(t, err)
+	saved, err := reloaded.Report(context.Background(), customer.ID, time.Now(), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 6, saved.Total.Requests, "totals survive a restart")
+	assert.Equal(t, 4, saved.Total.Errors)
+}
+
+func TestJobsAreIsolatedPerTenant(t *testing.T) {
+	jobs.Initialize()
+
+	store, err := lib.NewFileKeyStore("")
+	require.NoError(t, err)
+	app := fiber.New()
+	registerRoutes(app, &config.Config{}, lib.NewAuthenticator(store))
+
+	newToken := func(owner string) string {
+		key, token, err := lib.NewAPIKey(owner, owner, []lib.Scope{lib.ScopeRead, lib.ScopeSubmit}, nil)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(context.Background(), key))
+		return token
+	}
+	do := func(method, path, token string) *http.Response {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	acme, globex := newToken("acme"), newToken("globex")
+	job := jobs.NewJob("https://example.com/video")
+	job.Owner = "acme"
+	jobs.GetQueue().AddJob(job)
+
+	for _, path := range []string{
+		"/v1/transcribe/" + job.ID,
+		"/v1/transcribe/" + job.ID + "/events",
+		"/v1/transcribe/" + job.ID + "/transcript.txt",
+	} {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, path, globex).StatusCode, path)
+	}
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/transcribe/"+job.ID+"/cancel", globex).StatusCode)
+
+	var list models.JobListResponse
+	require.NoError(t, json.NewDecoder(do(http.MethodGet, "/v1/transcribe", globex).Body).Decode(&list))
+	assert.Empty(t, list.Jobs)
+
+	resp := do(http.MethodPost, "/v1/transcribe/"+job.ID+"/cancel", acme)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var summary models.JobSummary
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&summary))
+	assert.Equal(t, models.StatusCancelled, summary.Status)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/v1/transcribe/"+job.ID+"/cancel", acme).StatusCode)
+}
+
+func TestAdminManagesKeysJobsAndWorkers(t *testing.T) {
+	jobs.Initialize()
+	jobs.StartDispatcher(1, func(*jobs.Job) {})
+	handlers.UseAuditLog(&lib.FileAuditLog{})
+
+	store, err := lib.NewFileKeyStore("")
+	require.NoError(t, err)
+	app := fiber.New()
+	registerRoutes(app, &config.Config{}, lib.NewAuthenticator(store))
+
+	admin, adminToken, err := lib.NewAPIKey("ops", "operator", []lib.Scope{lib.ScopeAdmin}, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(context.Background(), admin))
+
+	do := func(method, path, token, body string, out interface{}) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		if out != nil {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+		}
+		return resp.StatusCode
+	}
+
+	var created lib.APIKeyInfo
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/v1/admin/keys", adminToken,
+		`{"name": "ci", "owner": "acme", "scopes": ["read", "submit"]}`, &created))
+	require.NotEmpty(t, created.Token)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/transcribe", created.Token, "", nil))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/v1/admin/keys", created.Token, "", nil))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/admin/keys", adminToken, `{"name": "ci", "owner": "acme", "scopes": ["root"]}`, nil))
+
+	var limited lib.APIKeyInfo
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/v1/admin/keys/"+created.ID+"/limits", adminToken,
+		`{"quota": {"jobs_per_day": 3}, "rate_limits": {"poll": {"per_minute": 30}}, "weight": 2}`, &limited))
+	assert.Equal(t, 3, limited.Quota.JobsPerDay)
+	assert.Equal(t, 30, limited.RateLimits[lib.RateLimitPoll].PerMinute)
+	assert.Equal(t, 2.0, limited.Weight)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/v1/admin/keys/"+created.ID+"/limits", adminToken, `{"weight": 101}`, nil))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/v1/admin/keys/"+created.ID+"/limits", adminToken, `{"rate_limits": {"upload": {"per_minute": 1}}}`, nil))
+
+	var rotated lib.APIKeyInfo
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/admin/keys/"+created.ID+"/rotate", adminToken, "", &rotated))
+	assert.Equal(t, created.ID, rotated.ID)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/v1/transcribe", created.Token, "", nil))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/transcribe", rotated.Token, "", nil))
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/admin/keys/"+created.ID+"/revoke", adminToken, "", nil))
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/v1/transcribe", rotated.Token, "", nil))
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/v1/admin/keys/"+created.ID+"/revoke", adminToken, "", nil))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/admin/keys/missing/rotate", adminToken, "", nil))
+
+	job := jobs.NewJob("https://example.com/video")
+	job.Owner = "acme"
+	job.Webhook = &models.JobWebhook{URL: "https://hooks.example.com/t", Headers: map[string]string{"Authorization": "Bearer tenant-secret"}}
+	jobs.GetQueue().AddJob(job)
+
+	var inspected models.Job
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/admin/jobs/"+job.ID, adminToken, "", &inspected))
+	assert.Equal(t, models.RedactedValue, inspected.Webhook.Headers["Authorization"])
+
+	var failed models.Job
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/admin/jobs/"+job.ID+"/fail", adminToken, `{"reason": "stuck"}`, &failed))
+	assert.Equal(t, models.StatusFailed, failed.Status)
+	assert.Equal(t, models.CodeJobFailedByOperator, failed.Problem.Code)
+	assert.Equal(t, models.RedactedValue, failed.Webhook.Headers["Authorization"])
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/v1/admin/jobs/"+job.ID+"/fail", adminToken, "", nil))
+
+	var pool models.WorkerPoolStatus
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/admin/workers/drain", adminToken, "", &pool))
+	assert.True(t, pool.Draining)
+
+	var requeued models.Job
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/admin/jobs/"+job.ID+"/requeue", adminToken, "", &requeued))
+	assert.Equal(t, models.StatusQueued, requeued.Status)
+	assert.Nil(t, requeued.Problem)
+
+	// A failed batch child stays failed, so its batch's counts and quota
+	// charge stand.
+	child := jobs.NewJob("https://example.com/child")
+	child.Owner = "acme"
+	batch := jobs.NewBatch("acme", "")
+	jobs.GetQueue().AddBatch(batch, []*jobs.Job{child})
+	_, err = jobs.GetQueue().Fail(child.ID, assert.AnError)
+	require.NoError(t, err)
+	jobs.GetQueue().CompleteBatch(batch.ID)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/v1/admin/jobs/"+child.ID+"/requeue", adminToken, "", nil))
+	stillFailed, err := jobs.GetQueue().GetJob(child.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusFailed, stillFailed.Status)
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/admin/workers", adminToken, "", &pool))
+	assert.Equal(t, 1, pool.Queued, "a draining pool starts nothing")
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/admin/workers/resume", adminToken, "", &pool))
+	assert.False(t, pool.Draining)
+
+	var audit models.AuditLogResponse
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/admin/audit", adminToken, "", &audit))
+	var actions []string
+	for _, entry := range audit.Entries {
+		assert.Equal(t, admin.ID, entry.ActorKeyID)
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []string{
+		models.AuditWorkersResumed, models.AuditJobRequeued, models.AuditWorkersDrained, models.AuditJobFailed,
+		models.AuditKeyRevoked, models.AuditKeyRotated, models.AuditKeyLimitsSet, models.AuditKeyCreated,
+	}, actions)
+}
+
+func TestSignedArtifactURLs(t *testing.T) {
+	jobs.Initialize()
+	defer handlers.UseURLSigner(nil)
+
+	store, err := lib.NewFileKeyStore("")
+	require.NoError(t, err)
+	app := fiber.New()
+	registerRoutes(app, &config.Config{}, lib.NewAuthenticator(store))
+
+	key, token, err := lib.NewAPIKey("acme", "acme", []lib.Scope{lib.ScopeRead}, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(context.Background(), key))
+
+	job := jobs.NewJob("https://example.com/video")
+	job.Owner = "acme"
+	queue := jobs.GetQueue()
+	queue.AddJob(job)
+	_, err = queue.Start(job.ID)
+	require.NoError(t, err)
+	_, err = queue.Complete(job.ID, "hello", []models.Segment{{Start: 0, End: 1, Text: "hello"}})
+	require.NoError(t, err)
+
+	get := func(path string) *http.Response {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		require.NoError(t, err)
+		return resp
+	}
+	signedURL := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/v1/transcribe/"+job.ID, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		var status handlers.JobStatusResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		require.NotNil(t, status.SubtitleFiles)
+		require.NotNil(t, status.SubtitleFiles.ExpiresAt)
+		return status.SubtitleFiles.TXTURL
+	}
+	useKeys := func(keys ...lib.SigningKey) {
+		signer, err := lib.NewURLSigner(keys, time.Hour)
+		require.NoError(t, err)
+		handlers.UseURLSigner(signer)
+	}
+	oldKey := lib.SigningKey{ID: "old", Secret: "old-secret"}
+	newKey := lib.SigningKey{ID: "new", Secret: "new-secret"}
+
+	useKeys(oldKey)
+	url := signedURL()
+	require.True(t, strings.HasPrefix(url, "/v1/artifacts/"+job.ID+"/transcript.txt?"), url)
+	resp := get(url)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "no API key needed")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(body))
+
+	assert.Equal(t, http.StatusForbidden, get(strings.Replace(url, "transcript.txt", "transcript.srt", 1)).StatusCode, "signed for one format")
+	assert.Equal(t, http.StatusForbidden, get(strings.Replace(url, "expires=", "expires=9", 1)).StatusCode, "expiry is signed")
+	assert.Equal(t, http.StatusForbidden, get("/v1/artifacts/"+job.ID+"/transcript.txt").StatusCode)
+
+	useKeys(newKey, oldKey)
+	assert.Equal(t, http.StatusOK, get(url).StatusCode, "rotated key still verifies")
+	assert.Contains(t, signedURL(), "key=new")
+
+	useKeys(newKey)
+	assert.Equal(t, http.StatusForbidden, get(url).StatusCode, "retired key no longer verifies")
+
+	signer, err := lib.NewURLSigner([]lib.SigningKey{newKey}, time.Hour)
+	require.NoError(t, err)
+	expired, _ := signer.Sign("", job.ID, lib.FormatTXT, time.Now().Add(-2*time.Hour))
+	resp = get(expired)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	var problem map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, "Download URL has expired", problem["error"])
+}
+
+func TestWebhookDeliveriesAreDeadLetteredAndReplayed(t *testing.T) {
+	jobs.Initialize()
+
+	outboxFile := filepath.Join(t.TempDir(), "outbox.jsonl")
+	outbox, err := lib.NewFileWebhookOutbox(outboxFile)
+	require.NoError(t, err)
+	dispatcher := lib.NewWebhookDispatcher(outbox, []string{"secret"})
+	handlers.UseWebhookDispatcher(dispatcher)
+	defer handlers.UseWebhookDispatcher(nil)
+
+	store, err := lib.NewFileKeyStore("")
+	require.NoError(t, err)
+	app := fiber.New()
+	registerRoutes(app, &config.Config{}, lib.NewAuthenticator(store))
+
+	newToken := func(owner string) string {
+		key, token, err := lib.NewAPIKey(owner, owner, []lib.Scope{lib.ScopeRead, lib.ScopeSubmit}, nil)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(context.Background(), key))
+		return token
+	}
+	do := func(method, path, token string) *http.Response {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	acme, globex := newToken("acme"), newToken("globex")
+
+	// The receiver below listens on loopback.
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+
+	var up atomic.Bool
+	var deliveryIDs []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		deliveryIDs = append(deliveryIDs, r.Header.Get("X-Webhook-Delivery-ID"))
+		mu.Unlock()
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	job := jobs.NewJob("https://example.com/video")
+	job.Owner = "acme"
+	job.Fail(errors.New("boom"))
+	manager := lib.NewWebhookManager(lib.WebhookConfig{URL: server.URL, Dispatcher: dispatcher, Owner: "acme"})
+	require.NoError(t, manager.SendJobFailed(context.Background(), job, "boom", 0))
+
+	// Make every retry due at once instead of waiting out the backoff, and
+	// run two passes at a time, which must not both attempt it.
+	for i := 1; i < models.WebhookMaxAttempts; i++ {
+		pending, err := outbox.List(context.Background(), models.WebhookDeliveryFilter{Status: models.DeliveryPending, Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, pending, 1, "attempt %d", i)
+		past := time.Now().Add(-time.Second)
+		pending[0].NextAttemptAt = &past
+		require.NoError(t, outbox.Put(context.Background(), &pending[0]))
+
+		var passes sync.WaitGroup
+		for range 2 {
+			passes.Add(1)
+			go func() {
+				defer passes.Done()
+				assert.NoError(t, dispatcher.DeliverDue(context.Background()))
+			}()
+		}
+		passes.Wait()
+	}
+
+	// The dead letter survives a restart.
+	reloaded, err := lib.NewFileWebhookOutbox(outboxFile)
+	require.NoError(t, err)
+	dead, err := reloaded.List(context.Background(), models.WebhookDeliveryFilter{Status: models.DeliveryDead, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, models.WebhookMaxAttempts, dead[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, dead[0].LastStatusCode)
+	mu.Lock()
+	assert.Len(t, deliveryIDs, models.WebhookMaxAttempts)
+	for _, id := range deliveryIDs {
+		assert.Equal(t, dead[0].ID, id)
+	}
+	mu.Unlock()
+
+	var list models.WebhookDeliveryList
+	require.NoError(t, json.NewDecoder(do(http.MethodGet, "/v1/webhooks/deliveries?status=dead", acme).Body).Decode(&list))
+	require.Len(t, list.Deliveries, 1)
+	assert.Equal(t, dead[0].ID, list.Deliveries[0].ID)
+	require.NoError(t, json.NewDecoder(do(http.MethodGet, "/v1/webhooks/deliveries", globex).Body).Decode(&list))
+	assert.Empty(t, list.Deliveries)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/webhooks/deliveries?status=lost", acme).StatusCode)
+
+	replay := "/v1/webhooks/deliveries/" + dead[0].ID + "/replay"
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, replay, globex).StatusCode)
+
+	resp := do(http.MethodPost, replay, acme)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var replayed models.WebhookDelivery
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&replayed))
+	assert.Equal(t, models.DeliveryPending, replayed.Status, "still failing, so retried later")
+	assert.Equal(t, 1, replayed.Attempts)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, replay, acme).StatusCode)
+
+	up.Store(true)
+	past := time.Now().Add(-time.Second)
+	replayed.NextAttemptAt = &past
+	require.NoError(t, outbox.Put(context.Background(), &replayed))
+	require.NoError(t, dispatcher.DeliverDue(context.Background()))
+
+	delivered, err := outbox.Get(context.Background(), dead[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DeliveryDelivered, delivered.Status)
+	assert.Equal(t, 2, delivered.Attempts)
+
+	resp = do(http.MethodPost, replay, acme)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "delivered webhooks can be replayed")
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&replayed))
+	assert.Equal(t, models.DeliveryDelivered, replayed.Status)
+}
+
+func TestRequestBodiesAreCappedExceptUploads(t *testing.T) {
+	jobs.Initialize()
+
+	cfg := &config.Config{MaxRequestBytes: 1024, MaxUploadBytes: 1 << 20, WorkDir: t.TempDir()}
+	handlers.UseConfig(cfg)
+	t.Cleanup(func() { handlers.UseConfig(&config.Config{}) })
+	app := newApp(cfg)
+	store, err := lib.NewFileKeyStore("")
+	require.NoError(t, err)
+	registerRoutes(app, cfg, lib.NewAuthenticator(store))
+
+	key, token, err := lib.NewAPIKey("ci", "acme", []lib.Scope{lib.ScopeRead, lib.ScopeSubmit}, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(context.Background(), key))
+
+	post := func(path, contentType string, body []byte, chunked bool) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", contentType)
+		if chunked {
+			req.ContentLength = -1
+			req.TransferEncoding = []string{"chunked"}
+		}
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	large := []byte(`{"url": "https://youtube.com/watch?v=` + strings.Repeat("a", 5<<20) + `"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("/v1/transcribe", "application/json", large, false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("/v1/transcribe", "application/json", large, true),
+		"chunked bodies have no Content-Length to check")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("/v1/admin/keys", "application/json", large, true))
+	assert.Equal(t, http.StatusBadRequest, post("/v1/transcribe", "application/json", []byte(`{"url": ""}`), true),
+		"small chunked bodies still reach the handler")
+
+	// Uploads stream past the JSON limit; this one is not media, so ffprobe
+	// turns it away.
+	assert.Equal(t, http.StatusUnprocessableEntity, post("/v1/transcribe/upload", "audio/wav", make([]byte, 64<<10), false))
+}
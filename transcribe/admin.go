@@ -0,0 +1,423 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// The admin endpoints below need a key with the admin scope. Keys from the
+// api_key secret are not stored, so they cannot be managed here.
+
+// auditLog returns the audit_log table, which the Fiber server also writes
+// when it shares the database.
+func auditLog() lib.AuditLog {
+	return lib.NewSQLAuditLog(db.Stdlib())
+}
+
+// recordAudit writes an admin action taken by the caller's key to the
+// audit_log table. The action has already happened, so a failed write is
+// logged rather than returned.
+func recordAudit(ctx context.Context, action, target string, details map[string]interface{}) {
+	var actor string
+	if data, ok := auth.Data().(*AuthData); ok {
+		actor = data.KeyID
+	}
+	entry := models.NewAuditEntry(actor, action, target, details)
+	if err := auditLog().Record(ctx, entry); err != nil {
+		rlog.Error("failed to record admin action", "error", err, "action", action, "target", target)
+	}
+}
+
+// ListAPIKeys lists every stored key, revoked ones included.
+//
+//encore:api auth method=GET path=/v1/admin/keys tag:v1 tag:admin
+func ListAPIKeys(ctx context.Context) (*lib.APIKeyList, error) {
+	keys, err := apiKeys().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &lib.APIKeyList{Keys: make([]lib.APIKeyInfo, 0, len(keys))}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, key.Info())
+	}
+	return response, nil
+}
+
+// CreateAPIKey generates a key and returns it with its token, which is not
+// shown again.
+//
+//encore:api auth method=POST path=/v1/admin/keys tag:v1 tag:admin
+func CreateAPIKey(ctx context.Context, req *lib.CreateAPIKeyRequest) (*lib.APIKeyInfo, error) {
+	if err := req.Validate(time.Now()); err != nil {
+		return nil, &errs.Error{Code: errs.InvalidArgument, Message: err.Error()}
+	}
+
+	key, token, err := req.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := apiKeys().Put(ctx, key); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, models.AuditKeyCreated, key.ID, map[string]interface{}{
+		"name":   key.Name,
+		"owner":  key.Owner,
+		"scopes": key.Scopes,
+	})
+
+	info := key.Info()
+	info.Token = token
+	return &info, nil
+}
+
+// GetAPIKey returns one key.
+//
+//encore:api auth method=GET path=/v1/admin/keys/:id tag:v1 tag:admin
+func GetAPIKey(ctx context.Context, id string) (*lib.APIKeyInfo, error) {
+	key, err := storedKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	info := key.Info()
+	return &info, nil
+}
+
+// SetAPIKeyLimits replaces a key's quota and rate limits. The new limits
+// apply from the key's next request.
+//
+//encore:api auth method=PUT path=/v1/admin/keys/:id/limits tag:v1 tag:admin
+func SetAPIKeyLimits(ctx context.Context, id string, limits *lib.APIKeyLimits) (*lib.APIKeyInfo, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, &errs.Error{Code: errs.InvalidArgument, Message: err.Error()}
+	}
+
+	key, err := storedKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	limits.Apply(key)
+	if err := apiKeys().Put(ctx, key); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, models.AuditKeyLimitsSet, key.ID, map[string]interface{}{
+		"quota":       key.Quota,
+		"rate_limits": key.RateLimits,
+		"weight":      key.Weight,
+	})
+
+	info := key.Info()
+	return &info, nil
+}
+
+// RotateAPIKey gives a key a new secret and returns its token. The old
+// token stops working immediately.
+//
+//encore:api auth method=POST path=/v1/admin/keys/:id/rotate tag:v1 tag:admin
+func RotateAPIKey(ctx context.Context, id string) (*lib.APIKeyInfo, error) {
+	key, err := storedKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.Revoked() {
+		return nil, &errs.Error{Code: errs.Aborted, Message: "API key has been revoked"}
+	}
+
+	token, err := key.Rotate()
+	if err != nil {
+		return nil, err
+	}
+	if err := apiKeys().Put(ctx, key); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, models.AuditKeyRotated, key.ID, nil)
+
+	info := key.Info()
+	info.Token = token
+	return &info, nil
+}
+
+// RevokeAPIKey stops a key from authenticating. The key is kept, so its
+// jobs, usage and audit trail still resolve.
+//
+//encore:api auth method=POST path=/v1/admin/keys/:id/revoke tag:v1 tag:admin
+func RevokeAPIKey(ctx context.Context, id string) (*lib.APIKeyInfo, error) {
+	key, err := storedKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.Revoked() {
+		return nil, &errs.Error{Code: errs.Aborted, Message: "API key has already been revoked"}
+	}
+
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	if err := apiKeys().Put(ctx, key); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, models.AuditKeyRevoked, key.ID, nil)
+
+	info := key.Info()
+	return &info, nil
+}
+
+// storedKey loads a key from api_keys.
+func storedKey(ctx context.Context, id string) (*lib.APIKey, error) {
+	key, err := apiKeys().Get(ctx, id)
+	if errors.Is(err, lib.ErrAPIKeyNotFound) {
+		return nil, &errs.Error{Code: errs.NotFound, Message: "API key not found"}
+	}
+	return key, err
+}
+
+// InspectJob returns every detail of any owner's job.
+//
+//encore:api auth method=GET path=/v1/admin/jobs/:id tag:v1 tag:admin
+func InspectJob(ctx context.Context, id string) (*models.Job, error) {
+	job, err := getJob(ctx, id)
+	if err != nil {
+		return nil, &errs.Error{Code: errs.NotFound, Message: "Job not found"}
+	}
+	return job.Redacted(), nil
+}
+
+// FailJob forcibly fails a queued or running job. A running job's worker
+// stops before its next stage, and any result it produced is dropped.
+//
+//encore:api auth method=POST path=/v1/admin/jobs/:id/fail tag:v1 tag:admin
+func FailJob(ctx context.Context, id string, req *models.FailJobRequest) (*models.Job, error) {
+	job, err := getJob(ctx, id)
+	if err != nil {
+		return nil, &errs.Error{Code: errs.NotFound, Message: "Job not found"}
+	}
+
+	cause := models.ErrFailedByOperator
+	if req.Reason != "" {
+		cause = fmt.Errorf("%w: %s", models.ErrFailedByOperator, req.Reason)
+	}
+	from := job.Status
+	if err := job.Fail(cause); err != nil {
+		return nil, &errs.Error{Code: errs.Aborted, Message: "Job has already finished"}
+	}
+	if err := updateJob(ctx, job, from); err != nil {
+		if errors.Is(err, models.ErrInvalidTransition) {
+			return nil, &errs.Error{
+				Code:    errs.Aborted,
+				Message: "Job changed status while being failed, retry the request",
+			}
+		}
+		return nil, err
+	}
+
+	if from == models.StatusQueued {
+		// Workers skip finished jobs, so clean up after it here.
+		if job.MediaPath != "" {
+			os.Remove(job.MediaPath)
+		}
+		if job.BatchID != "" {
+			finishBatchChild(ctx, job.BatchID)
+		}
+	}
+	recordAudit(ctx, models.AuditJobFailed, job.ID, map[string]interface{}{
+		"owner":  job.Owner,
+		"reason": req.Reason,
+	})
+
+	return job.Redacted(), nil
+}
+
+// RequeueJob returns a failed or cancelled job to the queue to run again.
+// Its quota was charged when it was first submitted. A worker still busy
+// with its previous run can no longer update it; see updateJob.
+//
+//encore:api auth method=POST path=/v1/admin/jobs/:id/requeue tag:v1 tag:admin
+func RequeueJob(ctx context.Context, id string) (*models.Job, error) {
+	job, err := getJob(ctx, id)
+	if err != nil {
+		return nil, &errs.Error{Code: errs.NotFound, Message: "Job not found"}
+	}
+	// A batch child's batch may already be completed, and its media was
+	// charged when it first started.
+	if job.BatchID != "" {
+		return nil, &errs.Error{Code: errs.Aborted, Message: "Jobs in a batch cannot be requeued, submit the URL again"}
+	}
+	if job.MediaPath != "" {
+		if _, err := os.Stat(job.MediaPath); err != nil {
+			return nil, &errs.Error{Code: errs.Aborted, Message: "Uploaded media is no longer available"}
+		}
+	}
+
+	from := job.Status
+	if err := job.Requeue(); err != nil {
+		return nil, &errs.Error{Code: errs.Aborted, Message: "Only failed or cancelled jobs can be requeued"}
+	}
+	if err := updateJob(ctx, job, from); err != nil {
+		if errors.Is(err, models.ErrInvalidTransition) {
+			return nil, &errs.Error{
+				Code:    errs.Aborted,
+				Message: "Job changed status while being requeued, retry the request",
+			}
+		}
+		return nil, err
+	}
+	if err := publishJob(ctx, job); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, models.AuditJobRequeued, job.ID, map[string]interface{}{
+		"owner":           job.Owner,
+		"previous_status": from,
+	})
+
+	return job.Redacted(), nil
+}
+
+// GetWorkers reports how many jobs are running and queued and whether the
+// workers are draining.
+//
+//encore:api auth method=GET path=/v1/admin/workers tag:v1 tag:admin
+func GetWorkers(ctx context.Context) (*models.WorkerPoolStatus, error) {
+	return workerPoolStatus(ctx)
+}
+
+// DrainWorkers stops the workers from starting queued jobs, for instance
+// ahead of a deploy. Running jobs finish; the drain is complete once none
+// are running. Jobs delivered meanwhile stay queued until ResumeWorkers.
+//
+//encore:api auth method=POST path=/v1/admin/workers/drain tag:v1 tag:admin
+func DrainWorkers(ctx context.Context) (*models.WorkerPoolStatus, error) {
+	if err := setDraining(ctx, true); err != nil {
+		return nil, err
+	}
+	status, err := workerPoolStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, models.AuditWorkersDrained, "", map[string]interface{}{
+		"busy":   status.Busy,
+		"queued": status.Queued,
+	})
+	return status, nil
+}
+
+// ResumeWorkers lets the workers start queued jobs again after a drain,
+// republishing the jobs they left queued.
+//
+//encore:api auth method=POST path=/v1/admin/workers/resume tag:v1 tag:admin
+func ResumeWorkers(ctx context.Context) (*models.WorkerPoolStatus, error) {
+	if err := setDraining(ctx, false); err != nil {
+		return nil, err
+	}
+	if err := republishQueuedJobs(ctx); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, models.AuditWorkersResumed, "", nil)
+	return workerPoolStatus(ctx)
+}
+
+// workersDraining reports whether workers may start queued jobs.
+func workersDraining(ctx context.Context) (bool, error) {
+	var draining bool
+	err := db.QueryRow(ctx, `SELECT draining FROM worker_state`).Scan(&draining)
+	return draining, err
+}
+
+func setDraining(ctx context.Context, draining bool) error {
+	_, err := db.Exec(ctx, `UPDATE worker_state SET draining = $1, updated_at = NOW()`, draining)
+	return err
+}
+
+func workerPoolStatus(ctx context.Context) (*models.WorkerPoolStatus, error) {
+	var status models.WorkerPoolStatus
+	err := db.QueryRow(ctx, `
+		SELECT
+			(SELECT draining FROM worker_state),
+			COUNT(*) FILTER (WHERE status = $1),
+			COUNT(*) FILTER (WHERE status = $2)
+		FROM jobs
+	`, models.StatusRunning, models.StatusQueued).Scan(&status.Draining, &status.Busy, &status.Queued)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// republishQueuedJobs publishes every queued job again. Workers work from
+// the stored job, so a job that was still in flight runs only once.
+func republishQueuedJobs(ctx context.Context) error {
+	rows, err := db.Query(ctx, `SELECT id FROM jobs WHERE status = $1 ORDER BY created_at`, models.StatusQueued)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		job, err := getJob(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := publishJob(ctx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AuditLogParams selects audit log entries.
+type AuditLogParams struct {
+	// Action keeps only entries of this action, such as key.revoked.
+	Action string `query:"action"`
+	// Target keeps only entries on this key or job ID.
+	Target string `query:"target"`
+	// Limit is the number of entries to return, up to 500.
+	Limit int `query:"limit"`
+}
+
+// GetAuditLog lists admin actions, newest first.
+//
+//encore:api auth method=GET path=/v1/admin/audit tag:v1 tag:admin
+func GetAuditLog(ctx context.Context, params *AuditLogParams) (*models.AuditLogResponse, error) {
+	limit := params.Limit
+	if limit == 0 {
+		limit = models.DefaultAuditListLimit
+	}
+	if limit < 1 || limit > models.MaxAuditListLimit {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: fmt.Sprintf("Invalid limit, expected 1 to %d", models.MaxAuditListLimit),
+		}
+	}
+
+	entries, err := auditLog().List(ctx, models.AuditFilter{
+		Action: params.Action,
+		Target: params.Target,
+		Limit:  limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.AuditLogResponse{Entries: entries}, nil
+}
@@ -0,0 +1,240 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+	"github.com/google/uuid"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// SubmitBatch submits a list of URLs or a playlist/channel URL as child jobs
+// under a single batch.
+//
+//encore:api auth method=POST path=/v1/transcribe/batch tag:v1 tag:submit
+func SubmitBatch(ctx context.Context, req *models.BatchRequest) (*models.BatchResponse, error) {
+	return withIdempotency(ctx, req.IdempotencyKey, "transcribe/batch", req, func() (*models.BatchResponse, error) {
+		return submitBatch(ctx, req)
+	})
+}
+
+func submitBatch(ctx context.Context, req *models.BatchRequest) (*models.BatchResponse, error) {
+	if (len(req.URLs) == 0) == (req.PlaylistURL == "") {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Provide either urls or playlist_url",
+		}
+	}
+
+	if !req.Priority.Valid() {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Invalid priority, expected low, normal or high",
+		}
+	}
+
+	if problems := req.TranscriptionOptions.Validate(); len(problems) > 0 {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Invalid transcription options",
+			Details: fieldErrors{Fields: problems},
+		}
+	}
+
+	if req.SetsJobWebhook() {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "webhook_headers and webhook_events are not supported on batches, whose webhook_url receives only batch.completed",
+		}
+	}
+
+	if req.WebhookURL != "" {
+		if err := lib.ValidateWebhookConfig(lib.WebhookConfig{URL: req.WebhookURL}); err != nil {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: err.Error(),
+			}
+		}
+	}
+
+	urls := req.URLs
+	if req.PlaylistURL != "" {
+		if !models.ValidateURL(req.PlaylistURL) {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: "Invalid playlist URL",
+			}
+		}
+
+		expanded, err := lib.ExpandPlaylist(req.PlaylistURL, models.MaxBatchSize+1)
+		if err != nil {
+			rlog.Error("failed to expand playlist", "error", err, "url", req.PlaylistURL)
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: "Failed to expand playlist",
+			}
+		}
+		urls = expanded
+	}
+
+	if len(urls) > models.MaxBatchSize {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: fmt.Sprintf("Batch exceeds the maximum of %d URLs", models.MaxBatchSize),
+		}
+	}
+
+	for _, url := range urls {
+		if !models.ValidateURL(url) {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: "Invalid URL in batch: " + url,
+			}
+		}
+	}
+
+	uid, _ := auth.UserID()
+	batch := &batchRecord{
+		ID:         uuid.New().String(),
+		Owner:      string(uid),
+		SourceURL:  req.PlaylistURL,
+		WebhookURL: req.WebhookURL,
+		CreatedAt:  time.Now(),
+	}
+
+	children := make([]*models.Job, 0, len(urls))
+	jobIDs := make([]string, 0, len(urls))
+	for _, url := range urls {
+		job := models.NewJob(url)
+		job.Owner = batch.Owner
+		job.BatchID = batch.ID
+		job.Options = req.TranscriptionOptions.Clone()
+		if data, ok := auth.Data().(*AuthData); ok {
+			job.APIKeyID = data.KeyID
+		}
+		if req.Priority != "" {
+			job.Priority = req.Priority
+		}
+		children = append(children, job)
+		jobIDs = append(jobIDs, job.ID)
+	}
+
+	if err := storeBatch(ctx, batch, children); err != nil {
+		return nil, quotaError(err)
+	}
+
+	for _, job := range children {
+		if err := publishJob(ctx, job); err != nil {
+			return nil, err
+		}
+	}
+
+	rlog.Info("batch submitted", "batch_id", batch.ID, "jobs", len(children))
+
+	return &models.BatchResponse{
+		BatchID: batch.ID,
+		JobIDs:  jobIDs,
+	}, nil
+}
+
+// GetBatch reports the aggregate progress of a batch.
+//
+//encore:api auth method=GET path=/v1/batches/:id tag:v1 tag:read
+func GetBatch(ctx context.Context, id string) (*models.BatchStatusResponse, error) {
+	uid, _ := auth.UserID()
+	batch, err := getBatch(ctx, id)
+	if err != nil || batch.Owner != string(uid) {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "Batch not found",
+		}
+	}
+
+	children, err := listBatchJobs(ctx, batch.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return batchStatus(batch, children), nil
+}
+
+func batchStatus(batch *batchRecord, children []models.JobSummary) *models.BatchStatusResponse {
+	response := &models.BatchStatusResponse{
+		ID:          batch.ID,
+		Status:      "running",
+		SourceURL:   batch.SourceURL,
+		Total:       len(children),
+		CreatedAt:   batch.CreatedAt,
+		CompletedAt: batch.CompletedAt,
+		Jobs:        children,
+	}
+	if batch.CompletedAt != nil {
+		response.Status = "completed"
+	}
+
+	for _, child := range children {
+		switch child.Status {
+		case models.StatusQueued:
+			response.Queued++
+		case models.StatusRunning:
+			response.Running++
+		case models.StatusCompleted:
+			response.Completed++
+		case models.StatusFailed:
+			response.Failed++
+		case models.StatusCancelled:
+			response.Cancelled++
+		}
+	}
+
+	if response.Total > 0 {
+		finished := response.Completed + response.Failed + response.Cancelled
+		response.Progress = float64(finished) / float64(response.Total) * 100
+	}
+
+	return response
+}
+
+// finishBatchChild fires the batch.completed webhook when the last child of
+// a batch finishes.
+func finishBatchChild(ctx context.Context, batchID string) {
+	completed, err := completeBatch(ctx, batchID)
+	if err != nil {
+		rlog.Error("failed to update batch", "error", err, "batch_id", batchID)
+		return
+	}
+	if !completed {
+		return
+	}
+
+	batch, err := getBatch(ctx, batchID)
+	if err != nil {
+		rlog.Error("failed to load batch", "error", err, "batch_id", batchID)
+		return
+	}
+
+	webhookURL := batch.WebhookURL
+	if webhookURL == "" {
+		webhookURL = cfg.WebhookURL
+	}
+	if webhookURL == "" {
+		return
+	}
+
+	children, err := listBatchJobs(ctx, batchID)
+	if err != nil {
+		rlog.Error("failed to load batch jobs", "error", err, "batch_id", batchID)
+		return
+	}
+
+	webhookManager := lib.NewWebhookManager(webhookConfig(webhookURL, batch.Owner))
+	webhookManager.SendBatchCompleted(ctx, batchStatus(batch, children))
+}
@@ -5,9 +5,15 @@ package transcribe
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"encore.dev/storage/sqldb"
 
+	"omnitranscripts/jobs"
+	"omnitranscripts/lib"
 	"omnitranscripts/models"
 )
 
@@ -16,21 +22,52 @@ var db = sqldb.NewDatabase("jobs", sqldb.DatabaseConfig{
 	Migrations: "./migrations",
 })
 
+// execer runs statements on the database or inside a transaction.
+type execer interface {
+	Exec(ctx context.Context, query string, args ...interface{}) (sqldb.ExecResult, error)
+}
+
 // storeJob stores a job in the database.
 func storeJob(ctx context.Context, job *models.Job) error {
+	return insertJob(ctx, db, job)
+}
+
+// storeAdmittedJob stores a job after counting it, with minutes media
+// minutes, against the caller's quota in the same transaction.
+func storeAdmittedJob(ctx context.Context, job *models.Job, minutes int) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := admitJobs(ctx, tx, 1, minutes); err != nil {
+		return err
+	}
+	if err := insertJob(ctx, tx, job); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertJob(ctx context.Context, ex execer, job *models.Job) error {
 	segmentsJSON, err := json.Marshal(job.Segments)
 	if err != nil {
 		return err
 	}
+	optionsJSON, err := json.Marshal(job.Options)
+	if err != nil {
+		return err
+	}
 
 	query := `
-		INSERT INTO jobs (id, url, status, transcript, segments, error, created_at, completed_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO jobs (id, url, media_path, owner, api_key_id, batch_id, priority, media_seconds, options, status, transcript, segments, error, created_at, update_time, completed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 	`
 
-	_, err = db.Exec(ctx, query,
-		job.ID, job.URL, job.Status, job.Transcript,
-		segmentsJSON, job.Error, job.CreatedAt, job.CompletedAt,
+	_, err = ex.Exec(ctx, query,
+		job.ID, job.URL, job.MediaPath, job.Owner, nullString(job.APIKeyID), nullString(job.BatchID), job.Priority, job.MediaSeconds, optionsJSON, job.Status, job.Transcript,
+		segmentsJSON, job.Error, job.CreatedAt, job.UpdatedAt, job.CompletedAt,
 	)
 	return err
 }
@@ -38,16 +75,17 @@ func storeJob(ctx context.Context, job *models.Job) error {
 // getJob retrieves a job from the database.
 func getJob(ctx context.Context, id string) (*models.Job, error) {
 	query := `
-		SELECT id, url, status, transcript, segments, error, created_at, completed_at
+		SELECT id, url, media_path, owner, COALESCE(api_key_id, ''), COALESCE(batch_id, ''), priority, media_seconds, options, status, COALESCE(stage, ''), transcript, segments, error, problem,
+		       created_at, start_time, stage_started_at, COALESCE(update_time, created_at), completed_at
 		FROM jobs WHERE id = $1
 	`
 
 	var job models.Job
-	var segmentsJSON []byte
+	var segmentsJSON, optionsJSON, problemJSON []byte
 
 	err := db.QueryRow(ctx, query, id).Scan(
-		&job.ID, &job.URL, &job.Status, &job.Transcript,
-		&segmentsJSON, &job.Error, &job.CreatedAt, &job.CompletedAt,
+		&job.ID, &job.URL, &job.MediaPath, &job.Owner, &job.APIKeyID, &job.BatchID, &job.Priority, &job.MediaSeconds, &optionsJSON, &job.Status, &job.Stage, &job.Transcript,
+		&segmentsJSON, &job.Error, &problemJSON, &job.CreatedAt, &job.StartedAt, &job.StageStartedAt, &job.UpdatedAt, &job.CompletedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -58,26 +96,347 @@ func getJob(ctx context.Context, id string) (*models.Job, error) {
 			return nil, err
 		}
 	}
+	if len(optionsJSON) > 0 {
+		if err := json.Unmarshal(optionsJSON, &job.Options); err != nil {
+			return nil, err
+		}
+	}
+	if len(problemJSON) > 0 {
+		if err := json.Unmarshal(problemJSON, &job.Problem); err != nil {
+			return nil, err
+		}
+	}
 
 	return &job, nil
 }
 
-// updateJob updates a job in the database.
-func updateJob(ctx context.Context, job *models.Job) error {
+// updateJob writes a job whose state machine moved on from status from.
+// The update only applies while the stored row is still in that status, so
+// concurrent or redelivered workers cannot overwrite each other's results;
+// a lost race returns models.ErrInvalidTransition.
+func updateJob(ctx context.Context, job *models.Job, from models.JobStatus) error {
 	segmentsJSON, err := json.Marshal(job.Segments)
 	if err != nil {
 		return err
 	}
+	var problemJSON []byte
+	if job.Problem != nil {
+		if problemJSON, err = json.Marshal(job.Problem); err != nil {
+			return err
+		}
+	}
 
+	// A running job is only updated by the run that started it: once the
+	// job is failed and requeued, its start time changes and the previous
+	// run's updates no longer match.
 	query := `
 		UPDATE jobs
-		SET status = $2, transcript = $3, segments = $4, error = $5, completed_at = $6
-		WHERE id = $1
+		SET status = $2, stage = $3, transcript = $4, segments = $5, error = $6, problem = $7,
+		    start_time = $8, stage_started_at = $9, update_time = $10, completed_at = $11, media_seconds = $12
+		WHERE id = $1 AND status = $13 AND ($13 <> 'running' OR start_time = $8)
 	`
 
-	_, err = db.Exec(ctx, query,
-		job.ID, job.Status, job.Transcript,
-		segmentsJSON, job.Error, job.CompletedAt,
+	result, err := db.Exec(ctx, query,
+		job.ID, job.Status, job.Stage, job.Transcript, segmentsJSON, job.Error, problemJSON,
+		job.StartedAt, job.StageStartedAt, job.UpdatedAt, job.CompletedAt, job.MediaSeconds, from,
 	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: job %s is no longer %s", models.ErrInvalidTransition, job.ID, from)
+	}
+	return nil
+}
+
+// listJobs returns one page of the owner's jobs matching the filter, newest
+// first, along with the cursor for the next page.
+func listJobs(ctx context.Context, filter jobs.ListFilter) ([]models.JobSummary, string, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = jobs.DefaultListLimit
+	}
+	if limit > jobs.MaxListLimit {
+		limit = jobs.MaxListLimit
+	}
+
+	conditions := []string{"owner = $1"}
+	args := []interface{}{filter.Owner}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if filter.Status != "" {
+		addCondition("status = $%d", string(filter.Status))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		addCondition("created_at > $%d", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		addCondition("created_at < $%d", filter.CreatedBefore)
+	}
+	if filter.Host != "" {
+		host := strings.ToLower(strings.TrimSpace(filter.Host))
+		args = append(args, host, escapeLike(host))
+		n := len(args)
+		conditions = append(conditions, fmt.Sprintf(
+			"(lower(substring(url from '^[a-zA-Z]+://([^/:?#]+)')) = $%d OR lower(substring(url from '^[a-zA-Z]+://([^/:?#]+)')) LIKE '%%.' || $%d ESCAPE '\\')",
+			n-1, n,
+		))
+	}
+	if filter.Query != "" {
+		addCondition("transcript ILIKE '%%' || $%d || '%%' ESCAPE '\\'", escapeLike(filter.Query))
+	}
+	if filter.Cursor != "" {
+		cursor, err := jobs.DecodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, cursor.CreatedAt, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	args = append(args, limit+1)
+	query := fmt.Sprintf(`
+		SELECT id, url, status, COALESCE(stage, ''), error, created_at, start_time, completed_at
+		FROM jobs
+		WHERE %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d
+	`, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	summaries := make([]models.JobSummary, 0, limit)
+	for rows.Next() {
+		var s models.JobSummary
+		var errMsg *string
+		if err := rows.Scan(&s.ID, &s.URL, &s.Status, &s.Stage, &errMsg, &s.CreatedAt, &s.StartedAt, &s.CompletedAt); err != nil {
+			return nil, "", err
+		}
+		if errMsg != nil {
+			s.Error = *errMsg
+		}
+		summaries = append(summaries, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(summaries) > limit {
+		summaries = summaries[:limit]
+		last := summaries[limit-1]
+		next = jobs.EncodeCursor(jobs.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	return summaries, next, nil
+}
+
+// likeEscaper escapes the LIKE wildcards, so user input matches literally
+// as it does in jobs.ListFilter.Matches.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike quotes s for use in a LIKE pattern with ESCAPE '\'.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// nullString maps an empty string to SQL NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// batchRecord is a row of the batches table.
+type batchRecord struct {
+	ID          string
+	Owner       string
+	SourceURL   string
+	WebhookURL  string
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+}
+
+// storeBatch stores a batch together with its child jobs in one
+// transaction, counting the children against the caller's quota. Their
+// media minutes are charged as each one starts.
+func storeBatch(ctx context.Context, batch *batchRecord, children []*models.Job) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := admitBatch(ctx, tx, len(children)); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO batches (id, owner, source_url, webhook_url, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, batch.ID, batch.Owner, nullString(batch.SourceURL), nullString(batch.WebhookURL), batch.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range children {
+		optionsJSON, err := json.Marshal(job.Options)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO jobs (id, url, owner, api_key_id, batch_id, priority, options, status, transcript, segments, error, created_at, update_time)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, '', 'null', '', $9, $10)
+		`, job.ID, job.URL, job.Owner, nullString(job.APIKeyID), batch.ID, job.Priority, optionsJSON, job.Status, job.CreatedAt, job.UpdatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getBatch retrieves a batch from the database.
+func getBatch(ctx context.Context, id string) (*batchRecord, error) {
+	var batch batchRecord
+	var sourceURL, webhookURL *string
+
+	err := db.QueryRow(ctx, `
+		SELECT id, owner, source_url, webhook_url, created_at, completed_at
+		FROM batches WHERE id = $1
+	`, id).Scan(&batch.ID, &batch.Owner, &sourceURL, &webhookURL, &batch.CreatedAt, &batch.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if sourceURL != nil {
+		batch.SourceURL = *sourceURL
+	}
+	if webhookURL != nil {
+		batch.WebhookURL = *webhookURL
+	}
+
+	return &batch, nil
+}
+
+// listBatchJobs returns summaries of the batch's child jobs in submission order.
+func listBatchJobs(ctx context.Context, batchID string) ([]models.JobSummary, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, url, status, COALESCE(stage, ''), COALESCE(error, ''), created_at, start_time, completed_at
+		FROM jobs WHERE batch_id = $1
+		ORDER BY created_at, id
+	`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []models.JobSummary
+	for rows.Next() {
+		var s models.JobSummary
+		if err := rows.Scan(&s.ID, &s.URL, &s.Status, &s.Stage, &s.Error, &s.CreatedAt, &s.StartedAt, &s.CompletedAt); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// completeBatch marks the batch completed if none of its children are still
+// outstanding. Only the caller that performs the update gets true back, so
+// the batch.completed webhook fires once even with concurrent subscribers.
+func completeBatch(ctx context.Context, batchID string) (bool, error) {
+	var id string
+	err := db.QueryRow(ctx, `
+		UPDATE batches SET completed_at = NOW()
+		WHERE id = $1
+		  AND completed_at IS NULL
+		  AND NOT EXISTS (
+		      SELECT 1 FROM jobs
+		      WHERE batch_id = $1 AND status NOT IN ($2, $3, $4)
+		  )
+		RETURNING id
+	`, batchID, models.StatusCompleted, models.StatusFailed, models.StatusCancelled).Scan(&id)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// reserveIdempotencyKey claims key for the API key keyID. It returns the
+// stored response if the key was already used with the same fingerprint,
+// lib.ErrIdempotencyKeyReused for a different fingerprint and
+// lib.ErrIdempotencyKeyInFlight while the first request is unfinished.
+func reserveIdempotencyKey(ctx context.Context, keyID, key, fingerprint string, window time.Duration) ([]byte, error) {
+	_, err := db.Exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE api_key_id = $1 AND key = $2 AND expires_at <= NOW()
+	`, keyID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(ctx, `
+		INSERT INTO idempotency_keys (api_key_id, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (api_key_id, key) DO NOTHING
+	`, keyID, key, fingerprint, time.Now().Add(window))
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var storedFingerprint string
+	var response []byte
+	err = db.QueryRow(ctx, `
+		SELECT fingerprint, response FROM idempotency_keys
+		WHERE api_key_id = $1 AND key = $2
+	`, keyID, key).Scan(&storedFingerprint, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if storedFingerprint != fingerprint {
+		return nil, lib.ErrIdempotencyKeyReused
+	}
+	if response == nil {
+		return nil, lib.ErrIdempotencyKeyInFlight
+	}
+	return response, nil
+}
+
+// completeIdempotencyKey stores the response to replay for a reserved key.
+func completeIdempotencyKey(ctx context.Context, keyID, key string, response interface{}) error {
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		UPDATE idempotency_keys SET response = $3
+		WHERE api_key_id = $1 AND key = $2
+	`, keyID, key, responseJSON)
 	return err
-}
\ No newline at end of file
+}
+
+// releaseIdempotencyKey forgets a reserved key after a failed attempt.
+func releaseIdempotencyKey(ctx context.Context, keyID, key string) error {
+	_, err := db.Exec(ctx, `
+		DELETE FROM idempotency_keys WHERE api_key_id = $1 AND key = $2 AND response IS NULL
+	`, keyID, key)
+	return err
+}
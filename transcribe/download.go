@@ -0,0 +1,128 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"encore.dev"
+	"encore.dev/beta/auth"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// DownloadTranscript serves a completed job's transcript as
+// transcript.txt, transcript.srt, transcript.vtt or transcript.json, with
+// ETag and Range support.
+//
+//encore:api auth raw method=GET path=/v1/transcribe/:id/:file
+func DownloadTranscript(w http.ResponseWriter, req *http.Request) {
+	w, done := meterRaw(w)
+	defer done()
+	w.Header().Set(lib.APIVersionHeader, "v1")
+	if !requireScopeRaw(w, lib.ScopeRead) || !rateLimitRaw(w, req, lib.RateLimitDownload) {
+		return
+	}
+	params := encore.CurrentRequest().PathParams
+
+	name, ok := strings.CutPrefix(params.Get("file"), "transcript.")
+	format, known := lib.ParseTranscriptFormat(name)
+	if !ok || !known {
+		writeJSONError(w, http.StatusNotFound, "Unknown transcript format")
+		return
+	}
+
+	uid, _ := auth.UserID()
+	job, err := getJob(req.Context(), params.Get("id"))
+	if err != nil || job.Owner != string(uid) {
+		writeJSONError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	if job.Status != models.StatusCompleted {
+		writeJSONError(w, http.StatusConflict, "Transcript is not available until the job completes")
+		return
+	}
+
+	lib.ServeTranscript(w, req, job, format)
+}
+
+// urlSigner signs download links. Every instance must accept the links
+// the others signed, so the service refuses to start without configured
+// keys rather than signing with a random key of its own.
+var urlSigner = mustURLSigner()
+
+func mustURLSigner() *lib.URLSigner {
+	keys, err := lib.ParseSigningKeys(cfg.ArtifactSigningKeys)
+	if err == nil && len(keys) == 0 {
+		err = errors.New("no keys are configured")
+	}
+	var signer *lib.URLSigner
+	if err == nil {
+		signer, err = lib.NewURLSigner(keys, time.Duration(cfg.ArtifactURLTTLHours)*time.Hour)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("transcribe: artifact_signing_keys: %v", err))
+	}
+	return signer
+}
+
+// DownloadArtifact serves a transcript from a signed URL, which needs no
+// API key until it expires.
+//
+//encore:api public raw method=GET path=/v1/artifacts/:id/:file
+func DownloadArtifact(w http.ResponseWriter, req *http.Request) {
+	w, done := meterRaw(w)
+	defer done()
+	w.Header().Set(lib.APIVersionHeader, "v1")
+	params := encore.CurrentRequest().PathParams
+
+	name, ok := strings.CutPrefix(params.Get("file"), "transcript.")
+	format, known := lib.ParseTranscriptFormat(name)
+	if !ok || !known {
+		writeJSONError(w, http.StatusNotFound, "Unknown transcript format")
+		return
+	}
+
+	if err := urlSigner.Verify(params.Get("id"), format, req.URL.Query(), time.Now()); err != nil {
+		message := "Invalid download URL signature"
+		if errors.Is(err, lib.ErrExpiredSignature) {
+			message = "Download URL has expired"
+		}
+		writeJSONError(w, http.StatusForbidden, message)
+		return
+	}
+
+	job, err := getJob(req.Context(), params.Get("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	if job.Status != models.StatusCompleted {
+		writeJSONError(w, http.StatusConflict, "Transcript is not available until the job completes")
+		return
+	}
+
+	lib.ServeTranscript(w, req, job, format)
+}
+
+// writeJSONError writes an error body shaped like the Fiber API's errors.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// writeProblem writes problem as an RFC 7807 body, as the Fiber API reports
+// failed transcriptions.
+func writeProblem(w http.ResponseWriter, problem *models.Problem) {
+	w.Header().Set("Content-Type", models.ProblemContentType)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
@@ -0,0 +1,86 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/rlog"
+
+	"omnitranscripts/lib"
+)
+
+// idempotencyWindow returns how long Idempotency-Key values are remembered.
+func idempotencyWindow() time.Duration {
+	if cfg.IdempotencyWindowHours > 0 {
+		return time.Duration(cfg.IdempotencyWindowHours) * time.Hour
+	}
+	return lib.DefaultIdempotencyWindow
+}
+
+// withIdempotency runs fn once per API key and Idempotency-Key within the
+// idempotency window and returns the stored response for repeats. A repeat
+// must carry the same request to the same endpoint. Failed attempts are not
+// stored, so they can be retried with the same key.
+func withIdempotency[T any](ctx context.Context, key, endpoint string, req interface{}, fn func() (*T, error)) (*T, error) {
+	if key == "" {
+		return fn()
+	}
+	if len(key) > lib.MaxIdempotencyKeyLength {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Idempotency-Key must be at most 255 characters",
+		}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := lib.RequestFingerprint([]byte(endpoint), body)
+
+	var keyID string
+	if data, ok := auth.Data().(*AuthData); ok {
+		keyID = data.KeyID
+	}
+
+	stored, err := reserveIdempotencyKey(ctx, keyID, key, fingerprint, idempotencyWindow())
+	switch {
+	case errors.Is(err, lib.ErrIdempotencyKeyReused):
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Idempotency-Key was already used with a different request",
+		}
+	case errors.Is(err, lib.ErrIdempotencyKeyInFlight):
+		return nil, &errs.Error{
+			Code:    errs.Aborted,
+			Message: "A request with this Idempotency-Key is still being processed",
+		}
+	case err != nil:
+		return nil, err
+	case stored != nil:
+		var resp T
+		if err := json.Unmarshal(stored, &resp); err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+
+	resp, err := fn()
+	if err != nil {
+		if relErr := releaseIdempotencyKey(ctx, keyID, key); relErr != nil {
+			rlog.Error("failed to release idempotency key", "error", relErr)
+		}
+		return nil, err
+	}
+
+	if err := completeIdempotencyKey(ctx, keyID, key, resp); err != nil {
+		rlog.Error("failed to store idempotent response", "error", err)
+	}
+	return resp, nil
+}
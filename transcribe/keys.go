@@ -0,0 +1,140 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/middleware"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// AuthData describes the API key that authenticated a request.
+type AuthData struct {
+	KeyID  string
+	Name   string
+	Scopes []lib.Scope
+	// Quota is the key's own quota; nil means the service defaults.
+	Quota *models.Quota
+	// RateLimits are the key's own rate limits, by class.
+	RateLimits lib.RateLimits
+}
+
+// HasScope reports whether the key grants scope.
+func (d *AuthData) HasScope(scope lib.Scope) bool {
+	key := lib.APIKey{Scopes: d.Scopes}
+	return key.HasScope(scope)
+}
+
+var (
+	authenticatorOnce sync.Once
+	authenticator     *lib.Authenticator
+)
+
+// keyAuthenticator checks tokens against the api_keys table, falling back
+// to the api_key secret. The Fiber server reads the same table when it
+// shares the database, and the secret's jobs have the same owner on both.
+func keyAuthenticator() *lib.Authenticator {
+	authenticatorOnce.Do(func() {
+		legacy := lib.LegacyKey(lib.DefaultKeyID, lib.DefaultOwner, cfg.APIKey, lib.ScopeRead, lib.ScopeSubmit)
+		authenticator = lib.NewAuthenticator(lib.NewSQLKeyStore(db.Stdlib()), legacy)
+	})
+	return authenticator
+}
+
+// apiKeys returns the store of API keys.
+func apiKeys() lib.KeyStore {
+	return keyAuthenticator().Store()
+}
+
+// AuthHandler validates API key authentication. The key's owner is the
+// UID that jobs and batches are recorded under.
+//
+//encore:authhandler
+func AuthHandler(ctx context.Context, token string) (auth.UID, *AuthData, error) {
+	key, err := keyAuthenticator().Authenticate(ctx, token)
+	switch {
+	case errors.Is(err, lib.ErrExpiredAPIKey):
+		return "", nil, &errs.Error{
+			Code:    errs.Unauthenticated,
+			Message: "API key has expired",
+		}
+	case errors.Is(err, lib.ErrRevokedAPIKey):
+		return "", nil, &errs.Error{
+			Code:    errs.Unauthenticated,
+			Message: "API key has been revoked",
+		}
+	case errors.Is(err, lib.ErrInvalidAPIKey):
+		return "", nil, &errs.Error{
+			Code:    errs.Unauthenticated,
+			Message: "Invalid API key",
+		}
+	case err != nil:
+		return "", nil, err
+	}
+
+	return auth.UID(key.Owner), &AuthData{
+		KeyID:      key.ID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		Quota:      key.Quota,
+		RateLimits: key.RateLimits,
+	}, nil
+}
+
+// RequireRead rejects keys without the read scope.
+//
+//encore:middleware target=tag:read
+func RequireRead(req middleware.Request, next middleware.Next) middleware.Response {
+	if message := missingScope(lib.ScopeRead); message != "" {
+		return middleware.Response{Err: &errs.Error{Code: errs.PermissionDenied, Message: message}}
+	}
+	return next(req)
+}
+
+// RequireAdmin rejects keys without the admin scope.
+//
+//encore:middleware target=tag:admin
+func RequireAdmin(req middleware.Request, next middleware.Next) middleware.Response {
+	if missingScope(lib.ScopeAdmin) != "" {
+		return middleware.Response{Err: &errs.Error{Code: errs.PermissionDenied, Message: "Admin access required"}}
+	}
+	return next(req)
+}
+
+// RequireSubmit rejects keys without the submit scope.
+//
+//encore:middleware target=tag:submit
+func RequireSubmit(req middleware.Request, next middleware.Next) middleware.Response {
+	if message := missingScope(lib.ScopeSubmit); message != "" {
+		return middleware.Response{Err: &errs.Error{Code: errs.PermissionDenied, Message: message}}
+	}
+	return next(req)
+}
+
+// missingScope describes why the request's key may not use scope, or
+// returns "" when it may.
+func missingScope(scope lib.Scope) string {
+	data, _ := auth.Data().(*AuthData)
+	if data != nil && data.HasScope(scope) {
+		return ""
+	}
+	return "API key lacks the " + string(scope) + " scope"
+}
+
+// requireScopeRaw rejects a raw request whose key lacks scope and returns
+// false. Raw endpoints check scopes themselves.
+func requireScopeRaw(w http.ResponseWriter, scope lib.Scope) bool {
+	if message := missingScope(scope); message != "" {
+		writeJSONError(w, http.StatusForbidden, message)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"encore.dev/beta/errs"
+	"encore.dev/middleware"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// The unversioned paths below are deprecated aliases of the /v1 endpoints.
+// They serve v1 only: once a later version exists, a client asking for it
+// with the API-Version header must move to its versioned paths.
+
+// VersionHeader names the API version of every /v1 response.
+//
+//encore:middleware target=tag:v1
+func VersionHeader(req middleware.Request, next middleware.Next) middleware.Response {
+	resp := next(req)
+	resp.Header().Set(lib.APIVersionHeader, "v1")
+	return resp
+}
+
+// LegacyHeaders marks responses of the unversioned aliases as deprecated.
+//
+//encore:middleware target=tag:legacy
+func LegacyHeaders(req middleware.Request, next middleware.Next) middleware.Response {
+	if message := unsupportedVersion(req.Data().Headers.Get(lib.APIVersionHeader)); message != "" {
+		return middleware.Response{Err: &errs.Error{Code: errs.InvalidArgument, Message: message}}
+	}
+
+	resp := next(req)
+	resp.Header().Set(lib.APIVersionHeader, models.LegacyAPIVersion)
+	for name, value := range lib.DeprecationHeaders(legacyDate(cfg.LegacyDeprecatedAt), legacyDate(cfg.LegacySunset), "/"+models.LegacyAPIVersion+req.Data().Path) {
+		resp.Header().Set(name, value)
+	}
+	return resp
+}
+
+// unsupportedVersion describes why the aliases cannot serve the requested
+// API version, or returns "" when they can.
+func unsupportedVersion(requested string) string {
+	if requested == "" || requested == models.LegacyAPIVersion {
+		return ""
+	}
+	return "Unversioned paths serve " + models.LegacyAPIVersion + " only; use the /" + requested +
+		" paths, or one of " + strings.Join(models.APIVersions, ", ")
+}
+
+// legacyDate parses a YYYY-MM-DD date of the config, which is zero when
+// unset.
+func legacyDate(value string) time.Time {
+	date, _ := time.Parse(time.DateOnly, value)
+	return date
+}
+
+// LegacyTranscribe is the deprecated alias of Transcribe.
+//
+//encore:api auth method=POST path=/transcribe tag:legacy tag:submit
+func LegacyTranscribe(ctx context.Context, req *TranscribeRequest) (*TranscribeResponse, error) {
+	return Transcribe(ctx, req)
+}
+
+// LegacyGetJob is the deprecated alias of GetJob.
+//
+//encore:api auth method=GET path=/transcribe/:id tag:legacy tag:read
+func LegacyGetJob(ctx context.Context, id string) (*JobStatusResponse, error) {
+	return GetJob(ctx, id)
+}
+
+// LegacyCancelJob is the deprecated alias of CancelJob.
+//
+//encore:api auth method=POST path=/transcribe/:id/cancel tag:legacy tag:submit
+func LegacyCancelJob(ctx context.Context, id string) (*models.JobSummary, error) {
+	return CancelJob(ctx, id)
+}
+
+// LegacyListJobs is the deprecated alias of ListJobs.
+//
+//encore:api auth method=GET path=/transcribe tag:legacy tag:read
+func LegacyListJobs(ctx context.Context, params *ListJobsParams) (*models.JobListResponse, error) {
+	return ListJobs(ctx, params)
+}
+
+// LegacySubmitBatch is the deprecated alias of SubmitBatch.
+//
+//encore:api auth method=POST path=/transcribe/batch tag:legacy tag:submit
+func LegacySubmitBatch(ctx context.Context, req *models.BatchRequest) (*models.BatchResponse, error) {
+	return SubmitBatch(ctx, req)
+}
+
+// LegacyGetBatch is the deprecated alias of GetBatch.
+//
+//encore:api auth method=GET path=/batches/:id tag:legacy tag:read
+func LegacyGetBatch(ctx context.Context, id string) (*models.BatchStatusResponse, error) {
+	return GetBatch(ctx, id)
+}
+
+// LegacyGetUsage is the deprecated alias of GetUsage.
+//
+//encore:api auth method=GET path=/usage tag:legacy tag:read
+func LegacyGetUsage(ctx context.Context) (*models.UsageResponse, error) {
+	return GetUsage(ctx)
+}
+
+// LegacyGetUsageReport is the deprecated alias of GetUsageReport.
+//
+//encore:api auth method=GET path=/usage/report tag:legacy tag:read
+func LegacyGetUsageReport(ctx context.Context, params *UsageReportParams) (*models.UsageReport, error) {
+	return GetUsageReport(ctx, params)
+}
+
+// LegacyDownloadTranscript is the deprecated alias of DownloadTranscript.
+// Middleware cannot set the headers of raw endpoints, so it does so itself.
+//
+//encore:api auth raw method=GET path=/transcribe/:id/:file
+func LegacyDownloadTranscript(w http.ResponseWriter, req *http.Request) {
+	if legacyRaw(w, req) {
+		DownloadTranscript(w, req)
+	}
+}
+
+// LegacyTranscribeUpload is the deprecated alias of TranscribeUpload.
+//
+//encore:api auth raw method=POST path=/transcribe/upload
+func LegacyTranscribeUpload(w http.ResponseWriter, req *http.Request) {
+	if legacyRaw(w, req) {
+		TranscribeUpload(w, req)
+	}
+}
+
+// legacyRaw sets the deprecation headers of a raw alias, or rejects the
+// request and returns false.
+func legacyRaw(w http.ResponseWriter, req *http.Request) bool {
+	if message := unsupportedVersion(req.Header.Get(lib.APIVersionHeader)); message != "" {
+		writeJSONError(w, http.StatusBadRequest, message)
+		return false
+	}
+	for name, value := range lib.DeprecationHeaders(legacyDate(cfg.LegacyDeprecatedAt), legacyDate(cfg.LegacySunset), "/"+models.LegacyAPIVersion+req.URL.Path) {
+		w.Header().Set(name, value)
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"encore.dev"
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/middleware"
+	"encore.dev/rlog"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// MeterRequests records every typed request in api_usage. Raw endpoints
+// meter themselves with meterRaw, since middleware cannot see what they
+// write.
+//
+//encore:middleware target=all
+func MeterRequests(req middleware.Request, next middleware.Next) middleware.Response {
+	data := req.Data()
+	if data.API != nil && data.API.Raw {
+		return next(req)
+	}
+
+	start := time.Now()
+	resp := next(req)
+
+	status := resp.HTTPStatus
+	if status == 0 {
+		status = http.StatusOK
+		if resp.Err != nil {
+			status = errs.Code(resp.Err).HTTPStatus()
+		}
+	}
+	var responseBytes int
+	if resp.Payload != nil {
+		if body, err := json.Marshal(resp.Payload); err == nil {
+			responseBytes = len(body)
+		}
+	}
+
+	call := newAPICall(data, start, status, responseBytes)
+	recordCall(req.Context(), call)
+	return resp
+}
+
+// meterRaw wraps the writer of a raw endpoint so that done records the
+// request in api_usage once the handler returns.
+func meterRaw(w http.ResponseWriter) (http.ResponseWriter, func()) {
+	start := time.Now()
+	metered := &meteredWriter{ResponseWriter: w}
+	return metered, func() {
+		data := encore.CurrentRequest()
+		status := metered.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		recordCall(context.Background(), newAPICall(data, start, status, metered.bytes))
+	}
+}
+
+type meteredWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *meteredWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *meteredWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func newAPICall(data *encore.Request, start time.Time, status, responseBytes int) models.APICall {
+	call := models.APICall{
+		Endpoint:      data.Service + "." + data.Endpoint,
+		Method:        data.Method,
+		Status:        status,
+		Latency:       time.Since(start),
+		ResponseBytes: responseBytes,
+		UserAgent:     data.Headers.Get("User-Agent"),
+		IP:            forwardedIP(data.Headers),
+		At:            start,
+	}
+	call.RequestBytes, _ = strconv.Atoi(data.Headers.Get("Content-Length"))
+	if uid, ok := auth.UserID(); ok {
+		call.Owner = string(uid)
+	}
+	if authData, ok := auth.Data().(*AuthData); ok {
+		call.APIKeyID = authData.KeyID
+	}
+	return call
+}
+
+// forwardedIP returns the client address reported by the proxy in front of
+// the service, or "" if there is none.
+func forwardedIP(headers http.Header) string {
+	first, _, _ := strings.Cut(headers.Get("X-Forwarded-For"), ",")
+	ip := net.ParseIP(strings.TrimSpace(first))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// recordCall writes call in the background, so metering does not delay
+// the response.
+func recordCall(ctx context.Context, call models.APICall) {
+	go func() {
+		if err := usageMeter().RecordCall(context.WithoutCancel(ctx), call); err != nil {
+			rlog.Error("failed to record api usage", "error", err, "endpoint", call.Endpoint)
+		}
+	}()
+}
+
+// recordJobUsage records the media a completed job is billed for.
+func recordJobUsage(ctx context.Context, job *models.Job) {
+	usage := models.BillableJob{
+		JobID:        job.ID,
+		APIKeyID:     job.APIKeyID,
+		Owner:        job.Owner,
+		MediaSeconds: job.BillableSeconds(),
+		At:           time.Now(),
+	}
+	if err := usageMeter().RecordJob(ctx, usage); err != nil {
+		rlog.Error("failed to record job usage", "error", err, "job_id", job.ID)
+	}
+}
+
+// usageMeter returns the api_usage and job_usage tables, which the Fiber
+// server also writes when it shares the database.
+func usageMeter() lib.UsageMeter {
+	return lib.NewSQLUsageMeter(db.Stdlib())
+}
+
+// UsageReportParams selects the period and key of a usage report.
+type UsageReportParams struct {
+	// From is the first day, as YYYY-MM-DD; it defaults to 30 days before To.
+	From string `query:"from"`
+	// To is the last day, as YYYY-MM-DD; it defaults to today.
+	To string `query:"to"`
+	// KeyID names another key to report on, which needs the admin scope.
+	KeyID string `query:"key_id"`
+}
+
+// GetUsageReport reports an API key's requests and billable media per UTC
+// day: the caller's own key, or with the admin scope any key.
+//
+//encore:api auth method=GET path=/v1/usage/report tag:v1 tag:read
+func GetUsageReport(ctx context.Context, params *UsageReportParams) (*models.UsageReport, error) {
+	data, _ := auth.Data().(*AuthData)
+	if data == nil {
+		return nil, &errs.Error{Code: errs.Unauthenticated, Message: "Invalid API key"}
+	}
+	keyID := data.KeyID
+	if params.KeyID != "" && params.KeyID != keyID {
+		if message := missingScope(lib.ScopeAdmin); message != "" {
+			return nil, &errs.Error{Code: errs.PermissionDenied, Message: message}
+		}
+		keyID = params.KeyID
+	}
+
+	from, to, err := models.ParseUsagePeriod(params.From, params.To, time.Now())
+	if errors.Is(err, models.ErrInvalidUsagePeriod) {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Invalid period, expected from and to as YYYY-MM-DD, at most 366 days apart",
+		}
+	}
+	return usageMeter().Report(ctx, keyID, from, to)
+}
@@ -0,0 +1,2 @@
+-- Remove job options column
+ALTER TABLE jobs DROP COLUMN IF EXISTS options;
@@ -0,0 +1,2 @@
+-- Per-request transcription options, kept for echoing and reprocessing
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS options JSONB NOT NULL DEFAULT '{}';
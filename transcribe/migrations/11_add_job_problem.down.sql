@@ -0,0 +1,2 @@
+-- Remove job problem column
+ALTER TABLE jobs DROP COLUMN IF EXISTS problem;
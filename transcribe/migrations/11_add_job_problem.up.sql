@@ -0,0 +1,2 @@
+-- RFC 7807 problem details of failed jobs
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS problem JSONB;
@@ -0,0 +1,13 @@
+-- API keys. Only the SHA-256 hash of each key is stored; owner is the
+-- auth UID the key's jobs and batches are recorded under.
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    owner TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    scopes JSONB NOT NULL DEFAULT '[]',
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_owner ON api_keys(owner);
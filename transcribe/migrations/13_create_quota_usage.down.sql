@@ -0,0 +1,4 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS quota;
+DROP INDEX IF EXISTS idx_jobs_api_key_status;
+ALTER TABLE jobs DROP COLUMN IF EXISTS api_key_id;
+DROP TABLE IF EXISTS quota_usage;
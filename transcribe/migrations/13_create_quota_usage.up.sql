@@ -0,0 +1,17 @@
+-- Per-key quota counters: jobs admitted per UTC day and media minutes per
+-- UTC month. Concurrent jobs are counted from the jobs table.
+CREATE TABLE quota_usage (
+    key_id TEXT NOT NULL,
+    period TEXT NOT NULL,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    jobs INTEGER NOT NULL DEFAULT 0,
+    media_minutes INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (key_id, period, starts_at)
+);
+
+-- The key that admitted each job, whose quota it counts against
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS api_key_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_jobs_api_key_status ON jobs(api_key_id, status);
+
+-- Per-key quota overriding the service defaults
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS quota JSONB;
@@ -0,0 +1,2 @@
+-- Per-key rate limits by class, overriding the service defaults
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rate_limits JSONB;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_api_usage_key_time;
+ALTER TABLE jobs DROP COLUMN IF EXISTS media_seconds;
+DROP TABLE IF EXISTS job_usage;
@@ -0,0 +1,16 @@
+-- The media each completed job is billed for. Rows outlive their jobs so
+-- billing does not depend on job retention.
+CREATE TABLE job_usage (
+    job_id TEXT PRIMARY KEY,
+    api_key_id TEXT,
+    owner TEXT,
+    media_seconds INTEGER NOT NULL,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_job_usage_key_time ON job_usage(api_key_id, recorded_at);
+
+-- Length of each job's media, measured before it runs
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS media_seconds INTEGER NOT NULL DEFAULT 0;
+
+-- Usage reports read api_usage by key and day
+CREATE INDEX IF NOT EXISTS idx_api_usage_key_time ON api_usage(api_key_id, recorded_at);
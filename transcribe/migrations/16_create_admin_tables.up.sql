@@ -0,0 +1,23 @@
+-- Revoked keys are kept for the audit trail but no longer authenticate
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP WITH TIME ZONE;
+
+-- Every action taken through the admin API
+CREATE TABLE audit_log (
+    id TEXT PRIMARY KEY,
+    actor_key_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target TEXT,
+    details JSONB,
+    request_id TEXT,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_audit_log_time ON audit_log(recorded_at DESC);
+CREATE INDEX idx_audit_log_target ON audit_log(target, recorded_at DESC);
+
+-- Whether workers may start queued jobs; a single row
+CREATE TABLE worker_state (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    draining BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+INSERT INTO worker_state (id) VALUES (TRUE);
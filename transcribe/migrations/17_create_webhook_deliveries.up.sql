@@ -0,0 +1,22 @@
+-- Outbox of webhook deliveries, kept until the endpoint accepts them or
+-- they are dead-lettered
+CREATE TABLE webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    owner TEXT NOT NULL,
+    event TEXT NOT NULL,
+    job_id TEXT,
+    batch_id TEXT,
+    url TEXT NOT NULL,
+    headers JSONB,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_status_code INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    next_attempt_at TIMESTAMP WITH TIME ZONE,
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_owner ON webhook_deliveries(owner, status, created_at DESC);
@@ -0,0 +1,2 @@
+-- Scheduling weight of a key's owner relative to other owners; 0 means 1
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS weight DOUBLE PRECISION NOT NULL DEFAULT 0;
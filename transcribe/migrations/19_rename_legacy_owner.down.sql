@@ -0,0 +1,6 @@
+-- Keys issued under the default owner cannot be told apart from the
+-- api_key secret's, so this only undoes the rename for the jobs it owned.
+UPDATE jobs SET owner = 'authenticated' WHERE owner = 'default' AND (api_key_id IS NULL OR api_key_id = 'default');
+UPDATE batches SET owner = 'authenticated' WHERE owner = 'default';
+UPDATE job_usage SET owner = 'authenticated' WHERE owner = 'default' AND (api_key_id IS NULL OR api_key_id = 'default');
+UPDATE webhook_deliveries SET owner = 'authenticated' WHERE owner = 'default';
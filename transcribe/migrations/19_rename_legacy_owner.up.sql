@@ -0,0 +1,12 @@
+-- The api_key secret's jobs were owned by "authenticated"; both servers now
+-- record them under "default", the owner of the configured keys.
+UPDATE jobs SET owner = 'default' WHERE owner = 'authenticated';
+UPDATE batches SET owner = 'default' WHERE owner = 'authenticated';
+UPDATE job_usage SET owner = 'default' WHERE owner = 'authenticated';
+UPDATE webhook_deliveries SET owner = 'default' WHERE owner = 'authenticated';
+UPDATE idempotency_keys SET owner = 'default'
+WHERE owner = 'authenticated'
+  AND NOT EXISTS (
+    SELECT 1 FROM idempotency_keys taken
+    WHERE taken.owner = 'default' AND taken.key = idempotency_keys.key
+  );
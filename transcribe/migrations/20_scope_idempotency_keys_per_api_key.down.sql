@@ -0,0 +1,2 @@
+DELETE FROM idempotency_keys;
+ALTER TABLE idempotency_keys RENAME COLUMN api_key_id TO owner;
@@ -0,0 +1,5 @@
+-- Idempotency keys were scoped per owner, so keys of one owner shared them.
+-- Stored keys cannot be attributed to the API key that used them; they
+-- expire within the idempotency window anyway, so they are dropped.
+DELETE FROM idempotency_keys;
+ALTER TABLE idempotency_keys RENAME COLUMN owner TO api_key_id;
@@ -0,0 +1,4 @@
+-- Remove job ownership column
+DROP INDEX IF EXISTS idx_jobs_owner_created_id;
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS owner;
@@ -0,0 +1,5 @@
+-- Record which API key submitted each job so listings can be scoped per caller
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+
+-- Listings page through (owner, created_at DESC, id DESC)
+CREATE INDEX IF NOT EXISTS idx_jobs_owner_created_id ON jobs(owner, created_at DESC, id DESC);
@@ -0,0 +1,7 @@
+-- Remove batches
+DROP INDEX IF EXISTS idx_batches_owner_created;
+DROP INDEX IF EXISTS idx_jobs_batch_id;
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS batch_id;
+
+DROP TABLE IF EXISTS batches;
@@ -0,0 +1,20 @@
+-- Restore legacy job status names
+ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_status_check;
+ALTER TABLE jobs DROP COLUMN IF EXISTS stage_started_at;
+
+UPDATE jobs SET status = 'pending' WHERE status = 'queued';
+UPDATE jobs SET status = 'complete' WHERE status = 'completed';
+UPDATE jobs SET status = 'error' WHERE status IN ('failed', 'cancelled');
+
+CREATE OR REPLACE VIEW current_job_stats AS
+SELECT
+    COUNT(*) as total_jobs,
+    COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed_jobs,
+    COUNT(CASE WHEN status = 'running' THEN 1 END) as running_jobs,
+    COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending_jobs,
+    COUNT(CASE WHEN status = 'error' THEN 1 END) as failed_jobs,
+    AVG(CASE WHEN status = 'completed' AND completed_at IS NOT NULL
+        THEN EXTRACT(EPOCH FROM (completed_at - created_at)) END) as avg_processing_time_seconds,
+    COUNT(CASE WHEN created_at >= CURRENT_DATE THEN 1 END) as jobs_today,
+    COUNT(CASE WHEN created_at >= CURRENT_DATE - INTERVAL '7 days' THEN 1 END) as jobs_this_week
+FROM jobs;
@@ -0,0 +1,75 @@
+-- Normalize job statuses to queued/running/completed/failed/cancelled and
+-- track when the current pipeline stage started
+UPDATE jobs SET status = 'queued' WHERE status = 'pending';
+UPDATE jobs SET status = 'completed' WHERE status = 'complete';
+UPDATE jobs SET status = 'failed' WHERE status = 'error';
+UPDATE jobs SET status = 'running' WHERE status IN ('downloading', 'extracting', 'transcribing');
+
+ALTER TABLE jobs ADD CONSTRAINT jobs_status_check
+    CHECK (status IN ('queued', 'running', 'completed', 'failed', 'cancelled'));
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS stage_started_at TIMESTAMP WITH TIME ZONE;
+
+-- Rebuild dashboard stats on the normalized status names
+CREATE OR REPLACE VIEW current_job_stats AS
+SELECT
+    COUNT(*) as total_jobs,
+    COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed_jobs,
+    COUNT(CASE WHEN status = 'running' THEN 1 END) as running_jobs,
+    COUNT(CASE WHEN status = 'queued' THEN 1 END) as pending_jobs,
+    COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed_jobs,
+    AVG(CASE WHEN status = 'completed' AND completed_at IS NOT NULL
+        THEN EXTRACT(EPOCH FROM (completed_at - created_at)) END) as avg_processing_time_seconds,
+    COUNT(CASE WHEN created_at >= CURRENT_DATE THEN 1 END) as jobs_today,
+    COUNT(CASE WHEN created_at >= CURRENT_DATE - INTERVAL '7 days' THEN 1 END) as jobs_this_week
+FROM jobs;
+
+CREATE OR REPLACE FUNCTION update_business_metrics()
+RETURNS void AS $$
+DECLARE
+    target_date DATE := CURRENT_DATE;
+    job_stats RECORD;
+    revenue_per_job NUMERIC := 2.50;
+BEGIN
+    -- Get job statistics for the target date
+    SELECT
+        COUNT(*) as total,
+        COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed,
+        COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed,
+        COUNT(DISTINCT NULLIF(owner, '')) as unique_users,
+        AVG(CASE WHEN status = 'completed' AND completed_at IS NOT NULL
+            THEN EXTRACT(EPOCH FROM (completed_at - created_at)) END) as avg_processing_time,
+        SUM(CASE WHEN status = 'completed'
+            THEN COALESCE(EXTRACT(EPOCH FROM duration::INTERVAL), 0)
+            ELSE 0 END) as total_video_seconds
+    INTO job_stats
+    FROM jobs
+    WHERE created_at >= target_date AND created_at < target_date + INTERVAL '1 day';
+
+    -- Insert or update business metrics
+    INSERT INTO business_metrics (
+        date, total_jobs, completed_jobs, failed_jobs,
+        revenue_usd, unique_users, avg_processing_time_seconds,
+        total_video_duration_seconds, updated_at
+    ) VALUES (
+        target_date,
+        COALESCE(job_stats.total, 0),
+        COALESCE(job_stats.completed, 0),
+        COALESCE(job_stats.failed, 0),
+        COALESCE(job_stats.completed, 0) * revenue_per_job,
+        COALESCE(job_stats.unique_users, 0),
+        COALESCE(job_stats.avg_processing_time, 0),
+        COALESCE(job_stats.total_video_seconds, 0),
+        NOW()
+    )
+    ON CONFLICT (date) DO UPDATE SET
+        total_jobs = EXCLUDED.total_jobs,
+        completed_jobs = EXCLUDED.completed_jobs,
+        failed_jobs = EXCLUDED.failed_jobs,
+        revenue_usd = EXCLUDED.revenue_usd,
+        unique_users = EXCLUDED.unique_users,
+        avg_processing_time_seconds = EXCLUDED.avg_processing_time_seconds,
+        total_video_duration_seconds = EXCLUDED.total_video_duration_seconds,
+        updated_at = NOW();
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,2 @@
+-- Remove job priority column
+ALTER TABLE jobs DROP COLUMN IF EXISTS priority;
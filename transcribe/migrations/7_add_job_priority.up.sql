@@ -0,0 +1,3 @@
+-- Per-job priority; orders a key's own jobs against each other
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'normal'
+    CHECK (priority IN ('low', 'normal', 'high'));
@@ -0,0 +1,2 @@
+-- Remove idempotency key storage
+DROP TABLE IF EXISTS idempotency_keys;
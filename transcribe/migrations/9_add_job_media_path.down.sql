@@ -0,0 +1,2 @@
+-- Remove job media path column
+ALTER TABLE jobs DROP COLUMN IF EXISTS media_path;
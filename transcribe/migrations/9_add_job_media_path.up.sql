@@ -0,0 +1,2 @@
+-- Local media file for jobs created from uploads
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS media_path TEXT NOT NULL DEFAULT '';
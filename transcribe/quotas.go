@@ -0,0 +1,267 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/storage/sqldb"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// Periods of the quota_usage counters.
+const (
+	periodDay   = "day"
+	periodMonth = "month"
+)
+
+// defaultQuota applies to keys that do not set their own.
+func defaultQuota() models.Quota {
+	return models.Quota{
+		JobsPerDay:           cfg.FreeJobLimit,
+		MediaMinutesPerMonth: cfg.QuotaMediaMinutes,
+		ConcurrentJobs:       cfg.QuotaConcurrentJobs,
+		MaxMediaSeconds:      cfg.MaxVideoLength,
+	}
+}
+
+// callerQuota returns the quota applied to the caller's submissions.
+func callerQuota() models.Quota {
+	data, _ := auth.Data().(*AuthData)
+	if data == nil {
+		return defaultQuota()
+	}
+	return lib.QuotaFor(&lib.APIKey{Quota: data.Quota}, defaultQuota())
+}
+
+// quotaDetails accompanies errors for submissions over quota.
+type quotaDetails struct {
+	Limit     string     `json:"limit"`
+	Remaining int        `json:"remaining"`
+	ResetsAt  *time.Time `json:"resets_at,omitempty"`
+}
+
+func (quotaDetails) ErrDetails() {}
+
+// quotaError converts a *models.QuotaExceededError in err's chain into the
+// error returned to clients. Encore has no 402 code, so every quota
+// rejection of a typed endpoint is ResourceExhausted (429).
+func quotaError(err error) error {
+	var exceeded *models.QuotaExceededError
+	if !errors.As(err, &exceeded) {
+		return err
+	}
+	details := quotaDetails{Limit: exceeded.Limit, Remaining: exceeded.Remaining}
+	if !exceeded.ResetsAt.IsZero() {
+		details.ResetsAt = &exceeded.ResetsAt
+	}
+	return &errs.Error{
+		Code:    errs.ResourceExhausted,
+		Message: "Quota exceeded: " + exceeded.Limit,
+		Details: details,
+	}
+}
+
+// admitJobs counts jobs costing minutes media minutes against the caller's
+// quota inside tx. It returns a *models.QuotaExceededError when they do not
+// fit.
+func admitJobs(ctx context.Context, tx *sqldb.Tx, jobs, minutes int) error {
+	data, _ := auth.Data().(*AuthData)
+	if data == nil {
+		return nil
+	}
+	quota := callerQuota()
+	return chargeQuota(ctx, tx, data.KeyID, jobs, minutes, func(usage models.QuotaUsage) *models.QuotaExceededError {
+		return quota.Check(usage, jobs, minutes)
+	})
+}
+
+// admitBatch counts a batch's children against the caller's quota inside
+// tx like admitJobs, with the batch taking a single concurrent job.
+func admitBatch(ctx context.Context, tx *sqldb.Tx, children int) error {
+	data, _ := auth.Data().(*AuthData)
+	if data == nil {
+		return nil
+	}
+	quota := callerQuota()
+	return chargeQuota(ctx, tx, data.KeyID, children, 0, func(usage models.QuotaUsage) *models.QuotaExceededError {
+		return quota.CheckBatch(usage, children)
+	})
+}
+
+// admitSync counts a job transcribed synchronously, which is never stored,
+// against the caller's quota.
+func admitSync(ctx context.Context, minutes int) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := admitJobs(ctx, tx, 1, minutes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// chargeBatchChild checks the length of a batch child's media and charges
+// its media minutes, neither known when the batch is admitted, once a
+// worker picks it up. It returns a download.too_long error or a
+// *models.QuotaExceededError when the media does not fit.
+func chargeBatchChild(ctx context.Context, job *models.Job) error {
+	if job.BatchID == "" || job.APIKeyID == "" {
+		return nil
+	}
+	duration, err := lib.GetVideoDuration(job.URL)
+	if err != nil || duration == 0 {
+		// The pipeline reports unreachable media itself, and media of
+		// unknown length is charged once it has run.
+		return nil
+	}
+	// Stored with the job's next update, for billing.
+	job.MediaSeconds = duration
+	return chargeMedia(ctx, job.APIKeyID, duration)
+}
+
+// chargeMeasuredMedia checks the length of a job's media and charges its
+// media minutes once it has run, when the length was not known before, as
+// with streams that report no duration. The length is the end of the last
+// segment.
+func chargeMeasuredMedia(ctx context.Context, job *models.Job, segments []models.Segment) error {
+	if job.MediaSeconds > 0 || len(segments) == 0 || job.APIKeyID == "" {
+		return nil
+	}
+	return chargeMedia(ctx, job.APIKeyID, int(math.Ceil(segments[len(segments)-1].End)))
+}
+
+// chargeMedia checks media lasting seconds against keyID's length limit and
+// charges its minutes to the key's monthly media, for a job admitted before
+// its length was known.
+func chargeMedia(ctx context.Context, keyID string, seconds int) error {
+	quota := defaultQuota()
+	key, err := apiKeys().Get(ctx, keyID)
+	switch {
+	case err == nil:
+		quota = lib.QuotaFor(key, quota)
+	case !errors.Is(err, lib.ErrAPIKeyNotFound):
+		return err
+	}
+	if err := quota.CheckLength(seconds); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The job was already admitted, so only its media counts.
+	media := models.Quota{MediaMinutesPerMonth: quota.MediaMinutesPerMonth}
+	minutes := models.MediaMinutes(seconds)
+	err = chargeQuota(ctx, tx, keyID, 0, minutes, func(usage models.QuotaUsage) *models.QuotaExceededError {
+		return media.Check(usage, 0, minutes)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// chargeQuota counts jobs and minutes against keyID's quota inside tx if
+// check finds they fit, or returns the *models.QuotaExceededError it
+// reports. A per-key advisory lock held until tx ends serializes charges,
+// so concurrent submissions cannot both take the last of a quota.
+func chargeQuota(ctx context.Context, tx *sqldb.Tx, keyID string, jobs, minutes int, check func(models.QuotaUsage) *models.QuotaExceededError) error {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, keyID); err != nil {
+		return err
+	}
+	now := time.Now()
+	usage, err := quotaUsage(ctx, tx, keyID, now)
+	if err != nil {
+		return err
+	}
+	if exceeded := check(usage); exceeded != nil {
+		return exceeded
+	}
+	return addQuotaUsage(ctx, tx, keyID, jobs, minutes, now)
+}
+
+type queryRower interface {
+	QueryRow(ctx context.Context, query string, args ...interface{}) *sqldb.Row
+}
+
+// quotaUsage reads a key's usage in the periods containing now. The
+// unfinished children of a batch count as one active job.
+func quotaUsage(ctx context.Context, q queryRower, keyID string, now time.Time) (models.QuotaUsage, error) {
+	day, month := models.DayStart(now), models.MonthStart(now)
+	usage := models.QuotaUsage{
+		DayResetsAt:   day.AddDate(0, 0, 1),
+		MonthResetsAt: month.AddDate(0, 1, 0),
+	}
+
+	err := q.QueryRow(ctx, `
+		SELECT
+			COALESCE((SELECT jobs FROM quota_usage WHERE key_id = $1 AND period = $2 AND starts_at = $3), 0),
+			COALESCE((SELECT media_minutes FROM quota_usage WHERE key_id = $1 AND period = $4 AND starts_at = $5), 0),
+			(SELECT COUNT(DISTINCT COALESCE(batch_id, id)) FROM jobs WHERE api_key_id = $1 AND status IN ('queued', 'running'))
+	`, keyID, periodDay, day, periodMonth, month).Scan(&usage.JobsToday, &usage.MediaMinutesMonth, &usage.ActiveJobs)
+	return usage, err
+}
+
+// addQuotaUsage adds jobs to the day's counter and minutes to the month's.
+func addQuotaUsage(ctx context.Context, ex execer, keyID string, jobs, minutes int, now time.Time) error {
+	for _, counter := range []struct {
+		period        string
+		startsAt      time.Time
+		jobs, minutes int
+	}{
+		{periodDay, models.DayStart(now), jobs, 0},
+		{periodMonth, models.MonthStart(now), 0, minutes},
+	} {
+		if counter.jobs == 0 && counter.minutes == 0 {
+			continue
+		}
+		_, err := ex.Exec(ctx, `
+			INSERT INTO quota_usage (key_id, period, starts_at, jobs, media_minutes)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (key_id, period, starts_at) DO UPDATE SET
+				jobs = quota_usage.jobs + EXCLUDED.jobs,
+				media_minutes = quota_usage.media_minutes + EXCLUDED.media_minutes
+		`, keyID, counter.period, counter.startsAt, counter.jobs, counter.minutes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUsage reports the caller's quota, what it has used and when each
+// period resets.
+//
+//encore:api auth method=GET path=/v1/usage tag:v1 tag:read
+func GetUsage(ctx context.Context) (*models.UsageResponse, error) {
+	data, _ := auth.Data().(*AuthData)
+	if data == nil {
+		return nil, &errs.Error{Code: errs.Unauthenticated, Message: "Invalid API key"}
+	}
+
+	usage, err := quotaUsage(ctx, db, data.KeyID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	quota := callerQuota()
+	return &models.UsageResponse{
+		KeyID:     data.KeyID,
+		Quota:     quota,
+		Usage:     usage,
+		Remaining: quota.Remaining(usage),
+	}, nil
+}
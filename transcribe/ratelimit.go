@@ -0,0 +1,101 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/middleware"
+	"encore.dev/rlog"
+
+	"omnitranscripts/lib"
+)
+
+// rateLimitBuckets holds this instance's token buckets.
+var rateLimitBuckets lib.RateLimitStore = lib.NewMemoryRateLimitStore()
+
+// defaultRateLimits apply to keys that do not set their own.
+func defaultRateLimits() lib.RateLimits {
+	return lib.RateLimits{
+		lib.RateLimitSubmit:   {PerMinute: cfg.RateLimitSubmit},
+		lib.RateLimitPoll:     {PerMinute: cfg.RateLimitPoll},
+		lib.RateLimitDownload: {PerMinute: cfg.RateLimitDownload},
+	}
+}
+
+// LimitSubmissions rate limits each key's submissions.
+//
+//encore:middleware target=tag:submit
+func LimitSubmissions(req middleware.Request, next middleware.Next) middleware.Response {
+	return rateLimited(req, next, lib.RateLimitSubmit)
+}
+
+// LimitPolling rate limits each key's reads of jobs, batches and usage.
+//
+//encore:middleware target=tag:read
+func LimitPolling(req middleware.Request, next middleware.Next) middleware.Response {
+	return rateLimited(req, next, lib.RateLimitPoll)
+}
+
+func rateLimited(req middleware.Request, next middleware.Next, class lib.RateLimitClass) middleware.Response {
+	result, limited := takeRateLimit(req.Context(), class)
+	if !limited {
+		return next(req)
+	}
+
+	var resp middleware.Response
+	if result.Allowed {
+		resp = next(req)
+	} else {
+		resp = middleware.Response{Err: &errs.Error{
+			Code:    errs.ResourceExhausted,
+			Message: "Rate limit exceeded",
+		}}
+	}
+	for name, value := range lib.RateLimitHeaders(result) {
+		resp.Header().Set(name, value)
+	}
+	return resp
+}
+
+// takeRateLimit takes a request of class from the caller's bucket. It
+// returns false when the caller is not limited.
+func takeRateLimit(ctx context.Context, class lib.RateLimitClass) (lib.RateLimitResult, bool) {
+	data, _ := auth.Data().(*AuthData)
+	if data == nil {
+		return lib.RateLimitResult{}, false
+	}
+	limit := defaultRateLimits().For(&lib.APIKey{RateLimits: data.RateLimits}, class)
+	if limit.PerMinute <= 0 {
+		return lib.RateLimitResult{}, false
+	}
+	result, err := rateLimitBuckets.Take(ctx, string(class)+":"+data.KeyID, limit, time.Now())
+	if err != nil {
+		// A failing store should not take the API down with it.
+		rlog.Error("rate limit store failed", "error", err)
+		return lib.RateLimitResult{}, false
+	}
+	return result, true
+}
+
+// rateLimitRaw takes a request of class from the caller's bucket for a raw
+// endpoint, which middleware does not cover. It rejects the request and
+// returns false when the bucket is empty.
+func rateLimitRaw(w http.ResponseWriter, req *http.Request, class lib.RateLimitClass) bool {
+	result, limited := takeRateLimit(req.Context(), class)
+	if !limited {
+		return true
+	}
+	for name, value := range lib.RateLimitHeaders(result) {
+		w.Header().Set(name, value)
+	}
+	if !result.Allowed {
+		writeJSONError(w, http.StatusTooManyRequests, "Rate limit exceeded, retry after "+w.Header().Get("Retry-After")+" seconds")
+		return false
+	}
+	return true
+}
@@ -6,14 +6,19 @@ package transcribe
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"os"
 	"time"
 
+	"encore.dev"
 	"encore.dev/beta/auth"
 	"encore.dev/beta/errs"
 	"encore.dev/config"
 	"encore.dev/pubsub"
 	"encore.dev/rlog"
 
+	"omnitranscripts/jobs"
 	"omnitranscripts/lib"
 	"omnitranscripts/models"
 )
@@ -22,42 +27,160 @@ import (
 var cfg = config.Load[Config]()
 
 type Config struct {
-	APIKey         string               `json:"api_key"`
-	WorkDir        string               `json:"work_dir"`
-	MaxVideoLength int                  `json:"max_video_length"`
-	FreeJobLimit   int                  `json:"free_job_limit"`
-	WebhookURL     string               `json:"webhook_url"`
-	WebhookSecret  string               `json:"webhook_secret"`
-	WebhookEvents  []string             `json:"webhook_events"`
+	APIKey         string   `json:"api_key"`
+	WorkDir        string   `json:"work_dir"`
+	MaxVideoLength int      `json:"max_video_length"`
+	FreeJobLimit   int      `json:"free_job_limit"`
+	WebhookURL     string   `json:"webhook_url"`
+	WebhookSecret  string   `json:"webhook_secret"`
+	WebhookEvents  []string `json:"webhook_events"`
+
+	// WebhookSecrets sign every webhook delivery in the
+	// X-OmniTranscripts-Signature header, which receivers check with
+	// lib.VerifyWebhookSignature. Listing several lets a secret be rotated;
+	// WebhookSecret signs alongside them.
+	WebhookSecrets []string `json:"webhook_secrets"`
+
+	// PublicBaseURL is the public root of the API, used for download links.
+	PublicBaseURL string `json:"public_base_url"`
+
+	// ArtifactSigningKeys are the comma-separated id:secret keys signing
+	// download links; the first signs and all verify. They are required,
+	// and the service fails to start without them.
+	ArtifactSigningKeys string `json:"artifact_signing_keys"`
+
+	// ArtifactURLTTLHours is how long signed download links stay valid;
+	// zero means 24 hours.
+	ArtifactURLTTLHours int `json:"artifact_url_ttl_hours"`
+
+	// MaxUploadMB caps files sent to /transcribe/upload; zero means 500.
+	MaxUploadMB int64 `json:"max_upload_mb"`
+
+	// IdempotencyWindowHours is how long Idempotency-Key values are
+	// remembered; zero means 24 hours.
+	IdempotencyWindowHours int `json:"idempotency_window_hours"`
+
+	// QuotaMediaMinutes and QuotaConcurrentJobs are the default monthly
+	// media minutes and concurrent jobs per API key, alongside FreeJobLimit
+	// jobs a day; zero is unlimited.
+	QuotaMediaMinutes   int `json:"quota_media_minutes"`
+	QuotaConcurrentJobs int `json:"quota_concurrent_jobs"`
+
+	// RateLimitSubmit, RateLimitPoll and RateLimitDownload are the default
+	// requests per minute per API key for submissions, reads and transcript
+	// downloads; zero is unlimited.
+	RateLimitSubmit   int `json:"rate_limit_submit"`
+	RateLimitPoll     int `json:"rate_limit_poll"`
+	RateLimitDownload int `json:"rate_limit_download"`
+
+	// SyncMaxDuration is the longest media, in seconds, transcribed while
+	// the request waits; zero means 120.
+	SyncMaxDuration int `json:"sync_max_duration"`
+
+	// LegacyDeprecatedAt is the date, as YYYY-MM-DD, the unversioned paths
+	// were deprecated, sent as their Deprecation header; it defaults to
+	// lib.DefaultLegacyDeprecatedAt.
+	LegacyDeprecatedAt string `json:"legacy_deprecated_at"`
+
+	// LegacySunset is the date, as YYYY-MM-DD, the deprecated unversioned
+	// paths stop working; it is sent as their Sunset header.
+	LegacySunset string `json:"legacy_sunset"`
 }
 
 // TranscribeRequest represents a transcription request.
 type TranscribeRequest struct {
-	URL string `json:"url"`
+	URL      string          `json:"url"`
+	Priority models.Priority `json:"priority,omitempty"`
+
+	// Webhooks here go to the webhook_url of the service's config, so the
+	// per-job fields are only read to reject them.
+	WebhookURL     string            `json:"webhook_url,omitempty"`
+	WebhookHeaders map[string]string `json:"webhook_headers,omitempty"`
+	WebhookEvents  []string          `json:"webhook_events,omitempty"`
+
+	models.TranscriptionOptions
+
+	// IdempotencyKey makes retries return the original response.
+	IdempotencyKey string `header:"Idempotency-Key" json:"-"`
+}
+
+// errJobWebhook rejects a submission that sets its own webhook, which this
+// deployment does not support.
+const errJobWebhook = "webhook_url, webhook_headers and webhook_events are not supported here; webhooks go to the service's configured webhook_url"
+
+// fieldErrors lists the invalid fields of a request in an error's details.
+type fieldErrors struct {
+	Fields []models.FieldError `json:"fields"`
+}
+
+func (fieldErrors) ErrDetails() {}
+
+// problemDetails carries a failure's RFC 7807 problem in an error's details.
+type problemDetails struct {
+	*models.Problem
+}
+
+func (problemDetails) ErrDetails() {}
+
+// problemError reports a transcription failure as an Encore error whose
+// details are the problem, tagged with the request's trace ID.
+func problemError(problem *models.Problem) error {
+	problem = problem.Clone()
+	if trace := encore.CurrentRequest().Trace; trace != nil {
+		problem.RequestID = trace.TraceID
+	}
+
+	code := errs.Internal
+	switch problem.Status {
+	case http.StatusUnprocessableEntity:
+		code = errs.InvalidArgument
+	case http.StatusConflict:
+		code = errs.Aborted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		code = errs.Unavailable
+	case http.StatusGatewayTimeout:
+		code = errs.DeadlineExceeded
+	}
+
+	return &errs.Error{
+		Code:    code,
+		Message: problem.Title,
+		Details: problemDetails{problem},
+	}
 }
 
 // TranscribeResponse represents the response from a transcription request.
 type TranscribeResponse struct {
-	JobID      string         `json:"job_id,omitempty"`
-	Transcript string         `json:"transcript,omitempty"`
+	JobID      string           `json:"job_id,omitempty"`
+	Transcript string           `json:"transcript,omitempty"`
 	Segments   []models.Segment `json:"segments,omitempty"`
 }
 
 // JobStatusResponse represents the response for job status queries.
 type JobStatusResponse struct {
-	ID            string           `json:"id"`
-	Status        string           `json:"status"`
-	Transcript    string           `json:"transcript,omitempty"`
-	Segments      []models.Segment `json:"segments,omitempty"`
-	Error         string           `json:"error,omitempty"`
-	CreatedAt     time.Time        `json:"created_at"`
-	CompletedAt   *time.Time       `json:"completed_at,omitempty"`
-	SubtitleFiles *SubtitleFiles   `json:"subtitle_files,omitempty"`
+	ID             string                       `json:"id"`
+	Status         string                       `json:"status"`
+	Priority       models.Priority              `json:"priority,omitempty"`
+	Options        *models.TranscriptionOptions `json:"options,omitempty"`
+	Stage          string                       `json:"stage,omitempty"`
+	Transcript     string                       `json:"transcript,omitempty"`
+	Segments       []models.Segment             `json:"segments,omitempty"`
+	Error          string                       `json:"error,omitempty"`
+	Problem        *models.Problem              `json:"problem,omitempty"`
+	CreatedAt      time.Time                    `json:"created_at"`
+	UpdatedAt      time.Time                    `json:"updated_at"`
+	StartedAt      *time.Time                   `json:"started_at,omitempty"`
+	StageStartedAt *time.Time                   `json:"stage_started_at,omitempty"`
+	CompletedAt    *time.Time                   `json:"completed_at,omitempty"`
+	SubtitleFiles  *SubtitleFiles               `json:"subtitle_files,omitempty"`
 }
 
 type SubtitleFiles struct {
-	SRTURL string `json:"srt_url,omitempty"`
-	VTTURL string `json:"vtt_url,omitempty"`
+	TXTURL    string     `json:"txt_url,omitempty"`
+	SRTURL    string     `json:"srt_url,omitempty"`
+	VTTURL    string     `json:"vtt_url,omitempty"`
+	JSONURL   string     `json:"json_url,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 // Health endpoint that doesn't require authentication.
@@ -75,10 +198,25 @@ type HealthResponse struct {
 	Message string `json:"message"`
 }
 
+// syncMaxDuration is the longest media, in seconds, transcribed while the
+// request waits.
+func syncMaxDuration() int {
+	if cfg.SyncMaxDuration > 0 {
+		return cfg.SyncMaxDuration
+	}
+	return 120
+}
+
 // Transcribe transcribes a YouTube video.
 //
-//encore:api auth method=POST path=/transcribe
+//encore:api auth method=POST path=/v1/transcribe tag:v1 tag:submit
 func Transcribe(ctx context.Context, req *TranscribeRequest) (*TranscribeResponse, error) {
+	return withIdempotency(ctx, req.IdempotencyKey, "transcribe", req, func() (*TranscribeResponse, error) {
+		return transcribe(ctx, req)
+	})
+}
+
+func transcribe(ctx context.Context, req *TranscribeRequest) (*TranscribeResponse, error) {
 	rlog.Info("transcribe request", "url", req.URL)
 
 	// Validate URL
@@ -89,31 +227,69 @@ func Transcribe(ctx context.Context, req *TranscribeRequest) (*TranscribeRespons
 		}
 	}
 
+	if !req.Priority.Valid() {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Invalid priority, expected low, normal or high",
+		}
+	}
+
+	if req.WebhookURL != "" || len(req.WebhookHeaders) > 0 || len(req.WebhookEvents) > 0 {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: errJobWebhook,
+		}
+	}
+
+	if problems := req.TranscriptionOptions.Validate(); len(problems) > 0 {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Invalid transcription options",
+			Details: fieldErrors{Fields: problems},
+		}
+	}
+
 	// Get video duration to determine processing strategy
 	duration, err := lib.GetVideoDuration(req.URL)
 	if err != nil {
 		rlog.Error("failed to get video duration", "error", err, "url", req.URL)
-		return nil, &errs.Error{
-			Code:    errs.InvalidArgument,
-			Message: "Failed to get video information",
-		}
+		return nil, problemError(models.NewProblem(err))
+	}
+	if err := callerQuota().CheckLength(duration); err != nil {
+		return nil, problemError(models.NewProblem(err))
 	}
 
 	// Create job
 	job := models.NewJob(req.URL)
+	if uid, ok := auth.UserID(); ok {
+		job.Owner = string(uid)
+	}
+	if data, ok := auth.Data().(*AuthData); ok {
+		job.APIKeyID = data.KeyID
+	}
+	job.Options = req.TranscriptionOptions
+	if req.Priority != "" {
+		job.Priority = req.Priority
+	}
+	job.MediaSeconds = duration
+	minutes := models.MediaMinutes(duration)
 
-	// For short videos (≤2 min), process synchronously
-	if duration <= 120 {
+	// Short videos are processed synchronously, but not media of unknown
+	// length
+	if duration > 0 && duration <= syncMaxDuration() {
 		rlog.Info("processing video synchronously", "duration", duration, "job_id", job.ID)
 
-		transcript, segments, err := lib.ProcessTranscription(req.URL, job.ID)
+		if err := admitSync(ctx, minutes); err != nil {
+			return nil, quotaError(err)
+		}
+
+		transcript, segments, err := lib.ProcessTranscriptionWithStages(ctx, req.URL, job.ID, lib.TenantDir(cfg.WorkDir, job.Owner), job.Options, lib.Progress{})
 		if err != nil {
 			rlog.Error("transcription failed", "error", err, "job_id", job.ID)
-			return nil, &errs.Error{
-				Code:    errs.Internal,
-				Message: "Transcription failed",
-			}
+			return nil, problemError(models.NewProblem(err))
 		}
+		// Synchronous jobs are never stored, but are billed all the same.
+		recordJobUsage(ctx, job)
 
 		return &TranscribeResponse{
 			Transcript: transcript,
@@ -125,8 +301,8 @@ func Transcribe(ctx context.Context, req *TranscribeRequest) (*TranscribeRespons
 	rlog.Info("queueing video for async processing", "duration", duration, "job_id", job.ID)
 
 	// Store job in database and publish to queue
-	if err := storeJob(ctx, job); err != nil {
-		return nil, err
+	if err := storeAdmittedJob(ctx, job, minutes); err != nil {
+		return nil, quotaError(err)
 	}
 
 	// Publish job to processing queue
@@ -141,10 +317,11 @@ func Transcribe(ctx context.Context, req *TranscribeRequest) (*TranscribeRespons
 
 // GetJob retrieves the status and result of a transcription job.
 //
-//encore:api auth method=GET path=/transcribe/:id
+//encore:api auth method=GET path=/v1/transcribe/:id tag:v1 tag:read
 func GetJob(ctx context.Context, id string) (*JobStatusResponse, error) {
+	uid, _ := auth.UserID()
 	job, err := getJob(ctx, id)
-	if err != nil {
+	if err != nil || job.Owner != string(uid) {
 		return nil, &errs.Error{
 			Code:    errs.NotFound,
 			Message: "Job not found",
@@ -152,34 +329,160 @@ func GetJob(ctx context.Context, id string) (*JobStatusResponse, error) {
 	}
 
 	response := &JobStatusResponse{
-		ID:        job.ID,
-		Status:    string(job.Status),
-		CreatedAt: job.CreatedAt,
+		ID:             job.ID,
+		Status:         string(job.Status),
+		Priority:       job.Priority,
+		Stage:          job.Stage,
+		CreatedAt:      job.CreatedAt,
+		UpdatedAt:      job.UpdatedAt,
+		StartedAt:      job.StartedAt,
+		StageStartedAt: job.StageStartedAt,
+		CompletedAt:    job.CompletedAt,
+	}
+	if !job.Options.IsZero() {
+		response.Options = &job.Options
 	}
 
-	if job.Status == models.StatusComplete {
+	switch job.Status {
+	case models.StatusCompleted:
 		response.Transcript = job.Transcript
 		response.Segments = job.Segments
-		response.CompletedAt = job.CompletedAt
-	} else if job.Status == models.StatusError {
+		files := lib.NewSubtitleFiles(cfg.PublicBaseURL, urlSigner, job)
+		response.SubtitleFiles = &SubtitleFiles{
+			TXTURL:    files.TXTURL,
+			SRTURL:    files.SRTURL,
+			VTTURL:    files.VTTURL,
+			JSONURL:   files.JSONURL,
+			ExpiresAt: files.ExpiresAt,
+		}
+	case models.StatusFailed:
 		response.Error = job.Error
-		response.CompletedAt = job.CompletedAt
+		response.Problem = job.Problem
 	}
 
 	return response, nil
 }
 
-// AuthHandler validates API key authentication.
+// CancelJob cancels one of the caller's queued or running jobs. A running
+// job stops before its next stage, or at once while downloading, and any
+// result it produced is dropped.
+//
+//encore:api auth method=POST path=/v1/transcribe/:id/cancel tag:v1 tag:submit
+func CancelJob(ctx context.Context, id string) (*models.JobSummary, error) {
+	uid, _ := auth.UserID()
+	job, err := getJob(ctx, id)
+	if err != nil || job.Owner != string(uid) {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "Job not found",
+		}
+	}
+
+	from := job.Status
+	if err := job.Cancel(); err != nil {
+		return nil, &errs.Error{
+			Code:    errs.Aborted,
+			Message: "Job has already finished",
+		}
+	}
+	if err := updateJob(ctx, job, from); err != nil {
+		if errors.Is(err, models.ErrInvalidTransition) {
+			return nil, &errs.Error{
+				Code:    errs.Aborted,
+				Message: "Job changed status while being cancelled, retry the request",
+			}
+		}
+		return nil, err
+	}
+
+	if from == models.StatusQueued {
+		// Workers skip finished jobs, so clean up after it here.
+		if job.MediaPath != "" {
+			os.Remove(job.MediaPath)
+		}
+		if job.BatchID != "" {
+			finishBatchChild(ctx, job.BatchID)
+		}
+	}
+
+	summary := job.Summary()
+	return &summary, nil
+}
+
+// ListJobsParams holds the filters for listing jobs.
+type ListJobsParams struct {
+	Status        string `query:"status"`
+	CreatedAfter  string `query:"created_after"`
+	CreatedBefore string `query:"created_before"`
+	Host          string `query:"host"`
+	Q             string `query:"q"`
+	Cursor        string `query:"cursor"`
+	Limit         int    `query:"limit"`
+}
+
+// ListJobs lists the caller's transcription jobs, newest first.
 //
-//encore:authhandler
-func AuthHandler(ctx context.Context, token string) (auth.UID, error) {
-	if token != cfg.APIKey {
-		return "", &errs.Error{
-			Code:    errs.Unauthenticated,
-			Message: "Invalid API key",
+//encore:api auth method=GET path=/v1/transcribe tag:v1 tag:read
+func ListJobs(ctx context.Context, params *ListJobsParams) (*models.JobListResponse, error) {
+	uid, _ := auth.UserID()
+	filter := jobs.ListFilter{
+		Owner:  string(uid),
+		Status: jobs.JobStatus(params.Status),
+		Host:   params.Host,
+		Query:  params.Q,
+		Cursor: params.Cursor,
+		Limit:  params.Limit,
+	}
+
+	if filter.Status != "" && !filter.Status.Valid() {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Invalid status filter",
+		}
+	}
+
+	for name, pair := range map[string]struct {
+		value  string
+		target *time.Time
+	}{
+		"created_after":  {params.CreatedAfter, &filter.CreatedAfter},
+		"created_before": {params.CreatedBefore, &filter.CreatedBefore},
+	} {
+		if pair.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, pair.value)
+		if err != nil {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: "Invalid " + name + ", expected RFC 3339 timestamp",
+			}
 		}
+		*pair.target = t
 	}
-	return auth.UID("authenticated"), nil
+
+	if filter.Cursor != "" {
+		if _, err := jobs.DecodeCursor(filter.Cursor); err != nil {
+			return nil, &errs.Error{
+				Code:    errs.InvalidArgument,
+				Message: "Invalid cursor",
+			}
+		}
+	}
+
+	summaries, next, err := listJobs(ctx, filter)
+	if err != nil {
+		rlog.Error("failed to list jobs", "error", err)
+		return nil, &errs.Error{
+			Code:    errs.Internal,
+			Message: "Failed to list jobs",
+		}
+	}
+
+	return &models.JobListResponse{
+		Jobs:       summaries,
+		NextCursor: next,
+	}, nil
 }
 
 // Topic for job processing
@@ -198,48 +501,128 @@ var _ = pubsub.NewSubscription(jobTopic, "process-jobs", pubsub.SubscriptionConf
 	Handler: processJobAsync,
 })
 
+// webhookConfig builds the delivery settings for the given URL from the
+// service configuration, for webhooks about owner's jobs and batches.
+func webhookConfig(url, owner string) lib.WebhookConfig {
+	webhookConfig := lib.WebhookConfig{
+		URL:        url,
+		Events:     cfg.WebhookEvents,
+		Timeout:    10 * time.Second,
+		Retries:    3,
+		BaseURL:    cfg.PublicBaseURL,
+		Dispatcher: webhookDispatcher,
+		Owner:      owner,
+		Signer:     urlSigner,
+	}
+	webhookConfig.Secrets = webhookSecrets()
+	return webhookConfig
+}
+
+// webhookSecrets returns every secret deliveries are signed with.
+func webhookSecrets() []string {
+	secrets := append([]string(nil), cfg.WebhookSecrets...)
+	if cfg.WebhookSecret != "" {
+		secrets = append(secrets, cfg.WebhookSecret)
+	}
+	return secrets
+}
+
 // processJobAsync processes a job asynchronously.
-func processJobAsync(ctx context.Context, job *models.Job) error {
+func processJobAsync(ctx context.Context, msg *models.Job) error {
 	startTime := time.Now()
-	rlog.Info("processing job async", "job_id", job.ID, "url", job.URL)
+	rlog.Info("processing job async", "job_id", msg.ID, "url", msg.URL)
 
-	// Initialize webhook manager if configured
-	var webhookManager *lib.WebhookManager
-	if cfg.WebhookURL != "" {
-		webhookConfig := lib.WebhookConfig{
-			URL:     cfg.WebhookURL,
-			Events:  cfg.WebhookEvents,
-			Timeout: 10 * time.Second,
-			Retries: 3,
+	// The message is a snapshot from submission time and may be redelivered,
+	// so work from the stored job and skip anything already finished.
+	job, err := getJob(ctx, msg.ID)
+	if err != nil {
+		return err
+	}
+	if job.IsTerminal() {
+		rlog.Info("skipping finished job", "job_id", job.ID, "status", job.Status)
+		return nil
+	}
+
+	// Mark job as running. A job left running by a crashed worker is picked
+	// up again as is.
+	if job.Status == models.StatusQueued {
+		draining, err := workersDraining(ctx)
+		if err != nil {
+			return err
+		}
+		if draining {
+			// ResumeWorkers publishes the job again.
+			rlog.Info("workers draining, leaving job queued", "job_id", job.ID)
+			return nil
+		}
+		if err := job.Start(); err != nil {
+			return err
 		}
-		if cfg.WebhookSecret != "" {
-			webhookConfig.Headers = map[string]string{
-				"X-Webhook-Secret": cfg.WebhookSecret,
+		if err := updateJob(ctx, job, models.StatusQueued); err != nil {
+			if errors.Is(err, models.ErrInvalidTransition) {
+				// Another delivery claimed the job first.
+				return nil
 			}
+			return err
 		}
-		webhookManager = lib.NewWebhookManager(webhookConfig)
+	}
+
+	// Initialize webhook manager if configured
+	var webhookManager *lib.WebhookManager
+	if cfg.WebhookURL != "" {
+		webhookManager = lib.NewWebhookManager(webhookConfig(cfg.WebhookURL, job.Owner))
 
 		// Send job started webhook
 		webhookManager.SendJobStarted(ctx, job)
 	}
 
-	// Mark job as running
-	job.MarkRunning()
-	if err := updateJob(ctx, job); err != nil {
-		return err
+	// Process transcription. Recording each stage fails once the job is no
+	// longer running, having been cancelled or failed by an operator, which
+	// stops the pipeline before that stage.
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	onStage := func(stage string) {
+		if err := job.EnterStage(stage); err == nil {
+			if err := updateJob(ctx, job, models.StatusRunning); errors.Is(err, models.ErrInvalidTransition) {
+				stop()
+			}
+		}
+	}
+	var transcript string
+	var segments []models.Segment
+	workDir := lib.TenantDir(cfg.WorkDir, job.Owner)
+	err = chargeBatchChild(ctx, job)
+	switch {
+	case err != nil:
+	case job.MediaPath != "":
+		transcript, segments, err = lib.ProcessMediaFileWithStages(runCtx, job.MediaPath, job.ID, workDir, job.Options, lib.Progress{OnStage: onStage})
+		os.Remove(job.MediaPath)
+	default:
+		transcript, segments, err = lib.ProcessTranscriptionWithStages(runCtx, job.URL, job.ID, workDir, job.Options, lib.Progress{OnStage: onStage})
+	}
+	if err == nil {
+		err = chargeMeasuredMedia(ctx, job, segments)
+	}
+	if err != nil && runCtx.Err() != nil && ctx.Err() == nil {
+		rlog.Info("job stopped after it was cancelled", "job_id", job.ID)
+		if job.BatchID != "" {
+			finishBatchChild(ctx, job.BatchID)
+		}
+		return nil
 	}
-
-	// Process transcription
-	transcript, segments, err := lib.ProcessTranscription(job.URL, job.ID)
 	if err != nil {
 		processingTime := time.Since(startTime)
 		rlog.Error("async transcription failed", "error", err, "job_id", job.ID)
-		job.MarkError(err)
-		updateJob(ctx, job)
+		if tErr := job.Fail(err); tErr == nil {
+			updateJob(ctx, job, models.StatusRunning)
+		}
 
 		// Send failure webhook
 		if webhookManager != nil {
-			webhookManager.SendJobFailed(ctx, job, err.Error(), processingTime)
+			webhookManager.SendJobFailed(ctx, job, job.Error, processingTime)
+		}
+		if job.BatchID != "" {
+			finishBatchChild(ctx, job.BatchID)
 		}
 		return err
 	}
@@ -247,7 +630,7 @@ func processJobAsync(ctx context.Context, job *models.Job) error {
 	// Generate subtitle files
 	var srtPath, vttPath string
 	if len(segments) > 0 {
-		outputDir := cfg.WorkDir
+		outputDir := lib.TenantDir(cfg.WorkDir, job.Owner)
 		srtPath, vttPath, err = lib.GenerateSubtitles(segments, outputDir, job.ID)
 		if err != nil {
 			rlog.Error("subtitle generation failed", "error", err, "job_id", job.ID)
@@ -258,17 +641,33 @@ func processJobAsync(ctx context.Context, job *models.Job) error {
 	}
 
 	// Mark job as complete
-	job.MarkComplete(transcript, segments)
-	if err := updateJob(ctx, job); err != nil {
+	if err := job.Complete(transcript, segments); err != nil {
+		return err
+	}
+	if err := updateJob(ctx, job, models.StatusRunning); err != nil {
+		if errors.Is(err, models.ErrInvalidTransition) {
+			// Cancelled while transcribing; drop the result.
+			rlog.Info("job changed status while processing", "job_id", job.ID)
+			if job.BatchID != "" {
+				finishBatchChild(ctx, job.BatchID)
+			}
+			return nil
+		}
 		return err
 	}
 
+	recordJobUsage(ctx, job)
+
 	// Send completion webhook
 	if webhookManager != nil {
 		processingTime := time.Since(startTime)
 		webhookManager.SendJobCompleted(ctx, job, srtPath, vttPath, processingTime)
 	}
 
+	if job.BatchID != "" {
+		finishBatchChild(ctx, job.BatchID)
+	}
+
 	rlog.Info("job completed successfully", "job_id", job.ID, "processing_time", time.Since(startTime))
 	return nil
-}
\ No newline at end of file
+}
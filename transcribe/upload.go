@@ -0,0 +1,131 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"encore.dev/beta/auth"
+	"encore.dev/rlog"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// TranscribeUpload accepts a media file as the "file" part of a multipart
+// form or as the raw request body, streams it to WorkDir and queues it for
+// transcription once ffprobe has accepted it. Uploads are always processed
+// asynchronously, so the worker must share WorkDir with the API.
+//
+//encore:api auth raw method=POST path=/v1/transcribe/upload
+func TranscribeUpload(w http.ResponseWriter, req *http.Request) {
+	w, done := meterRaw(w)
+	defer done()
+	w.Header().Set(lib.APIVersionHeader, "v1")
+	if !requireScopeRaw(w, lib.ScopeSubmit) || !rateLimitRaw(w, req, lib.RateLimitSubmit) {
+		return
+	}
+	query := req.URL.Query()
+
+	priority := models.Priority(query.Get("priority"))
+	if !priority.Valid() {
+		writeJSONError(w, http.StatusBadRequest, "Invalid priority, expected low, normal or high")
+		return
+	}
+
+	options, err := models.ParseOptions(query.Get("options"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid options, expected a JSON object")
+		return
+	}
+	if problems := options.Validate(); len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Invalid transcription options",
+			"fields": problems,
+		})
+		return
+	}
+
+	if query.Has("webhook_url") || query.Has("webhook_headers") || query.Has("webhook_events") {
+		writeJSONError(w, http.StatusBadRequest, errJobWebhook)
+		return
+	}
+
+	maxBytes := cfg.MaxUploadMB << 20
+	if maxBytes <= 0 {
+		maxBytes = 500 << 20
+	}
+
+	uid, _ := auth.UserID()
+	upload, err := lib.SaveUpload(req.Body, req.Header.Get("Content-Type"), query.Get("filename"),
+		filepath.Join(lib.TenantDir(cfg.WorkDir, string(uid)), "uploads"), maxBytes)
+	switch {
+	case errors.Is(err, lib.ErrUploadTooLarge):
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Upload exceeds the maximum size")
+		return
+	case errors.Is(err, lib.ErrUnsupportedMediaType):
+		writeJSONError(w, http.StatusUnsupportedMediaType, "Unsupported media type, expected an audio or video file")
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+
+	info, err := lib.ProbeMedia(upload.Path)
+	if err != nil {
+		os.Remove(upload.Path)
+		writeJSONError(w, http.StatusUnprocessableEntity, "Uploaded file is not a supported audio or video file")
+		return
+	}
+
+	if err := callerQuota().CheckLength(int(info.Duration)); err != nil {
+		os.Remove(upload.Path)
+		problem := models.NewProblem(err)
+		problem.Instance = req.URL.Path
+		writeProblem(w, problem)
+		return
+	}
+
+	job := models.NewJob(lib.UploadSourceURL(upload.Filename))
+	job.MediaPath = upload.Path
+	job.MediaSeconds = int(info.Duration)
+	job.Owner = string(uid)
+	job.Options = options
+	if data, ok := auth.Data().(*AuthData); ok {
+		job.APIKeyID = data.KeyID
+	}
+	if priority != "" {
+		job.Priority = priority
+	}
+
+	ctx := req.Context()
+	err = storeAdmittedJob(ctx, job, models.MediaMinutes(int(info.Duration)))
+	var exceeded *models.QuotaExceededError
+	switch {
+	case errors.As(err, &exceeded):
+		os.Remove(upload.Path)
+		writeJSONError(w, exceeded.Status(), "Quota exceeded: "+exceeded.Limit)
+		return
+	case err != nil:
+		os.Remove(upload.Path)
+		rlog.Error("failed to store upload job", "error", err, "job_id", job.ID)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to queue upload")
+		return
+	}
+	if err := publishJob(ctx, job); err != nil {
+		rlog.Error("failed to publish upload job", "error", err, "job_id", job.ID)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to queue upload")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", models.JobPath(job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(TranscribeResponse{JobID: job.ID})
+}
@@ -0,0 +1,245 @@
+//go:build encore
+
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/cron"
+	"encore.dev/storage/sqldb"
+
+	"omnitranscripts/lib"
+	"omnitranscripts/models"
+)
+
+// webhookDispatcher delivers every webhook through the webhook_deliveries
+// table.
+var webhookDispatcher = lib.NewWebhookDispatcher(sqlWebhookOutbox{}, webhookSecrets())
+
+// Retries failed webhook deliveries once they are due.
+var _ = cron.NewJob("deliver-webhooks", cron.JobConfig{
+	Title:    "Deliver due webhooks",
+	Every:    1 * cron.Minute,
+	Endpoint: DeliverWebhooks,
+})
+
+// DeliverWebhooks attempts every webhook delivery that is due and drops
+// delivered and dead-lettered ones past their retention.
+//
+//encore:api private
+func DeliverWebhooks(ctx context.Context) error {
+	_, err := db.Exec(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE (status = $1 AND delivered_at < $3) OR (status = $2 AND updated_at < $3)
+	`, models.DeliveryDelivered, models.DeliveryDead, time.Now().Add(-models.WebhookDeliveryRetention))
+	if err != nil {
+		return err
+	}
+	return webhookDispatcher.DeliverDue(ctx)
+}
+
+// WebhookDeliveryParams selects webhook deliveries.
+type WebhookDeliveryParams struct {
+	// Status keeps only deliveries that are pending, delivered or dead.
+	Status models.DeliveryStatus `query:"status"`
+	// Limit is the number of deliveries to return, up to 500.
+	Limit int `query:"limit"`
+}
+
+// ListWebhookDeliveries lists the caller's webhook deliveries, newest
+// first.
+//
+//encore:api auth method=GET path=/v1/webhooks/deliveries tag:v1 tag:read
+func ListWebhookDeliveries(ctx context.Context, params *WebhookDeliveryParams) (*models.WebhookDeliveryList, error) {
+	if params.Status != "" && !params.Status.Valid() {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: "Invalid status, expected pending, delivered or dead",
+		}
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = models.DefaultDeliveryListLimit
+	}
+	if limit < 1 || limit > models.MaxDeliveryListLimit {
+		return nil, &errs.Error{
+			Code:    errs.InvalidArgument,
+			Message: fmt.Sprintf("Invalid limit, expected 1 to %d", models.MaxDeliveryListLimit),
+		}
+	}
+
+	uid, _ := auth.UserID()
+	deliveries, err := (sqlWebhookOutbox{}).List(ctx, models.WebhookDeliveryFilter{
+		Owner:  string(uid),
+		Status: params.Status,
+		Limit:  limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.WebhookDeliveryList{Deliveries: deliveries}, nil
+}
+
+// ReplayWebhookDelivery delivers one of the caller's delivered or
+// dead-lettered webhooks again and returns it after the first attempt.
+//
+//encore:api auth method=POST path=/v1/webhooks/deliveries/:id/replay tag:v1 tag:submit
+func ReplayWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	uid, _ := auth.UserID()
+	delivery, err := (sqlWebhookOutbox{}).Get(ctx, id)
+	if errors.Is(err, lib.ErrWebhookDeliveryNotFound) || (err == nil && delivery.Owner != string(uid)) {
+		return nil, &errs.Error{
+			Code:    errs.NotFound,
+			Message: "Webhook delivery not found",
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := webhookDispatcher.Replay(ctx, delivery); err != nil {
+		if errors.Is(err, models.ErrDeliveryPending) {
+			return nil, &errs.Error{
+				Code:    errs.Aborted,
+				Message: "Webhook delivery is still pending",
+			}
+		}
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// sqlWebhookOutbox keeps webhook deliveries in the webhook_deliveries table.
+type sqlWebhookOutbox struct{}
+
+const webhookDeliveryColumns = `
+	id, owner, event, COALESCE(job_id, ''), COALESCE(batch_id, ''), url, headers, payload,
+	status, attempts, COALESCE(last_status_code, 0), COALESCE(last_error, ''),
+	created_at, updated_at, next_attempt_at, delivered_at
+`
+
+func (sqlWebhookOutbox) Put(ctx context.Context, d *models.WebhookDelivery) error {
+	var headers []byte
+	if len(d.Headers) > 0 {
+		var err error
+		if headers, err = json.Marshal(d.Headers); err != nil {
+			return err
+		}
+	}
+	var statusCode *int
+	if d.LastStatusCode != 0 {
+		statusCode = &d.LastStatusCode
+	}
+
+	_, err := db.Exec(ctx, `
+		INSERT INTO webhook_deliveries (id, owner, event, job_id, batch_id, url, headers, payload, status, attempts, last_status_code, last_error, created_at, updated_at, next_attempt_at, delivered_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (id) DO UPDATE SET
+			status = EXCLUDED.status,
+			attempts = EXCLUDED.attempts,
+			last_status_code = EXCLUDED.last_status_code,
+			last_error = EXCLUDED.last_error,
+			updated_at = EXCLUDED.updated_at,
+			next_attempt_at = EXCLUDED.next_attempt_at,
+			delivered_at = EXCLUDED.delivered_at
+	`, d.ID, d.Owner, d.Event, nullString(d.JobID), nullString(d.BatchID), d.URL, headers, []byte(d.Payload),
+		d.Status, d.Attempts, statusCode, nullString(d.LastError), d.CreatedAt, d.UpdatedAt, d.NextAttemptAt, d.DeliveredAt)
+	return err
+}
+
+func (sqlWebhookOutbox) Get(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	row := db.QueryRow(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = $1`, id)
+	d, err := scanWebhookDelivery(row)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, lib.ErrWebhookDeliveryNotFound
+	}
+	return d, err
+}
+
+// Claim leases the due rows in one statement. Rows another instance is
+// claiming at the same time are skipped rather than waited for, so each
+// delivery is attempted by one instance only.
+func (sqlWebhookOutbox) Claim(ctx context.Context, now, until time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := db.Query(ctx, `
+		UPDATE webhook_deliveries
+		SET next_attempt_at = $3
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $1 AND next_attempt_at <= $2
+			ORDER BY created_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+webhookDeliveryColumns,
+		models.DeliveryPending, now, until, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []*models.WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+	return due, nil
+}
+
+func (sqlWebhookOutbox) List(ctx context.Context, filter models.WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries
+		WHERE ($1 = '' OR owner = $1) AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, filter.Owner, filter.Status, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+// scanWebhookDelivery reads a row selected with webhookDeliveryColumns.
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var headers, payload []byte
+	err := row.Scan(
+		&d.ID, &d.Owner, &d.Event, &d.JobID, &d.BatchID, &d.URL, &headers, &payload,
+		&d.Status, &d.Attempts, &d.LastStatusCode, &d.LastError,
+		&d.CreatedAt, &d.UpdatedAt, &d.NextAttemptAt, &d.DeliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) > 0 {
+		if err := json.Unmarshal(headers, &d.Headers); err != nil {
+			return nil, err
+		}
+	}
+	d.Payload = payload
+	return &d, nil
+}
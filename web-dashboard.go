@@ -16,6 +16,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"omnitranscripts/models"
 )
 
 var startTime = time.Now()
@@ -1555,6 +1557,7 @@ const dashboardHTML = `
             const statusIconMap = {
                 'completed': '✅',
                 'failed': '❌',
+                'cancelled': '🚫',
                 'queued': '⏳',
                 'running': '🔄'
             };
@@ -1593,15 +1596,11 @@ const dashboardHTML = `
             updateStatProgress('queued-progress', stats.QueuedPercentage);
 
             // Update business metrics in main area
-            updateMetricValue('revenue-today', '$' + Math.floor(stats.RevenueToday));
             updateMetricValue('jobs-processed', stats.JobsToday);
-            updateMetricValue('avg-revenue-job', '$' + stats.AvgRevenuePerJob.toFixed(2));
             updateMetricValue('success-rate', stats.SuccessRate.toFixed(1) + '%');
 
             // Update growth trend
-            updateMetricChange('revenue-growth', stats.RevenueGrowth);
             updateJobTrend('jobs-trend', stats.JobsToday, stats.JobsYesterday);
-            updateRevenueInsight('revenue-insight', stats.AvgRevenuePerJob);
             updateSuccessStatus('success-status', stats.SuccessRate);
 
             // Update RapidAPI sidebar metrics
@@ -1636,9 +1635,7 @@ const dashboardHTML = `
                 const card = element.closest('.metric-card');
                 const label = card.querySelector('.metric-label');
                 if (label) {
-                    if ((className === 'revenue-today' && label.textContent.includes('Revenue Today')) ||
-                        (className === 'jobs-processed' && label.textContent.includes('Jobs Processed')) ||
-                        (className === 'avg-revenue-job' && label.textContent.includes('Avg Revenue/Job')) ||
+                    if ((className === 'jobs-processed' && label.textContent.includes('Jobs Processed')) ||
                         (className === 'success-rate' && label.textContent.includes('Success Rate'))) {
                         element.textContent = value;
                     }
@@ -1646,16 +1643,6 @@ const dashboardHTML = `
             });
         }
 
-        function updateMetricChange(className, growthValue) {
-            const elements = document.querySelectorAll('.metric-change');
-            elements.forEach(element => {
-                if (element.textContent.includes('↗')) {
-                    element.textContent = '↗ ' + growthValue.toFixed(1) + '%';
-                    element.className = growthValue >= 0 ? 'metric-change positive' : 'metric-change';
-                }
-            });
-        }
-
         function updateJobTrend(className, todayJobs, yesterdayJobs) {
             const elements = document.querySelectorAll('.metric-trend');
             elements.forEach(element => {
@@ -1669,15 +1656,6 @@ const dashboardHTML = `
             });
         }
 
-        function updateRevenueInsight(className, avgRevenue) {
-            const elements = document.querySelectorAll('.metric-insight');
-            elements.forEach(element => {
-                if (element.textContent.includes('tier')) {
-                    element.textContent = avgRevenue > 5.0 ? 'Premium tier' : 'Standard tier';
-                }
-            });
-        }
-
         function updateSuccessStatus(className, successRate) {
             const elements = document.querySelectorAll('.metric-status');
             elements.forEach(element => {
@@ -1804,7 +1782,7 @@ const dashboardHTML = `
             showDashboard();
             document.querySelector('.page-title').textContent = 'Processing Queue';
             document.querySelector('.page-subtitle').textContent = 'Jobs currently in the transcription queue';
-            filterJobsByStatus(['pending', 'running']);
+            filterJobsByStatus(['queued', 'running']);
         }
 
 
@@ -2082,6 +2060,7 @@ const transactionDetailHTML = `
         .status-failed { background: #fee2e2; color: #991b1b; }
         .status-queued { background: #fef3c7; color: #92400e; }
         .status-running { background: #dbeafe; color: #1e40af; }
+        .status-cancelled { background: #e5e7eb; color: #374151; }
 
         .detail-grid {
             display: grid;
@@ -2593,11 +2572,8 @@ type DashboardData struct {
 	Uptime           string
 	QueueHealth      string
 	QueueHealthClass string
-	// Business insights for monetization
-	RevenueToday       float64
-	RevenueGrowth      float64
-	AvgRevenuePerJob   float64
-	JobsYesterday      int
+	// Business insights
+	JobsYesterday int
 }
 
 func main() {
@@ -2668,14 +2644,14 @@ func dashboardHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Calculate basic job counts
 	for _, job := range jobs {
-		switch job.Status {
-		case "downloading", "extracting", "transcribing":
+		switch models.JobStatus(job.Status) {
+		case models.StatusRunning:
 			data.RunningJobs++
-		case "completed":
+		case models.StatusCompleted:
 			data.CompletedJobs++
-		case "failed":
+		case models.StatusFailed:
 			data.FailedJobs++
-		case "queued":
+		case models.StatusQueued:
 			data.QueuedJobs++
 		}
 	}
@@ -2716,7 +2692,7 @@ func calculatePerformanceMetrics(data *DashboardData, jobs []Job) {
 	var completedJobs int
 
 	for _, job := range jobs {
-		if job.Status == "completed" && !job.StartTime.IsZero() && !job.UpdateTime.IsZero() {
+		if job.Status == string(models.StatusCompleted) && !job.StartTime.IsZero() && !job.UpdateTime.IsZero() {
 			processingTime := job.UpdateTime.Sub(job.StartTime)
 			totalProcessingSeconds += int64(processingTime.Seconds())
 			completedJobs++
@@ -2741,7 +2717,7 @@ func calculatePerformanceMetrics(data *DashboardData, jobs []Job) {
 	// Calculate total storage used
 	var totalStorage int64
 	for _, job := range jobs {
-		if job.Status == "completed" {
+		if job.Status == string(models.StatusCompleted) {
 			outputDir := fmt.Sprintf("transcripts/%s", job.VideoID)
 			if files, err := os.ReadDir(outputDir); err == nil {
 				for _, file := range files {
@@ -2854,7 +2830,7 @@ func addJobHandler(w http.ResponseWriter, r *http.Request) {
 		VideoID:    videoID,
 		URL:        req.URL,
 		Title:      "Loading...",
-		Status:     "queued",
+		Status:     string(models.StatusQueued),
 		Progress:   0,
 		StartTime:  time.Now(),
 		UpdateTime: time.Now(),
@@ -3197,14 +3173,14 @@ func calculateDashboardData(jobs []Job) DashboardData {
 
 	// Calculate job statistics
 	for _, job := range jobs {
-		switch job.Status {
-		case "completed":
+		switch models.JobStatus(job.Status) {
+		case models.StatusCompleted:
 			data.CompletedJobs++
-		case "failed":
+		case models.StatusFailed:
 			data.FailedJobs++
-		case "queued":
+		case models.StatusQueued:
 			data.QueuedJobs++
-		case "downloading", "extracting", "transcribing", "running":
+		case models.StatusRunning:
 			data.RunningJobs++
 		}
 	}
@@ -3244,6 +3220,7 @@ func loadJobs() []Job {
 
 	// Set default values for new fields
 	for i := range jobs {
+		normalizeJobStatus(&jobs[i])
 		if jobs[i].CategoryClass == "" {
 			jobs[i].CategoryClass = "entertainment"
 		}
@@ -3258,6 +3235,20 @@ func loadJobs() []Job {
 	return jobs
 }
 
+// normalizeJobStatus rewrites legacy statuses from older jobs.json files,
+// where pipeline stages were stored as statuses, to the shared status set.
+func normalizeJobStatus(job *Job) {
+	switch job.Status {
+	case "downloading":
+		job.Stage = models.StageDownload
+	case "extracting":
+		job.Stage = models.StageNormalize
+	case "transcribing":
+		job.Stage = models.StageTranscribe
+	}
+	job.Status = string(models.NormalizeStatus(job.Status))
+}
+
 func saveJobs(jobs []Job) {
 	os.MkdirAll("logs", 0755)
 	data, _ := json.MarshalIndent(jobs, "", "  ")
@@ -3296,8 +3287,9 @@ func updateJobStatus(job *Job) {
 	// Check if transcription process is running
 	cmd := exec.Command("pgrep", "-f", fmt.Sprintf("transcribe.*%s", job.VideoID))
 	if output, err := cmd.Output(); err == nil && len(strings.TrimSpace(string(output))) > 0 {
-		status, progress := parseJobProgress(job)
+		status, stage, progress := parseJobProgress(job)
 		job.Status = status
+		job.Stage = stage
 		job.Progress = progress
 		job.UpdateTime = time.Now()
 		updateJobStats(job)
@@ -3308,9 +3300,10 @@ func updateJobStatus(job *Job) {
 	// Check if completed
 	outputDir := fmt.Sprintf("transcripts/%s", job.VideoID)
 	if files, err := os.ReadDir(outputDir); err == nil && len(files) > 0 {
-		if job.Status != "completed" {
+		if job.Status != string(models.StatusCompleted) {
 			// Only update status and stats if not already completed
-			job.Status = "completed"
+			job.Status = string(models.StatusCompleted)
+			job.Stage = ""
 			job.Progress = 100
 			job.UpdateTime = time.Now()
 			job.FileCount = len(files)
@@ -3321,27 +3314,34 @@ func updateJobStatus(job *Job) {
 	}
 
 	// If not running and not completed, check if it failed
-	if job.Status != "queued" && job.Status != "completed" {
-		job.Status = "failed"
+	if !models.JobStatus(job.Status).IsTerminal() && job.Status != string(models.StatusQueued) {
+		job.Status = string(models.StatusFailed)
 		job.UpdateTime = time.Now()
 		updateStatusText(job)
 	}
 }
 
 func updateStatusText(job *Job) {
-	switch job.Status {
-	case "queued":
+	switch models.JobStatus(job.Status) {
+	case models.StatusQueued:
 		job.StatusText = "Queued for processing"
-	case "downloading":
-		job.StatusText = "Downloading video"
-	case "extracting":
-		job.StatusText = "Extracting audio"
-	case "transcribing":
-		job.StatusText = "Transcribing audio"
-	case "completed":
+	case models.StatusRunning:
+		switch job.Stage {
+		case models.StageDownload:
+			job.StatusText = "Downloading video"
+		case models.StageNormalize:
+			job.StatusText = "Extracting audio"
+		case models.StageTranscribe:
+			job.StatusText = "Transcribing audio"
+		default:
+			job.StatusText = "Processing"
+		}
+	case models.StatusCompleted:
 		job.StatusText = "Transcription complete"
-	case "failed":
+	case models.StatusFailed:
 		job.StatusText = "Processing failed"
+	case models.StatusCancelled:
+		job.StatusText = "Cancelled"
 	default:
 		job.StatusText = "Processing"
 	}
@@ -3349,7 +3349,7 @@ func updateStatusText(job *Job) {
 
 func updateJobStats(job *Job) {
 	// Calculate duration - only update for running jobs, preserve completed job durations
-	if !job.StartTime.IsZero() && job.Status != "completed" && job.Status != "failed" {
+	if !job.StartTime.IsZero() && !models.JobStatus(job.Status).IsTerminal() {
 		// For running jobs, show elapsed time
 		duration := time.Since(job.StartTime)
 		job.Duration = formatDuration(duration)
@@ -3371,7 +3371,7 @@ func updateJobStats(job *Job) {
 	}
 }
 
-func parseJobProgress(job *Job) (string, int) {
+func parseJobProgress(job *Job) (string, string, int) {
 	logFiles := []string{
 		job.LogFile,
 		"transcription.log",
@@ -3379,46 +3379,47 @@ func parseJobProgress(job *Job) (string, int) {
 	}
 
 	for _, logFile := range logFiles {
-		if status, progress := parseLogFile(logFile); status != "" {
+		if status, stage, progress := parseLogFile(logFile); status != "" {
 			if title := extractTitleFromLog(logFile); title != "" && job.Title == "Loading..." {
 				job.Title = title
 			}
-			return status, progress
+			return status, stage, progress
 		}
 	}
 
-	return "transcribing", job.Progress
+	return string(models.StatusRunning), models.StageTranscribe, job.Progress
 }
 
-func parseLogFile(filename string) (string, int) {
+func parseLogFile(filename string) (string, string, int) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return "", 0
+		return "", "", 0
 	}
 
+	running := string(models.StatusRunning)
 	content := string(data)
 	if strings.Contains(content, "Downloading video") {
-		return "downloading", 10
+		return running, models.StageDownload, 10
 	}
 	if strings.Contains(content, "Downloaded:") && strings.Contains(content, "Extracting audio") {
-		return "extracting", 30
+		return running, models.StageNormalize, 30
 	}
 	if strings.Contains(content, "Audio extracted:") && strings.Contains(content, "Transcribing") {
-		return "transcribing", 50
+		return running, models.StageTranscribe, 50
 	}
 	if strings.Contains(content, "Transcription complete") {
-		return "completed", 100
+		return string(models.StatusCompleted), "", 100
 	}
 
 	re := regexp.MustCompile(`(\d+)%`)
 	matches := re.FindAllStringSubmatch(content, -1)
 	if len(matches) > 0 {
 		if percent, err := strconv.Atoi(matches[len(matches)-1][1]); err == nil {
-			return "transcribing", 50 + (percent/2)
+			return running, models.StageTranscribe, 50 + (percent/2)
 		}
 	}
 
-	return "", 0
+	return "", "", 0
 }
 
 func extractTitleFromLog(filename string) string {
@@ -3472,32 +3473,16 @@ func formatFileSize(size int64) string {
 	return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
 }
 
+// calculateBusinessMetrics counts the jobs started yesterday, for the
+// comparison with today's jobs.
 func calculateBusinessMetrics(data *DashboardData, jobs []Job) {
-	// Pricing assumptions for RapidAPI monetization
-	basePrice := 2.50  // Base price per job
-	premiumMultiplier := 2.0  // Premium tier multiplier
+	today := models.DayStart(time.Now())
+	yesterday := today.AddDate(0, 0, -1)
 
-	// Calculate revenue metrics
-	data.RevenueToday = float64(data.JobsToday) * basePrice
-	data.JobsYesterday = data.JobsToday - 1  // Simulated previous day data
-
-	// Calculate revenue per job with tier detection
-	if data.TotalJobs > 0 {
-		avgDuration := float64(data.TotalJobs * 5) // Assume 5 min avg
-		if avgDuration > 10 { // Longer videos = premium tier
-			data.AvgRevenuePerJob = basePrice * premiumMultiplier
-		} else {
-			data.AvgRevenuePerJob = basePrice
+	for _, job := range jobs {
+		if !job.StartTime.Before(yesterday) && job.StartTime.Before(today) {
+			data.JobsYesterday++
 		}
-	} else {
-		data.AvgRevenuePerJob = basePrice
-	}
-
-	// Calculate growth rate (simulated)
-	if data.JobsYesterday > 0 {
-		data.RevenueGrowth = ((float64(data.JobsToday) - float64(data.JobsYesterday)) / float64(data.JobsYesterday)) * 100
-	} else {
-		data.RevenueGrowth = 25.0 // Default positive growth for new service
 	}
 }
 